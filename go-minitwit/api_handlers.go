@@ -37,7 +37,10 @@ type UserData struct {
 }
 
 type MessageData struct {
-	Content string `json:"content"`
+	Content     string       `json:"content"`
+	ReplyTo     int          `json:"reply_to"`    // optional id of the message replied to
+	Attachments []int        `json:"attachments"` // optional ids of images uploaded with /api/v2/users/<username>/attachments
+	Poll        *PollRequest `json:"poll"`        // optional poll: {"options": [<text>, ...], "duration": <seconds>}
 }
 
 func not_req_from_simulator(c *gin.Context) (statusCode int, errStr string) {
@@ -203,6 +206,12 @@ func apiRegisterHandler(c *gin.Context) {
 			c.AbortWithStatusJSON(400, errorData.error_msg)
 			return
 
+		} else if isReservedUsername(username) {
+			errorData.status = 400
+			errorData.error_msg = "The username is reserved"
+			c.AbortWithStatusJSON(400, errorData.error_msg)
+			return
+
 		} else {
 			hash := md5.Sum([]byte(password))
 			err := registerUser(username, email, hash)
@@ -365,7 +374,7 @@ func apiMsgsPerUserHandler(c *gin.Context) {
 			}).Info("Fallback to default number of messages due to parsing error")
 		}
 
-		messages, err := getUserMessages(userId, numMsgsInt)
+		messages, err := getUserMessages(userId, 0, numMsgsInt)
 		if err != nil {
 
 			logger.WithFields(logrus.Fields{
@@ -438,8 +447,21 @@ func apiMsgsPerUserHandler(c *gin.Context) {
 			c.AbortWithStatusJSON(http.StatusBadRequest, errorData)
 		}
 
-		err = addMessage(text, authorId)
-		if err != nil {
+		poll, err := pollFromRequest(messageReq.Poll)
+		if err == nil {
+			err = addMessage(text, authorId, messageReq.ReplyTo, messageReq.Attachments, poll)
+		}
+		if err == errBlocked {
+			errorData.status = http.StatusForbidden
+			errorData.error_msg = err.Error()
+			c.AbortWithStatusJSON(http.StatusForbidden, errorData)
+			return
+		} else if err == errReplyTargetNotFound || err == errAttachmentNotFound || err == errTooManyAttachments || isPollInputError(err) || isMessageTextError(err) {
+			errorData.status = http.StatusBadRequest
+			errorData.error_msg = err.Error()
+			c.AbortWithStatusJSON(http.StatusBadRequest, errorData.error_msg)
+			return
+		} else if err != nil {
 			errorData.status = http.StatusInternalServerError
 			errorData.error_msg = "Failed to upload message"
 			c.AbortWithStatusJSON(http.StatusInternalServerError, errorData)
@@ -530,7 +552,7 @@ func apiFllwsHandler(c *gin.Context) {
 
 		// Fetch all followers for the user
 		userIdStr := strconv.Itoa(userId)
-		followers, err := getFollowing(userIdStr, numFollrInt)
+		followers, err := getFollowing(userIdStr, numFollrInt, 0)
 		if err != nil {
 
 			logger.WithFields(logrus.Fields{
@@ -622,7 +644,12 @@ func apiFllwsHandler(c *gin.Context) {
 			profileUserIDStr := strconv.Itoa(profileUserID)
 
 			// Follow the user
-			if err := followUser(userIdStr, profileUserIDStr); err != nil {
+			if err := followUser(userIdStr, profileUserIDStr); err == errBlocked {
+				errorData.status = http.StatusForbidden
+				errorData.error_msg = err.Error()
+				c.AbortWithStatusJSON(http.StatusForbidden, errorData)
+				return
+			} else if err != nil {
 
 				logger.WithFields(logrus.Fields{
 					"source":   "api",
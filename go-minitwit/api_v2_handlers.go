@@ -0,0 +1,2244 @@
+// api_v2_handlers.go
+/*
+Version 2 of the API. Unlike v1 it is not driven by the simulator, so it does not track
+the latest command id, but it is guarded by the same authorization header.
+Lists are paginated with ?page=<n> (PERPAGE entries per page).
+*/
+
+package main
+
+import (
+	"crypto/md5"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// message representation of the v2 API
+type APIMessage struct {
+	ID       int    `json:"id"`
+	Content  string `json:"content"`
+	PubDate  int64  `json:"pub_date"`
+	User     string `json:"user"`
+	ReplyTo  int    `json:"reply_to,omitempty"`
+	RepostOf int    `json:"repost_of,omitempty"`
+	Likes    int    `json:"likes"`
+	Reposts  int    `json:"reposts"`
+	EditedAt int64  `json:"edited_at,omitempty"`
+	// images in their order in the message
+	Attachments []APIAttachment `json:"attachments,omitempty"`
+	Poll        *APIPoll        `json:"poll,omitempty"`
+}
+
+func formatAPIMessages(messages []MessageUser) []APIMessage {
+	var messageIDs []int
+	for _, m := range messages {
+		messageIDs = append(messageIDs, m.MessageID)
+	}
+	attachments, _ := getAttachments(messageIDs)
+	polls, pollOptions, _ := getPolls(messageIDs)
+
+	apiMessages := []APIMessage{}
+	for _, m := range messages {
+		var apiAttachments []APIAttachment
+		for _, a := range attachments[m.MessageID] {
+			apiAttachments = append(apiAttachments, formatAPIAttachment(a))
+		}
+		var poll *APIPoll
+		if p, ok := polls[m.MessageID]; ok {
+			poll = formatAPIPoll(formatPoll(p, pollOptions[m.MessageID]))
+		}
+		apiMessages = append(apiMessages, APIMessage{
+			ID:       m.MessageID,
+			Content:  m.Text,
+			PubDate:  int64(m.PubDate),
+			User:     m.Username,
+			ReplyTo:  m.ReplyToID,
+			RepostOf: m.RepostOfID,
+			Likes:    m.LikeCount,
+			Reposts:  m.RepostCount,
+			EditedAt: int64(m.EditedAt),
+
+			Attachments: apiAttachments,
+			Poll:        poll,
+		})
+	}
+	return apiMessages
+}
+
+// apiV2AuthRequired rejects requests without the simulator authorization header
+func apiV2AuthRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		statusCode, errStr := not_req_from_simulator(c)
+		if statusCode == http.StatusForbidden && errStr != "" {
+
+			logger.WithFields(logrus.Fields{
+				"source":   "api_v2",
+				"endpoint": c.FullPath(),
+				"action":   "access_denied",
+				"reason":   errStr,
+			}).Warn("Request denied: not authorized")
+
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": http.StatusForbidden, "error_msg": errStr})
+			return
+		}
+		c.Next()
+	}
+}
+
+// apiV2Error aborts with the v2 error body
+func apiV2Error(c *gin.Context, status int, errorMsg string) {
+	c.AbortWithStatusJSON(status, gin.H{"status": status, "error_msg": errorMsg})
+}
+
+// apiV2User resolves the :username path parameter, aborting with 404 if it does not exist
+func apiV2User(c *gin.Context) (User, bool) {
+	user, err := getUserByUsername(c.Param("username"))
+	if err != nil || user.Username == "" {
+		apiV2Error(c, http.StatusNotFound, "User not found")
+		return user, false
+	}
+	return user, true
+}
+
+// apiV2Viewer reads the optional ?username the messages are seen by, 0 without (as anonymous viewers)
+func apiV2Viewer(c *gin.Context) (int, bool) {
+	username := c.Query("username")
+	if username == "" {
+		return 0, true
+	}
+	userID, err := getUserIDByUsername(username)
+	if err != nil || userID == -1 {
+		apiV2Error(c, http.StatusNotFound, "User not found")
+		return 0, false
+	}
+	return userID, true
+}
+
+// apiV2PageLinks returns the page fields added to paginated responses
+func apiV2PageLinks(pagination Pagination) gin.H {
+	links := gin.H{"page": pagination.Page, "next_page": nil, "prev_page": nil}
+	if pagination.NextPage != 0 {
+		links["next_page"] = pagination.NextPage
+	}
+	if pagination.PrevPage != 0 {
+		links["prev_page"] = pagination.PrevPage
+	}
+	return links
+}
+
+/*
+/api/v2/users/<username>/followers?page=<n>
+/api/v2/users/<username>/following?page=<n>
+GET
+returns: {"users": [<username>, ...], "page": n, "next_page": n+1|null, "prev_page": n-1|null}
+*/
+func apiV2FollowListHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	listKind := c.FullPath()[strings.LastIndex(c.FullPath(), "/")+1:]
+	page := getPage(c)
+	userIDStr := strconv.Itoa(user.UserID)
+
+	var users []User
+	var err error
+	if listKind == "followers" {
+		users, err = getFollowers(userIDStr, PERPAGE+1, (page-1)*PERPAGE)
+	} else {
+		users, err = getFollowing(userIDStr, PERPAGE+1, (page-1)*PERPAGE)
+	}
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "api_v2",
+			"endpoint": c.FullPath(),
+			"action":   "fetch_follow_list",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to fetch follow list from DB")
+
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch "+listKind+" from DB")
+		return
+	}
+
+	pagination := newPagination(page, len(users))
+	if len(users) > PERPAGE {
+		users = users[:PERPAGE]
+	}
+
+	userNames := []string{}
+	for _, u := range users {
+		userNames = append(userNames, u.Username)
+	}
+
+	response := apiV2PageLinks(pagination)
+	response["users"] = userNames
+	c.JSON(http.StatusOK, response)
+}
+
+/*
+/api/v2/users/<username>/stats
+GET
+returns: {"username": <username>, "followers": n, "following": n, "messages": n}
+*/
+func apiV2UserStatsHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	stats, err := getUserStats(user.UserID)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch user stats from DB")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"username":  user.Username,
+		"followers": stats.FollowerCount,
+		"following": stats.FollowingCount,
+		"messages":  stats.MessageCount,
+	})
+}
+
+// apiV2Message resolves the :id path parameter, aborting with 404 if the message does not exist, is flagged or deleted
+func apiV2Message(c *gin.Context) (MessageUser, bool) {
+	messageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		apiV2Error(c, http.StatusNotFound, "Message not found")
+		return MessageUser{}, false
+	}
+
+	message, found, err := getMessage(messageID)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch message from DB")
+		return message, false
+	}
+	if !found || isHiddenMessage(message) {
+		apiV2Error(c, http.StatusNotFound, "Message not found")
+		return message, false
+	}
+	return message, true
+}
+
+/*
+/api/v2/msgs/<id>/likes
+POST {"username": <username>}
+likes the message as <username>, liking twice has no effect
+returns: ("", 204)
+*/
+func apiV2LikeHandler(c *gin.Context) {
+	message, ok := apiV2Message(c)
+	if !ok {
+		return
+	}
+
+	var requestBody struct {
+		Username string `json:"username"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil || requestBody.Username == "" {
+		apiV2Error(c, http.StatusBadRequest, "Body must contain the username liking the message")
+		return
+	}
+
+	userID, err := getUserIDByUsername(requestBody.Username)
+	if err != nil || userID == -1 {
+		apiV2Error(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	err = likeMessage(userID, message.MessageID)
+	if err == errMessageNotFound {
+		apiV2Error(c, http.StatusNotFound, "Message not found")
+		return
+	} else if err == errBlocked {
+		apiV2Error(c, http.StatusForbidden, err.Error())
+		return
+	} else if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "api_v2",
+			"endpoint": c.FullPath(),
+			"action":   "like",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to like message")
+
+		apiV2Error(c, http.StatusInternalServerError, "Failed to like message")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+/*
+/api/v2/msgs/<id>/likes/<username>
+DELETE
+removes the like of <username>, unliking a message that was not liked has no effect
+returns: ("", 204)
+*/
+func apiV2UnlikeHandler(c *gin.Context) {
+	message, ok := apiV2Message(c)
+	if !ok {
+		return
+	}
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	if err := unlikeMessage(user.UserID, message.MessageID); err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "api_v2",
+			"endpoint": c.FullPath(),
+			"action":   "unlike",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to unlike message")
+
+		apiV2Error(c, http.StatusInternalServerError, "Failed to unlike message")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+/*
+/api/v2/msgs/<id>/likes?page=<n>
+GET
+returns: {"likes": n, "users": [<username>, ...], "page": n, "next_page": n+1|null, "prev_page": n-1|null}
+*/
+func apiV2LikesHandler(c *gin.Context) {
+	message, ok := apiV2Message(c)
+	if !ok {
+		return
+	}
+
+	page := getPage(c)
+	users, err := getLikingUsers(message.MessageID, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch likes from DB")
+		return
+	}
+
+	pagination := newPagination(page, len(users))
+	if len(users) > PERPAGE {
+		users = users[:PERPAGE]
+	}
+
+	userNames := []string{}
+	for _, u := range users {
+		userNames = append(userNames, u.Username)
+	}
+
+	response := apiV2PageLinks(pagination)
+	response["likes"] = message.LikeCount
+	response["users"] = userNames
+	c.JSON(http.StatusOK, response)
+}
+
+/*
+/api/v2/users/<username>/likes?page=<n>&username=<viewer>
+GET
+returns: {"messages": [<message>, ...], "page": n, "next_page": n+1|null, "prev_page": n-1|null}
+*/
+func apiV2UserLikesHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+	viewerID, ok := apiV2Viewer(c)
+	if !ok {
+		return
+	}
+
+	page := getPage(c)
+	messages, err := getLikedMessages(user.UserID, viewerID, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch liked messages from DB")
+		return
+	}
+
+	pagination := newPagination(page, len(messages))
+	if len(messages) > PERPAGE {
+		messages = messages[:PERPAGE]
+	}
+
+	response := apiV2PageLinks(pagination)
+	response["messages"] = formatAPIMessages(messages)
+	c.JSON(http.StatusOK, response)
+}
+
+/*
+/api/v2/users/<username>/bookmarks?page=<n>
+GET the bookmarked messages, most recently bookmarked first. Bookmarks of deleted or flagged messages are left out.
+returns: {"messages": [<message>, ...], "page": n, "next_page": n+1|null, "prev_page": n-1|null}
+*/
+func apiV2BookmarksHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	page := getPage(c)
+	messages, err := getBookmarkedMessages(user.UserID, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch bookmarked messages from DB")
+		return
+	}
+
+	pagination := newPagination(page, len(messages))
+	if len(messages) > PERPAGE {
+		messages = messages[:PERPAGE]
+	}
+
+	response := apiV2PageLinks(pagination)
+	response["messages"] = formatAPIMessages(messages)
+	c.JSON(http.StatusOK, response)
+}
+
+/*
+/api/v2/users/<username>/bookmarks
+POST {"message_id": <id>} bookmarks the message, bookmarking twice has no effect
+/api/v2/users/<username>/bookmarks/<id>
+DELETE removes the bookmark, removing a missing bookmark has no effect
+returns: ("", 204), 404 if the message to bookmark does not exist
+*/
+func apiV2BookmarkHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	var err error
+	action := "bookmark"
+	if c.Request.Method == http.MethodDelete {
+		action = "unbookmark"
+		messageID, errID := strconv.Atoi(c.Param("id"))
+		if errID != nil {
+			apiV2Error(c, http.StatusNotFound, "Message not found")
+			return
+		}
+		err = unbookmarkMessage(user.UserID, messageID)
+	} else {
+		var requestBody struct {
+			MessageID int `json:"message_id"`
+		}
+		if errBind := c.ShouldBindJSON(&requestBody); errBind != nil || requestBody.MessageID == 0 {
+			apiV2Error(c, http.StatusBadRequest, "Body must contain the message_id to bookmark")
+			return
+		}
+		err = bookmarkMessage(user.UserID, requestBody.MessageID)
+	}
+
+	if err == errMessageNotFound {
+		apiV2Error(c, http.StatusNotFound, "Message not found")
+		return
+	} else if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "api_v2",
+			"endpoint": c.FullPath(),
+			"action":   action,
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to " + action + " message")
+
+		apiV2Error(c, http.StatusInternalServerError, "Failed to "+action+" message")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+/*
+/api/v2/msgs/<id>/reposts
+POST {"username": <username>, "content": <text>}
+reposts the message as <username>, with content it is a quote post. Reposting a repost reposts the original message.
+returns: ("", 204), 409 if <username> already reposted the message without content
+*/
+func apiV2RepostHandler(c *gin.Context) {
+	message, ok := apiV2Message(c)
+	if !ok {
+		return
+	}
+
+	var requestBody struct {
+		Username string `json:"username"`
+		Content  string `json:"content"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil || requestBody.Username == "" {
+		apiV2Error(c, http.StatusBadRequest, "Body must contain the username reposting the message")
+		return
+	}
+
+	userID, err := getUserIDByUsername(requestBody.Username)
+	if err != nil || userID == -1 {
+		apiV2Error(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	err = repostMessage(userID, message.MessageID, strings.TrimSpace(requestBody.Content))
+	if err == errMessageNotFound {
+		apiV2Error(c, http.StatusNotFound, "Message not found")
+		return
+	} else if err == errAlreadyReposted {
+		apiV2Error(c, http.StatusConflict, err.Error())
+		return
+	} else if err == errPrivateRepost || err == errBlocked {
+		apiV2Error(c, http.StatusForbidden, err.Error())
+		return
+	} else if isMessageTextError(err) {
+		apiV2Error(c, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "api_v2",
+			"endpoint": c.FullPath(),
+			"action":   "repost",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to repost message")
+
+		apiV2Error(c, http.StatusInternalServerError, "Failed to repost message")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+/*
+/api/v2/msgs/<id>/reposts/<username>
+DELETE
+removes the repost (without content) of <username>, quote posts are not affected
+returns: ("", 204)
+*/
+func apiV2UnrepostHandler(c *gin.Context) {
+	message, ok := apiV2Message(c)
+	if !ok {
+		return
+	}
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	if err := unrepostMessage(user.UserID, message.MessageID); err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "api_v2",
+			"endpoint": c.FullPath(),
+			"action":   "unrepost",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to unrepost message")
+
+		apiV2Error(c, http.StatusInternalServerError, "Failed to unrepost message")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+/*
+/api/v2/users/<username>/mentions?page=<n>&username=<viewer>
+GET
+returns: {"messages": [<message>, ...], "page": n, "next_page": n+1|null, "prev_page": n-1|null}
+*/
+func apiV2UserMentionsHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+	viewerID, ok := apiV2Viewer(c)
+	if !ok {
+		return
+	}
+
+	page := getPage(c)
+	messages, err := getMentioningMessages(user.UserID, viewerID, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch mentions from DB")
+		return
+	}
+
+	pagination := newPagination(page, len(messages))
+	if len(messages) > PERPAGE {
+		messages = messages[:PERPAGE]
+	}
+
+	response := apiV2PageLinks(pagination)
+	response["messages"] = formatAPIMessages(messages)
+	c.JSON(http.StatusOK, response)
+}
+
+/*
+/api/v2/tags/trending?window=<1h|24h|7d>
+GET
+returns: {"window": <window>, "updated_at": <unix time>, "tags": [{"tag": <tag>, "count": n}, ...]}
+*/
+func apiV2TrendingTagsHandler(c *gin.Context) {
+	window := c.DefaultQuery("window", trendingWindows[0].Name)
+	tags, updatedAt, ok := getTrendingTags(window)
+	if !ok {
+		apiV2Error(c, http.StatusBadRequest, "Unknown window, use 1h, 24h or 7d")
+		return
+	}
+	if tags == nil {
+		tags = []TrendingTag{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"window":     window,
+		"updated_at": updatedAt.Unix(),
+		"tags":       tags,
+	})
+}
+
+/*
+/api/v2/tags/<tag>/msgs?page=<n>&username=<viewer>
+GET
+returns: {"tag": <tag>, "messages": [<message>, ...], "page": n, "next_page": n+1|null, "prev_page": n-1|null}
+*/
+func apiV2TaggedMessagesHandler(c *gin.Context) {
+	tag := normalizeTag(c.Param("name"))
+	if tag == "" {
+		apiV2Error(c, http.StatusBadRequest, "Invalid tag")
+		return
+	}
+	viewerID, ok := apiV2Viewer(c)
+	if !ok {
+		return
+	}
+
+	page := getPage(c)
+	messages, err := getTaggedMessages(tag, viewerID, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch tagged messages from DB")
+		return
+	}
+
+	pagination := newPagination(page, len(messages))
+	if len(messages) > PERPAGE {
+		messages = messages[:PERPAGE]
+	}
+
+	response := apiV2PageLinks(pagination)
+	response["tag"] = tag
+	response["messages"] = formatAPIMessages(messages)
+	c.JSON(http.StatusOK, response)
+}
+
+// notification representation of the v2 API
+type APINotification struct {
+	ID        int    `json:"id"`
+	Type      string `json:"type"`
+	Actor     string `json:"actor"`
+	MessageID int    `json:"message_id,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	Read      bool   `json:"read"`
+}
+
+/*
+/api/v2/users/<username>/notifications?page=<n>&unread=1
+GET
+returns: {"notifications": [<notification>, ...], "unread": n, "page": n, "next_page": n+1|null, "prev_page": n-1|null}
+*/
+func apiV2NotificationsHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	page := getPage(c)
+	notifications, err := getNotifications(user.UserID, c.Query("unread") == "1", PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch notifications from DB")
+		return
+	}
+	unread, err := countUnreadNotifications(user.UserID)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch notifications from DB")
+		return
+	}
+
+	pagination := newPagination(page, len(notifications))
+	if len(notifications) > PERPAGE {
+		notifications = notifications[:PERPAGE]
+	}
+
+	apiNotifications := []APINotification{}
+	for _, n := range notifications {
+		apiNotifications = append(apiNotifications, APINotification{
+			ID:        n.NotificationID,
+			Type:      n.Type,
+			Actor:     n.ActorUsername,
+			MessageID: n.MessageID,
+			CreatedAt: int64(n.CreatedAt),
+			Read:      n.IsRead,
+		})
+	}
+
+	response := apiV2PageLinks(pagination)
+	response["notifications"] = apiNotifications
+	response["unread"] = unread
+	c.JSON(http.StatusOK, response)
+}
+
+/*
+/api/v2/users/<username>/notifications/read
+POST {"ids": [<id>, ...]}
+marks the given notifications as read, all of them without ids
+returns: ("", 204)
+*/
+func apiV2MarkNotificationsReadHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	var requestBody struct {
+		IDs []int `json:"ids"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&requestBody); err != nil {
+			apiV2Error(c, http.StatusBadRequest, "Body must be of the form {\"ids\": [<id>, ...]}")
+			return
+		}
+	}
+
+	if err := markNotificationsRead(user.UserID, requestBody.IDs); err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to mark notifications as read")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+/*
+/api/v2/users/<username>/notification_preferences
+GET
+PUT {"follow": bool, "mention": bool, "reply": bool, "like": bool}, omitted types are left unchanged
+returns: {"follow": bool, "mention": bool, "reply": bool, "like": bool}
+*/
+func apiV2NotificationPreferencesHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	if c.Request.Method == http.MethodPut {
+		var requestBody map[string]bool
+		if err := c.ShouldBindJSON(&requestBody); err != nil {
+			apiV2Error(c, http.StatusBadRequest, "Body must map notification types to true or false")
+			return
+		}
+		for notificationType := range requestBody {
+			if !isNotificationType(notificationType) {
+				apiV2Error(c, http.StatusBadRequest, "Unknown notification type "+notificationType)
+				return
+			}
+		}
+		if err := setNotificationPreferences(user.UserID, requestBody); err != nil {
+			apiV2Error(c, http.StatusInternalServerError, "Failed to save notification preferences")
+			return
+		}
+	}
+
+	preferences, err := getNotificationPreferences(user.UserID)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch notification preferences from DB")
+		return
+	}
+	c.JSON(http.StatusOK, preferences)
+}
+
+// conversation representation of the v2 API
+type APIConversation struct {
+	ID           int      `json:"id"`
+	Participants []string `json:"participants"`
+	LastMessage  string   `json:"last_message"`
+	LastSender   string   `json:"last_sender"`
+	UpdatedAt    int64    `json:"updated_at"`
+	Unread       int      `json:"unread"`
+}
+
+// apiV2ConversationID resolves the :id path parameter to a conversation of the user, aborting with 404 otherwise
+func apiV2ConversationID(c *gin.Context, user User) (int, []string, bool) {
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		apiV2Error(c, http.StatusNotFound, "Conversation not found")
+		return 0, nil, false
+	}
+
+	participants, err := getConversation(conversationID, user.UserID)
+	if err == errConversationNotFound {
+		apiV2Error(c, http.StatusNotFound, "Conversation not found")
+		return 0, nil, false
+	} else if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch conversation from DB")
+		return 0, nil, false
+	}
+	return conversationID, participants, true
+}
+
+/*
+/api/v2/users/<username>/conversations?page=<n>
+GET
+returns: {"conversations": [<conversation>, ...], "unread": n, "page": n, "next_page": n+1|null, "prev_page": n-1|null}
+*/
+func apiV2ConversationsHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	page := getPage(c)
+	conversations, err := getConversations(user.UserID, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch conversations from DB")
+		return
+	}
+	unread, err := countUnreadDirectMessages(user.UserID)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch conversations from DB")
+		return
+	}
+
+	pagination := newPagination(page, len(conversations))
+	if len(conversations) > PERPAGE {
+		conversations = conversations[:PERPAGE]
+	}
+
+	apiConversations := []APIConversation{}
+	for _, conversation := range conversations {
+		participants := conversation.Participants
+		if participants == nil {
+			participants = []string{}
+		}
+		apiConversations = append(apiConversations, APIConversation{
+			ID:           conversation.ConversationID,
+			Participants: participants,
+			LastMessage:  conversation.LastMessage,
+			LastSender:   conversation.LastSender,
+			UpdatedAt:    int64(conversation.UpdatedAt),
+			Unread:       conversation.Unread,
+		})
+	}
+
+	response := apiV2PageLinks(pagination)
+	response["conversations"] = apiConversations
+	response["unread"] = unread
+	c.JSON(http.StatusOK, response)
+}
+
+/*
+/api/v2/users/<username>/conversations
+POST {"to": [<username>, ...], "content": <text>}
+starts a conversation (or continues the one-to-one conversation with the recipient)
+returns: ({"id": <conversation id>}, 201), 403 if a recipient does not accept direct messages from <username>
+*/
+func apiV2StartConversationHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	var requestBody struct {
+		To      []string `json:"to"`
+		Content string   `json:"content"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil || len(requestBody.To) == 0 || strings.TrimSpace(requestBody.Content) == "" {
+		apiV2Error(c, http.StatusBadRequest, "Body must contain the recipients and the content")
+		return
+	}
+
+	userIDs, err := getUserIDsByUsernames(requestBody.To)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch users from DB")
+		return
+	}
+	var recipientIDs []int
+	for _, name := range requestBody.To {
+		recipientID, found := userIDs[name]
+		if !found {
+			apiV2Error(c, http.StatusNotFound, "User "+name+" not found")
+			return
+		}
+		recipientIDs = append(recipientIDs, recipientID)
+	}
+
+	conversationID, err := startConversation(user.UserID, recipientIDs, strings.TrimSpace(requestBody.Content))
+	if _, denied := err.(errNotAllowedToMessage); denied {
+		apiV2Error(c, http.StatusForbidden, err.Error())
+		return
+	} else if err == errTooManyParticipants || err == errNoRecipients {
+		apiV2Error(c, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "api_v2",
+			"endpoint": c.FullPath(),
+			"action":   "start_conversation",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to start conversation")
+
+		apiV2Error(c, http.StatusInternalServerError, "Failed to start conversation")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"id": conversationID})
+}
+
+/*
+/api/v2/users/<username>/conversations/<id>?page=<n>
+GET
+returns the messages newest first and marks the conversation as read when the first page is fetched:
+{"id": n, "participants": [<username>, ...], "messages": [{"user": <username>, "content": <text>, "pub_date": <unix time>}, ...], "page": n, ...}
+*/
+func apiV2ConversationHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+	conversationID, participants, ok := apiV2ConversationID(c, user)
+	if !ok {
+		return
+	}
+
+	page := getPage(c)
+	messages, err := getDirectMessages(conversationID, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch messages from DB")
+		return
+	}
+
+	pagination := newPagination(page, len(messages))
+	if len(messages) > PERPAGE {
+		messages = messages[:PERPAGE]
+	}
+	if page == 1 {
+		markConversationRead(conversationID, user.UserID)
+	}
+
+	apiMessages := []gin.H{}
+	for _, m := range messages {
+		apiMessages = append(apiMessages, gin.H{"user": m.Username, "content": m.Text, "pub_date": m.PubDate})
+	}
+
+	response := apiV2PageLinks(pagination)
+	response["id"] = conversationID
+	response["participants"] = participants
+	response["messages"] = apiMessages
+	c.JSON(http.StatusOK, response)
+}
+
+/*
+/api/v2/users/<username>/conversations/<id>
+POST {"content": <text>}
+returns: ("", 204)
+*/
+func apiV2SendDirectMessageHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+	conversationID, _, ok := apiV2ConversationID(c, user)
+	if !ok {
+		return
+	}
+
+	var requestBody struct {
+		Content string `json:"content"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil || strings.TrimSpace(requestBody.Content) == "" {
+		apiV2Error(c, http.StatusBadRequest, "Body must contain the content")
+		return
+	}
+
+	if err := sendDirectMessage(conversationID, user.UserID, strings.TrimSpace(requestBody.Content)); err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to send message")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+/*
+/api/v2/users/<username>/dm_settings
+GET
+PUT {"open_dms": bool}
+returns: {"open_dms": bool}
+*/
+func apiV2DirectMessageSettingsHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	if c.Request.Method == http.MethodPut {
+		var requestBody struct {
+			OpenDMs *bool `json:"open_dms"`
+		}
+		if err := c.ShouldBindJSON(&requestBody); err != nil || requestBody.OpenDMs == nil {
+			apiV2Error(c, http.StatusBadRequest, "Body must be of the form {\"open_dms\": true|false}")
+			return
+		}
+		if err := setOpenDMs(user.UserID, *requestBody.OpenDMs); err != nil {
+			apiV2Error(c, http.StatusInternalServerError, "Failed to save settings")
+			return
+		}
+		user.OpenDMs = *requestBody.OpenDMs
+	}
+
+	c.JSON(http.StatusOK, gin.H{"open_dms": user.OpenDMs})
+}
+
+// apiV2MessageChangeError maps the errors of editMessage and deleteMessage to responses
+func apiV2MessageChangeError(c *gin.Context, action string, err error) {
+	switch {
+	case err == errMessageNotFound:
+		apiV2Error(c, http.StatusNotFound, "Message not found")
+	case err == errNotMessageAuthor, err == errEditWindowClosed:
+		apiV2Error(c, http.StatusForbidden, err.Error())
+	case isMessageTextError(err):
+		apiV2Error(c, http.StatusBadRequest, err.Error())
+	default:
+
+		logger.WithFields(logrus.Fields{
+			"source":   "api_v2",
+			"endpoint": c.FullPath(),
+			"action":   action,
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to " + action + " message")
+
+		apiV2Error(c, http.StatusInternalServerError, "Failed to "+action+" message")
+	}
+}
+
+/*
+/api/v2/msgs/<id>
+PATCH {"username": <username>, "content": <text>}
+edits the message of <username> within the edit window
+returns: (<message>, 200), 403 if <username> is not the author or the edit window is over
+*/
+func apiV2EditMessageHandler(c *gin.Context) {
+	message, ok := apiV2Message(c)
+	if !ok {
+		return
+	}
+
+	var requestBody struct {
+		Username string `json:"username"`
+		Content  string `json:"content"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil || requestBody.Username == "" || strings.TrimSpace(requestBody.Content) == "" {
+		apiV2Error(c, http.StatusBadRequest, "Body must contain the username and the new content")
+		return
+	}
+
+	userID, err := getUserIDByUsername(requestBody.Username)
+	if err != nil || userID == -1 {
+		apiV2Error(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if err := editMessage(userID, message.MessageID, strings.TrimSpace(requestBody.Content)); err != nil {
+		apiV2MessageChangeError(c, "edit", err)
+		return
+	}
+
+	message, _, err = getMessage(message.MessageID)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch message from DB")
+		return
+	}
+	c.JSON(http.StatusOK, formatAPIMessages([]MessageUser{message})[0])
+}
+
+/*
+/api/v2/msgs/<id>?username=<username>
+DELETE
+deletes the message of <username>
+returns: ("", 204), 403 if <username> is not the author
+*/
+func apiV2DeleteMessageHandler(c *gin.Context) {
+	message, ok := apiV2Message(c)
+	if !ok {
+		return
+	}
+
+	userID, err := getUserIDByUsername(c.Query("username"))
+	if err != nil || userID == -1 {
+		apiV2Error(c, http.StatusBadRequest, "The username query parameter must name the author")
+		return
+	}
+
+	if err := deleteMessage(userID, message.MessageID); err != nil {
+		apiV2MessageChangeError(c, "delete", err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+/*
+/api/v2/msgs/<id>/history
+GET
+returns: {"id": n, "content": <current text>, "edited_at": <unix time>|0, "edits": [{"content": <previous text>, "edited_at": <unix time>}, ...]}
+*/
+func apiV2MessageHistoryHandler(c *gin.Context) {
+	message, ok := apiV2Message(c)
+	if !ok {
+		return
+	}
+
+	edits, err := getMessageEdits(message.MessageID)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch edit history from DB")
+		return
+	}
+
+	apiEdits := []gin.H{}
+	for _, edit := range edits {
+		apiEdits = append(apiEdits, gin.H{"content": edit.Text, "edited_at": edit.EditedAt})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":        message.MessageID,
+		"content":   message.Text,
+		"edited_at": message.EditedAt,
+		"edits":     apiEdits,
+	})
+}
+
+/*
+/api/v2/users/<username>/blocks?page=<n>
+/api/v2/users/<username>/mutes?page=<n>
+GET
+returns: {"users": [<username>, ...], "page": n, "next_page": n+1|null, "prev_page": n-1|null}
+*/
+func apiV2BlockListHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	listKind := c.FullPath()[strings.LastIndex(c.FullPath(), "/")+1:]
+	page := getPage(c)
+
+	var users []User
+	var err error
+	if listKind == "blocks" {
+		users, err = getBlockedUsers(user.UserID, PERPAGE+1, (page-1)*PERPAGE)
+	} else {
+		users, err = getMutedUsers(user.UserID, PERPAGE+1, (page-1)*PERPAGE)
+	}
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch "+listKind+" from DB")
+		return
+	}
+
+	pagination := newPagination(page, len(users))
+	if len(users) > PERPAGE {
+		users = users[:PERPAGE]
+	}
+
+	userNames := []string{}
+	for _, u := range users {
+		userNames = append(userNames, u.Username)
+	}
+
+	response := apiV2PageLinks(pagination)
+	response["users"] = userNames
+	c.JSON(http.StatusOK, response)
+}
+
+/*
+/api/v2/users/<username>/blocks
+/api/v2/users/<username>/mutes
+POST {"username": <blocked or muted username>}
+blocking also removes the follows between both users, blocking or muting twice has no effect
+
+/api/v2/users/<username>/blocks/<target>
+/api/v2/users/<username>/mutes/<target>
+DELETE
+returns: ("", 204)
+*/
+func apiV2BlockHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	targetName := c.Param("target")
+	if c.Request.Method == http.MethodPost {
+		var requestBody struct {
+			Username string `json:"username"`
+		}
+		if err := c.ShouldBindJSON(&requestBody); err != nil || requestBody.Username == "" {
+			apiV2Error(c, http.StatusBadRequest, "Body must contain the username to block or mute")
+			return
+		}
+		targetName = requestBody.Username
+	}
+
+	targetID, err := getUserIDByUsername(targetName)
+	if err != nil || targetID == -1 {
+		apiV2Error(c, http.StatusNotFound, "User not found")
+		return
+	}
+	if targetID == user.UserID {
+		apiV2Error(c, http.StatusBadRequest, "Users can't block or mute themselves")
+		return
+	}
+
+	blocks := strings.Contains(c.FullPath(), "/blocks")
+	var action string
+	switch {
+	case blocks && c.Request.Method == http.MethodPost:
+		action = "block"
+		err = blockUser(user.UserID, targetID)
+	case blocks:
+		action = "unblock"
+		err = unblockUser(user.UserID, targetID)
+	case c.Request.Method == http.MethodPost:
+		action = "mute"
+		err = muteUser(user.UserID, targetID)
+	default:
+		action = "unmute"
+		err = unmuteUser(user.UserID, targetID)
+	}
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "api_v2",
+			"endpoint": c.FullPath(),
+			"action":   action,
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to " + action + " user")
+
+		apiV2Error(c, http.StatusInternalServerError, "Failed to "+action+" user")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+/*
+/api/v2/users/<username>/following
+POST {"username": <followed username>}
+follows a public account or asks a private one for approval
+returns: ({"state": "following"|"requested"}, 200), 403 if one of the users blocked the other
+*/
+func apiV2FollowHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	var requestBody struct {
+		Username string `json:"username"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil || requestBody.Username == "" {
+		apiV2Error(c, http.StatusBadRequest, "Body must contain the username to follow")
+		return
+	}
+
+	targetID, err := getUserIDByUsername(requestBody.Username)
+	if err != nil || targetID == -1 {
+		apiV2Error(c, http.StatusNotFound, "User not found")
+		return
+	}
+	if targetID == user.UserID {
+		apiV2Error(c, http.StatusBadRequest, "Users can't follow themselves")
+		return
+	}
+
+	state, err := requestFollow(user.UserID, targetID)
+	if err == errBlocked {
+		apiV2Error(c, http.StatusForbidden, err.Error())
+		return
+	} else if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "api_v2",
+			"endpoint": c.FullPath(),
+			"action":   "follow",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to follow user")
+
+		apiV2Error(c, http.StatusInternalServerError, "Failed to follow user")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"state": state})
+}
+
+/*
+/api/v2/users/<username>/following/<target>
+GET
+returns: {"state": "none"|"requested"|"following"}
+*/
+func apiV2FollowStateHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	targetID, err := getUserIDByUsername(c.Param("target"))
+	if err != nil || targetID == -1 {
+		apiV2Error(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	state, err := getFollowState(user.UserID, targetID)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch follow state from DB")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"state": state})
+}
+
+/*
+/api/v2/users/<username>/following/<target>
+DELETE
+unfollows <target> or withdraws the pending follow request
+returns: ("", 204)
+*/
+func apiV2UnfollowHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	targetID, err := getUserIDByUsername(c.Param("target"))
+	if err != nil || targetID == -1 {
+		apiV2Error(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if err := unfollowUser(strconv.Itoa(user.UserID), strconv.Itoa(targetID)); err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to unfollow user")
+		return
+	}
+	if err := deleteFollowRequest(user.UserID, targetID); err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to withdraw follow request")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+/*
+/api/v2/users/<username>/follow_requests?page=<n>
+GET
+returns: {"users": [<requesting username>, ...], "page": n, "next_page": n+1|null, "prev_page": n-1|null}
+*/
+func apiV2FollowRequestsHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	page := getPage(c)
+	users, err := getFollowRequests(user.UserID, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch follow requests from DB")
+		return
+	}
+
+	pagination := newPagination(page, len(users))
+	if len(users) > PERPAGE {
+		users = users[:PERPAGE]
+	}
+
+	userNames := []string{}
+	for _, u := range users {
+		userNames = append(userNames, u.Username)
+	}
+
+	response := apiV2PageLinks(pagination)
+	response["users"] = userNames
+	c.JSON(http.StatusOK, response)
+}
+
+/*
+/api/v2/users/<username>/follow_requests/<requester>
+POST approves the request of <requester>
+DELETE denies it
+returns: ("", 204), 404 if <requester> has no pending request
+*/
+func apiV2FollowRequestHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	requesterID, err := getUserIDByUsername(c.Param("requester"))
+	if err != nil || requesterID == -1 {
+		apiV2Error(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if c.Request.Method == http.MethodPost {
+		err = approveFollowRequest(user.UserID, requesterID)
+	} else {
+		err = deleteFollowRequest(requesterID, user.UserID)
+	}
+	if err == errFollowRequestNotFound {
+		apiV2Error(c, http.StatusNotFound, "Follow request not found")
+		return
+	} else if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "api_v2",
+			"endpoint": c.FullPath(),
+			"action":   "answer_follow_request",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to answer follow request")
+
+		apiV2Error(c, http.StatusInternalServerError, "Failed to answer follow request")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+/*
+/api/v2/users/<username>/privacy
+GET
+PUT {"private": bool}, making an account public approves its pending follow requests
+returns: {"private": bool}
+*/
+func apiV2PrivacyHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	if c.Request.Method == http.MethodPut {
+		var requestBody struct {
+			Private *bool `json:"private"`
+		}
+		if err := c.ShouldBindJSON(&requestBody); err != nil || requestBody.Private == nil {
+			apiV2Error(c, http.StatusBadRequest, "Body must be of the form {\"private\": true|false}")
+			return
+		}
+		if err := setPrivate(user.UserID, *requestBody.Private); err != nil {
+			apiV2Error(c, http.StatusInternalServerError, "Failed to save settings")
+			return
+		}
+		user.Private = *requestBody.Private
+	}
+
+	c.JSON(http.StatusOK, gin.H{"private": user.Private})
+}
+
+/*
+/api/v2/users/<username>/profile
+GET
+PATCH {"display_name": <text>, "bio": <text>, "location": <text>, "website": <url>}, missing fields are left unchanged.
+"email" and "password" can be changed as well, together with the "current_password".
+returns: {"username": <username>, "display_name": <text>, "bio": <text>, "location": <text>, "website": <url>, "avatar_url": <url>, "private": bool},
+400 naming the invalid field, 403 if the current password is wrong
+*/
+func apiV2ProfileHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	if c.Request.Method == http.MethodPatch {
+		var requestBody struct {
+			ProfileUpdate
+			Email           *string `json:"email"`
+			Password        *string `json:"password"`
+			CurrentPassword string  `json:"current_password"`
+		}
+		if err := c.ShouldBindJSON(&requestBody); err != nil {
+			apiV2Error(c, http.StatusBadRequest, "Body must be a JSON object of profile fields")
+			return
+		}
+
+		update := requestBody.ProfileUpdate
+		err := validateProfile(&update)
+		if err == nil && requestBody.Email != nil {
+			*requestBody.Email = strings.TrimSpace(*requestBody.Email)
+			err = validateEmail(*requestBody.Email)
+		}
+		if err == nil && requestBody.Password != nil && *requestBody.Password == "" {
+			err = errInvalidProfileField{Field: "password", Reason: "can not be empty"}
+		}
+		if err != nil {
+			apiV2Error(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// the account fields are checked first, so a wrong password changes nothing
+		if requestBody.Email != nil || requestBody.Password != nil {
+			if err := verifyPassword(user.UserID, requestBody.CurrentPassword); err == errWrongPassword {
+				apiV2Error(c, http.StatusForbidden, err.Error())
+				return
+			} else if err != nil {
+				apiV2Error(c, http.StatusInternalServerError, "Failed to check the password")
+				return
+			}
+		}
+		if requestBody.Email != nil {
+			err = changeEmail(user.UserID, requestBody.CurrentPassword, *requestBody.Email)
+		}
+		if err == nil && requestBody.Password != nil {
+			err = changePassword(user.UserID, requestBody.CurrentPassword, md5.Sum([]byte(*requestBody.Password)))
+		}
+		if err == nil {
+			err = updateProfile(user.UserID, update)
+		}
+		if err != nil {
+
+			logger.WithFields(logrus.Fields{
+				"source":   "api_v2",
+				"endpoint": c.FullPath(),
+				"action":   "update_profile",
+				"status":   "error",
+				"error":    err.Error(),
+			}).Error("Failed to update profile")
+
+			apiV2Error(c, http.StatusInternalServerError, "Failed to update profile")
+			return
+		}
+
+		if user, ok = apiV2User(c); !ok {
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"username":     user.Username,
+		"display_name": user.DisplayName,
+		"bio":          user.Bio,
+		"location":     user.Location,
+		"website":      user.Website,
+		"avatar_url":   avatarURL(user.UserID, user.AvatarVersion, user.Email, 160),
+		"private":      user.Private,
+	})
+}
+
+/*
+/api/v2/users/<username>/avatar
+PUT the image (JPEG, PNG or GIF) as request body, or as the "avatar" field of a multipart form
+DELETE goes back to the default avatar
+returns: ({"avatar_url": <url>}, 200), 400 if the image is not accepted
+*/
+func apiV2AvatarHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	var err error
+	if c.Request.Method == http.MethodDelete {
+		err = removeAvatar(user)
+	} else {
+		var data []byte
+		if strings.HasPrefix(c.ContentType(), "multipart/") {
+			data, err = readUpload(c, "avatar", AVATAR_MAX_BYTES)
+		} else {
+			data, err = io.ReadAll(io.LimitReader(c.Request.Body, AVATAR_MAX_BYTES+1))
+		}
+		if err == nil {
+			err = setAvatar(user, data)
+		}
+	}
+
+	if _, invalid := err.(errInvalidImage); invalid || err == errMissingUpload {
+		apiV2Error(c, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "api_v2",
+			"endpoint": c.FullPath(),
+			"action":   "set_avatar",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to save avatar")
+
+		apiV2Error(c, http.StatusInternalServerError, "Failed to save avatar")
+		return
+	}
+
+	if user, ok = apiV2User(c); !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"avatar_url": avatarURL(user.UserID, user.AvatarVersion, user.Email, 160)})
+}
+
+/*
+/api/v2/users/<username>/attachments
+POST the image (JPEG, PNG or GIF) as request body with the alt text in ?alt=, or as the "image" and "alt" fields of a multipart form.
+The returned id is passed in the "attachments" of the message posted with /api/msgs/<username>, uploads that are not attached within an hour are removed.
+returns: ({"id": <id>, "url": <url>, "thumbnail_url": <url>, "alt": <alt>, "width": <width>, "height": <height>}, 201), 400 if the image is not accepted
+*/
+func apiV2AttachmentHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	var data []byte
+	var err error
+	alt := c.Query("alt")
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		data, err = readUpload(c, "image", ATTACHMENT_MAX_BYTES)
+		alt = c.PostForm("alt")
+	} else {
+		data, err = io.ReadAll(io.LimitReader(c.Request.Body, ATTACHMENT_MAX_BYTES+1))
+	}
+	var attachment Attachment
+	if err == nil {
+		attachment, err = createAttachment(user.UserID, data, alt)
+	}
+
+	if isUploadError(err) {
+		apiV2Error(c, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "api_v2",
+			"endpoint": c.FullPath(),
+			"action":   "upload_attachment",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to store attachment")
+
+		apiV2Error(c, http.StatusInternalServerError, "Failed to store image")
+		return
+	}
+
+	c.JSON(http.StatusCreated, formatAPIAttachment(attachment))
+}
+
+/*
+/api/v2/search?q=<query>&type=messages|users&page=<n>
+GET, q takes words and the filters from:<username>, #<tag>, since:<YYYY-MM-DD> and until:<YYYY-MM-DD>,
+which can also be passed as the from, tag, since and until parameters.
+Messages are searched as seen by the optional ?username=, anonymously without it.
+returns: {"messages": [<message>, ...], "page": n, "next_page": n+1|null, "prev_page": n-1|null},
+with type=users {"users": [<username>, ...], ...}
+*/
+func apiV2SearchHandler(c *gin.Context) {
+	query := searchQueryFromRequest(c)
+	page := getPage(c)
+
+	if c.Query("type") == "users" {
+		users, err := searchUsers(query.Terms, PERPAGE+1, (page-1)*PERPAGE)
+		if err != nil {
+			apiV2Error(c, http.StatusInternalServerError, "Failed to search users")
+			return
+		}
+		pagination := newPagination(page, len(users))
+		if len(users) > PERPAGE {
+			users = users[:PERPAGE]
+		}
+
+		usernames := []string{}
+		for _, u := range users {
+			usernames = append(usernames, u.Username)
+		}
+		response := apiV2PageLinks(pagination)
+		response["users"] = usernames
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	if query.Empty() {
+		apiV2Error(c, http.StatusBadRequest, "The search needs words, from:<username> or a #tag")
+		return
+	}
+	viewerID, ok := apiV2Viewer(c)
+	if !ok {
+		return
+	}
+
+	messages, err := searchMessages(query, viewerID, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to search messages")
+		return
+	}
+	pagination := newPagination(page, len(messages))
+	if len(messages) > PERPAGE {
+		messages = messages[:PERPAGE]
+	}
+
+	response := apiV2PageLinks(pagination)
+	response["messages"] = formatAPIMessages(messages)
+	c.JSON(http.StatusOK, response)
+}
+
+// apiV2ListError answers the errors of the list changes
+func apiV2ListError(c *gin.Context, action string, err error) {
+	if isListInputError(err) {
+		apiV2Error(c, http.StatusBadRequest, err.Error())
+		return
+	} else if err == errListNotFound {
+		apiV2Error(c, http.StatusNotFound, "List not found")
+		return
+	} else if err == errBlocked {
+		apiV2Error(c, http.StatusForbidden, err.Error())
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"source":   "api_v2",
+		"endpoint": c.FullPath(),
+		"action":   action,
+		"status":   "error",
+		"error":    err.Error(),
+	}).Error("Failed to " + action)
+
+	apiV2Error(c, http.StatusInternalServerError, "Failed to "+action)
+}
+
+// apiV2List reads the list of the :id parameter and the optional ?username viewer (0 without),
+// the list must be visible to the viewer
+func apiV2List(c *gin.Context) (ListSummary, int, bool) {
+	viewerID, ok := apiV2Viewer(c)
+	if !ok {
+		return ListSummary{}, 0, false
+	}
+
+	listID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		apiV2Error(c, http.StatusNotFound, "List not found")
+		return ListSummary{}, 0, false
+	}
+	list, found, err := getList(listID)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch list from DB")
+		return list, 0, false
+	}
+	if !found || !canViewList(list.UserList, viewerID) {
+		apiV2Error(c, http.StatusNotFound, "List not found")
+		return list, 0, false
+	}
+	return list, viewerID, true
+}
+
+/*
+/api/v2/users/<username>/lists
+GET the lists of <username>, private lists included
+returns: {"lists": [<list>, ...]}
+POST {"name": <name>, "description": <text>, "private": true|false} creates a list
+returns: (<list>, 201), 400 if the name is missing, too long or already used by another list of <username>
+<list> is {"id": n, "name": <name>, "description": <text>, "private": true|false, "owner": <username>, "members": n, "created_at": <unix time>}
+*/
+func apiV2ListsHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	if c.Request.Method == http.MethodPost {
+		var update ListUpdate
+		if err := c.ShouldBindJSON(&update); err != nil {
+			apiV2Error(c, http.StatusBadRequest, "Body must be a JSON object of list fields")
+			return
+		}
+		if update.Name == nil {
+			apiV2Error(c, http.StatusBadRequest, errListNameRequired.Error())
+			return
+		}
+		err := validateList(&update)
+		var list UserList
+		if err == nil {
+			list, err = createList(user.UserID, update)
+		}
+		if err != nil {
+			apiV2ListError(c, "create list", err)
+			return
+		}
+		c.JSON(http.StatusCreated, formatAPIList(ListSummary{UserList: list, OwnerUsername: user.Username}))
+		return
+	}
+
+	lists, err := getUserLists(user.UserID, true)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch lists from DB")
+		return
+	}
+	formatted := []APIList{}
+	for _, list := range lists {
+		formatted = append(formatted, formatAPIList(list))
+	}
+	c.JSON(http.StatusOK, gin.H{"lists": formatted})
+}
+
+/*
+/api/v2/users/<username>/lists/<id>
+PATCH {"name": <name>, "description": <text>, "private": true|false}, missing fields are left as they are
+returns: <list>, 400 if a field is invalid, 404 if <username> has no such list
+DELETE deletes the list
+returns: ("", 204), 404 if <username> has no such list
+*/
+func apiV2ListHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+	listID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		apiV2Error(c, http.StatusNotFound, "List not found")
+		return
+	}
+
+	if c.Request.Method == http.MethodDelete {
+		if err := deleteList(user.UserID, listID); err != nil {
+			apiV2ListError(c, "delete list", err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	var update ListUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		apiV2Error(c, http.StatusBadRequest, "Body must be a JSON object of list fields")
+		return
+	}
+	err = validateList(&update)
+	if err == nil {
+		err = updateList(user.UserID, listID, update)
+	}
+	if err != nil {
+		apiV2ListError(c, "update list", err)
+		return
+	}
+
+	list, _, err := getList(listID)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch list from DB")
+		return
+	}
+	c.JSON(http.StatusOK, formatAPIList(list))
+}
+
+/*
+/api/v2/users/<username>/lists/<id>/members
+POST {"username": <member>} adds <member> to the list, adding twice has no effect
+/api/v2/users/<username>/lists/<id>/members/<member>
+DELETE removes <member> from the list, removing a non-member has no effect
+returns: ("", 204), 404 if <username> has no such list, 403 if <member> blocked <username> or the other way round
+*/
+func apiV2ListMemberHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+	listID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		apiV2Error(c, http.StatusNotFound, "List not found")
+		return
+	}
+
+	member := c.Param("member")
+	if c.Request.Method == http.MethodPost {
+		var requestBody struct {
+			Username string `json:"username"`
+		}
+		if err := c.ShouldBindJSON(&requestBody); err != nil || requestBody.Username == "" {
+			apiV2Error(c, http.StatusBadRequest, "Body must contain the username to add")
+			return
+		}
+		member = requestBody.Username
+	}
+
+	memberID, err := getUserIDByUsername(member)
+	if err != nil || memberID == -1 {
+		apiV2Error(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	action := "add list member"
+	if c.Request.Method == http.MethodDelete {
+		action = "remove list member"
+		err = removeListMember(user.UserID, listID, memberID)
+	} else {
+		err = addListMember(user.UserID, listID, memberID)
+	}
+	if err != nil {
+		apiV2ListError(c, action, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+/*
+/api/v2/lists/<id>?username=<viewer>
+GET the list, private lists are only found by their owner
+returns: <list>
+*/
+func apiV2ListInfoHandler(c *gin.Context) {
+	list, _, ok := apiV2List(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, formatAPIList(list))
+}
+
+/*
+/api/v2/lists/<id>/members?username=<viewer>&page=<n>
+GET
+returns: {"users": [<username>, ...], "page": n, "next_page": n+1|null, "prev_page": n-1|null}
+*/
+func apiV2ListMembersHandler(c *gin.Context) {
+	list, _, ok := apiV2List(c)
+	if !ok {
+		return
+	}
+
+	page := getPage(c)
+	users, err := getListMembers(list.ListID, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch list members from DB")
+		return
+	}
+	pagination := newPagination(page, len(users))
+	if len(users) > PERPAGE {
+		users = users[:PERPAGE]
+	}
+
+	usernames := []string{}
+	for _, u := range users {
+		usernames = append(usernames, u.Username)
+	}
+	response := apiV2PageLinks(pagination)
+	response["users"] = usernames
+	c.JSON(http.StatusOK, response)
+}
+
+/*
+/api/v2/lists/<id>/msgs?username=<viewer>&page=<n>
+GET the timeline of the list, the messages of its members as <viewer> sees them
+returns: {"messages": [<message>, ...], "page": n, "next_page": n+1|null, "prev_page": n-1|null}
+*/
+func apiV2ListMessagesHandler(c *gin.Context) {
+	list, viewerID, ok := apiV2List(c)
+	if !ok {
+		return
+	}
+
+	page := getPage(c)
+	messages, err := getListMessages(list.ListID, viewerID, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch list messages from DB")
+		return
+	}
+	pagination := newPagination(page, len(messages))
+	if len(messages) > PERPAGE {
+		messages = messages[:PERPAGE]
+	}
+
+	response := apiV2PageLinks(pagination)
+	response["messages"] = formatAPIMessages(messages)
+	c.JSON(http.StatusOK, response)
+}
+
+// apiV2ScheduledError answers the errors of the pending post changes
+func apiV2ScheduledError(c *gin.Context, action string, err error) {
+	if err == errScheduledNotFound {
+		apiV2Error(c, http.StatusNotFound, "Scheduled post not found")
+		return
+	} else if err == errBlocked {
+		apiV2Error(c, http.StatusForbidden, err.Error())
+		return
+	} else if isScheduleInputError(err) {
+		apiV2Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"source":   "api_v2",
+		"endpoint": c.FullPath(),
+		"action":   action,
+		"status":   "error",
+		"error":    err.Error(),
+	}).Error("Failed to " + action)
+
+	apiV2Error(c, http.StatusInternalServerError, "Failed to "+action)
+}
+
+// apiV2ScheduledPost answers with a pending post and its images
+func apiV2ScheduledPost(c *gin.Context, code int, post ScheduledMessage) {
+	attachments, err := getScheduledAttachments([]int{post.ScheduledID})
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch images from DB")
+		return
+	}
+	c.JSON(code, formatAPIScheduled(post, attachments[post.ScheduledID]))
+}
+
+/*
+/api/v2/users/<username>/scheduled
+GET the drafts and scheduled posts of <username>, the scheduled ones first (next first), then the drafts
+returns: {"posts": [<post>, ...]}
+POST {"content": <text>, "publish_at": <unix time>, "reply_to": <id>, "attachments": [<id>, ...]}
+schedules a post, without publish_at (or with 0) it is saved as a draft
+returns: (<post>, 201), 400 if the time is in the past or too far ahead, the post is empty or there are too many pending posts
+<post> is {"id": n, "content": <text>, "reply_to": <id>, "status": "draft"|"scheduled", "publish_at": <unix time>, "error": <why publishing failed>, "attachments": [<attachment>, ...], "created_at": <unix time>, "updated_at": <unix time>}
+*/
+func apiV2ScheduledHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+
+	if c.Request.Method == http.MethodPost {
+		var requestBody struct {
+			MessageData
+			PublishAt int `json:"publish_at"`
+		}
+		if err := c.ShouldBindJSON(&requestBody); err != nil {
+			apiV2Error(c, http.StatusBadRequest, "Body must be a JSON object with the content of the post")
+			return
+		}
+
+		post := ScheduledMessage{
+			AuthorID:  user.UserID,
+			Text:      requestBody.Content,
+			ReplyToID: requestBody.ReplyTo,
+			PublishAt: requestBody.PublishAt,
+		}
+		err := validatePublishTime(post.PublishAt)
+		if err == nil {
+			err = createScheduledMessage(&post, requestBody.Attachments)
+		}
+		if err != nil {
+			apiV2ScheduledError(c, "schedule post", err)
+			return
+		}
+		apiV2ScheduledPost(c, http.StatusCreated, post)
+		return
+	}
+
+	posts, err := getScheduledMessages(user.UserID)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch scheduled posts from DB")
+		return
+	}
+	var scheduledIDs []int
+	for _, post := range posts {
+		scheduledIDs = append(scheduledIDs, post.ScheduledID)
+	}
+	attachments, err := getScheduledAttachments(scheduledIDs)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch images from DB")
+		return
+	}
+
+	formatted := []APIScheduledPost{}
+	for _, post := range posts {
+		formatted = append(formatted, formatAPIScheduled(post, attachments[post.ScheduledID]))
+	}
+	c.JSON(http.StatusOK, gin.H{"posts": formatted})
+}
+
+/*
+/api/v2/users/<username>/scheduled/<id>
+GET
+returns: <post>
+PATCH {"content": <text>, "publish_at": <unix time>}, missing fields are left as they are, a publish_at of 0 makes it a draft
+returns: <post>, 400 if a field is invalid
+DELETE
+returns: ("", 204)
+all return 404 if <username> has no such pending post
+*/
+func apiV2ScheduledPostHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+	scheduledID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		apiV2Error(c, http.StatusNotFound, "Scheduled post not found")
+		return
+	}
+
+	switch c.Request.Method {
+	case http.MethodDelete:
+		if err := deleteScheduledMessage(user.UserID, scheduledID); err != nil {
+			apiV2ScheduledError(c, "delete scheduled post", err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+		return
+	case http.MethodPatch:
+		var update struct {
+			Content   *string `json:"content"`
+			PublishAt *int    `json:"publish_at"`
+		}
+		if err := c.ShouldBindJSON(&update); err != nil {
+			apiV2Error(c, http.StatusBadRequest, "Body must be a JSON object of post fields")
+			return
+		}
+		if update.PublishAt != nil {
+			err = validatePublishTime(*update.PublishAt)
+		}
+		if err == nil {
+			err = updateScheduledMessage(user.UserID, scheduledID, ScheduledUpdate{Text: update.Content, PublishAt: update.PublishAt})
+		}
+		if err != nil {
+			apiV2ScheduledError(c, "update scheduled post", err)
+			return
+		}
+	}
+
+	post, err := getScheduledMessage(user.UserID, scheduledID)
+	if err != nil {
+		apiV2ScheduledError(c, "fetch scheduled post", err)
+		return
+	}
+	apiV2ScheduledPost(c, http.StatusOK, post)
+}
+
+/*
+/api/v2/users/<username>/scheduled/<id>/publish
+POST publishes the draft or scheduled post right away
+returns: (<message>, 201), 404 if <username> has no such pending post, 400 if the message it replies to was deleted
+*/
+func apiV2PublishScheduledHandler(c *gin.Context) {
+	user, ok := apiV2User(c)
+	if !ok {
+		return
+	}
+	scheduledID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		apiV2Error(c, http.StatusNotFound, "Scheduled post not found")
+		return
+	}
+
+	post, err := getScheduledMessage(user.UserID, scheduledID)
+	var message Message
+	if err == nil {
+		message, err = publishScheduledMessage(post, false)
+	}
+	if err != nil {
+		apiV2ScheduledError(c, "publish scheduled post", err)
+		return
+	}
+
+	published, _, err := getMessage(message.MessageID)
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch message from DB")
+		return
+	}
+	c.JSON(http.StatusCreated, formatAPIMessages([]MessageUser{published})[0])
+}
+
+/*
+/api/v2/msgs/<id>/poll?username=<username>
+GET the poll of the message, the vote counts are included once the poll is closed or <username> voted
+returns: {"options": [{"id": n, "text": <text>, "votes": n}, ...], "closes_at": <unix time>, "closed": true|false, "total_votes": n, "voted": <option id of <username>>}
+*/
+func apiV2PollHandler(c *gin.Context) {
+	message, ok := apiV2Message(c)
+	if !ok {
+		return
+	}
+
+	polls, options, err := getPolls([]int{message.MessageID})
+	if err != nil {
+		apiV2Error(c, http.StatusInternalServerError, "Failed to fetch poll from DB")
+		return
+	}
+	poll, found := polls[message.MessageID]
+	if !found {
+		apiV2Error(c, http.StatusNotFound, errPollNotFound.Error())
+		return
+	}
+
+	messages := []MessageUI{{MessageID: message.MessageID, Poll: formatPoll(poll, options[poll.MessageID])}}
+	if username := c.Query("username"); username != "" {
+		userID, err := getUserIDByUsername(username)
+		if err != nil || userID == -1 {
+			apiV2Error(c, http.StatusNotFound, "User not found")
+			return
+		}
+		markPollVotes(messages, userID)
+	}
+	c.JSON(http.StatusOK, formatAPIPoll(messages[0].Poll))
+}
+
+/*
+/api/v2/msgs/<id>/poll/votes
+POST {"username": <username>, "option": <option id>}
+votes as <username>, votes are final
+returns: ("", 204), 400 if the option is not one of the poll, 403 if the poll is closed, 409 if <username> already voted
+*/
+func apiV2VoteHandler(c *gin.Context) {
+	message, ok := apiV2Message(c)
+	if !ok {
+		return
+	}
+
+	var requestBody struct {
+		Username string `json:"username"`
+		Option   int    `json:"option"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil || requestBody.Username == "" || requestBody.Option == 0 {
+		apiV2Error(c, http.StatusBadRequest, "Body must contain the username voting and the option id")
+		return
+	}
+
+	userID, err := getUserIDByUsername(requestBody.Username)
+	if err != nil || userID == -1 {
+		apiV2Error(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	err = votePoll(userID, message.MessageID, requestBody.Option)
+	if err == errMessageNotFound || err == errPollNotFound {
+		apiV2Error(c, http.StatusNotFound, err.Error())
+		return
+	} else if err == errPollOptionNotFound {
+		apiV2Error(c, http.StatusBadRequest, err.Error())
+		return
+	} else if err == errPollClosed || err == errBlocked {
+		apiV2Error(c, http.StatusForbidden, err.Error())
+		return
+	} else if err == errAlreadyVoted {
+		apiV2Error(c, http.StatusConflict, err.Error())
+		return
+	} else if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "api_v2",
+			"endpoint": c.FullPath(),
+			"action":   "vote",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to vote")
+
+		apiV2Error(c, http.StatusInternalServerError, "Failed to vote")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
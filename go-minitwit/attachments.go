@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+ATTACHMENTS
+Images are uploaded first and attached to a message when it is posted. Uploads are re-encoded
+(dropping their EXIF data) and stored with a thumbnail in the blob store under a random token.
+Uploads that never make it into a message are removed by the cleanup job.
+*/
+
+const (
+	MAX_ATTACHMENTS          = 4
+	ATTACHMENT_MAX_BYTES     = 5 << 20
+	ATTACHMENT_THUMBNAIL_MAX = 400
+	MAX_ALT_TEXT_LENGTH      = 420
+	ATTACHMENT_ORPHAN_AGE    = time.Hour
+	ATTACHMENT_CACHE_SECONDS = 365 * 24 * 60 * 60
+)
+
+var (
+	errAttachmentNotFound  = errors.New("an attached image does not exist or is used by another message")
+	errTooManyAttachments  = fmt.Errorf("a message can have at most %d images", MAX_ATTACHMENTS)
+	errAltTextTooLong      = fmt.Errorf("the alt text can be at most %d characters", MAX_ALT_TEXT_LENGTH)
+	attachmentTokenPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+)
+
+// isUploadError tells the errors of createAttachment that are caused by the upload itself
+func isUploadError(err error) bool {
+	_, invalid := err.(errInvalidImage)
+	return invalid || err == errMissingUpload || err == errAltTextTooLong
+}
+
+// attachmentKey is the blob key of the full image ("full") or its thumbnail ("thumb")
+func attachmentKey(token string, variant string, extension string) string {
+	return "attachments/" + token + "/" + variant + extension
+}
+
+func newAttachmentToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// createAttachment stores an uploaded image of the user, it is attached by passing its id to addMessage
+func createAttachment(uploaderID int, data []byte, altText string) (Attachment, error) {
+	altText = strings.TrimSpace(altText)
+	if utf8.RuneCountInString(altText) > MAX_ALT_TEXT_LENGTH {
+		return Attachment{}, errAltTextTooLong
+	}
+
+	img, contentType, err := decodeImage(data, ATTACHMENT_MAX_BYTES)
+	if err != nil {
+		return Attachment{}, err
+	}
+	full := toRGBA(img)
+	width, height := full.Bounds().Dx(), full.Bounds().Dy()
+	thumbWidth, thumbHeight := fitWithin(width, height, ATTACHMENT_THUMBNAIL_MAX)
+
+	fullData, extension, err := encodeImage(full, contentType)
+	if err != nil {
+		return Attachment{}, err
+	}
+	thumbData, _, err := encodeImage(resizeImage(full, thumbWidth, thumbHeight), contentType)
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	token, err := newAttachmentToken()
+	if err != nil {
+		return Attachment{}, err
+	}
+	attachment := Attachment{
+		UploaderID:  uploaderID,
+		Token:       token,
+		Extension:   extension,
+		AltText:     altText,
+		Width:       width,
+		Height:      height,
+		ThumbWidth:  thumbWidth,
+		ThumbHeight: thumbHeight,
+		CreatedAt:   int(time.Now().UTC().Unix()),
+	}
+
+	// the files go first, so a stored attachment always has its files
+	if err := blobStore.Put(attachmentKey(token, "full", extension), fullData); err != nil {
+		return Attachment{}, err
+	}
+	if err := blobStore.Put(attachmentKey(token, "thumb", extension), thumbData); err != nil {
+		deleteAttachmentFiles(attachment)
+		return Attachment{}, err
+	}
+	if err := insertAttachment(&attachment); err != nil {
+		deleteAttachmentFiles(attachment)
+		return Attachment{}, err
+	}
+	return attachment, nil
+}
+
+// removeUnattached deletes uploads that are not attached to a message (yet), the ones that got
+// attached in the meantime are kept
+func removeUnattached(attachments []Attachment) {
+	for _, attachment := range attachments {
+		deleted, err := deleteUnattachedAttachment(attachment.AttachmentID)
+		if err == nil && deleted {
+			deleteAttachmentFiles(attachment)
+		}
+	}
+}
+
+// deleteAttachmentFiles is best effort, the token of a deleted attachment is never used again
+func deleteAttachmentFiles(attachment Attachment) {
+	for _, variant := range []string{"full", "thumb"} {
+		if err := blobStore.Delete(attachmentKey(attachment.Token, variant, attachment.Extension)); err != nil {
+
+			logger.WithFields(logrus.Fields{
+				"source": "attachments",
+				"action": "delete_attachment",
+				"status": "error",
+				"error":  err.Error(),
+			}).Warn("Failed to delete attachment file")
+		}
+	}
+}
+
+/*
+RENDERING
+*/
+
+type AttachmentUI struct {
+	URL      string
+	ThumbURL string
+	Alt      string
+	Width    int // of the thumbnail
+	Height   int
+}
+
+type APIAttachment struct {
+	ID           int    `json:"id"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	Alt          string `json:"alt,omitempty"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
+
+func formatAttachment(a Attachment) AttachmentUI {
+	return AttachmentUI{
+		URL:      "/" + attachmentKey(a.Token, "full", a.Extension),
+		ThumbURL: "/" + attachmentKey(a.Token, "thumb", a.Extension),
+		Alt:      a.AltText,
+		Width:    a.ThumbWidth,
+		Height:   a.ThumbHeight,
+	}
+}
+
+func formatAPIAttachment(a Attachment) APIAttachment {
+	return APIAttachment{
+		ID:           a.AttachmentID,
+		URL:          "/" + attachmentKey(a.Token, "full", a.Extension),
+		ThumbnailURL: "/" + attachmentKey(a.Token, "thumb", a.Extension),
+		Alt:          a.AltText,
+		Width:        a.Width,
+		Height:       a.Height,
+	}
+}
+
+// attachAttachments loads the images of the messages (and the messages they quote) for rendering
+func attachAttachments(messages []MessageUI) {
+	var messageIDs []int
+	for _, m := range messages {
+		messageIDs = append(messageIDs, m.MessageID)
+		if m.Quoted != nil {
+			messageIDs = append(messageIDs, m.Quoted.MessageID)
+		}
+	}
+
+	attachments, err := getAttachments(messageIDs)
+	if err != nil {
+		return
+	}
+	format := func(list []Attachment) []AttachmentUI {
+		var formatted []AttachmentUI
+		for _, a := range list {
+			formatted = append(formatted, formatAttachment(a))
+		}
+		return formatted
+	}
+	for i := range messages {
+		messages[i].Attachments = format(attachments[messages[i].MessageID])
+		if messages[i].Quoted != nil {
+			messages[i].Quoted.Attachments = format(attachments[messages[i].Quoted.MessageID])
+		}
+	}
+}
+
+// attachmentSlots numbers the image inputs of the timeline form
+func attachmentSlots() []int {
+	slots := make([]int, MAX_ATTACHMENTS)
+	for i := range slots {
+		slots[i] = i
+	}
+	return slots
+}
+
+/*
+SERVING AND CLEANUP
+*/
+
+// attachmentHandler serves /attachments/<token>/<full|thumb>.<jpg|png> from the blob store.
+// An attachment never changes, so the images are cached for a year.
+func attachmentHandler(c *gin.Context) {
+	token := c.Param("token")
+	file := c.Param("file")
+	var contentType string
+	switch {
+	case strings.HasSuffix(file, ".jpg"):
+		contentType = "image/jpeg"
+	case strings.HasSuffix(file, ".png"):
+		contentType = "image/png"
+	}
+	variant := strings.TrimSuffix(strings.TrimSuffix(file, ".jpg"), ".png")
+	if !attachmentTokenPattern.MatchString(token) || contentType == "" || (variant != "full" && variant != "thumb") {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	key := "attachments/" + token + "/" + file
+	data, modTime, err := blobStore.Get(key)
+	if err == errBlobNotFound {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Cache-Control", "public, max-age="+strconv.Itoa(ATTACHMENT_CACHE_SECONDS)+", immutable")
+	c.Header("ETag", `"`+token+"-"+variant+`"`)
+	http.ServeContent(c.Writer, c.Request, key, modTime, bytes.NewReader(data))
+}
+
+// cleanupOrphanedAttachments removes a batch of old uploads, the next run picks up what is left
+func cleanupOrphanedAttachments() {
+	before := int(time.Now().UTC().Add(-ATTACHMENT_ORPHAN_AGE).Unix())
+	orphans, err := getOrphanedAttachments(before, 500)
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source": "attachment_cleanup_job",
+			"action": "get_orphans",
+			"status": "error",
+			"error":  err.Error(),
+		}).Error("Failed to find orphaned attachments")
+
+		return
+	}
+	removeUnattached(orphans)
+}
+
+// startAttachmentCleanupJob removes uploads that were not attached within ATTACHMENT_ORPHAN_AGE,
+// every ATTACHMENT_CLEANUP_INTERVAL (default 10m). Running it on several replicas is safe.
+func startAttachmentCleanupJob() {
+	interval, err := time.ParseDuration(os.Getenv("ATTACHMENT_CLEANUP_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = 10 * time.Minute
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			cleanupOrphanedAttachments()
+		}
+	}()
+}
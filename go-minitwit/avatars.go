@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"image/png"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+AVATARS
+Uploaded avatars are cropped to a square and stored as PNG in the standard sizes.
+Every upload gets a new version, so avatar urls never change content and can be cached forever.
+Users without an avatar get a Gravatar, unless GRAVATAR=off, then a local placeholder.
+*/
+
+const (
+	AVATAR_MAX_BYTES      = 2 << 20
+	DEFAULT_AVATAR_URL    = "/static/default_avatar.svg"
+	AVATAR_CACHE_SECONDS  = 365 * 24 * 60 * 60
+	AVATAR_CONTENT_TYPE   = "image/png"
+	AVATAR_FILE_EXTENSION = ".png"
+)
+
+// the sizes avatars are stored in, pages ask for the smallest one that is large enough
+var avatarSizes = []int{48, 80, 160}
+
+func gravatarEnabled() bool {
+	return os.Getenv("GRAVATAR") != "off"
+}
+
+func gravatarURL(email string, size int) string {
+	if size <= 0 {
+		size = 80 // Default size
+	}
+
+	email = strings.ToLower(strings.TrimSpace(email))
+	hash := md5.Sum([]byte(email))
+	return fmt.Sprintf("https://www.gravatar.com/avatar/%x?d=identicon&s=%d", hash, size)
+}
+
+// avatarSize rounds a requested size up to a stored size
+func avatarSize(size int) int {
+	for _, s := range avatarSizes {
+		if s >= size {
+			return s
+		}
+	}
+	return avatarSizes[len(avatarSizes)-1]
+}
+
+func avatarKey(userID int, version int, size int) string {
+	return fmt.Sprintf("avatars/%d/%d/%d%s", userID, version, size, AVATAR_FILE_EXTENSION)
+}
+
+// avatarURL is the image shown for a user, version is the AvatarVersion of the user (0 without upload)
+func avatarURL(userID int, version int, email string, size int) string {
+	if version > 0 {
+		return "/" + avatarKey(userID, version, avatarSize(size))
+	}
+	if gravatarEnabled() {
+		return gravatarURL(email, size)
+	}
+	return DEFAULT_AVATAR_URL
+}
+
+// processAvatar validates an upload and renders it in all avatarSizes as PNG
+func processAvatar(data []byte) (map[int][]byte, error) {
+	img, _, err := decodeImage(data, AVATAR_MAX_BYTES)
+	if err != nil {
+		return nil, err
+	}
+
+	square := cropSquare(img)
+	rendered := map[int][]byte{}
+	for _, size := range avatarSizes {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, resizeImage(square, size, size)); err != nil {
+			return nil, err
+		}
+		rendered[size] = buf.Bytes()
+	}
+	return rendered, nil
+}
+
+// setAvatar stores a new avatar version and removes the files of the previous one
+func setAvatar(user User, data []byte) error {
+	rendered, err := processAvatar(data)
+	if err != nil {
+		return err
+	}
+
+	version := user.AvatarVersion + 1
+	for size, image := range rendered {
+		if err := blobStore.Put(avatarKey(user.UserID, version, size), image); err != nil {
+			return err
+		}
+	}
+	if err := setAvatarVersion(user.UserID, version); err != nil {
+		return err
+	}
+	deleteAvatarFiles(user.UserID, user.AvatarVersion)
+	return nil
+}
+
+// removeAvatar goes back to the Gravatar (or placeholder)
+func removeAvatar(user User) error {
+	if user.AvatarVersion == 0 {
+		return nil
+	}
+	if err := setAvatarVersion(user.UserID, 0); err != nil {
+		return err
+	}
+	deleteAvatarFiles(user.UserID, user.AvatarVersion)
+	return nil
+}
+
+// deleteAvatarFiles is best effort, a leftover file is never served again as the version moved on
+func deleteAvatarFiles(userID int, version int) {
+	if version == 0 {
+		return
+	}
+	for _, size := range avatarSizes {
+		if err := blobStore.Delete(avatarKey(userID, version, size)); err != nil {
+
+			logger.WithFields(logrus.Fields{
+				"source": "avatars",
+				"action": "delete_avatar",
+				"status": "error",
+				"error":  err.Error(),
+			}).Warn("Failed to delete old avatar file")
+		}
+	}
+}
+
+// avatarHandler serves /avatars/<user id>/<version>/<size>.png from the blob store.
+// The urls are versioned, so the images are cached for a year.
+func avatarHandler(c *gin.Context) {
+	userID, errUser := strconv.Atoi(c.Param("id"))
+	version, errVersion := strconv.Atoi(c.Param("version"))
+	size, errSize := strconv.Atoi(strings.TrimSuffix(c.Param("file"), AVATAR_FILE_EXTENSION))
+	if errUser != nil || errVersion != nil || errSize != nil || !strings.HasSuffix(c.Param("file"), AVATAR_FILE_EXTENSION) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	key := avatarKey(userID, version, size)
+	data, modTime, err := blobStore.Get(key)
+	if err == errBlobNotFound {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Header("Content-Type", AVATAR_CONTENT_TYPE)
+	c.Header("Cache-Control", "public, max-age="+strconv.Itoa(AVATAR_CACHE_SECONDS)+", immutable")
+	c.Header("ETag", `"`+strings.ReplaceAll(key, "/", "-")+`"`)
+	http.ServeContent(c.Writer, c.Request, key, modTime, bytes.NewReader(data))
+}
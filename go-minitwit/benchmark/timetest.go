@@ -1,113 +1,637 @@
 package main
 
+/*
+Load generator for the minitwit API.
+
+Run a load test (request-count or duration mode):
+
+	go run timetest.go -url http://localhost:8081 -workers 8 -requests 2000 -ramp 5s -out run1
+	go run timetest.go -url http://localhost:8081 -workers 8 -duration 1m -out run2
+
+Compare two runs and flag regressions (exit code 1 if any):
+
+	go run timetest.go compare run1.json run2.json -threshold 0.1
+
+Each run registers its own test users (prefixed with the run id) and writes <out>.json and <out>.csv.
+Pass -db (and -db-driver) to delete the created users and everything they wrote afterwards.
+*/
+
 import (
 	"bytes"
+	"database/sql"
+	"encoding/csv"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
+	"math/rand"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3"
 )
 
+const SIMULATOR_AUTH = "Basic c2ltdWxhdG9yOnN1cGVyX3NhZmUh"
+
+// upper bounds of the latency histogram buckets, the last bucket is everything above
+var histogramBuckets = []time.Duration{
+	1 * time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+}
+
 type Endpoint struct {
-	Method      string
-	URL         string
-	Body        interface{}
-	IterateUser bool
+	Name   string
+	Method string
+	Weight int
+	// builds the path and body for a request made as user `from` towards user `to`
+	Build func(from string, to string) (string, interface{})
 }
 
-// There order matters here so be careful with ;)
+// the weighted endpoint mix, weights are relative to each other
 var endpoints = []Endpoint{
-	{"POST", "/api/register", nil, false},
-	{"POST", "/api/msgs/testTime", map[string]string{"content": "testTime content"}, true},
-	{"POST", "/api/fllws/testTime", map[string]string{"follow": "testTime1"}, true},
-	{"GET", "/api/fllws/testTime2", nil, false},
-	{"GET", "/api/msgs/testTime1", nil, false},
-	{"GET", "/api/msgs", nil, false},
-	{"GET", "/api/latest", nil, false},
+	{"post_message", "POST", 30, func(from, to string) (string, interface{}) {
+		return "/api/msgs/" + from, map[string]string{"content": "load test message from " + from}
+	}},
+	{"follow", "POST", 10, func(from, to string) (string, interface{}) {
+		return "/api/fllws/" + from, map[string]string{"follow": to}
+	}},
+	{"unfollow", "POST", 5, func(from, to string) (string, interface{}) {
+		return "/api/fllws/" + from, map[string]string{"unfollow": to}
+	}},
+	{"get_follows", "GET", 10, func(from, to string) (string, interface{}) {
+		return "/api/fllws/" + to, nil
+	}},
+	{"get_user_messages", "GET", 20, func(from, to string) (string, interface{}) {
+		return "/api/msgs/" + to, nil
+	}},
+	{"get_messages", "GET", 20, func(from, to string) (string, interface{}) {
+		return "/api/msgs", nil
+	}},
+	{"get_latest", "GET", 5, func(from, to string) (string, interface{}) {
+		return "/api/latest", nil
+	}},
 }
 
-func MeasureResponseTime(baseURL string, numRequests int) {
-	file, err := os.Create("response_times.txt")
+type Sample struct {
+	Endpoint string
+	Status   int // 0 for transport errors
+	Latency  time.Duration
+}
+
+type EndpointStats struct {
+	Endpoint  string         `json:"endpoint"`
+	Requests  int            `json:"requests"`
+	Errors    int            `json:"errors"`
+	ErrorRate float64        `json:"error_rate"`
+	P50Ms     float64        `json:"p50_ms"`
+	P90Ms     float64        `json:"p90_ms"`
+	P99Ms     float64        `json:"p99_ms"`
+	MaxMs     float64        `json:"max_ms"`
+	Statuses  map[string]int `json:"statuses"`
+	Histogram map[string]int `json:"histogram"`
+	latencies []time.Duration
+}
+
+type RunReport struct {
+	RunID      string           `json:"run_id"`
+	Target     string           `json:"target"`
+	Workers    int              `json:"workers"`
+	Started    time.Time        `json:"started"`
+	DurationS  float64          `json:"duration_s"`
+	Requests   int              `json:"requests"`
+	Throughput float64          `json:"throughput_rps"`
+	Endpoints  []*EndpointStats `json:"endpoints"`
+}
+
+type Config struct {
+	BaseURL  string
+	Workers  int
+	Requests int
+	Duration time.Duration
+	Ramp     time.Duration
+	Users    int
+	Out      string
+	DBDriver string
+	DSN      string
+	Seed     int64
+}
+
+/*
+	REQUESTS
+*/
+
+func doRequest(client *http.Client, method string, url string, body interface{}) (int, time.Duration, error) {
+	var reader io.Reader
+	if body != nil {
+		jsonBody, err := json.Marshal(body)
+		if err != nil {
+			return 0, 0, err
+		}
+		reader = bytes.NewReader(jsonBody)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
 	if err != nil {
-		fmt.Println("Error creating file:", err)
-		return
+		return 0, 0, err
 	}
-	defer file.Close()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", SIMULATOR_AUTH)
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, time.Since(start), err
+	}
+	// drain and close the body so the connection can be reused
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode, time.Since(start), nil
+}
+
+// registerUsers creates the test users of this run, registration is measured as its own endpoint
+func registerUsers(client *http.Client, config Config, runID string) ([]string, []Sample) {
+	var users []string
+	var samples []Sample
+	for i := 0; i < config.Users; i++ {
+		username := runID + "_" + strconv.Itoa(i)
+		body := map[string]string{"username": username, "email": username + "@example.com", "pwd": "test_password"}
+		status, latency, err := doRequest(client, "POST", config.BaseURL+"/api/register", body)
+		if err != nil {
+			fmt.Printf("Error registering %s: %v\n", username, err)
+		}
+		samples = append(samples, Sample{Endpoint: "register", Status: status, Latency: latency})
+		if err == nil && status < 300 {
+			users = append(users, username)
+		}
+	}
+	return users, samples
+}
 
+func pickEndpoint(rng *rand.Rand, totalWeight int) Endpoint {
+	n := rng.Intn(totalWeight)
 	for _, endpoint := range endpoints {
-		var totalResponseTime time.Duration
-		var start time.Time
-
-		for i := 0; i < numRequests; i++ {
-			var route string
-			if endpoint.IterateUser {
-				route = endpoint.URL + strconv.Itoa(i)
-			} else {
-				route = endpoint.URL
-			}
-			if endpoint.Method == "GET" {
-				start = time.Now()
-				_, err := http.Get(baseURL + route)
-				if err != nil {
-					fmt.Printf("Error occurred while making GET request to %s: %v\n", endpoint.URL, err)
-					continue
+		if n < endpoint.Weight {
+			return endpoint
+		}
+		n -= endpoint.Weight
+	}
+	return endpoints[len(endpoints)-1]
+}
+
+// runLoad starts the workers (staggered over the ramp-up) and stops after config.Requests
+// requests or when config.Duration has passed
+func runLoad(client *http.Client, config Config, users []string) []Sample {
+	totalWeight := 0
+	for _, endpoint := range endpoints {
+		totalWeight += endpoint.Weight
+	}
+
+	var issued int64
+	deadline := time.Time{}
+	if config.Duration > 0 {
+		deadline = time.Now().Add(config.Ramp + config.Duration)
+	}
+
+	var mu sync.Mutex
+	var samples []Sample
+	var wg sync.WaitGroup
+
+	for w := 0; w < config.Workers; w++ {
+		wg.Add(1)
+		delay := time.Duration(0)
+		if config.Workers > 1 {
+			delay = config.Ramp * time.Duration(w) / time.Duration(config.Workers-1)
+		}
+
+		go func(worker int, delay time.Duration) {
+			defer wg.Done()
+			time.Sleep(delay)
+			rng := rand.New(rand.NewSource(config.Seed + int64(worker)))
+			var local []Sample
+
+			for {
+				if config.Requests > 0 && atomic.AddInt64(&issued, 1) > int64(config.Requests) {
+					break
+				}
+				if !deadline.IsZero() && time.Now().After(deadline) {
+					break
 				}
-			} else if endpoint.Method == "POST" {
-				if endpoint.URL == "/api/register" {
-					username := "testTime" + strconv.Itoa(i)
-					email := "test_email" + strconv.Itoa(i) + "@example.com"
-					pwd := "test_password" + strconv.Itoa(i)
-					body := map[string]string{"username": username, "email": email, "pwd": pwd}
-					jsonBody, err := json.Marshal(body)
-					if err != nil {
-						fmt.Printf("Error marshaling JSON for POST request to %s: %v\n", endpoint.URL, err)
-						continue
-					}
-					start = time.Now()
-					_, err = http.Post(baseURL+route, "application/json", bytes.NewBuffer(jsonBody))
-					if err != nil {
-						fmt.Printf("Error occurred while making POST request to %s: %v\n", endpoint.URL, err)
-						continue
-					}
-				} else {
-					jsonBody, err := json.Marshal(endpoint.Body)
-					if err != nil {
-						fmt.Printf("Error marshaling JSON for POST request to %s: %v\n", endpoint.URL, err)
-						continue
-					}
-					start = time.Now()
-					_, err = http.Post(baseURL+route, "application/json", bytes.NewBuffer(jsonBody))
-					if err != nil {
-						fmt.Printf("Error occurred while making POST request to %s: %v\n", endpoint.URL, err)
-						continue
-					}
+
+				endpoint := pickEndpoint(rng, totalWeight)
+				from := users[rng.Intn(len(users))]
+				to := users[rng.Intn(len(users))]
+				path, body := endpoint.Build(from, to)
+
+				status, latency, err := doRequest(client, endpoint.Method, config.BaseURL+path, body)
+				if err != nil {
+					status = 0
 				}
+				local = append(local, Sample{Endpoint: endpoint.Name, Status: status, Latency: latency})
 			}
-			totalResponseTime += time.Since(start)
+
+			mu.Lock()
+			samples = append(samples, local...)
+			mu.Unlock()
+		}(w, delay)
+	}
+	wg.Wait()
+
+	return samples
+}
+
+/*
+	STATISTICS
+*/
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(float64(len(sorted)-1)*p)]
+}
+
+func toMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func bucketLabel(i int) string {
+	if i == len(histogramBuckets) {
+		return "+Inf"
+	}
+	return "<=" + histogramBuckets[i].String()
+}
+
+func aggregate(samples []Sample) []*EndpointStats {
+	byEndpoint := map[string]*EndpointStats{}
+	var order []string
+
+	for _, sample := range samples {
+		stats, ok := byEndpoint[sample.Endpoint]
+		if !ok {
+			stats = &EndpointStats{Endpoint: sample.Endpoint, Statuses: map[string]int{}, Histogram: map[string]int{}}
+			byEndpoint[sample.Endpoint] = stats
+			order = append(order, sample.Endpoint)
+		}
+		stats.Requests++
+		stats.latencies = append(stats.latencies, sample.Latency)
+
+		if sample.Status == 0 {
+			stats.Statuses["error"]++
+		} else {
+			stats.Statuses[strconv.Itoa(sample.Status)]++
 		}
-		avgResponseTime := totalResponseTime / time.Duration(numRequests)
-		line := fmt.Sprintf("Average response time for %s: %v\n", endpoint.URL, avgResponseTime)
-		fmt.Print(line)
-		if _, err := file.WriteString(line); err != nil {
-			fmt.Println("Error writing to file:", err)
+		if sample.Status == 0 || sample.Status >= 400 {
+			stats.Errors++
+		}
+
+		bucket := len(histogramBuckets)
+		for i, upper := range histogramBuckets {
+			if sample.Latency <= upper {
+				bucket = i
+				break
+			}
 		}
+		stats.Histogram[bucketLabel(bucket)]++
+	}
+
+	var result []*EndpointStats
+	for _, name := range order {
+		stats := byEndpoint[name]
+		sort.Slice(stats.latencies, func(i, j int) bool { return stats.latencies[i] < stats.latencies[j] })
+		stats.P50Ms = toMs(percentile(stats.latencies, 0.50))
+		stats.P90Ms = toMs(percentile(stats.latencies, 0.90))
+		stats.P99Ms = toMs(percentile(stats.latencies, 0.99))
+		stats.MaxMs = toMs(stats.latencies[len(stats.latencies)-1])
+		stats.ErrorRate = float64(stats.Errors) / float64(stats.Requests)
+		result = append(result, stats)
 	}
+	return result
 }
 
-func main() {
-	if len(os.Args) < 3 {
-		fmt.Println("To use this executable please insert also the <base_URL> and <requests_number>")
-		return
+/*
+	OUTPUT
+*/
+
+func writeJSON(path string, report RunReport) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
 	}
-	baseURL := os.Args[1]
-	numRequests, err := strconv.Atoi(os.Args[2])
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func writeCSV(path string, report RunReport) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	header := []string{"endpoint", "requests", "errors", "error_rate", "p50_ms", "p90_ms", "p99_ms", "max_ms"}
+	for i := 0; i <= len(histogramBuckets); i++ {
+		header = append(header, bucketLabel(i))
+	}
+	writer.Write(header)
+
+	for _, stats := range report.Endpoints {
+		row := []string{
+			stats.Endpoint,
+			strconv.Itoa(stats.Requests),
+			strconv.Itoa(stats.Errors),
+			strconv.FormatFloat(stats.ErrorRate, 'f', 4, 64),
+			strconv.FormatFloat(stats.P50Ms, 'f', 3, 64),
+			strconv.FormatFloat(stats.P90Ms, 'f', 3, 64),
+			strconv.FormatFloat(stats.P99Ms, 'f', 3, 64),
+			strconv.FormatFloat(stats.MaxMs, 'f', 3, 64),
+		}
+		for i := 0; i <= len(histogramBuckets); i++ {
+			row = append(row, strconv.Itoa(stats.Histogram[bucketLabel(i)]))
+		}
+		writer.Write(row)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func printReport(report RunReport) {
+	fmt.Printf("\n%d requests in %.1fs (%.1f req/s) with %d workers\n", report.Requests, report.DurationS, report.Throughput, report.Workers)
+	fmt.Printf("%-20s %8s %8s %9s %9s %9s %9s  %s\n", "endpoint", "requests", "errors", "p50(ms)", "p90(ms)", "p99(ms)", "max(ms)", "statuses")
+	for _, stats := range report.Endpoints {
+		var statuses []string
+		for status, count := range stats.Statuses {
+			statuses = append(statuses, status+":"+strconv.Itoa(count))
+		}
+		sort.Strings(statuses)
+		fmt.Printf("%-20s %8d %8d %9.2f %9.2f %9.2f %9.2f  %s\n", stats.Endpoint, stats.Requests, stats.Errors,
+			stats.P50Ms, stats.P90Ms, stats.P99Ms, stats.MaxMs, strings.Join(statuses, " "))
+	}
+}
+
+/*
+	CLEANUP
+*/
+
+// tableExists tells whether the database has the table, the search tables depend on the backend
+func tableExists(db *sql.DB, driver string, table string) (bool, error) {
+	query := "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?"
+	if driver == "sqlite3" {
+		query = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+	}
+	var tables int
+	err := db.QueryRow(query, table).Scan(&tables)
+	return tables > 0, err
+}
+
+// cleanup deletes the users created by this run and every row the run wrote for them: their messages with
+// the hashtags, mentions, likes and search entries of those messages, their notifications, follows and counters
+func cleanup(config Config, runID string) error {
+	db, err := sql.Open(config.DBDriver, config.DSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	userIDs := "SELECT user_id FROM user WHERE username LIKE ?"
+	pattern := runID + "\\_%"
+	if config.DBDriver == "sqlite3" {
+		userIDs += " ESCAPE '\\'"
+	}
+	messageIDs := "SELECT message_id FROM message WHERE author_id IN (" + userIDs + ")"
+
+	// the rows pointing at messages go first, they are found through the messages
+	statements := []string{
+		"DELETE FROM message_tag WHERE message_id IN (" + messageIDs + ")",
+		"DELETE FROM message_mention WHERE message_id IN (" + messageIDs + ")",
+		"DELETE FROM message_mention WHERE user_id IN (" + userIDs + ")",
+		"DELETE FROM message_like WHERE message_id IN (" + messageIDs + ")",
+		"DELETE FROM message_like WHERE user_id IN (" + userIDs + ")",
+		"DELETE FROM notification WHERE message_id IN (" + messageIDs + ")",
+		"DELETE FROM notification WHERE user_id IN (" + userIDs + ")",
+		"DELETE FROM notification WHERE actor_id IN (" + userIDs + ")",
+	}
+	// the FTS5 index of SQLite builds and the table of the words backend, MySQL's FULLTEXT index
+	// follows the message table by itself
+	searchTables := []struct{ table, column string }{
+		{"message_search", "rowid"},
+		{"message_search_word", "message_id"},
+	}
+	for _, search := range searchTables {
+		exists, err := tableExists(db, config.DBDriver, search.table)
+		if err != nil {
+			return err
+		}
+		if exists {
+			statements = append(statements, "DELETE FROM "+search.table+" WHERE "+search.column+" IN ("+messageIDs+")")
+		}
+	}
+	statements = append(statements,
+		"DELETE FROM message WHERE author_id IN ("+userIDs+")",
+		"DELETE FROM follower WHERE who_id IN ("+userIDs+")",
+		"DELETE FROM follower WHERE whom_id IN ("+userIDs+")",
+		"DELETE FROM user_stats WHERE user_id IN ("+userIDs+")",
+	)
+	for _, statement := range statements {
+		// MySQL does not allow selecting from the table being deleted from, the subqueries only read
+		// `user` and `message`, and the statements deleting messages only read `user`
+		if _, err := db.Exec(statement, pattern); err != nil {
+			return fmt.Errorf("%s: %v", statement, err)
+		}
+	}
+	userDelete := "DELETE FROM user WHERE username LIKE ?"
+	if config.DBDriver == "sqlite3" {
+		userDelete += " ESCAPE '\\'"
+	}
+	result, err := db.Exec(userDelete, pattern)
+	if err != nil {
+		return err
+	}
+	deleted, _ := result.RowsAffected()
+	fmt.Printf("Cleanup removed %d test users of run %s\n", deleted, runID)
+	return nil
+}
+
+/*
+	COMPARE
+*/
+
+// compare diffs two JSON reports and returns the regressions: p90/p99 latencies that grew by more
+// than the threshold (relative) or error rates that grew by more than the threshold (absolute)
+func compare(basePath string, newPath string, threshold float64) ([]string, error) {
+	var base, current RunReport
+	for path, report := range map[string]*RunReport{basePath: &base, newPath: &current} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, report); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	baseByEndpoint := map[string]*EndpointStats{}
+	for _, stats := range base.Endpoints {
+		baseByEndpoint[stats.Endpoint] = stats
+	}
+
+	var regressions []string
+	fmt.Printf("%-20s %21s %21s %21s\n", "endpoint", "p90(ms) old->new", "p99(ms) old->new", "errors old->new")
+	for _, stats := range current.Endpoints {
+		old, ok := baseByEndpoint[stats.Endpoint]
+		if !ok {
+			fmt.Printf("%-20s (not in %s)\n", stats.Endpoint, basePath)
+			continue
+		}
+
+		flag := ""
+		if old.P90Ms > 0 && (stats.P90Ms-old.P90Ms)/old.P90Ms > threshold {
+			regressions = append(regressions, fmt.Sprintf("%s: p90 %.2fms -> %.2fms", stats.Endpoint, old.P90Ms, stats.P90Ms))
+			flag = " REGRESSION"
+		}
+		if old.P99Ms > 0 && (stats.P99Ms-old.P99Ms)/old.P99Ms > threshold {
+			regressions = append(regressions, fmt.Sprintf("%s: p99 %.2fms -> %.2fms", stats.Endpoint, old.P99Ms, stats.P99Ms))
+			flag = " REGRESSION"
+		}
+		if stats.ErrorRate-old.ErrorRate > threshold {
+			regressions = append(regressions, fmt.Sprintf("%s: error rate %.2f%% -> %.2f%%", stats.Endpoint, old.ErrorRate*100, stats.ErrorRate*100))
+			flag = " REGRESSION"
+		}
+
+		fmt.Printf("%-20s %9.2f -> %9.2f %9.2f -> %9.2f %8.2f%% -> %7.2f%%%s\n", stats.Endpoint,
+			old.P90Ms, stats.P90Ms, old.P99Ms, stats.P99Ms, old.ErrorRate*100, stats.ErrorRate*100, flag)
+	}
+	return regressions, nil
+}
+
+func compareMain(args []string) {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	threshold := flags.Float64("threshold", 0.1, "relative latency growth (and absolute error rate growth) flagged as regression")
+	// allow the flags after the two report paths
+	var paths []string
+	for len(args) > 0 {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) > 0 {
+			paths = append(paths, args[0])
+			args = args[1:]
+		}
+	}
+	if len(paths) != 2 {
+		fmt.Println("Usage: timetest compare <base.json> <new.json> [-threshold 0.1]")
+		os.Exit(2)
+	}
+
+	regressions, err := compare(paths[0], paths[1], *threshold)
 	if err != nil {
-		fmt.Println("Error parsing the requests number:", err)
+		fmt.Println("Error comparing runs:", err)
+		os.Exit(2)
+	}
+	if len(regressions) > 0 {
+		fmt.Printf("\n%d regression(s):\n", len(regressions))
+		for _, regression := range regressions {
+			fmt.Println("  " + regression)
+		}
+		os.Exit(1)
+	}
+	fmt.Println("\nNo regressions.")
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		compareMain(os.Args[2:])
 		return
 	}
-	MeasureResponseTime(baseURL, numRequests)
 
-	// TODO: Find a way to clear the db with all the info created!
+	var config Config
+	flag.StringVar(&config.BaseURL, "url", "http://localhost:8081", "base URL of the minitwit instance")
+	flag.IntVar(&config.Workers, "workers", 4, "number of concurrent workers")
+	flag.IntVar(&config.Requests, "requests", 1000, "total number of requests (request-count mode)")
+	flag.DurationVar(&config.Duration, "duration", 0, "run for this long instead of a fixed number of requests (duration mode)")
+	flag.DurationVar(&config.Ramp, "ramp", 0, "ramp-up period over which the workers are started")
+	flag.IntVar(&config.Users, "users", 20, "number of test users registered for the run")
+	flag.StringVar(&config.Out, "out", "response_times", "output file prefix, writes <out>.json and <out>.csv")
+	flag.StringVar(&config.DBDriver, "db-driver", "sqlite3", "database driver used for cleanup (sqlite3 or mysql)")
+	flag.StringVar(&config.DSN, "db", "", "database used for cleanup of the test users, e.g. ../tmp/minitwit_empty.db (skipped if empty)")
+	flag.Int64Var(&config.Seed, "seed", time.Now().UnixNano(), "seed of the endpoint/user choices")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout per request")
+	flag.Parse()
+
+	if config.Duration > 0 {
+		config.Requests = 0
+	}
+	if config.Workers < 1 || config.Users < 2 {
+		fmt.Println("At least 1 worker and 2 users are needed")
+		os.Exit(2)
+	}
+
+	client := &http.Client{
+		Timeout:   *timeout,
+		Transport: &http.Transport{MaxIdleConnsPerHost: config.Workers},
+	}
+	runID := "bench" + strconv.FormatInt(time.Now().Unix(), 36)
+
+	started := time.Now()
+	fmt.Printf("Run %s: registering %d test users\n", runID, config.Users)
+	users, samples := registerUsers(client, config, runID)
+	if len(users) < 2 {
+		fmt.Println("Could not register enough test users, is the server running?")
+		os.Exit(1)
+	}
+	if len(users) < config.Users {
+		fmt.Printf("Run %s: only %d of the %d test users were registered\n", runID, len(users), config.Users)
+	}
+
+	fmt.Printf("Run %s: %d workers against %s\n", runID, config.Workers, config.BaseURL)
+	loadStart := time.Now()
+	loadSamples := runLoad(client, config, users)
+	elapsed := time.Since(loadStart)
+	samples = append(samples, loadSamples...)
+
+	report := RunReport{
+		RunID:     runID,
+		Target:    config.BaseURL,
+		Workers:   config.Workers,
+		Started:   started,
+		DurationS: elapsed.Seconds(),
+		Requests:  len(samples),
+		Endpoints: aggregate(samples),
+	}
+	// registrations happen before the timed part, only the load requests count
+	report.Throughput = float64(len(loadSamples)) / elapsed.Seconds()
+	printReport(report)
+
+	if err := writeJSON(config.Out+".json", report); err != nil {
+		fmt.Println("Error writing JSON report:", err)
+	}
+	if err := writeCSV(config.Out+".csv", report); err != nil {
+		fmt.Println("Error writing CSV report:", err)
+	}
+	fmt.Printf("Reports written to %s.json and %s.csv\n", config.Out, config.Out)
+
+	if config.DSN != "" {
+		if err := cleanup(config, runID); err != nil {
+			fmt.Println("Error cleaning up test users:", err)
+		}
+	} else {
+		fmt.Printf("Skipping cleanup, pass -db to remove the users prefixed %s_\n", runID)
+	}
 }
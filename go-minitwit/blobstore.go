@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+BLOB STORE
+Uploaded files (avatars) go through the BlobStore interface, so the local filesystem
+can be swapped for an object store when the app runs on several replicas.
+*/
+
+var errBlobNotFound = errors.New("blob not found")
+
+type BlobStore interface {
+	Put(key string, data []byte) error
+	// Get returns the content of a blob and when it was written
+	Get(key string) ([]byte, time.Time, error)
+	// Delete removes a blob, deleting a missing blob is not an error
+	Delete(key string) error
+}
+
+var blobStore BlobStore
+
+// newBlobStore picks the store from BLOB_STORE, only "local" (the default) exists so far.
+// The local store writes below BLOB_DIR (default ./tmp/blobs).
+func newBlobStore() (BlobStore, error) {
+	switch os.Getenv("BLOB_STORE") {
+	case "", "local":
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			dir = "./tmp/blobs"
+		}
+		return newLocalBlobStore(dir)
+	default:
+		return nil, errors.New("unknown BLOB_STORE " + os.Getenv("BLOB_STORE"))
+	}
+}
+
+type localBlobStore struct {
+	root string
+}
+
+func newLocalBlobStore(root string) (*localBlobStore, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &localBlobStore{root: root}, nil
+}
+
+// path maps a key like "avatars/6/1/48.png" below the root, rejecting keys that would escape it
+func (s *localBlobStore) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if clean == "/" || strings.Contains(key, "..") {
+		return "", errors.New("invalid blob key " + key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(clean)), nil
+}
+
+// Put writes to a temporary file first, so readers never see a partially written blob
+func (s *localBlobStore) Put(key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *localBlobStore) Get(key string) ([]byte, time.Time, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, time.Time{}, errBlobNotFound
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, time.Time{}, errBlobNotFound
+	} else if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := os.ReadFile(path)
+	return data, info.ModTime(), err
+}
+
+func (s *localBlobStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// drop the directory with the last blob, this fails (and is ignored) while it is not empty
+	os.Remove(filepath.Dir(path))
+	return nil
+}
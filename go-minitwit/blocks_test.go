@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBlockedUsersCannotInteract(t *testing.T) {
+	for _, blockerIsAuthor := range []bool{true, false} {
+		setupTestDB(t)
+		alice := createTestUser(t, "alice")
+		bob := createTestUser(t, "bob")
+		messageID, optionID := postTestPoll(t, alice)
+		if blockerIsAuthor {
+			if err := blockUser(alice, bob); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := blockUser(bob, alice); err != nil {
+			t.Fatal(err)
+		}
+
+		actions := map[string]func() error{
+			"reply":  func() error { return addMessage("a reply", bob, messageID, nil, nil) },
+			"like":   func() error { return likeMessage(bob, messageID) },
+			"repost": func() error { return repostMessage(bob, messageID, "") },
+			"quote":  func() error { return repostMessage(bob, messageID, "a quote") },
+			"vote":   func() error { return votePoll(bob, messageID, optionID) },
+		}
+		for name, action := range actions {
+			if err := action(); err != errBlocked {
+				t.Errorf("%s (author blocked the actor: %v) returned %v, want errBlocked", name, blockerIsAuthor, err)
+			}
+		}
+	}
+}
+
+func TestNotificationsSkipBlockedActors(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	messageID := postTestMessage(t, alice, "hello")
+
+	storeNotification(NotificationEvent{Type: NOTIFY_LIKE, UserID: alice, ActorID: bob, MessageID: messageID})
+	if count, _ := countUnreadNotifications(alice); count != 1 {
+		t.Fatalf("got %d notifications before the block, want 1", count)
+	}
+
+	if err := blockUser(alice, bob); err != nil {
+		t.Fatal(err)
+	}
+	storeNotification(NotificationEvent{Type: NOTIFY_LIKE, UserID: alice, ActorID: bob, MessageID: messageID})
+	if count, _ := countUnreadNotifications(alice); count != 1 {
+		t.Fatalf("got %d notifications after the block, want still 1", count)
+	}
+}
+
+func TestBlockedUsersDontSeeTheBlockerOnOtherTimelines(t *testing.T) {
+	setupTestDB(t)
+	router := testRouter(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	carol := createTestUser(t, "carol")
+	messageID := postTestMessage(t, alice, "whispering to @carol #news")
+	if err := likeMessage(alice, messageID); err != nil {
+		t.Fatal(err)
+	}
+	if err := blockUser(alice, bob); err != nil {
+		t.Fatal(err)
+	}
+
+	pages := map[string]string{
+		"/carol/mentions": "/api/v2/users/carol/mentions",
+		"/alice/likes":    "/api/v2/users/alice/likes",
+		"/tag/news":       "/api/v2/tags/news/msgs",
+	}
+	for page, api := range pages {
+		for _, viewer := range []int{bob, carol} {
+			response := serveTestRequest(router, http.MethodGet, page, "", viewer)
+			if shown := strings.Contains(response.Body.String(), "whispering"); shown != (viewer == carol) {
+				t.Errorf("GET %s as user %d shows the message of the blocker: %v", page, viewer, shown)
+			}
+		}
+		for _, viewer := range []string{"bob", "carol"} {
+			response := serveTestRequest(router, http.MethodGet, api+"?username="+viewer, "", 0)
+			if shown := strings.Contains(response.Body.String(), "whispering"); shown != (viewer == "carol") {
+				t.Errorf("GET %s for %s shows the message of the blocker: %v", api, viewer, shown)
+			}
+		}
+	}
+}
+
+func TestMutedUsersRepostedByFollowsStayHidden(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	carol := createTestUser(t, "carol")
+	followTestUser(t, alice, bob)
+	if err := muteUser(alice, carol); err != nil {
+		t.Fatal(err)
+	}
+	muted := postTestMessage(t, carol, "from a muted user")
+	if err := repostMessage(bob, muted, ""); err != nil {
+		t.Fatal(err)
+	}
+	repost := lastTestMessageID(t, bob)
+	own := postTestMessage(t, bob, "from a followed user")
+
+	messages, err := getMyMessages(strconv.Itoa(alice), PERPAGE, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].MessageID != own {
+		t.Errorf("the home timeline has %v, want only the message of the followed user", messages)
+	}
+
+	home := &streamSubscriber{timeline: STREAM_HOME, viewerID: alice}
+	for messageID, want := range map[int]bool{repost: false, own: true} {
+		message, _, _ := getMessage(messageID)
+		repostedAuthorID := 0
+		if isPureRepost(message) {
+			repostedAuthorID = carol
+		}
+		audience, err := getStreamAudience(bob, repostedAuthorID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := home.wants(message, audience); got != want {
+			t.Errorf("the home stream wants message %d: %v, want %v", messageID, got, want)
+		}
+	}
+}
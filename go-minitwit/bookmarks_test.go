@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func bookmarkedTestIDs(t *testing.T, userID int) []int {
+	t.Helper()
+	messages, err := getBookmarkedMessages(userID, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, m := range messages {
+		ids = append(ids, m.MessageID)
+	}
+	return ids
+}
+
+func TestBookmarks(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	first := postTestMessage(t, alice, "first")
+	second := postTestMessage(t, alice, "second")
+
+	for _, messageID := range []int{first, second, first} {
+		if err := bookmarkMessage(bob, messageID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ids := bookmarkedTestIDs(t, bob); len(ids) != 2 || ids[0] != second || ids[1] != first {
+		t.Errorf("bob's bookmarks are %v, want %d then %d", ids, second, first)
+	}
+	if ids := bookmarkedTestIDs(t, alice); len(ids) != 0 {
+		t.Errorf("alice has bookmarks %v of bob", ids)
+	}
+	marked, err := getBookmarkedMessageIDs(bob, []int{first, second, second + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !marked[first] || !marked[second] || marked[second+1] {
+		t.Errorf("the bookmarked ids are %v", marked)
+	}
+
+	if err := bookmarkMessage(bob, second+100); err != errMessageNotFound {
+		t.Errorf("bookmarking a missing message returned %v, want errMessageNotFound", err)
+	}
+
+	if err := unbookmarkMessage(bob, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := unbookmarkMessage(bob, first); err != nil {
+		t.Errorf("removing a missing bookmark returned %v", err)
+	}
+	if ids := bookmarkedTestIDs(t, bob); len(ids) != 1 || ids[0] != second {
+		t.Errorf("bob's bookmarks are %v after removing one, want only %d", ids, second)
+	}
+}
+
+func TestBookmarksOfHiddenMessages(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	messageID := postTestMessage(t, alice, "soon deleted")
+	if err := bookmarkMessage(bob, messageID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dbNew.Model(&Message{}).Where("message_id = ?", messageID).Update("deleted_at", 1).Error; err != nil {
+		t.Fatal(err)
+	}
+	if ids := bookmarkedTestIDs(t, bob); len(ids) != 0 {
+		t.Errorf("the bookmarks show the deleted message")
+	}
+	if err := unbookmarkMessage(bob, messageID); err != nil {
+		t.Errorf("removing the bookmark of a deleted message returned %v", err)
+	}
+}
+
+func TestBookmarksOfPrivateMessages(t *testing.T) {
+	setupTestDB(t)
+	carol, bob, dave, messageID := privateTestAccount(t)
+
+	if err := bookmarkMessage(bob, messageID); err != errMessageNotFound {
+		t.Errorf("a non-follower bookmarking a private message got %v, want errMessageNotFound", err)
+	}
+	if err := bookmarkMessage(dave, messageID); err != nil {
+		t.Fatal(err)
+	}
+	if ids := bookmarkedTestIDs(t, dave); len(ids) != 1 {
+		t.Errorf("the follower's bookmarks are %v, want the private message", ids)
+	}
+
+	// the bookmark stays hidden once the follow ends
+	if err := dbNew.Where("who_id = ? AND whom_id = ?", dave, carol).Delete(&Follower{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if ids := bookmarkedTestIDs(t, dave); len(ids) != 0 {
+		t.Errorf("the bookmarks show a private message after the follow ended")
+	}
+}
+
+func TestBookmarkAPI(t *testing.T) {
+	setupTestDB(t)
+	router := testRouter(t)
+	alice := createTestUser(t, "alice")
+	createTestUser(t, "bob")
+	messageID := postTestMessage(t, alice, "worth keeping")
+
+	response := serveTestRequest(router, http.MethodPost, "/api/v2/users/bob/bookmarks", `{"message_id": `+strconv.Itoa(messageID)+`}`, 0)
+	if response.Code >= 300 {
+		t.Fatalf("bookmarking answered %d: %s", response.Code, response.Body.String())
+	}
+	response = serveTestRequest(router, http.MethodGet, "/api/v2/users/bob/bookmarks", "", 0)
+	if response.Code != http.StatusOK || !strings.Contains(response.Body.String(), "worth keeping") {
+		t.Errorf("listing the bookmarks answered %d: %s", response.Code, response.Body.String())
+	}
+	response = serveTestRequest(router, http.MethodPost, "/api/v2/users/bob/bookmarks", `{"message_id": 9999}`, 0)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("bookmarking a missing message answered %d, want 404", response.Code)
+	}
+}
+
+func TestBookmarkedQuotePostsShowTheQuotedMessage(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	carol := createTestUser(t, "carol")
+	original := postTestMessage(t, alice, "a striped zebra")
+	if err := repostMessage(bob, original, "look at this"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bookmarkMessage(carol, lastTestMessageID(t, bob)); err != nil {
+		t.Fatal(err)
+	}
+
+	response := serveTestRequest(testRouter(t), http.MethodGet, "/bookmarks", "", carol)
+	if response.Code != http.StatusOK {
+		t.Fatalf("got status %d", response.Code)
+	}
+	if !strings.Contains(response.Body.String(), "striped zebra") {
+		t.Errorf("the bookmarked quote post doesn't show the quoted message")
+	}
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
 
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3" // Import the SQLite3 driver
@@ -13,6 +15,7 @@ import (
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/schema"
 )
 
@@ -25,6 +28,15 @@ type User struct {
 	Username string
 	Email    string
 	PwHash   string
+	OpenDMs  bool `gorm:"column:open_dms;not null;default:false"` // anybody may send direct messages, not only followers
+	Private  bool `gorm:"not null;default:false"`                 // only approved followers see the messages
+	// profile fields, validated by validateProfile
+	DisplayName string `gorm:"size:50;not null;default:''"`
+	Bio         string `gorm:"size:160;not null;default:''"`
+	Location    string `gorm:"size:30;not null;default:''"`
+	Website     string `gorm:"size:100;not null;default:''"`
+	// 0 without an uploaded avatar, bumped by every upload so avatar urls can be cached
+	AvatarVersion int `gorm:"not null;default:0"`
 }
 
 type Latest struct {
@@ -38,36 +50,355 @@ type Message struct {
 	Text      string
 	PubDate   int
 	Flagged   int
+	ReplyToID int `gorm:"not null;default:0"`       // 0 if the message is not a reply
+	RootID    int `gorm:"not null;default:0;index"` // first message of the conversation, 0 for top level messages
+	LikeCount int `gorm:"not null;default:0"`
+	// reposts point to the reposted message, without text they are plain reposts, with text quote posts
+	RepostOfID  int `gorm:"not null;default:0;index"`
+	RepostCount int `gorm:"not null;default:0"`
+	// deleted messages are kept (soft delete) but hidden everywhere
+	DeletedAt int `gorm:"not null;default:0"`
+	EditedAt  int `gorm:"not null;default:0"`
 }
 
-type MessageUser struct {
-	MessageID int `gorm:"primaryKey"`
-	AuthorID  int
+// a previous version of an edited message
+type MessageEdit struct {
+	EditID    int `gorm:"primaryKey"`
+	MessageID int `gorm:"not null;index"`
 	Text      string
-	PubDate   int
-	Flagged   int
-	UserID    int `gorm:"primaryKey"`
-	Username  string
-	Email     string
-	PwHash    string
+	EditedAt  int // when this version was replaced
+}
+
+// the composite primary key makes repeated likes of the same message idempotent
+type MessageLike struct {
+	MessageID int `gorm:"primaryKey;autoIncrement:false"`
+	UserID    int `gorm:"primaryKey;autoIncrement:false;index"`
+	CreatedAt int
+}
+
+// a private bookmark, it is kept when the message is deleted or flagged (the message is hidden then)
+type MessageBookmark struct {
+	UserID    int `gorm:"primaryKey;autoIncrement:false"`
+	MessageID int `gorm:"primaryKey;autoIncrement:false;index"`
+	CreatedAt int
+}
+
+// tag index of the messages, the publication date is copied so trending tags can be counted without a join
+type MessageTag struct {
+	MessageID int    `gorm:"primaryKey;autoIncrement:false"`
+	Tag       string `gorm:"primaryKey;size:64;index"`
+	PubDate   int    `gorm:"index"`
+}
+
+// users mentioned in a message, only mentions of existing users are stored
+type MessageMention struct {
+	MessageID int `gorm:"primaryKey;autoIncrement:false"`
+	UserID    int `gorm:"primaryKey;autoIncrement:false;index"`
+}
+
+// IsRead because READ is a reserved word in MySQL
+type Notification struct {
+	NotificationID int    `gorm:"primaryKey"`
+	UserID         int    `gorm:"not null;index"`
+	ActorID        int    `gorm:"not null"`
+	Type           string `gorm:"size:16;not null"`
+	MessageID      int    `gorm:"not null;default:0"`
+	CreatedAt      int
+	IsRead         bool `gorm:"not null;default:false"`
+}
+
+// only the types a user changed are stored, missing types are enabled
+type NotificationPreference struct {
+	UserID  int    `gorm:"primaryKey;autoIncrement:false"`
+	Type    string `gorm:"primaryKey;size:16"`
+	Enabled bool
+}
+
+// a notification with the name of the user that caused it and the text of the message it is about
+type NotificationUser struct {
+	Notification
+	ActorUsername string
+	MessageText   string
+}
+
+// direct messages are kept apart from the public message table
+type Conversation struct {
+	ConversationID int `gorm:"primaryKey"`
+	LastMessageID  int `gorm:"not null;default:0"`
+	UpdatedAt      int `gorm:"index"`
+}
+
+// LastReadID is the last direct message of the conversation the participant has seen
+type ConversationParticipant struct {
+	ConversationID int `gorm:"primaryKey;autoIncrement:false"`
+	UserID         int `gorm:"primaryKey;autoIncrement:false;index"`
+	LastReadID     int `gorm:"not null;default:0"`
+}
+
+type DirectMessage struct {
+	DirectMessageID int `gorm:"primaryKey"`
+	ConversationID  int `gorm:"not null;index"`
+	SenderID        int `gorm:"not null"`
+	Text            string
+	PubDate         int
+}
+
+// a direct message with the name and e-mail of the sender
+type DirectMessageUser struct {
+	DirectMessage
+	Username      string
+	Email         string
+	AvatarVersion int
+}
+
+// a conversation as listed in the inbox of a user
+type ConversationSummary struct {
+	ConversationID int
+	UpdatedAt      int
+	Participants   []string // the other participants
+	LastMessage    string
+	LastSender     string
+	Unread         int
+}
+
+type MessageUser struct {
+	MessageID       int `gorm:"primaryKey"`
+	AuthorID        int
+	Text            string
+	PubDate         int
+	Flagged         int
+	ReplyToID       int
+	RootID          int
+	LikeCount       int
+	RepostOfID      int
+	RepostCount     int
+	DeletedAt       int
+	EditedAt        int
+	ReplyToUsername string
+	UserID          int `gorm:"primaryKey"`
+	Username        string
+	Email           string
+	PwHash          string
+	AvatarVersion   int
 }
 
 type MessageUI struct {
-	MessageID    int
-	AuthorID     int
+	MessageID       int
+	AuthorID        int
+	Text            string
+	PubDate         int
+	Flagged         bool
+	User            User
+	Email           string
+	Username        string
+	Profile_link    string
+	Avatar          string
+	ReplyToID       int
+	ReplyToUsername string
+	Thread_link     string
+	LikeCount       int
+	Liked           bool // liked by the logged in user
+	Bookmarked      bool // bookmarked by the logged in user
+	RepostCount     int
+	Reposted        bool       // reposted by the logged in user
+	RepostedBy      []string   // users whose repost put the message in the timeline
+	QuoteOfID       int        // for quote posts the quoted message
+	Quoted          *MessageUI // nil if the quoted message is gone or unavailable to the viewer
+	Unavailable     bool       // a reposted message the viewer may not see
+	Mentions        []string   // usernames of the mentioned users
+	EditedAt        int
+	Mine            bool // written by the logged in user
+	Editable        bool // the logged in user can still edit it
+	Attachments     []AttachmentUI
+	Highlight       []string // search terms to mark in the text
+	Poll            *PollUI  // nil without a poll
+}
+
+// a message of a conversation with its replies, Missing marks deleted or flagged messages
+type ThreadNode struct {
+	Message  MessageUI
+	Missing  bool
+	Current  bool
+	Children []*ThreadNode
+}
+
+type NotificationUI struct {
+	NotificationID int
+	Type           string
+	Description    string
+	ActorUsername  string
+	Actor_link     string
+	Message_link   string
+	MessageText    string
+	CreatedAt      int
+	IsRead         bool
+}
+
+type ConversationUI struct {
+	ConversationID int
+	Link           string
+	Participants   []string
+	LastMessage    string
+	LastSender     string
+	UpdatedAt      int
+	Unread         int
+}
+
+type DirectMessageUI struct {
+	Username     string
+	Profile_link string
+	Avatar       string
 	Text         string
 	PubDate      int
-	Flagged      bool
-	User         User
-	Email        string
+	Mine         bool // sent by the logged in user
+}
+
+type UserUI struct {
+	UserID       int
 	Username     string
 	Profile_link string
-	Gravatar     string
+	Avatar       string
+}
+
+// the profile header of the user timeline
+type ProfileUI struct {
+	Username    string
+	DisplayName string
+	Bio         string
+	Location    string
+	Website     string
+	Avatar      string
 }
 
 type Follower struct {
-	WhoID  int
-	WhomID int
+	WhoID  int `gorm:"uniqueIndex:idx_follower_pair"`
+	WhomID int `gorm:"uniqueIndex:idx_follower_pair"`
+}
+
+// a blocked user can't follow or mention the blocker, nor see the blocker's messages on their profile
+type UserBlock struct {
+	BlockerID int `gorm:"primaryKey;autoIncrement:false"`
+	BlockedID int `gorm:"primaryKey;autoIncrement:false;index"`
+	CreatedAt int
+}
+
+// the messages of muted users are left out of the muter's timeline, follows are kept
+type UserMute struct {
+	MuterID   int `gorm:"primaryKey;autoIncrement:false"`
+	MutedID   int `gorm:"primaryKey;autoIncrement:false"`
+	CreatedAt int
+}
+
+// a pending request to follow a private account
+type FollowRequest struct {
+	RequesterID int `gorm:"primaryKey;autoIncrement:false"`
+	TargetID    int `gorm:"primaryKey;autoIncrement:false;index"`
+	CreatedAt   int
+}
+
+// a named list of users curated by its owner, list names are unique per owner
+type UserList struct {
+	ListID      int    `gorm:"primaryKey"`
+	OwnerID     int    `gorm:"not null;uniqueIndex:idx_user_list_owner_name"`
+	Name        string `gorm:"size:200;not null;uniqueIndex:idx_user_list_owner_name"`
+	Description string `gorm:"size:640;not null;default:''"`
+	Private     bool   `gorm:"not null;default:false"`
+	CreatedAt   int
+}
+
+type UserListMember struct {
+	ListID    int `gorm:"primaryKey;autoIncrement:false"`
+	UserID    int `gorm:"primaryKey;autoIncrement:false;index"`
+	CreatedAt int
+}
+
+// a list with the name of its owner and its number of members
+type ListSummary struct {
+	UserList
+	OwnerUsername string
+	MemberCount   int
+}
+
+// a poll attached to a message, the vote counts are kept on the poll and its options
+type Poll struct {
+	MessageID int `gorm:"primaryKey;autoIncrement:false"`
+	ClosesAt  int `gorm:"not null"`
+	VoteCount int `gorm:"not null;default:0"`
+}
+
+type PollOption struct {
+	OptionID  int    `gorm:"primaryKey"`
+	MessageID int    `gorm:"not null;index"`
+	Position  int    `gorm:"not null"`
+	Text      string `gorm:"size:200;not null"`
+	VoteCount int    `gorm:"not null;default:0"`
+}
+
+// the composite primary key allows a single vote per user and poll
+type PollVote struct {
+	MessageID int `gorm:"primaryKey;autoIncrement:false"`
+	UserID    int `gorm:"primaryKey;autoIncrement:false"`
+	OptionID  int `gorm:"not null"`
+	CreatedAt int
+}
+
+// a message that is not published yet: a draft (PublishAt 0) or a post scheduled for PublishAt
+type ScheduledMessage struct {
+	ScheduledID int `gorm:"primaryKey"`
+	AuthorID    int `gorm:"not null;index"`
+	Text        string
+	ReplyToID   int    `gorm:"not null;default:0"`
+	PublishAt   int    `gorm:"not null;default:0;index"`
+	Error       string `gorm:"size:400;not null;default:''"` // why publishing failed, the post went back to the drafts
+	Version     int    `gorm:"not null;default:0"`           // bumped on every change, publishing claims the version it read
+	CreatedAt   int
+	UpdatedAt   int
+}
+
+// a lease on a background job, only the holder runs it until the lease expires
+type JobLease struct {
+	Name      string `gorm:"primaryKey;size:64"`
+	Holder    string `gorm:"size:128;not null"`
+	ExpiresAt int    `gorm:"not null"`
+}
+
+// the secret token in the url of the personal timeline feed of a user, replaced to revoke the old url
+type FeedToken struct {
+	UserID    int    `gorm:"primaryKey;autoIncrement:false"`
+	Token     string `gorm:"size:64;not null;uniqueIndex"`
+	CreatedAt int
+}
+
+// an uploaded image, MessageID is 0 until the image is attached to a message
+type Attachment struct {
+	AttachmentID int    `gorm:"primaryKey"`
+	MessageID    int    `gorm:"not null;default:0;index"`
+	ScheduledID  int    `gorm:"not null;default:0;index"` // the pending post holding the image until it is published
+	UploaderID   int    `gorm:"not null"`
+	Token        string `gorm:"size:32;not null;uniqueIndex"` // random, names the files in the blob store
+	Extension    string `gorm:"size:8;not null"`
+	AltText      string `gorm:"size:1700;not null;default:''"`
+	Width        int
+	Height       int
+	ThumbWidth   int
+	ThumbHeight  int
+	Position     int `gorm:"not null;default:0"` // order of the images in the message
+	CreatedAt    int `gorm:"index"`
+}
+
+// how the logged in user and a profile user blocked or muted each other
+type BlockStatus struct {
+	Blocked   bool // the logged in user blocked the profile user
+	BlockedBy bool // the profile user blocked the logged in user
+	Muted     bool
+}
+
+// counters maintained on follow/unfollow and addMessage, so profile pages don't COUNT(*) on every render
+type UserStats struct {
+	UserID         int `gorm:"primaryKey;autoIncrement:false"`
+	FollowerCount  int
+	FollowingCount int
+	MessageCount   int
 }
 
 /*
@@ -88,7 +419,7 @@ func connect_dev_DB(dsn string) (*gorm.DB, error) {
 		panic("failed to connect to database")
 	}
 
-	db.AutoMigrate(&User{}, &Message{}, &Follower{})
+	migrateDB(db)
 
 	return db, nil
 }
@@ -112,15 +443,195 @@ func connect_prod_DB() (*gorm.DB, error) {
 		return nil, err
 	}
 
-	db.AutoMigrate(&User{}, &Message{}, &Follower{})
+	migrateDB(db)
 
 	return db, nil
 }
 
+// migrateDB creates/updates the tables and backfills derived data
+func migrateDB(db *gorm.DB) {
+	hadTagIndex := db.Migrator().HasTable(&MessageTag{})
+	hadMentionIndex := db.Migrator().HasTable(&MessageMention{})
+
+	dedupedFollowers := false
+	if db.Migrator().HasTable(&Follower{}) && !db.Migrator().HasIndex(&Follower{}, "idx_follower_pair") {
+		var err error
+		if dedupedFollowers, err = dedupeFollowers(db); err != nil {
+			logMessage(err.Error())
+		}
+	}
+
+	db.AutoMigrate(&User{}, &Message{}, &Follower{}, &UserStats{}, &MessageLike{}, &MessageTag{}, &MessageMention{},
+		&Notification{}, &NotificationPreference{}, &Conversation{}, &ConversationParticipant{}, &DirectMessage{},
+		&MessageEdit{}, &UserBlock{}, &UserMute{}, &FollowRequest{}, &Attachment{}, &MessageBookmark{},
+		&UserList{}, &UserListMember{}, &ScheduledMessage{}, &JobLease{},
+		&Poll{}, &PollOption{}, &PollVote{}, &FeedToken{})
+
+	if dedupedFollowers {
+		// the counters of the users counted the duplicate follows
+		if err := db.Where("1 = 1").Delete(&UserStats{}).Error; err != nil {
+			logMessage(err.Error())
+		}
+	}
+	if err := backfillUserStats(db, 0); err != nil {
+		logMessage(err.Error())
+	}
+	if !hadTagIndex {
+		if err := backfillMessageTags(db); err != nil {
+			logMessage(err.Error())
+		}
+	}
+	if !hadMentionIndex {
+		if err := backfillMessageMentions(db); err != nil {
+			logMessage(err.Error())
+		}
+	}
+}
+
+// dedupeFollowers drops the duplicate follows written before the follower pairs were unique, so the
+// unique index can be created. It reports whether there were any
+func dedupeFollowers(db *gorm.DB) (bool, error) {
+	var duplicates int64
+	err := db.Raw("SELECT COUNT(*) FROM (SELECT who_id, whom_id FROM follower GROUP BY who_id, whom_id HAVING COUNT(*) > 1) AS duplicate").
+		Scan(&duplicates).Error
+	if err != nil || duplicates == 0 {
+		return false, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("CREATE TABLE follower_dedupe AS SELECT DISTINCT who_id, whom_id FROM follower").Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM follower").Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("INSERT INTO follower (who_id, whom_id) SELECT who_id, whom_id FROM follower_dedupe").Error; err != nil {
+			return err
+		}
+		return tx.Exec("DROP TABLE follower_dedupe").Error
+	})
+	return err == nil, err
+}
+
+// backfillMessageTags indexes the hashtags of the messages written before the tag index existed
+func backfillMessageTags(db *gorm.DB) error {
+	var messages []Message
+	return db.Where("text LIKE ?", "%#%").FindInBatches(&messages, 500, func(tx *gorm.DB, batch int) error {
+		for _, m := range messages {
+			if err := indexMessageTags(db, m); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// backfillMessageMentions resolves the mentions of the messages written before the mention index existed
+func backfillMessageMentions(db *gorm.DB) error {
+	var messages []Message
+	return db.Where("text LIKE ?", "%@%").FindInBatches(&messages, 500, func(tx *gorm.DB, batch int) error {
+		for _, m := range messages {
+			if _, err := indexMessageMentions(db, m); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// indexMessageMentions resolves the @usernames of a message against the user table and stores the mentions.
+// It returns the ids of the mentioned users.
+func indexMessageMentions(tx *gorm.DB, message Message) ([]int, error) {
+	names := parseMentions(message.Text)
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	// users that blocked the author can't be mentioned by them
+	var userIDs []int
+	err := tx.Model(&User{}).
+		Where("username IN ? AND user_id NOT IN (?)", names,
+			tx.Model(&UserBlock{}).Select("blocker_id").Where("blocked_id = ?", message.AuthorID)).
+		Pluck("user_id", &userIDs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var mentions []MessageMention
+	for _, userID := range userIDs {
+		mentions = append(mentions, MessageMention{MessageID: message.MessageID, UserID: userID})
+	}
+	if len(mentions) == 0 {
+		return nil, nil
+	}
+	return userIDs, tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&mentions).Error
+}
+
+// indexMessageTags stores the hashtags of a message in the tag index
+func indexMessageTags(tx *gorm.DB, message Message) error {
+	var tags []MessageTag
+	for _, tag := range parseHashtags(message.Text) {
+		tags = append(tags, MessageTag{MessageID: message.MessageID, Tag: tag, PubDate: message.PubDate})
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&tags).Error
+}
+
+// backfillUserStats computes the counters of users without a user_stats row.
+// With a userID only that user is (re)computed.
+func backfillUserStats(db *gorm.DB, userID int) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("backfillUserStats").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	counts := `SELECT user.user_id,
+			(SELECT COUNT(*) FROM follower WHERE follower.whom_id = user.user_id),
+			(SELECT COUNT(*) FROM follower WHERE follower.who_id = user.user_id),
+			(SELECT COUNT(*) FROM message WHERE message.author_id = user.user_id AND message.flagged = 0 AND message.deleted_at = 0 AND (message.repost_of_id = 0 OR message.text <> ''))
+		FROM user`
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if userID != 0 {
+			if err := tx.Where("user_id = ?", userID).Delete(&UserStats{}).Error; err != nil {
+				return err
+			}
+			return tx.Exec("INSERT INTO user_stats (user_id, follower_count, following_count, message_count) "+counts+" WHERE user.user_id = ?", userID).Error
+		}
+		return tx.Exec("INSERT INTO user_stats (user_id, follower_count, following_count, message_count) " + counts +
+			" WHERE NOT EXISTS (SELECT 1 FROM user_stats WHERE user_stats.user_id = user.user_id)").Error
+	})
+}
+
+// bumpUserStat adds delta to one of the user_stats counters, recomputing the row if it is missing
+func bumpUserStat(tx *gorm.DB, userID int, column string, delta int) error {
+	result := tx.Model(&UserStats{}).Where("user_id = ?", userID).UpdateColumn(column, gorm.Expr(column+" + ?", delta))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return backfillUserStats(tx, userID)
+	}
+	return nil
+}
+
 /*
 	GET DATA
 */
 
+// timelineQuery is the base of the timeline queries: messages joined with their author and,
+// for replies, the author of the parent message
+func timelineQuery() *gorm.DB {
+	return dbNew.Table("message").
+		Select("message.*, user.*, reply_user.username AS reply_to_username").
+		Joins("JOIN user ON message.author_id = user.user_id").
+		Joins("LEFT JOIN message AS reply_msg ON reply_msg.message_id = message.reply_to_id").
+		Joins("LEFT JOIN user AS reply_user ON reply_user.user_id = reply_msg.author_id")
+}
+
 // fetches all public messages for display.
 func getPublicMessages(numMsgs int) ([]MessageUser, error) {
 
@@ -130,25 +641,25 @@ func getPublicMessages(numMsgs int) ([]MessageUser, error) {
 	}))
 	defer timer.ObserveDuration()
 
+	// plain reposts would only duplicate the reposted messages
 	var messages []MessageUser
-	dbNew.Table("message").
-		Select("message.*, user.*").
-		Joins("JOIN user AS user ON message.author_id = user.user_id").
-		Where("message.flagged = ?", 0).
+	err := timelineQuery().
+		Where("message.flagged = 0 AND message.deleted_at = 0 AND (message.repost_of_id = 0 OR message.text <> '')").
+		Where("user.private = ?", false).
 		Order("message.pub_date DESC").
 		Limit(numMsgs).
-		Find(&messages)
+		Find(&messages).Error
 
-	if dbNew.Error != nil {
-		logMessage(dbNew.Error.Error())
-		return nil, dbNew.Error
+	if err != nil {
+		logMessage(err.Error())
+		return nil, err
 	}
-	fmt.Println("test")
 	return messages, nil
 }
 
-// fetches all messages from picked user
-func getUserMessages(pUserId int, numMsgs int) ([]MessageUser, error) {
+// fetches all messages from picked user as seen by viewerID (0 for anonymous viewers),
+// the messages of private users are only returned to themselves and their followers
+func getUserMessages(pUserId int, viewerID int, numMsgs int) ([]MessageUser, error) {
 	//monitoring for Prometheus
 	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
 		dbProcessDuration.WithLabelValues("getUserMessages").Observe(v)
@@ -156,17 +667,17 @@ func getUserMessages(pUserId int, numMsgs int) ([]MessageUser, error) {
 	defer timer.ObserveDuration()
 
 	var messages []MessageUser
-	dbNew.Table("message").
-		Select("message.*, user.*").
-		Joins("JOIN user ON user.user_id = message.author_id").
-		Where("user.user_id = ?", pUserId).
+	err := timelineQuery().
+		Where("user.user_id = ? AND message.deleted_at = 0", pUserId).
+		Where("user.private = ? OR user.user_id = ? OR EXISTS (SELECT 1 FROM follower WHERE follower.who_id = ? AND follower.whom_id = user.user_id)",
+			false, viewerID, viewerID).
 		Order("message.pub_date desc").
 		Limit(numMsgs).
-		Find(&messages)
+		Find(&messages).Error
 
-	if dbNew.Error != nil {
-		logMessage(dbNew.Error.Error())
-		return nil, dbNew.Error
+	if err != nil {
+		logMessage(err.Error())
+		return nil, err
 	}
 
 	return messages, nil
@@ -199,8 +710,8 @@ func checkFollowStatus(userID int, pUserID int) (bool, error) {
 	return true, nil
 }
 
-// fetches all messages for the current logged in user for 'My Timeline'
-func getMyMessages(userID string) ([]MessageUser, error) {
+// fetches the messages for the current logged in user for 'My Timeline', limit -1 fetches all of them
+func getMyMessages(userID string, limit int, offset int) ([]MessageUser, error) {
 
 	//monitoring for Prometheus
 	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
@@ -223,274 +734,3392 @@ func getMyMessages(userID string) ([]MessageUser, error) {
 	}
 
 	// Use the retrieved followerIDs in the main query
-	dbNew.Table("message").
-		Select("message.*, user.*").
-		Joins("JOIN user ON message.author_id = user.user_id").
-		Where("message.flagged = ? AND (user.user_id = ? OR user.user_id IN (?))", 0, userID, followerIDs).
+	err := timelineQuery().
+		Where("message.flagged = 0 AND message.deleted_at = 0 AND (user.user_id = ? OR user.user_id IN (?))", userID, followerIDs).
+		Where("message.author_id NOT IN (?)", dbNew.Model(&UserMute{}).Select("muted_id").Where("muter_id = ?", userID)).
+		// plain reposts of muted users' messages are muted as well
+		Where("message.repost_of_id = 0 OR message.text <> '' OR NOT EXISTS (SELECT 1 FROM message AS original JOIN user_mute ON user_mute.muted_id = original.author_id WHERE original.message_id = message.repost_of_id AND user_mute.muter_id = ?)", userID).
 		Order("message.pub_date DESC").
-		Find(&messages)
+		Limit(limit).
+		Offset(offset).
+		Find(&messages).Error
 
-	if dbNew.Error != nil {
-		logMessage(dbNew.Error.Error())
-		return nil, dbNew.Error
+	if err != nil {
+		logMessage(err.Error())
+		return nil, err
 	}
 
 	return messages, nil
 }
 
-// fetches a user by their ID
-func getUserIDByUsername(userName string) (int, error) {
+// getMessage fetches a single message with its author, found is false if it does not exist
+func getMessage(messageID int) (MessageUser, bool, error) {
 
 	//monitoring for Prometheus
 	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
-		dbProcessDuration.WithLabelValues("getUserIDByUsername").Observe(v)
+		dbProcessDuration.WithLabelValues("getMessage").Observe(v)
 	}))
 	defer timer.ObserveDuration()
 
-	var user User
-	dbNew.Where("username = ?", userName).First(&user)
+	var messages []MessageUser
+	err := timelineQuery().
+		Where("message.message_id = ?", messageID).
+		Limit(1).
+		Find(&messages).Error
 
-	if user.UserID == 0 {
-		return -1, nil
-	} else {
-		return user.UserID, nil
+	if err != nil {
+		logMessage(err.Error())
+		return MessageUser{}, false, err
 	}
+	if len(messages) == 0 {
+		return MessageUser{}, false, nil
+	}
+	return messages[0], true, nil
 }
 
-// fetches a username by their ID
-func getUserNameByUserID(userID string) (string, error) {
+// getTaggedMessages fetches a page of the messages with the given hashtag as seen by viewerID
+// (0 for anonymous viewers), newest first
+func getTaggedMessages(tag string, viewerID int, limit int, offset int) ([]MessageUser, error) {
 
 	//monitoring for Prometheus
 	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
-		dbProcessDuration.WithLabelValues("getUserByUserID").Observe(v)
+		dbProcessDuration.WithLabelValues("getTaggedMessages").Observe(v)
 	}))
 	defer timer.ObserveDuration()
 
-	var user User
-	dbNew.First(&user, userID)
+	// tag pages are public like the public timeline, so private accounts are left out
+	query := timelineQuery().
+		Joins("JOIN message_tag ON message_tag.message_id = message.message_id").
+		Where("message_tag.tag = ? AND message.flagged = 0 AND message.deleted_at = 0", tag).
+		Where("user.private = ?", false)
+	query = whereVisibleTo(query, viewerID)
 
-	if dbNew.Error != nil {
-		logMessage(dbNew.Error.Error())
-		return "", dbNew.Error
+	var messages []MessageUser
+	err := query.
+		Order("message.pub_date DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&messages).Error
+
+	if err != nil {
+		logMessage(err.Error())
 	}
+	return messages, err
+}
 
-	return user.Username, nil
+// whereVisibleTo restricts a timeline query to the messages viewerID (0 for anonymous viewers) may see:
+// private accounts only for themselves and their followers, and nothing across a block
+func whereVisibleTo(query *gorm.DB, viewerID int) *gorm.DB {
+	query = query.Where("user.private = ? OR user.user_id = ? OR EXISTS (SELECT 1 FROM follower WHERE follower.who_id = ? AND follower.whom_id = user.user_id)",
+		false, viewerID, viewerID)
+	if viewerID != 0 {
+		query = query.Where("NOT EXISTS (SELECT 1 FROM user_block WHERE (user_block.blocker_id = ? AND user_block.blocked_id = user.user_id) OR (user_block.blocker_id = user.user_id AND user_block.blocked_id = ?))",
+			viewerID, viewerID)
+	}
+	return query
 }
 
-func getUserByUsername(userName string) (User, error) {
+// searchMessages fetches a page of the messages matching the query as seen by viewerID (0 for anonymous
+// viewers), newest first
+func searchMessages(query SearchQuery, viewerID int, limit int, offset int) ([]MessageUser, error) {
 
 	//monitoring for Prometheus
 	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
-		dbProcessDuration.WithLabelValues("getUserByUsername").Observe(v)
+		dbProcessDuration.WithLabelValues("searchMessages").Observe(v)
 	}))
 	defer timer.ObserveDuration()
 
-	var user User
-	dbNew.Where("username = ?", userName).First(&user)
-
-	if dbNew.Error != nil {
-		logMessage(dbNew.Error.Error())
-		return user, dbNew.Error
+	// plain reposts have no text of their own
+	tx := timelineQuery().
+		Where("message.flagged = 0 AND message.deleted_at = 0 AND message.text <> ''")
+	tx = whereVisibleTo(tx, viewerID)
+	if query.From != "" {
+		tx = tx.Where("user.username = ?", query.From)
+	}
+	if query.Tag != "" {
+		tx = tx.Where("EXISTS (SELECT 1 FROM message_tag WHERE message_tag.message_id = message.message_id AND message_tag.tag = ?)", query.Tag)
+	}
+	if query.Since != 0 {
+		tx = tx.Where("message.pub_date >= ?", query.Since)
+	}
+	if query.Until != 0 {
+		tx = tx.Where("message.pub_date < ?", query.Until)
+	}
+	if len(query.Terms) > 0 {
+		tx = searchIndex.Match(tx, query.Terms)
 	}
 
-	return user, nil
-}
-
-func getLatest() (int, error) {
-	var latest Latest
-	dbNew.Where("latest_id = 1").First(&latest)
-	return latest.Value, nil
-}
+	var messages []MessageUser
+	err := tx.Order("message.pub_date DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&messages).Error
 
-func updateLatest(commandID int) error {
-	dbNew.Save(&Latest{LatestID: 1, Value: commandID})
-	if dbNew.Error != nil {
-		logMessage(dbNew.Error.Error())
-		return dbNew.Error
+	if err != nil {
+		logMessage(err.Error())
 	}
-	return nil
+	return messages, err
 }
 
-/*
-	POST DATA
-*/
+// searchUsers fetches a page of the users whose username or display name contains all terms,
+// shortest usernames (the closest matches) first
+func searchUsers(terms []string, limit int, offset int) ([]User, error) {
 
-// registers a new user
-func registerUser(userName string, email string, password [16]byte) error {
 	//monitoring for Prometheus
 	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
-		dbProcessDuration.WithLabelValues("registerUser").Observe(v)
+		dbProcessDuration.WithLabelValues("searchUsers").Observe(v)
 	}))
 	defer timer.ObserveDuration()
 
-	pwHashString := hex.EncodeToString(password[:])
-
-	newUser := User{
-		Username: userName,
-		Email:    email,
-		PwHash:   pwHashString,
+	var users []User
+	if len(terms) == 0 {
+		return users, nil
 	}
 
-	dbNew.Create(&newUser)
-
-	if dbNew.Error != nil {
-		logMessage(dbNew.Error.Error())
-		return dbNew.Error
+	escaper := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+	tx := dbNew.Model(&User{})
+	for _, term := range terms {
+		pattern := "%" + escaper.Replace(term) + "%"
+		tx = tx.Where("LOWER(username) LIKE ? ESCAPE '!' OR LOWER(display_name) LIKE ? ESCAPE '!'", pattern, pattern)
 	}
+	err := tx.Order("LENGTH(username), username").
+		Limit(limit).
+		Offset(offset).
+		Find(&users).Error
 
-	return nil
+	if err != nil {
+		logMessage(err.Error())
+	}
+	return users, err
 }
 
-// adds a new message to the database
-func addMessage(text string, author_id int) error {
+// getMentioningMessages fetches a page of the messages mentioning the user as seen by viewerID
+// (0 for anonymous viewers), newest first
+func getMentioningMessages(userID int, viewerID int, limit int, offset int) ([]MessageUser, error) {
+
 	//monitoring for Prometheus
 	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
-		dbProcessDuration.WithLabelValues("addMessage").Observe(v)
+		dbProcessDuration.WithLabelValues("getMentioningMessages").Observe(v)
 	}))
 	defer timer.ObserveDuration()
 
-	currentTime := time.Now().UTC()
-	unixTimestamp := currentTime.Unix()
-
-	newMessage := Message{
-		AuthorID: author_id,
-		Text:     text,
-		PubDate:  int(unixTimestamp),
-		Flagged:  0, // Default to false for flagged
-	}
+	query := timelineQuery().
+		Joins("JOIN message_mention ON message_mention.message_id = message.message_id").
+		Where("message_mention.user_id = ? AND message.flagged = 0 AND message.deleted_at = 0", userID)
+	query = whereVisibleTo(query, viewerID)
 
-	dbNew.Create(&newMessage)
+	var messages []MessageUser
+	err := query.
+		Order("message.pub_date DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&messages).Error
 
-	if dbNew.Error != nil {
-		logMessage(dbNew.Error.Error())
-		return dbNew.Error
+	if err != nil {
+		logMessage(err.Error())
 	}
-
-	return nil
+	return messages, err
 }
 
-// followUser adds a new follower to the database
-func followUser(userID string, profileUserID string) error {
+// getMentionedUsernames returns the usernames mentioned by each of the given messages
+func getMentionedUsernames(messageIDs []int) (map[int][]string, error) {
 
 	//monitoring for Prometheus
 	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
-		dbProcessDuration.WithLabelValues("followUser").Observe(v)
+		dbProcessDuration.WithLabelValues("getMentionedUsernames").Observe(v)
 	}))
 	defer timer.ObserveDuration()
 
-	userIDInt, errz := strconv.Atoi(userID)
-	profileUserIDInt, errx := strconv.Atoi(profileUserID)
+	mentions := map[int][]string{}
+	if len(messageIDs) == 0 {
+		return mentions, nil
+	}
 
-	if errz != nil {
-		logMessage(errz.Error())
-		return errz
-	} else if errx != nil {
-		logMessage(errx.Error())
-		return errx
+	var rows []struct {
+		MessageID int
+		Username  string
+	}
+	err := dbNew.Table("message_mention").
+		Select("message_mention.message_id, user.username").
+		Joins("JOIN user ON user.user_id = message_mention.user_id").
+		Where("message_mention.message_id IN ?", messageIDs).
+		Scan(&rows).Error
+	if err != nil {
+		logMessage(err.Error())
+		return mentions, err
 	}
 
-	// following relationship already exists
-	var count int64
-	dbNew.Model(&Follower{}).Where("who_id = ? AND whom_id = ?", userIDInt, profileUserIDInt).Count(&count)
-	if count > 0 {
-		return nil
+	for _, row := range rows {
+		mentions[row.MessageID] = append(mentions[row.MessageID], row.Username)
 	}
+	return mentions, nil
+}
 
-	newFollower := Follower{
-		WhoID:  userIDInt,
-		WhomID: profileUserIDInt,
+// countTagsSince returns the most used tags of messages published after since
+func countTagsSince(since int, limit int) ([]TrendingTag, error) {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("countTagsSince").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	// trending is shown to everybody, so the tags of private accounts are left out
+	var tags []TrendingTag
+	err := dbNew.Table("message_tag").
+		Select("message_tag.tag AS tag, COUNT(*) AS count").
+		Joins("JOIN message ON message.message_id = message_tag.message_id").
+		Joins("JOIN user ON user.user_id = message.author_id").
+		Where("message_tag.pub_date >= ? AND message.flagged = 0 AND message.deleted_at = 0", since).
+		Where("user.private = ?", false).
+		Group("message_tag.tag").
+		Order("count DESC, message_tag.tag").
+		Limit(limit).
+		Scan(&tags).Error
+
+	if err != nil {
+		logMessage(err.Error())
 	}
+	return tags, err
+}
 
-	dbNew.Create(&newFollower)
+// getMessagesByIDs fetches the given messages with their authors as seen by viewerID (0 for anonymous
+// viewers), keyed by message id. Messages the viewer may not see are left out.
+func getMessagesByIDs(messageIDs []int, viewerID int) (map[int]MessageUser, error) {
 
-	if dbNew.Error != nil {
-		logMessage(dbNew.Error.Error())
-		return dbNew.Error
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getMessagesByIDs").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	messagesByID := map[int]MessageUser{}
+	if len(messageIDs) == 0 {
+		return messagesByID, nil
 	}
 
-	return nil
+	var messages []MessageUser
+	err := whereVisibleTo(timelineQuery(), viewerID).
+		Where("message.message_id IN ?", messageIDs).
+		Find(&messages).Error
+
+	if err != nil {
+		logMessage(err.Error())
+		return messagesByID, err
+	}
+	for _, m := range messages {
+		messagesByID[m.MessageID] = m
+	}
+	return messagesByID, nil
 }
 
-// unfollowUser removes a follower from the database
-func unfollowUser(userID string, profileUserID string) error {
+// getThreadMessages fetches the root message of a conversation and all its replies as seen by viewerID
+// (0 for anonymous viewers), oldest first
+func getThreadMessages(rootID int, viewerID int) ([]MessageUser, error) {
 
 	//monitoring for Prometheus
 	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
-		dbProcessDuration.WithLabelValues("unfollowUser").Observe(v)
+		dbProcessDuration.WithLabelValues("getThreadMessages").Observe(v)
 	}))
 	defer timer.ObserveDuration()
 
-	userIDInt, errz := strconv.Atoi(userID)
-	profileUserIDInt, errx := strconv.Atoi(profileUserID)
+	var messages []MessageUser
+	err := whereVisibleTo(timelineQuery(), viewerID).
+		Where("message.message_id = ? OR message.root_id = ?", rootID, rootID).
+		Order("message.pub_date, message.message_id").
+		Find(&messages).Error
 
-	if errz != nil {
-		logMessage(errz.Error())
-		return errz
-	} else if errx != nil {
-		logMessage(errx.Error())
-		return errx
+	if err != nil {
+		logMessage(err.Error())
+		return nil, err
 	}
+	return messages, nil
+}
 
-	dbNew.Where("who_id = ? AND whom_id = ?", userIDInt, profileUserIDInt).Delete(&Follower{})
+// getLikedMessages fetches the messages liked by a user as seen by viewerID (0 for anonymous viewers),
+// most recently liked first
+func getLikedMessages(userID int, viewerID int, limit int, offset int) ([]MessageUser, error) {
 
-	if dbNew.Error != nil {
-		logMessage(dbNew.Error.Error())
-		return dbNew.Error
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getLikedMessages").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	query := timelineQuery().
+		Joins("JOIN message_like ON message_like.message_id = message.message_id").
+		Where("message_like.user_id = ? AND message.flagged = 0 AND message.deleted_at = 0", userID)
+	query = whereVisibleTo(query, viewerID)
+
+	var messages []MessageUser
+	err := query.
+		Order("message_like.created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&messages).Error
+
+	if err != nil {
+		logMessage(err.Error())
+		return nil, err
 	}
+	return messages, nil
+}
 
-	return nil
+// getBookmarkedMessages fetches a page of the messages bookmarked by the user, most recently bookmarked first.
+// Bookmarks of deleted or flagged messages, and of messages the user may not see anymore, are skipped but kept.
+func getBookmarkedMessages(userID int, limit int, offset int) ([]MessageUser, error) {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getBookmarkedMessages").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	query := timelineQuery().
+		Joins("JOIN message_bookmark ON message_bookmark.message_id = message.message_id").
+		Where("message_bookmark.user_id = ? AND message.flagged = 0 AND message.deleted_at = 0", userID)
+	query = whereVisibleTo(query, userID)
+
+	var messages []MessageUser
+	err := query.
+		Order("message_bookmark.created_at DESC, message_bookmark.message_id DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&messages).Error
+
+	if err != nil {
+		logMessage(err.Error())
+		return nil, err
+	}
+	return messages, nil
 }
 
-/*
-//DEPRECATED
-// getFollowers fetches up to `limit` followers for the user identified by userID
-func getFollowers(userID string, limit int) ([]User, error) {
+// getLikingUsers fetches the users that liked a message, most recent first
+func getLikingUsers(messageID int, limit int, offset int) ([]User, error) {
 
 	//monitoring for Prometheus
 	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
-		dbProcessDuration.WithLabelValues("getFollowers").Observe(v)
+		dbProcessDuration.WithLabelValues("getLikingUsers").Observe(v)
 	}))
 	defer timer.ObserveDuration()
 
 	var users []User
-
-	dbNew.
+	err := dbNew.
 		Select("user.*").
-		Joins("INNER JOIN follower ON user.user_id = follower.who_id").
-		Where("follower.whom_id = ?", userID).
+		Joins("INNER JOIN message_like ON user.user_id = message_like.user_id").
+		Where("message_like.message_id = ?", messageID).
+		Order("message_like.created_at DESC").
 		Limit(limit).
-		Find(&users)
+		Offset(offset).
+		Find(&users).Error
+
+	if err != nil {
+		logMessage(err.Error())
+		return nil, err
+	}
+	return users, nil
+}
+
+// getLikedMessageIDs returns which of the given messages the user liked
+func getLikedMessageIDs(userID int, messageIDs []int) (map[int]bool, error) {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getLikedMessageIDs").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	liked := map[int]bool{}
+	if userID == 0 || len(messageIDs) == 0 {
+		return liked, nil
+	}
+
+	var ids []int
+	err := dbNew.Model(&MessageLike{}).
+		Where("user_id = ? AND message_id IN ?", userID, messageIDs).
+		Pluck("message_id", &ids).Error
+	if err != nil {
+		logMessage(err.Error())
+		return liked, err
+	}
+
+	for _, id := range ids {
+		liked[id] = true
+	}
+	return liked, nil
+}
+
+// getBookmarkedMessageIDs returns which of the given messages the user bookmarked
+func getBookmarkedMessageIDs(userID int, messageIDs []int) (map[int]bool, error) {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getBookmarkedMessageIDs").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	bookmarked := map[int]bool{}
+	if userID == 0 || len(messageIDs) == 0 {
+		return bookmarked, nil
+	}
+
+	var ids []int
+	err := dbNew.Model(&MessageBookmark{}).
+		Where("user_id = ? AND message_id IN ?", userID, messageIDs).
+		Pluck("message_id", &ids).Error
+	if err != nil {
+		logMessage(err.Error())
+		return bookmarked, err
+	}
+
+	for _, id := range ids {
+		bookmarked[id] = true
+	}
+	return bookmarked, nil
+}
+
+// getRepostedMessageIDs returns which of the given messages the user reposted (without commentary)
+func getRepostedMessageIDs(userID int, messageIDs []int) (map[int]bool, error) {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getRepostedMessageIDs").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	reposted := map[int]bool{}
+	if userID == 0 || len(messageIDs) == 0 {
+		return reposted, nil
+	}
+
+	var ids []int
+	err := dbNew.Model(&Message{}).
+		Where("author_id = ? AND text = '' AND repost_of_id IN ?", userID, messageIDs).
+		Pluck("repost_of_id", &ids).Error
+	if err != nil {
+		logMessage(err.Error())
+		return reposted, err
+	}
+
+	for _, id := range ids {
+		reposted[id] = true
+	}
+	return reposted, nil
+}
+
+// fetches a user by their ID
+func getUserIDByUsername(userName string) (int, error) {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getUserIDByUsername").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var user User
+	dbNew.Where("username = ?", userName).First(&user)
+
+	if user.UserID == 0 {
+		return -1, nil
+	} else {
+		return user.UserID, nil
+	}
+}
+
+// fetches a username by their ID
+func getUserNameByUserID(userID string) (string, error) {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getUserByUserID").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var user User
+	dbNew.First(&user, userID)
 
 	if dbNew.Error != nil {
 		logMessage(dbNew.Error.Error())
-		return users, dbNew.Error
+		return "", dbNew.Error
 	}
-	return users, nil
+
+	return user.Username, nil
 }
-*/
 
-// getFollowing fetches up to `limit` users that the user identified by userID is following
-func getFollowing(userID string, limit int) ([]User, error) {
+func getUserByUsername(userName string) (User, error) {
 
 	//monitoring for Prometheus
 	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
-		dbProcessDuration.WithLabelValues("getFollowing").Observe(v)
+		dbProcessDuration.WithLabelValues("getUserByUsername").Observe(v)
 	}))
 	defer timer.ObserveDuration()
 
-	var users []User
+	var user User
+	dbNew.Where("username = ?", userName).First(&user)
 
-	dbNew.
-		Select("user.*").
-		Joins("INNER JOIN follower ON user.user_id = follower.whom_id").
-		Where("follower.who_id = ?", userID).
-		Limit(limit).
-		Find(&users)
+	if dbNew.Error != nil {
+		logMessage(dbNew.Error.Error())
+		return user, dbNew.Error
+	}
+
+	return user, nil
+}
+
+func getLatest() (int, error) {
+	var latest Latest
+	dbNew.Where("latest_id = 1").First(&latest)
+	return latest.Value, nil
+}
 
+func updateLatest(commandID int) error {
+	dbNew.Save(&Latest{LatestID: 1, Value: commandID})
 	if dbNew.Error != nil {
 		logMessage(dbNew.Error.Error())
-		return users, dbNew.Error
+		return dbNew.Error
 	}
+	return nil
+}
 
-	return users, nil
+/*
+	POST DATA
+*/
+
+// registers a new user
+func registerUser(userName string, email string, password [16]byte) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("registerUser").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	pwHashString := hex.EncodeToString(password[:])
+
+	newUser := User{
+		Username: userName,
+		Email:    email,
+		PwHash:   pwHashString,
+	}
+
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&newUser).Error; err != nil {
+			return err
+		}
+		// ids can be reused after the tables were cleaned, so never trust an old stats row
+		return tx.Save(&UserStats{UserID: newUser.UserID}).Error
+	})
+
+	if err != nil {
+		logMessage(err.Error())
+		return err
+	}
+
+	return nil
+}
+
+var errReplyTargetNotFound = errors.New("the message you reply to does not exist")
+
+// adds a new message to the database, replyToID is the message it replies to (0 for none)
+// addMessage posts a message, attachmentIDs are images uploaded by the author that are not attached yet
+func addMessage(text string, author_id int, replyToID int, attachmentIDs []int, poll *PollInput) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("addMessage").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	if err := validateMessageText(text); err != nil {
+		return err
+	}
+
+	currentTime := time.Now().UTC()
+	unixTimestamp := currentTime.Unix()
+
+	newMessage := Message{
+		AuthorID: author_id,
+		Text:     text,
+		PubDate:  int(unixTimestamp),
+		Flagged:  0, // Default to false for flagged
+	}
+
+	var parentAuthorID int
+	var mentionedIDs []int
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		var err error
+		parentAuthorID, mentionedIDs, err = insertMessage(tx, &newMessage, replyToID)
+		if err != nil {
+			return err
+		}
+		if err := attachToMessage(tx, newMessage.MessageID, author_id, attachmentIDs); err != nil {
+			return err
+		}
+		return createPoll(tx, newMessage.MessageID, poll)
+	})
+
+	if err != nil {
+		logMessage(err.Error())
+		return err
+	}
+
+	notifyNewMessage(newMessage, parentAuthorID, mentionedIDs)
+	streamNewMessage(newMessage.MessageID)
+	return nil
+}
+
+// insertMessage stores a new message as a reply to replyToID (0 for none) and indexes it,
+// it returns the author of the parent message and the mentioned users to notify
+func insertMessage(tx *gorm.DB, newMessage *Message, replyToID int) (int, []int, error) {
+	var parentAuthorID int
+	if replyToID != 0 {
+		parent, err := findReplyTarget(tx, newMessage.AuthorID, replyToID)
+		if err != nil {
+			return 0, nil, err
+		}
+		parentAuthorID = parent.AuthorID
+		newMessage.ReplyToID = parent.MessageID
+		newMessage.RootID = parent.RootID
+		if newMessage.RootID == 0 {
+			newMessage.RootID = parent.MessageID
+		}
+	}
+
+	if err := tx.Create(newMessage).Error; err != nil {
+		return 0, nil, err
+	}
+	if err := searchIndex.Index(tx, *newMessage); err != nil {
+		return 0, nil, err
+	}
+	if err := indexMessageTags(tx, *newMessage); err != nil {
+		return 0, nil, err
+	}
+	mentionedIDs, err := indexMessageMentions(tx, *newMessage)
+	if err != nil {
+		return 0, nil, err
+	}
+	return parentAuthorID, mentionedIDs, bumpUserStat(tx, newMessage.AuthorID, "message_count", 1)
+}
+
+// findReplyTarget fetches the message a reply goes to, errReplyTargetNotFound if it is gone
+func findReplyTarget(tx *gorm.DB, userID int, replyToID int) (Message, error) {
+	var parent Message
+	result := tx.Where("message_id = ? AND flagged = 0 AND deleted_at = 0", replyToID).Limit(1).Find(&parent)
+	if result.Error != nil {
+		return parent, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return parent, errReplyTargetNotFound
+	}
+	if parent.RepostOfID != 0 && parent.Text == "" {
+		// a plain repost has nothing to reply to, the reply belongs to the reposted message
+		var reposted Message
+		result = tx.Where("message_id = ? AND flagged = 0 AND deleted_at = 0", parent.RepostOfID).Limit(1).Find(&reposted)
+		if result.Error != nil {
+			return parent, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return parent, errReplyTargetNotFound
+		}
+		parent = reposted
+	}
+
+	// the messages of private accounts don't exist for those who may not see them
+	visible, err := canSeeAuthor(tx, userID, parent.AuthorID)
+	if err != nil {
+		return parent, err
+	}
+	if !visible {
+		return parent, errReplyTargetNotFound
+	}
+	blocked, err := isBlockedEitherWay(tx, userID, parent.AuthorID)
+	if err != nil {
+		return parent, err
+	}
+	if blocked {
+		return parent, errBlocked
+	}
+	return parent, nil
+}
+
+// notifyNewMessage notifies the author of the parent message and the mentioned users,
+// a reply that also mentions the parent author only causes the reply notification
+func notifyNewMessage(message Message, parentAuthorID int, mentionedIDs []int) {
+	if parentAuthorID != 0 {
+		notify(NotificationEvent{Type: NOTIFY_REPLY, UserID: parentAuthorID, ActorID: message.AuthorID, MessageID: message.MessageID, CreatedAt: message.PubDate})
+	}
+	for _, userID := range mentionedIDs {
+		if userID != parentAuthorID {
+			notify(NotificationEvent{Type: NOTIFY_MENTION, UserID: userID, ActorID: message.AuthorID, MessageID: message.MessageID, CreatedAt: message.PubDate})
+		}
+	}
+}
+
+// followUser adds a new follower to the database
+func followUser(userID string, profileUserID string) error {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("followUser").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	userIDInt, errz := strconv.Atoi(userID)
+	profileUserIDInt, errx := strconv.Atoi(profileUserID)
+
+	if errz != nil {
+		logMessage(errz.Error())
+		return errz
+	} else if errx != nil {
+		logMessage(errx.Error())
+		return errx
+	}
+
+	blocked, err := isBlockedEitherWay(dbNew, userIDInt, profileUserIDInt)
+	if err != nil {
+		logMessage(err.Error())
+		return err
+	}
+	if blocked {
+		return errBlocked
+	}
+
+	newFollower := Follower{
+		WhoID:  userIDInt,
+		WhomID: profileUserIDInt,
+	}
+
+	// following twice, even concurrently, has no effect
+	followed := false
+	err = dbNew.Transaction(func(tx *gorm.DB) error {
+		var err error
+		followed, err = addFollow(tx, newFollower)
+		return err
+	})
+
+	if err != nil {
+		logMessage(err.Error())
+		return err
+	}
+
+	if followed {
+		notify(NotificationEvent{Type: NOTIFY_FOLLOW, UserID: profileUserIDInt, ActorID: userIDInt})
+	}
+	return nil
+}
+
+// addFollow inserts the follow, if it doesn't exist yet, and updates both counters.
+// It reports whether the follow is new
+func addFollow(tx *gorm.DB, follow Follower) (bool, error) {
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&follow)
+	if result.Error != nil || result.RowsAffected != 1 {
+		return false, result.Error
+	}
+	if err := bumpUserStat(tx, follow.WhoID, "following_count", 1); err != nil {
+		return false, err
+	}
+	return true, bumpUserStat(tx, follow.WhomID, "follower_count", 1)
+}
+
+// unfollowUser removes a follower from the database
+func unfollowUser(userID string, profileUserID string) error {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("unfollowUser").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	userIDInt, errz := strconv.Atoi(userID)
+	profileUserIDInt, errx := strconv.Atoi(profileUserID)
+
+	if errz != nil {
+		logMessage(errz.Error())
+		return errz
+	} else if errx != nil {
+		logMessage(errx.Error())
+		return errx
+	}
+
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		return removeFollow(tx, userIDInt, profileUserIDInt)
+	})
+
+	if err != nil {
+		logMessage(err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// removeFollow deletes the follow of whoID to whomID, if any, and updates both counters
+func removeFollow(tx *gorm.DB, whoID int, whomID int) error {
+	result := tx.Where("who_id = ? AND whom_id = ?", whoID, whomID).Delete(&Follower{})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	if err := bumpUserStat(tx, whoID, "following_count", -int(result.RowsAffected)); err != nil {
+		return err
+	}
+	return bumpUserStat(tx, whomID, "follower_count", -int(result.RowsAffected))
+}
+
+var errMessageNotFound = errors.New("message not found")
+
+// likeMessage records a like, liking a message twice has no effect
+func likeMessage(userID int, messageID int) error {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("likeMessage").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var message Message
+	liked := false
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("message_id = ? AND flagged = 0 AND deleted_at = 0", messageID).Limit(1).Find(&message)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errMessageNotFound
+		}
+		visible, err := canSeeAuthor(tx, userID, message.AuthorID)
+		if err != nil {
+			return err
+		}
+		if !visible {
+			return errMessageNotFound
+		}
+		blocked, err := isBlockedEitherWay(tx, userID, message.AuthorID)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			return errBlocked
+		}
+
+		like := MessageLike{MessageID: messageID, UserID: userID, CreatedAt: int(time.Now().UTC().Unix())}
+		result = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&like)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		liked = true
+		return tx.Model(&Message{}).Where("message_id = ?", messageID).
+			UpdateColumn("like_count", gorm.Expr("like_count + 1")).Error
+	})
+
+	if err != nil && err != errMessageNotFound && err != errBlocked {
+		logMessage(err.Error())
+	}
+	if err == nil && liked {
+		notify(NotificationEvent{Type: NOTIFY_LIKE, UserID: message.AuthorID, ActorID: userID, MessageID: messageID})
+	}
+	return err
+}
+
+var (
+	errAlreadyReposted = errors.New("you already reposted this message")
+	errPrivateRepost   = errors.New("messages of private accounts can't be reposted")
+)
+
+// repostMessage reposts a message as the user, with text it becomes a quote post.
+// Reposting a plain repost reposts the original message, plain reposts are only possible once per message.
+func repostMessage(userID int, messageID int, text string) error {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("repostMessage").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	if err := validateMessageText(text); err != nil {
+		return err
+	}
+
+	var repost Message
+	var mentionedIDs []int
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		var original Message
+		result := tx.Where("message_id = ? AND flagged = 0 AND deleted_at = 0", messageID).Limit(1).Find(&original)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errMessageNotFound
+		}
+		if original.RepostOfID != 0 && original.Text == "" {
+			var reposted Message
+			result = tx.Where("message_id = ? AND flagged = 0 AND deleted_at = 0", original.RepostOfID).Limit(1).Find(&reposted)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errMessageNotFound
+			}
+			original = reposted
+		}
+
+		visible, err := canSeeAuthor(tx, userID, original.AuthorID)
+		if err != nil {
+			return err
+		}
+		if !visible {
+			return errMessageNotFound
+		}
+		blocked, err := isBlockedEitherWay(tx, userID, original.AuthorID)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			return errBlocked
+		}
+
+		if original.AuthorID != userID {
+			var author User
+			if err := tx.Where("user_id = ?", original.AuthorID).Limit(1).Find(&author).Error; err != nil {
+				return err
+			}
+			if author.Private {
+				return errPrivateRepost
+			}
+		}
+
+		if text == "" {
+			var count int64
+			if err := tx.Model(&Message{}).Where("author_id = ? AND repost_of_id = ? AND text = ''", userID, original.MessageID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return errAlreadyReposted
+			}
+		}
+
+		repost = Message{
+			AuthorID:   userID,
+			Text:       text,
+			PubDate:    int(time.Now().UTC().Unix()),
+			RepostOfID: original.MessageID,
+		}
+		if err := tx.Create(&repost).Error; err != nil {
+			return err
+		}
+		if text != "" {
+			// quote posts are messages of their own
+			if err := searchIndex.Index(tx, repost); err != nil {
+				return err
+			}
+			if err := indexMessageTags(tx, repost); err != nil {
+				return err
+			}
+			var err error
+			if mentionedIDs, err = indexMessageMentions(tx, repost); err != nil {
+				return err
+			}
+			if err := bumpUserStat(tx, userID, "message_count", 1); err != nil {
+				return err
+			}
+		}
+		return tx.Model(&Message{}).Where("message_id = ?", original.MessageID).
+			UpdateColumn("repost_count", gorm.Expr("repost_count + 1")).Error
+	})
+
+	if err != nil && err != errMessageNotFound && err != errAlreadyReposted && err != errPrivateRepost && err != errBlocked {
+		logMessage(err.Error())
+	}
+	if err == nil && text != "" {
+		notifyNewMessage(repost, 0, mentionedIDs)
+	}
+	if err == nil {
+		streamNewMessage(repost.MessageID)
+	}
+	return err
+}
+
+var (
+	errNotMessageAuthor = errors.New("only the author can change a message")
+	errEditWindowClosed = errors.New("the message can not be edited anymore")
+)
+
+// editWindow is how long after posting authors can edit their messages, EDIT_WINDOW (default 15m)
+func editWindow() time.Duration {
+	window, err := time.ParseDuration(os.Getenv("EDIT_WINDOW"))
+	if err != nil || window < 0 {
+		return 15 * time.Minute
+	}
+	return window
+}
+
+func canEditMessage(pubDate int) bool {
+	return time.Since(time.Unix(int64(pubDate), 0)) <= editWindow()
+}
+
+// findOwnMessage loads a visible message written by the user, plain reposts are removed with unrepostMessage instead
+func findOwnMessage(tx *gorm.DB, userID int, messageID int) (Message, error) {
+	var message Message
+	result := tx.Where("message_id = ? AND flagged = 0 AND deleted_at = 0", messageID).Limit(1).Find(&message)
+	if result.Error != nil {
+		return message, result.Error
+	}
+	if result.RowsAffected == 0 || (message.RepostOfID != 0 && message.Text == "") {
+		return message, errMessageNotFound
+	}
+	if message.AuthorID != userID {
+		return message, errNotMessageAuthor
+	}
+	return message, nil
+}
+
+// editMessage replaces the text of a message by its author within the edit window,
+// the previous text is kept in the edit history. Hashtags and mentions are indexed again.
+func editMessage(userID int, messageID int, text string) error {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("editMessage").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	if err := validateMessageText(text); err != nil {
+		return err
+	}
+
+	var message Message
+	var newMentions []int
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if message, err = findOwnMessage(tx, userID, messageID); err != nil {
+			return err
+		}
+		if !canEditMessage(message.PubDate) {
+			return errEditWindowClosed
+		}
+		if message.Text == text {
+			return nil
+		}
+
+		now := int(time.Now().UTC().Unix())
+		if err := tx.Create(&MessageEdit{MessageID: messageID, Text: message.Text, EditedAt: now}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Message{}).Where("message_id = ?", messageID).
+			Updates(map[string]interface{}{"text": text, "edited_at": now}).Error; err != nil {
+			return err
+		}
+		message.Text = text
+		if err := searchIndex.Index(tx, message); err != nil {
+			return err
+		}
+
+		var oldMentions []int
+		if err := tx.Model(&MessageMention{}).Where("message_id = ?", messageID).Pluck("user_id", &oldMentions).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("message_id = ?", messageID).Delete(&MessageTag{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("message_id = ?", messageID).Delete(&MessageMention{}).Error; err != nil {
+			return err
+		}
+		if err := indexMessageTags(tx, message); err != nil {
+			return err
+		}
+		mentions, err := indexMessageMentions(tx, message)
+		if err != nil {
+			return err
+		}
+
+		// only users that were not mentioned before are notified
+		for _, mentioned := range mentions {
+			isNew := true
+			for _, old := range oldMentions {
+				isNew = isNew && old != mentioned
+			}
+			if isNew {
+				newMentions = append(newMentions, mentioned)
+			}
+		}
+		return nil
+	})
+
+	if err != nil && err != errMessageNotFound && err != errNotMessageAuthor && err != errEditWindowClosed {
+		logMessage(err.Error())
+	}
+	if err == nil {
+		notifyNewMessage(message, 0, newMentions)
+	}
+	return err
+}
+
+// deleteMessage soft deletes a message of the user, replies keep their place in the conversation
+func deleteMessage(userID int, messageID int) error {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("deleteMessage").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		message, err := findOwnMessage(tx, userID, messageID)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&Message{}).Where("message_id = ?", messageID).
+			UpdateColumn("deleted_at", int(time.Now().UTC().Unix())).Error; err != nil {
+			return err
+		}
+		if err := searchIndex.Remove(tx, messageID); err != nil {
+			return err
+		}
+		if message.RepostOfID != 0 {
+			// a quote post counted as a repost of the quoted message
+			if err := tx.Model(&Message{}).Where("message_id = ?", message.RepostOfID).
+				UpdateColumn("repost_count", gorm.Expr("repost_count - 1")).Error; err != nil {
+				return err
+			}
+		}
+		return bumpUserStat(tx, userID, "message_count", -1)
+	})
+
+	if err != nil && err != errMessageNotFound && err != errNotMessageAuthor {
+		logMessage(err.Error())
+	}
+	return err
+}
+
+// getMessageEdits fetches the previous versions of a message, oldest first
+func getMessageEdits(messageID int) ([]MessageEdit, error) {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getMessageEdits").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var edits []MessageEdit
+	err := dbNew.Where("message_id = ?", messageID).Order("edit_id").Find(&edits).Error
+	if err != nil {
+		logMessage(err.Error())
+	}
+	return edits, err
+}
+
+// unrepostMessage removes the plain repost of a message by the user, if there is one
+func unrepostMessage(userID int, messageID int) error {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("unrepostMessage").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("author_id = ? AND repost_of_id = ? AND text = ''", userID, messageID).Delete(&Message{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return tx.Model(&Message{}).Where("message_id = ?", messageID).
+			UpdateColumn("repost_count", gorm.Expr("repost_count - ?", result.RowsAffected)).Error
+	})
+
+	if err != nil {
+		logMessage(err.Error())
+	}
+	return err
+}
+
+// unlikeMessage removes a like, unliking a message that was not liked has no effect
+func unlikeMessage(userID int, messageID int) error {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("unlikeMessage").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("message_id = ? AND user_id = ?", messageID, userID).Delete(&MessageLike{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return tx.Model(&Message{}).Where("message_id = ?", messageID).
+			UpdateColumn("like_count", gorm.Expr("like_count - ?", result.RowsAffected)).Error
+	})
+
+	if err != nil {
+		logMessage(err.Error())
+	}
+	return err
+}
+
+// bookmarkMessage bookmarks a message for the user, bookmarking twice has no effect
+func bookmarkMessage(userID int, messageID int) error {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("bookmarkMessage").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var message Message
+	result := dbNew.Where("message_id = ? AND flagged = 0 AND deleted_at = 0", messageID).Limit(1).Find(&message)
+	if result.Error != nil {
+		logMessage(result.Error.Error())
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errMessageNotFound
+	}
+	visible, err := canSeeAuthor(dbNew, userID, message.AuthorID)
+	if err != nil {
+		logMessage(err.Error())
+		return err
+	}
+	if !visible {
+		return errMessageNotFound
+	}
+
+	bookmark := MessageBookmark{UserID: userID, MessageID: messageID, CreatedAt: int(time.Now().UTC().Unix())}
+	err = dbNew.Clauses(clause.OnConflict{DoNothing: true}).Create(&bookmark).Error
+	if err != nil {
+		logMessage(err.Error())
+	}
+	return err
+}
+
+// unbookmarkMessage removes a bookmark, also of a message that is hidden by now
+func unbookmarkMessage(userID int, messageID int) error {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("unbookmarkMessage").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Where("user_id = ? AND message_id = ?", userID, messageID).Delete(&MessageBookmark{}).Error
+	if err != nil {
+		logMessage(err.Error())
+	}
+	return err
+}
+
+// getFollowers fetches up to `limit` followers of the user identified by userID, skipping the first `offset`
+func getFollowers(userID string, limit int, offset int) ([]User, error) {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getFollowers").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var users []User
+
+	err := dbNew.
+		Select("user.*").
+		Joins("INNER JOIN follower ON user.user_id = follower.who_id").
+		Where("follower.whom_id = ?", userID).
+		Order("user.username").
+		Limit(limit).
+		Offset(offset).
+		Find(&users).Error
+
+	if err != nil {
+		logMessage(err.Error())
+		return users, err
+	}
+	return users, nil
+}
+
+// getFollowing fetches up to `limit` users that the user identified by userID is following, skipping the first `offset`
+func getFollowing(userID string, limit int, offset int) ([]User, error) {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getFollowing").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var users []User
+
+	err := dbNew.
+		Select("user.*").
+		Joins("INNER JOIN follower ON user.user_id = follower.whom_id").
+		Where("follower.who_id = ?", userID).
+		Order("user.username").
+		Limit(limit).
+		Offset(offset).
+		Find(&users).Error
+
+	if err != nil {
+		logMessage(err.Error())
+		return users, err
+	}
+
+	return users, nil
+}
+
+// getUserStats fetches the follower/following/message counters of a user
+func getUserStats(userID int) (UserStats, error) {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getUserStats").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	stats := UserStats{UserID: userID}
+	result := dbNew.Where("user_id = ?", userID).Limit(1).Find(&stats)
+	if result.Error != nil {
+		logMessage(result.Error.Error())
+		return stats, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		if err := backfillUserStats(dbNew, userID); err != nil {
+			logMessage(err.Error())
+			return stats, err
+		}
+		dbNew.Where("user_id = ?", userID).Limit(1).Find(&stats)
+	}
+
+	return stats, nil
+}
+
+/*
+	NOTIFICATIONS
+*/
+
+func createNotification(notification Notification) error {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("createNotification").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Create(&notification).Error
+	if err != nil {
+		logMessage(err.Error())
+	}
+	return err
+}
+
+// getNotifications fetches a page of the notifications of a user, newest first
+func getNotifications(userID int, unreadOnly bool, limit int, offset int) ([]NotificationUser, error) {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getNotifications").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	query := dbNew.Table("notification").
+		Select("notification.*, user.username AS actor_username, message.text AS message_text").
+		Joins("JOIN user ON user.user_id = notification.actor_id").
+		Joins("LEFT JOIN message ON message.message_id = notification.message_id").
+		Where("notification.user_id = ?", userID)
+	if unreadOnly {
+		query = query.Where("notification.is_read = ?", false)
+	}
+
+	var notifications []NotificationUser
+	err := query.Order("notification.notification_id DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&notifications).Error
+
+	if err != nil {
+		logMessage(err.Error())
+	}
+	return notifications, err
+}
+
+func countUnreadNotifications(userID int) (int64, error) {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("countUnreadNotifications").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var count int64
+	err := dbNew.Model(&Notification{}).Where("user_id = ? AND is_read = ?", userID, false).Count(&count).Error
+	if err != nil {
+		logMessage(err.Error())
+	}
+	return count, err
+}
+
+// markNotificationsRead marks the given notifications of the user as read, all of them without ids
+func markNotificationsRead(userID int, notificationIDs []int) error {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("markNotificationsRead").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	query := dbNew.Model(&Notification{}).Where("user_id = ? AND is_read = ?", userID, false)
+	if len(notificationIDs) > 0 {
+		query = query.Where("notification_id IN ?", notificationIDs)
+	}
+
+	err := query.UpdateColumn("is_read", true).Error
+	if err != nil {
+		logMessage(err.Error())
+	}
+	return err
+}
+
+// getNotificationPreferences returns for every notification type whether the user wants it
+func getNotificationPreferences(userID int) (map[string]bool, error) {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getNotificationPreferences").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	preferences := map[string]bool{}
+	for _, notificationType := range notificationTypes {
+		preferences[notificationType] = true
+	}
+
+	var stored []NotificationPreference
+	if err := dbNew.Where("user_id = ?", userID).Find(&stored).Error; err != nil {
+		logMessage(err.Error())
+		return preferences, err
+	}
+	for _, p := range stored {
+		preferences[p.Type] = p.Enabled
+	}
+	return preferences, nil
+}
+
+func setNotificationPreferences(userID int, preferences map[string]bool) error {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("setNotificationPreferences").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var rows []NotificationPreference
+	for notificationType, enabled := range preferences {
+		rows = append(rows, NotificationPreference{UserID: userID, Type: notificationType, Enabled: enabled})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	err := dbNew.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "type"}},
+		DoUpdates: clause.AssignmentColumns([]string{"enabled"}),
+	}).Create(&rows).Error
+	if err != nil {
+		logMessage(err.Error())
+	}
+	return err
+}
+
+/*
+	DIRECT MESSAGES
+*/
+
+const MAX_CONVERSATION_SIZE = 8
+
+var (
+	errConversationNotFound = errors.New("conversation not found")
+	errTooManyParticipants  = fmt.Errorf("a conversation can have at most %d participants", MAX_CONVERSATION_SIZE)
+	errNoRecipients         = errors.New("a conversation needs at least one other user")
+)
+
+// errNotAllowedToMessage names the user that does not accept direct messages from the sender
+type errNotAllowedToMessage struct {
+	Username string
+}
+
+func (e errNotAllowedToMessage) Error() string {
+	return e.Username + " does not accept direct messages from you"
+}
+
+// getUserIDsByUsernames resolves usernames, unknown ones are missing from the result
+func getUserIDsByUsernames(usernames []string) (map[string]int, error) {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getUserIDsByUsernames").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	userIDs := map[string]int{}
+	if len(usernames) == 0 {
+		return userIDs, nil
+	}
+
+	var users []User
+	if err := dbNew.Where("username IN ?", usernames).Find(&users).Error; err != nil {
+		logMessage(err.Error())
+		return userIDs, err
+	}
+	for _, u := range users {
+		userIDs[u.Username] = u.UserID
+	}
+	return userIDs, nil
+}
+
+// canMessage tells whether the recipient accepts direct messages from the sender:
+// the recipient follows the sender or opted in to open direct messages
+func canMessage(tx *gorm.DB, senderID int, recipient User) (bool, error) {
+	blocked, err := isBlockedEitherWay(tx, senderID, recipient.UserID)
+	if err != nil || blocked {
+		return false, err
+	}
+	if recipient.OpenDMs {
+		return true, nil
+	}
+	var count int64
+	err = tx.Model(&Follower{}).Where("who_id = ? AND whom_id = ?", recipient.UserID, senderID).Count(&count).Error
+	return count > 0, err
+}
+
+// startConversation sends the first message of a conversation. A one-to-one conversation
+// that already exists is continued, group conversations always start fresh.
+func startConversation(senderID int, recipientIDs []int, text string) (int, error) {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("startConversation").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	participants := []int{senderID}
+	seen := map[int]bool{senderID: true}
+	for _, id := range recipientIDs {
+		if !seen[id] {
+			seen[id] = true
+			participants = append(participants, id)
+		}
+	}
+	if len(participants) < 2 {
+		return 0, errNoRecipients
+	}
+	if len(participants) > MAX_CONVERSATION_SIZE {
+		return 0, errTooManyParticipants
+	}
+
+	var conversationID int
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		var recipients []User
+		if err := tx.Where("user_id IN ?", participants[1:]).Find(&recipients).Error; err != nil {
+			return err
+		}
+		for _, recipient := range recipients {
+			allowed, err := canMessage(tx, senderID, recipient)
+			if err != nil {
+				return err
+			}
+			if !allowed {
+				return errNotAllowedToMessage{Username: recipient.Username}
+			}
+		}
+
+		if len(participants) == 2 {
+			err := tx.Table("conversation_participant AS mine").
+				Select("mine.conversation_id").
+				Joins("JOIN conversation_participant AS theirs ON theirs.conversation_id = mine.conversation_id AND theirs.user_id = ?", participants[1]).
+				Where("mine.user_id = ?", senderID).
+				Where("(SELECT COUNT(*) FROM conversation_participant AS p WHERE p.conversation_id = mine.conversation_id) = 2").
+				Limit(1).
+				Scan(&conversationID).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if conversationID == 0 {
+			conversation := Conversation{UpdatedAt: int(time.Now().UTC().Unix())}
+			if err := tx.Create(&conversation).Error; err != nil {
+				return err
+			}
+			conversationID = conversation.ConversationID
+
+			var rows []ConversationParticipant
+			for _, userID := range participants {
+				rows = append(rows, ConversationParticipant{ConversationID: conversationID, UserID: userID})
+			}
+			if err := tx.Create(&rows).Error; err != nil {
+				return err
+			}
+		}
+
+		return createDirectMessage(tx, conversationID, senderID, text)
+	})
+
+	if err != nil {
+		if _, denied := err.(errNotAllowedToMessage); !denied && err != errNoRecipients {
+			logMessage(err.Error())
+		}
+		return 0, err
+	}
+	return conversationID, nil
+}
+
+// sendDirectMessage adds a message to a conversation the sender takes part in
+func sendDirectMessage(conversationID int, senderID int, text string) error {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("sendDirectMessage").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&ConversationParticipant{}).Where("conversation_id = ? AND user_id = ?", conversationID, senderID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return errConversationNotFound
+		}
+		return createDirectMessage(tx, conversationID, senderID, text)
+	})
+
+	if err != nil && err != errConversationNotFound {
+		logMessage(err.Error())
+	}
+	return err
+}
+
+func createDirectMessage(tx *gorm.DB, conversationID int, senderID int, text string) error {
+	message := DirectMessage{
+		ConversationID: conversationID,
+		SenderID:       senderID,
+		Text:           text,
+		PubDate:        int(time.Now().UTC().Unix()),
+	}
+	if err := tx.Create(&message).Error; err != nil {
+		return err
+	}
+	// the sender has read their own message
+	if err := tx.Model(&ConversationParticipant{}).Where("conversation_id = ? AND user_id = ?", conversationID, senderID).
+		UpdateColumn("last_read_id", message.DirectMessageID).Error; err != nil {
+		return err
+	}
+	return tx.Model(&Conversation{}).Where("conversation_id = ?", conversationID).
+		Updates(map[string]interface{}{"last_message_id": message.DirectMessageID, "updated_at": message.PubDate}).Error
+}
+
+// getConversations fetches a page of the conversations of a user, most recently active first
+func getConversations(userID int, limit int, offset int) ([]ConversationSummary, error) {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getConversations").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var rows []struct {
+		ConversationID int
+		UpdatedAt      int
+		LastMessage    string
+		LastSender     string
+	}
+	err := dbNew.Table("conversation").
+		Select("conversation.conversation_id, conversation.updated_at, direct_message.text AS last_message, user.username AS last_sender").
+		Joins("JOIN conversation_participant ON conversation_participant.conversation_id = conversation.conversation_id").
+		Joins("LEFT JOIN direct_message ON direct_message.direct_message_id = conversation.last_message_id").
+		Joins("LEFT JOIN user ON user.user_id = direct_message.sender_id").
+		Where("conversation_participant.user_id = ?", userID).
+		Order("conversation.updated_at DESC, conversation.conversation_id DESC").
+		Limit(limit).
+		Offset(offset).
+		Scan(&rows).Error
+	if err != nil {
+		logMessage(err.Error())
+		return nil, err
+	}
+
+	var conversationIDs []int
+	for _, row := range rows {
+		conversationIDs = append(conversationIDs, row.ConversationID)
+	}
+	participants, err := getConversationParticipants(conversationIDs, userID)
+	if err != nil {
+		return nil, err
+	}
+	unread, err := countUnreadByConversation(userID, conversationIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var conversations []ConversationSummary
+	for _, row := range rows {
+		conversations = append(conversations, ConversationSummary{
+			ConversationID: row.ConversationID,
+			UpdatedAt:      row.UpdatedAt,
+			Participants:   participants[row.ConversationID],
+			LastMessage:    row.LastMessage,
+			LastSender:     row.LastSender,
+			Unread:         unread[row.ConversationID],
+		})
+	}
+	return conversations, nil
+}
+
+// getConversationParticipants returns the usernames of the participants of each conversation, except the given user
+func getConversationParticipants(conversationIDs []int, exceptUserID int) (map[int][]string, error) {
+	participants := map[int][]string{}
+	if len(conversationIDs) == 0 {
+		return participants, nil
+	}
+
+	var rows []struct {
+		ConversationID int
+		Username       string
+	}
+	err := dbNew.Table("conversation_participant").
+		Select("conversation_participant.conversation_id, user.username").
+		Joins("JOIN user ON user.user_id = conversation_participant.user_id").
+		Where("conversation_participant.conversation_id IN ? AND conversation_participant.user_id <> ?", conversationIDs, exceptUserID).
+		Order("user.username").
+		Scan(&rows).Error
+	if err != nil {
+		logMessage(err.Error())
+		return participants, err
+	}
+	for _, row := range rows {
+		participants[row.ConversationID] = append(participants[row.ConversationID], row.Username)
+	}
+	return participants, nil
+}
+
+// countUnreadByConversation counts the messages of others the user has not read yet, per conversation.
+// Without conversation ids all conversations of the user are counted.
+func countUnreadByConversation(userID int, conversationIDs []int) (map[int]int, error) {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("countUnreadByConversation").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	query := dbNew.Table("direct_message").
+		Select("direct_message.conversation_id, COUNT(*) AS unread").
+		Joins("JOIN conversation_participant ON conversation_participant.conversation_id = direct_message.conversation_id AND conversation_participant.user_id = ?", userID).
+		Where("direct_message.direct_message_id > conversation_participant.last_read_id AND direct_message.sender_id <> ?", userID)
+	if len(conversationIDs) > 0 {
+		query = query.Where("direct_message.conversation_id IN ?", conversationIDs)
+	}
+
+	var rows []struct {
+		ConversationID int
+		Unread         int
+	}
+	unread := map[int]int{}
+	if err := query.Group("direct_message.conversation_id").Scan(&rows).Error; err != nil {
+		logMessage(err.Error())
+		return unread, err
+	}
+	for _, row := range rows {
+		unread[row.ConversationID] = row.Unread
+	}
+	return unread, nil
+}
+
+// countUnreadDirectMessages counts the unread direct messages of a user over all conversations
+func countUnreadDirectMessages(userID int) (int, error) {
+	unread, err := countUnreadByConversation(userID, nil)
+	total := 0
+	for _, count := range unread {
+		total += count
+	}
+	return total, err
+}
+
+// getConversation checks the user takes part in the conversation and returns the other participants
+func getConversation(conversationID int, userID int) ([]string, error) {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getConversation").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var count int64
+	if err := dbNew.Model(&ConversationParticipant{}).Where("conversation_id = ? AND user_id = ?", conversationID, userID).Count(&count).Error; err != nil {
+		logMessage(err.Error())
+		return nil, err
+	}
+	if count == 0 {
+		return nil, errConversationNotFound
+	}
+
+	participants, err := getConversationParticipants([]int{conversationID}, userID)
+	return participants[conversationID], err
+}
+
+// getDirectMessages fetches a page of the messages of a conversation, newest first
+func getDirectMessages(conversationID int, limit int, offset int) ([]DirectMessageUser, error) {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getDirectMessages").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var messages []DirectMessageUser
+	err := dbNew.Table("direct_message").
+		Select("direct_message.*, user.username, user.email, user.avatar_version").
+		Joins("JOIN user ON user.user_id = direct_message.sender_id").
+		Where("direct_message.conversation_id = ?", conversationID).
+		Order("direct_message.direct_message_id DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&messages).Error
+
+	if err != nil {
+		logMessage(err.Error())
+	}
+	return messages, err
+}
+
+// markConversationRead marks all messages of the conversation as read by the user
+func markConversationRead(conversationID int, userID int) error {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("markConversationRead").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Model(&ConversationParticipant{}).
+		Where("conversation_id = ? AND user_id = ?", conversationID, userID).
+		UpdateColumn("last_read_id", dbNew.Model(&Conversation{}).Select("last_message_id").Where("conversation_id = ?", conversationID)).Error
+	if err != nil {
+		logMessage(err.Error())
+	}
+	return err
+}
+
+func setOpenDMs(userID int, open bool) error {
+
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("setOpenDMs").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Model(&User{}).Where("user_id = ?", userID).UpdateColumn("open_dms", open).Error
+	if err != nil {
+		logMessage(err.Error())
+	}
+	return err
+}
+
+/*
+	BLOCKS AND MUTES
+*/
+
+var errBlocked = errors.New("you can't interact with a user that blocked you or that you blocked")
+
+// isBlockedEitherWay tells whether one of the two users blocked the other
+func isBlockedEitherWay(tx *gorm.DB, userID int, otherID int) (bool, error) {
+	var count int64
+	err := tx.Model(&UserBlock{}).
+		Where("(blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)", userID, otherID, otherID, userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// canSeeAuthor tells whether the user may see the messages of the author: public accounts,
+// their own messages and the private accounts they follow
+func canSeeAuthor(tx *gorm.DB, userID int, authorID int) (bool, error) {
+	if userID == authorID {
+		return true, nil
+	}
+	var author User
+	if err := tx.Select("private").Where("user_id = ?", authorID).Limit(1).Find(&author).Error; err != nil {
+		return false, err
+	}
+	if !author.Private {
+		return true, nil
+	}
+	var count int64
+	err := tx.Model(&Follower{}).Where("who_id = ? AND whom_id = ?", userID, authorID).Count(&count).Error
+	return count > 0, err
+}
+
+// canViewMessages tells whether the viewer (0 for anonymous viewers) may see the messages of the author,
+// following the rules of whereVisibleTo
+func canViewMessages(viewerID int, authorID int) (bool, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("canViewMessages").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	visible, err := canSeeAuthor(dbNew, viewerID, authorID)
+	if err == nil && visible {
+		var blocked bool
+		blocked, err = isBlockedEitherWay(dbNew, viewerID, authorID)
+		visible = !blocked
+	}
+	if err != nil {
+		logMessage(err.Error())
+		return false, err
+	}
+	return visible, nil
+}
+
+// checkBlockStatus tells how the logged in user and the profile user blocked or muted each other
+func checkBlockStatus(userID int, pUserID int) (BlockStatus, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("checkBlockStatus").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var status BlockStatus
+	if userID == 0 || userID == pUserID {
+		return status, nil
+	}
+
+	var blocks []UserBlock
+	err := dbNew.Where("(blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)", userID, pUserID, pUserID, userID).
+		Find(&blocks).Error
+	if err != nil {
+		logMessage(err.Error())
+		return status, err
+	}
+	for _, block := range blocks {
+		if block.BlockerID == userID {
+			status.Blocked = true
+		} else {
+			status.BlockedBy = true
+		}
+	}
+
+	var count int64
+	if err := dbNew.Model(&UserMute{}).Where("muter_id = ? AND muted_id = ?", userID, pUserID).Count(&count).Error; err != nil {
+		logMessage(err.Error())
+		return status, err
+	}
+	status.Muted = count > 0
+
+	return status, nil
+}
+
+// blockUser blocks a user and removes the follows between the two users, blocking twice has no effect
+func blockUser(userID int, blockedID int) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("blockUser").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		block := UserBlock{BlockerID: userID, BlockedID: blockedID, CreatedAt: int(time.Now().UTC().Unix())}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&block).Error; err != nil {
+			return err
+		}
+		if err := removeFollow(tx, userID, blockedID); err != nil {
+			return err
+		}
+		if err := removeFollow(tx, blockedID, userID); err != nil {
+			return err
+		}
+		if err := tx.Where("(requester_id = ? AND target_id = ?) OR (requester_id = ? AND target_id = ?)", userID, blockedID, blockedID, userID).
+			Delete(&FollowRequest{}).Error; err != nil {
+			return err
+		}
+		// neither of them stays on a list of the other
+		return tx.Where("(user_id = ? AND list_id IN (?)) OR (user_id = ? AND list_id IN (?))",
+			blockedID, tx.Model(&UserList{}).Select("list_id").Where("owner_id = ?", userID),
+			userID, tx.Model(&UserList{}).Select("list_id").Where("owner_id = ?", blockedID)).
+			Delete(&UserListMember{}).Error
+	})
+
+	if err != nil {
+		logMessage(err.Error())
+		return err
+	}
+	return nil
+}
+
+// unblockUser lifts a block, the removed follows are not restored
+func unblockUser(userID int, blockedID int) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("unblockUser").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Where("blocker_id = ? AND blocked_id = ?", userID, blockedID).Delete(&UserBlock{}).Error
+	if err != nil {
+		logMessage(err.Error())
+		return err
+	}
+	return nil
+}
+
+// muteUser hides the messages of a user from the timeline of userID, muting twice has no effect
+func muteUser(userID int, mutedID int) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("muteUser").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	mute := UserMute{MuterID: userID, MutedID: mutedID, CreatedAt: int(time.Now().UTC().Unix())}
+	err := dbNew.Clauses(clause.OnConflict{DoNothing: true}).Create(&mute).Error
+	if err != nil {
+		logMessage(err.Error())
+		return err
+	}
+	return nil
+}
+
+func unmuteUser(userID int, mutedID int) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("unmuteUser").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Where("muter_id = ? AND muted_id = ?", userID, mutedID).Delete(&UserMute{}).Error
+	if err != nil {
+		logMessage(err.Error())
+		return err
+	}
+	return nil
+}
+
+// getBlockedUsers fetches up to `limit` users blocked by userID, most recently blocked first, skipping the first `offset`
+func getBlockedUsers(userID int, limit int, offset int) ([]User, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getBlockedUsers").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var users []User
+	err := dbNew.
+		Select("user.*").
+		Joins("INNER JOIN user_block ON user.user_id = user_block.blocked_id").
+		Where("user_block.blocker_id = ?", userID).
+		Order("user_block.created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&users).Error
+
+	if err != nil {
+		logMessage(err.Error())
+		return users, err
+	}
+	return users, nil
+}
+
+// getMutedUsers fetches up to `limit` users muted by userID, most recently muted first, skipping the first `offset`
+func getMutedUsers(userID int, limit int, offset int) ([]User, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getMutedUsers").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var users []User
+	err := dbNew.
+		Select("user.*").
+		Joins("INNER JOIN user_mute ON user.user_id = user_mute.muted_id").
+		Where("user_mute.muter_id = ?", userID).
+		Order("user_mute.created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&users).Error
+
+	if err != nil {
+		logMessage(err.Error())
+		return users, err
+	}
+	return users, nil
+}
+
+/*
+	PRIVATE ACCOUNTS AND FOLLOW REQUESTS
+*/
+
+var errFollowRequestNotFound = errors.New("follow request not found")
+
+const (
+	FOLLOW_STATE_NONE      = "none"
+	FOLLOW_STATE_REQUESTED = "requested"
+	FOLLOW_STATE_FOLLOWING = "following"
+)
+
+// requestFollow follows a public account or asks a private one for approval, it returns the resulting
+// FOLLOW_STATE_*. The v1 API keeps calling followUser directly, so the simulator always follows.
+func requestFollow(userID int, targetID int) (string, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("requestFollow").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var target User
+	if err := dbNew.Where("user_id = ?", targetID).Limit(1).Find(&target).Error; err != nil {
+		logMessage(err.Error())
+		return FOLLOW_STATE_NONE, err
+	}
+
+	state, err := getFollowState(userID, targetID)
+	if err != nil || state != FOLLOW_STATE_NONE {
+		return state, err
+	}
+
+	if !target.Private {
+		if err := followUser(strconv.Itoa(userID), strconv.Itoa(targetID)); err != nil {
+			return FOLLOW_STATE_NONE, err
+		}
+		return FOLLOW_STATE_FOLLOWING, nil
+	}
+
+	blocked, err := isBlockedEitherWay(dbNew, userID, targetID)
+	if err != nil {
+		logMessage(err.Error())
+		return FOLLOW_STATE_NONE, err
+	}
+	if blocked {
+		return FOLLOW_STATE_NONE, errBlocked
+	}
+
+	request := FollowRequest{RequesterID: userID, TargetID: targetID, CreatedAt: int(time.Now().UTC().Unix())}
+	if err := dbNew.Clauses(clause.OnConflict{DoNothing: true}).Create(&request).Error; err != nil {
+		logMessage(err.Error())
+		return FOLLOW_STATE_NONE, err
+	}
+	notify(NotificationEvent{Type: NOTIFY_FOLLOW_REQUEST, UserID: targetID, ActorID: userID})
+
+	return FOLLOW_STATE_REQUESTED, nil
+}
+
+// getFollowState tells whether userID follows targetID, waits for approval or neither
+func getFollowState(userID int, targetID int) (string, error) {
+	followed, err := checkFollowStatus(userID, targetID)
+	if err != nil {
+		return FOLLOW_STATE_NONE, err
+	}
+	if followed {
+		return FOLLOW_STATE_FOLLOWING, nil
+	}
+
+	var count int64
+	err = dbNew.Model(&FollowRequest{}).Where("requester_id = ? AND target_id = ?", userID, targetID).Count(&count).Error
+	if err != nil {
+		logMessage(err.Error())
+		return FOLLOW_STATE_NONE, err
+	}
+	if count > 0 {
+		return FOLLOW_STATE_REQUESTED, nil
+	}
+	return FOLLOW_STATE_NONE, nil
+}
+
+// approveFollowRequest turns the pending request of requesterID into a follow of targetID
+func approveFollowRequest(targetID int, requesterID int) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("approveFollowRequest").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("requester_id = ? AND target_id = ?", requesterID, targetID).Delete(&FollowRequest{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errFollowRequestNotFound
+		}
+
+		_, err := addFollow(tx, Follower{WhoID: requesterID, WhomID: targetID})
+		return err
+	})
+
+	if err != nil {
+		if err != errFollowRequestNotFound {
+			logMessage(err.Error())
+		}
+		return err
+	}
+
+	notify(NotificationEvent{Type: NOTIFY_FOLLOW_ACCEPT, UserID: requesterID, ActorID: targetID})
+	return nil
+}
+
+// deleteFollowRequest denies a request (by the target) or withdraws it (by the requester),
+// deleting a request that does not exist has no effect
+func deleteFollowRequest(requesterID int, targetID int) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("deleteFollowRequest").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Where("requester_id = ? AND target_id = ?", requesterID, targetID).Delete(&FollowRequest{}).Error
+	if err != nil {
+		logMessage(err.Error())
+		return err
+	}
+	return nil
+}
+
+// getFollowRequests fetches up to `limit` users waiting for the approval of userID, oldest request first
+func getFollowRequests(userID int, limit int, offset int) ([]User, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getFollowRequests").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var users []User
+	err := dbNew.
+		Select("user.*").
+		Joins("INNER JOIN follow_request ON user.user_id = follow_request.requester_id").
+		Where("follow_request.target_id = ?", userID).
+		Order("follow_request.created_at").
+		Limit(limit).
+		Offset(offset).
+		Find(&users).Error
+
+	if err != nil {
+		logMessage(err.Error())
+		return users, err
+	}
+	return users, nil
+}
+
+// setPrivate switches an account between public and private. The pending requests
+// of an account that becomes public are approved, nobody would approve them anymore.
+func setPrivate(userID int, private bool) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("setPrivate").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	if err := dbNew.Model(&User{}).Where("user_id = ?", userID).UpdateColumn("private", private).Error; err != nil {
+		logMessage(err.Error())
+		return err
+	}
+	if private {
+		return nil
+	}
+
+	var requesterIDs []int
+	if err := dbNew.Model(&FollowRequest{}).Where("target_id = ?", userID).Pluck("requester_id", &requesterIDs).Error; err != nil {
+		logMessage(err.Error())
+		return err
+	}
+	for _, requesterID := range requesterIDs {
+		if err := approveFollowRequest(userID, requesterID); err != nil && err != errFollowRequestNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+	PROFILES
+*/
+
+var errWrongPassword = errors.New("the current password is not correct")
+
+// updateProfile saves the fields of a validated update, nil fields are left unchanged
+func updateProfile(userID int, update ProfileUpdate) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("updateProfile").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	columns := map[string]interface{}{}
+	if update.DisplayName != nil {
+		columns["display_name"] = *update.DisplayName
+	}
+	if update.Bio != nil {
+		columns["bio"] = *update.Bio
+	}
+	if update.Location != nil {
+		columns["location"] = *update.Location
+	}
+	if update.Website != nil {
+		columns["website"] = *update.Website
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+
+	err := dbNew.Model(&User{}).Where("user_id = ?", userID).UpdateColumns(columns).Error
+	if err != nil {
+		logMessage(err.Error())
+		return err
+	}
+	return nil
+}
+
+// verifyPassword re-authenticates a user before a sensitive change
+func verifyPassword(userID int, password string) error {
+	var user User
+	if err := dbNew.Where("user_id = ?", userID).Limit(1).Find(&user).Error; err != nil {
+		logMessage(err.Error())
+		return err
+	}
+	if user.UserID == 0 || !checkPasswordHash(password, user.PwHash) {
+		return errWrongPassword
+	}
+	return nil
+}
+
+// changeEmail sets a new (validated) e-mail address after checking the current password
+func changeEmail(userID int, currentPassword string, email string) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("changeEmail").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	if err := verifyPassword(userID, currentPassword); err != nil {
+		return err
+	}
+
+	err := dbNew.Model(&User{}).Where("user_id = ?", userID).UpdateColumn("email", email).Error
+	if err != nil {
+		logMessage(err.Error())
+		return err
+	}
+	return nil
+}
+
+// changePassword replaces the password hash after checking the current password
+func changePassword(userID int, currentPassword string, password [16]byte) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("changePassword").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	if err := verifyPassword(userID, currentPassword); err != nil {
+		return err
+	}
+
+	err := dbNew.Model(&User{}).Where("user_id = ?", userID).UpdateColumn("pw_hash", hex.EncodeToString(password[:])).Error
+	if err != nil {
+		logMessage(err.Error())
+		return err
+	}
+	return nil
+}
+
+func setAvatarVersion(userID int, version int) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("setAvatarVersion").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Model(&User{}).Where("user_id = ?", userID).UpdateColumn("avatar_version", version).Error
+	if err != nil {
+		logMessage(err.Error())
+		return err
+	}
+	return nil
+}
+
+// attachToMessage attaches the uploads in the given order, they must be unattached uploads of the author
+func attachToMessage(tx *gorm.DB, messageID int, authorID int, attachmentIDs []int) error {
+	return attachUploads(tx, "message_id", messageID, authorID, attachmentIDs)
+}
+
+// attachToScheduled keeps the uploads with a pending post until it is published
+func attachToScheduled(tx *gorm.DB, scheduledID int, authorID int, attachmentIDs []int) error {
+	return attachUploads(tx, "scheduled_id", scheduledID, authorID, attachmentIDs)
+}
+
+func attachUploads(tx *gorm.DB, column string, id int, authorID int, attachmentIDs []int) error {
+	if len(attachmentIDs) > MAX_ATTACHMENTS {
+		return errTooManyAttachments
+	}
+	for position, attachmentID := range attachmentIDs {
+		result := tx.Model(&Attachment{}).
+			Where("attachment_id = ? AND uploader_id = ? AND message_id = 0 AND scheduled_id = 0", attachmentID, authorID).
+			Updates(map[string]interface{}{column: id, "position": position})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// also covers an id given twice
+			return errAttachmentNotFound
+		}
+	}
+	return nil
+}
+
+func insertAttachment(attachment *Attachment) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("insertAttachment").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Create(attachment).Error
+	if err != nil {
+		logMessage(err.Error())
+		return err
+	}
+	return nil
+}
+
+// getAttachments returns the images of the messages by message id, in their order in the message
+func getAttachments(messageIDs []int) (map[int][]Attachment, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getAttachments").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	attachments := map[int][]Attachment{}
+	if len(messageIDs) == 0 {
+		return attachments, nil
+	}
+
+	var rows []Attachment
+	err := dbNew.Where("message_id IN ?", messageIDs).Order("message_id, position").Find(&rows).Error
+	if err != nil {
+		logMessage(err.Error())
+		return attachments, err
+	}
+	for _, row := range rows {
+		attachments[row.MessageID] = append(attachments[row.MessageID], row)
+	}
+	return attachments, nil
+}
+
+// getOrphanedAttachments returns uploads created before the given time that were never attached
+func getOrphanedAttachments(before int, limit int) ([]Attachment, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getOrphanedAttachments").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var attachments []Attachment
+	err := dbNew.Where("message_id = 0 AND scheduled_id = 0 AND created_at < ?", before).Order("attachment_id").Limit(limit).Find(&attachments).Error
+	if err != nil {
+		logMessage(err.Error())
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// deleteUnattachedAttachment deletes an upload unless it got attached, deleted tells whether it was
+func deleteUnattachedAttachment(attachmentID int) (bool, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("deleteUnattachedAttachment").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	result := dbNew.Where("attachment_id = ? AND message_id = 0 AND scheduled_id = 0", attachmentID).Delete(&Attachment{})
+	if result.Error != nil {
+		logMessage(result.Error.Error())
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func listSummaryQuery(tx *gorm.DB) *gorm.DB {
+	return tx.Table("user_list").
+		Select("user_list.*, user.username AS owner_username, " +
+			"(SELECT COUNT(*) FROM user_list_member WHERE user_list_member.list_id = user_list.list_id) AS member_count").
+		Joins("JOIN user ON user.user_id = user_list.owner_id")
+}
+
+// findOwnList fetches a list of ownerID, errListNotFound if it does not exist or belongs to somebody else
+func findOwnList(tx *gorm.DB, ownerID int, listID int) (UserList, error) {
+	var list UserList
+	result := tx.Where("list_id = ? AND owner_id = ?", listID, ownerID).Limit(1).Find(&list)
+	if result.Error != nil {
+		return list, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return list, errListNotFound
+	}
+	return list, nil
+}
+
+// listNameTaken tells whether the owner has another list (than exceptID) with the name, ignoring case
+func listNameTaken(tx *gorm.DB, ownerID int, name string, exceptID int) (bool, error) {
+	var count int64
+	err := tx.Model(&UserList{}).
+		Where("owner_id = ? AND LOWER(name) = LOWER(?) AND list_id <> ?", ownerID, name, exceptID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// createList creates a list of the user, the update must hold a name and be validated
+func createList(ownerID int, update ListUpdate) (UserList, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("createList").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	list := UserList{OwnerID: ownerID, Name: *update.Name, CreatedAt: int(time.Now().UTC().Unix())}
+	if update.Description != nil {
+		list.Description = *update.Description
+	}
+	if update.Private != nil {
+		list.Private = *update.Private
+	}
+
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&UserList{}).Where("owner_id = ?", ownerID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= MAX_LISTS_PER_USER {
+			return errTooManyLists
+		}
+		taken, err := listNameTaken(tx, ownerID, list.Name, 0)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return errListNameTaken
+		}
+		return tx.Create(&list).Error
+	})
+
+	if err != nil && !isListInputError(err) {
+		logMessage(err.Error())
+	}
+	return list, err
+}
+
+// updateList changes the non nil fields of a validated update of a list of the user
+func updateList(ownerID int, listID int, update ListUpdate) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("updateList").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		if _, err := findOwnList(tx, ownerID, listID); err != nil {
+			return err
+		}
+
+		changes := map[string]interface{}{}
+		if update.Name != nil {
+			taken, err := listNameTaken(tx, ownerID, *update.Name, listID)
+			if err != nil {
+				return err
+			}
+			if taken {
+				return errListNameTaken
+			}
+			changes["name"] = *update.Name
+		}
+		if update.Description != nil {
+			changes["description"] = *update.Description
+		}
+		if update.Private != nil {
+			changes["private"] = *update.Private
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+		return tx.Model(&UserList{}).Where("list_id = ?", listID).Updates(changes).Error
+	})
+
+	if err != nil && err != errListNotFound && !isListInputError(err) {
+		logMessage(err.Error())
+	}
+	return err
+}
+
+// deleteList deletes a list of the user with its memberships
+func deleteList(ownerID int, listID int) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("deleteList").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		if _, err := findOwnList(tx, ownerID, listID); err != nil {
+			return err
+		}
+		if err := tx.Where("list_id = ?", listID).Delete(&UserListMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("list_id = ?", listID).Delete(&UserList{}).Error
+	})
+
+	if err != nil && err != errListNotFound {
+		logMessage(err.Error())
+	}
+	return err
+}
+
+// getList fetches a list with its owner and member count, found is false if it does not exist
+func getList(listID int) (ListSummary, bool, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getList").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var list ListSummary
+	result := listSummaryQuery(dbNew).Where("user_list.list_id = ?", listID).Limit(1).Find(&list)
+	if result.Error != nil {
+		logMessage(result.Error.Error())
+		return list, false, result.Error
+	}
+	return list, result.RowsAffected > 0, nil
+}
+
+// getUserLists fetches the lists of the user by name, the private ones only if includePrivate is set
+func getUserLists(ownerID int, includePrivate bool) ([]ListSummary, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getUserLists").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	query := listSummaryQuery(dbNew).Where("user_list.owner_id = ?", ownerID)
+	if !includePrivate {
+		query = query.Where("user_list.private = ?", false)
+	}
+
+	var lists []ListSummary
+	err := query.Order("user_list.name").Find(&lists).Error
+	if err != nil {
+		logMessage(err.Error())
+		return nil, err
+	}
+	return lists, nil
+}
+
+// getListMemberships returns which lists of the owner the user is a member of
+func getListMemberships(ownerID int, userID int) (map[int]bool, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getListMemberships").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	memberships := map[int]bool{}
+	var listIDs []int
+	err := dbNew.Model(&UserListMember{}).
+		Joins("JOIN user_list ON user_list.list_id = user_list_member.list_id").
+		Where("user_list.owner_id = ? AND user_list_member.user_id = ?", ownerID, userID).
+		Pluck("user_list_member.list_id", &listIDs).Error
+	if err != nil {
+		logMessage(err.Error())
+		return memberships, err
+	}
+	for _, listID := range listIDs {
+		memberships[listID] = true
+	}
+	return memberships, nil
+}
+
+// addListMember adds a user to a list of the owner, adding twice has no effect. Users that blocked
+// the owner (or were blocked by them) can't be added.
+func addListMember(ownerID int, listID int, userID int) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("addListMember").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		if _, err := findOwnList(tx, ownerID, listID); err != nil {
+			return err
+		}
+		blocked, err := isBlockedEitherWay(tx, ownerID, userID)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			return errBlocked
+		}
+		member := UserListMember{ListID: listID, UserID: userID, CreatedAt: int(time.Now().UTC().Unix())}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&member).Error
+	})
+
+	if err != nil && err != errListNotFound && err != errBlocked {
+		logMessage(err.Error())
+	}
+	return err
+}
+
+// removeListMember removes a user from a list of the owner, removing a non member has no effect
+func removeListMember(ownerID int, listID int, userID int) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("removeListMember").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		if _, err := findOwnList(tx, ownerID, listID); err != nil {
+			return err
+		}
+		return tx.Where("list_id = ? AND user_id = ?", listID, userID).Delete(&UserListMember{}).Error
+	})
+
+	if err != nil && err != errListNotFound {
+		logMessage(err.Error())
+	}
+	return err
+}
+
+// getListMembers fetches a page of the members of a list, most recently added first
+func getListMembers(listID int, limit int, offset int) ([]User, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getListMembers").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var users []User
+	err := dbNew.
+		Select("user.*").
+		Joins("INNER JOIN user_list_member ON user.user_id = user_list_member.user_id").
+		Where("user_list_member.list_id = ?", listID).
+		Order("user_list_member.created_at DESC, user.user_id").
+		Limit(limit).
+		Offset(offset).
+		Find(&users).Error
+	if err != nil {
+		logMessage(err.Error())
+		return nil, err
+	}
+	return users, nil
+}
+
+// getListMessages fetches a page of the timeline of a list as seen by viewerID (0 for anonymous viewers), newest first
+func getListMessages(listID int, viewerID int, limit int, offset int) ([]MessageUser, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getListMessages").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	query := timelineQuery().
+		Joins("JOIN user_list_member ON user_list_member.user_id = message.author_id").
+		Where("user_list_member.list_id = ? AND message.flagged = 0 AND message.deleted_at = 0", listID)
+	query = whereVisibleTo(query, viewerID)
+
+	var messages []MessageUser
+	err := query.Order("message.pub_date DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&messages).Error
+	if err != nil {
+		logMessage(err.Error())
+		return nil, err
+	}
+	return messages, nil
+}
+
+// createScheduledMessage stores a draft or a scheduled post with its uploaded images
+func createScheduledMessage(post *ScheduledMessage, attachmentIDs []int) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("createScheduledMessage").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	if post.Text == "" && len(attachmentIDs) == 0 {
+		return errEmptyPost
+	}
+	if err := validateMessageText(post.Text); err != nil {
+		return err
+	}
+
+	now := int(time.Now().UTC().Unix())
+	post.CreatedAt = now
+	post.UpdatedAt = now
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		var pending int64
+		if err := tx.Model(&ScheduledMessage{}).Where("author_id = ?", post.AuthorID).Count(&pending).Error; err != nil {
+			return err
+		}
+		if pending >= MAX_PENDING_POSTS {
+			return errTooManyPendingPosts
+		}
+		if post.ReplyToID != 0 {
+			if _, err := findReplyTarget(tx, post.AuthorID, post.ReplyToID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(post).Error; err != nil {
+			return err
+		}
+		return attachToScheduled(tx, post.ScheduledID, post.AuthorID, attachmentIDs)
+	})
+	if err != nil && err != errTooManyPendingPosts && err != errReplyTargetNotFound && err != errBlocked && err != errAttachmentNotFound && err != errTooManyAttachments {
+		logMessage(err.Error())
+	}
+	return err
+}
+
+// getScheduledMessages returns the pending posts of the author, the scheduled ones first (next first)
+// and then the drafts (last edited first)
+func getScheduledMessages(authorID int) ([]ScheduledMessage, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getScheduledMessages").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var posts []ScheduledMessage
+	err := dbNew.Where("author_id = ?", authorID).
+		Order("CASE WHEN publish_at = 0 THEN 1 ELSE 0 END, publish_at, updated_at DESC, scheduled_id DESC").
+		Find(&posts).Error
+	if err != nil {
+		logMessage(err.Error())
+		return nil, err
+	}
+	return posts, nil
+}
+
+// getScheduledMessage fetches a pending post of the author, errScheduledNotFound if it does not exist
+func getScheduledMessage(authorID int, scheduledID int) (ScheduledMessage, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getScheduledMessage").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var post ScheduledMessage
+	result := dbNew.Where("scheduled_id = ? AND author_id = ?", scheduledID, authorID).Limit(1).Find(&post)
+	if result.Error != nil {
+		logMessage(result.Error.Error())
+		return post, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return post, errScheduledNotFound
+	}
+	return post, nil
+}
+
+// getScheduledAttachments returns the images of pending posts by post id, in their order in the post
+func getScheduledAttachments(scheduledIDs []int) (map[int][]Attachment, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getScheduledAttachments").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	attachments := map[int][]Attachment{}
+	if len(scheduledIDs) == 0 {
+		return attachments, nil
+	}
+
+	var rows []Attachment
+	err := dbNew.Where("scheduled_id IN ? AND message_id = 0", scheduledIDs).Order("scheduled_id, position").Find(&rows).Error
+	if err != nil {
+		logMessage(err.Error())
+		return attachments, err
+	}
+	for _, row := range rows {
+		attachments[row.ScheduledID] = append(attachments[row.ScheduledID], row)
+	}
+	return attachments, nil
+}
+
+// updateScheduledMessage changes the text or the time of a pending post, a publish time of 0 turns it into a draft
+func updateScheduledMessage(authorID int, scheduledID int, update ScheduledUpdate) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("updateScheduledMessage").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	if update.Text != nil {
+		if err := validateMessageText(*update.Text); err != nil {
+			return err
+		}
+	}
+
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		var post ScheduledMessage
+		result := tx.Where("scheduled_id = ? AND author_id = ?", scheduledID, authorID).Limit(1).Find(&post)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errScheduledNotFound
+		}
+
+		changes := map[string]interface{}{"error": "", "version": gorm.Expr("version + 1"), "updated_at": int(time.Now().UTC().Unix())}
+		if update.Text != nil {
+			if *update.Text == "" {
+				var images int64
+				if err := tx.Model(&Attachment{}).Where("scheduled_id = ? AND message_id = 0", scheduledID).Count(&images).Error; err != nil {
+					return err
+				}
+				if images == 0 {
+					return errEmptyPost
+				}
+			}
+			changes["text"] = *update.Text
+		}
+		if update.PublishAt != nil {
+			changes["publish_at"] = *update.PublishAt
+		}
+		return tx.Model(&ScheduledMessage{}).Where("scheduled_id = ?", scheduledID).Updates(changes).Error
+	})
+	if err != nil && err != errScheduledNotFound && err != errEmptyPost {
+		logMessage(err.Error())
+	}
+	return err
+}
+
+// deleteScheduledMessage deletes a pending post, its images are left to the attachment cleanup
+func deleteScheduledMessage(authorID int, scheduledID int) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("deleteScheduledMessage").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("scheduled_id = ? AND author_id = ?", scheduledID, authorID).Delete(&ScheduledMessage{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errScheduledNotFound
+		}
+		return tx.Model(&Attachment{}).Where("scheduled_id = ? AND message_id = 0", scheduledID).Update("scheduled_id", 0).Error
+	})
+	if err != nil && err != errScheduledNotFound {
+		logMessage(err.Error())
+	}
+	return err
+}
+
+// getDueScheduledMessages returns the posts scheduled at or before the given time, the oldest first
+func getDueScheduledMessages(now int, limit int) ([]ScheduledMessage, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getDueScheduledMessages").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var posts []ScheduledMessage
+	err := dbNew.Where("publish_at > 0 AND publish_at <= ?", now).Order("publish_at, scheduled_id").Limit(limit).Find(&posts).Error
+	if err != nil {
+		logMessage(err.Error())
+		return nil, err
+	}
+	return posts, nil
+}
+
+// publishScheduledMessage turns a pending post into a message. The post is deleted in the transaction
+// creating the message, so it is published once even when several replicas try. The delete matches the
+// version that was read, not the time of the last edit, as two edits can happen in the same second.
+// It fails with errScheduledNotFound when the post was published, deleted or edited since it was read,
+// and with onlyDue also when it is not due anymore.
+func publishScheduledMessage(post ScheduledMessage, onlyDue bool) (Message, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("publishScheduledMessage").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	now := int(time.Now().UTC().Unix())
+	message := Message{AuthorID: post.AuthorID, Text: post.Text, PubDate: now}
+
+	var parentAuthorID int
+	var mentionedIDs []int
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		claim := tx.Where("scheduled_id = ? AND version = ?", post.ScheduledID, post.Version)
+		if onlyDue {
+			claim = claim.Where("publish_at > 0 AND publish_at <= ?", now)
+		}
+		result := claim.Delete(&ScheduledMessage{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errScheduledNotFound
+		}
+
+		var err error
+		parentAuthorID, mentionedIDs, err = insertMessage(tx, &message, post.ReplyToID)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&Attachment{}).Where("scheduled_id = ? AND message_id = 0", post.ScheduledID).
+			Updates(map[string]interface{}{"message_id": message.MessageID, "scheduled_id": 0}).Error
+	})
+
+	if err != nil {
+		if err != errScheduledNotFound && err != errReplyTargetNotFound && err != errBlocked {
+			logMessage(err.Error())
+		}
+		return message, err
+	}
+
+	notifyNewMessage(message, parentAuthorID, mentionedIDs)
+	streamNewMessage(message.MessageID)
+	return message, nil
+}
+
+// unscheduleMessage moves a post that could not be published back to the drafts, with the reason
+func unscheduleMessage(scheduledID int, reason string) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("unscheduleMessage").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Model(&ScheduledMessage{}).Where("scheduled_id = ?", scheduledID).
+		Updates(map[string]interface{}{"publish_at": 0, "error": reason, "version": gorm.Expr("version + 1"), "updated_at": int(time.Now().UTC().Unix())}).Error
+	if err != nil {
+		logMessage(err.Error())
+	}
+	return err
+}
+
+// acquireLease takes or renews the lease on a job for ttl seconds, it tells whether holder has the lease.
+// An expired lease can be taken over, so a job moves on to another replica when its holder stops.
+func acquireLease(name string, holder string, ttl int) (bool, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("acquireLease").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	now := int(time.Now().UTC().Unix())
+	err := dbNew.Clauses(clause.OnConflict{DoNothing: true}).Create(&JobLease{Name: name, Holder: "", ExpiresAt: 0}).Error
+	if err == nil {
+		err = dbNew.Model(&JobLease{}).
+			Where("name = ? AND (holder = ? OR expires_at < ?)", name, holder, now).
+			Updates(map[string]interface{}{"holder": holder, "expires_at": now + ttl}).Error
+	}
+	// the holder is read back, MySQL does not count updates that change nothing
+	var lease JobLease
+	if err == nil {
+		err = dbNew.Where("name = ?", name).Take(&lease).Error
+	}
+	if err != nil {
+		logMessage(err.Error())
+		return false, err
+	}
+	return lease.Holder == holder, nil
+}
+
+// createPoll stores the poll of a new message, a nil poll is left out
+func createPoll(tx *gorm.DB, messageID int, poll *PollInput) error {
+	if poll == nil {
+		return nil
+	}
+	if err := tx.Create(&Poll{MessageID: messageID, ClosesAt: poll.ClosesAt}).Error; err != nil {
+		return err
+	}
+	options := make([]PollOption, 0, len(poll.Options))
+	for position, text := range poll.Options {
+		options = append(options, PollOption{MessageID: messageID, Position: position, Text: text})
+	}
+	return tx.Create(&options).Error
+}
+
+// getPolls returns the polls of the messages and their options in order, by message id
+func getPolls(messageIDs []int) (map[int]Poll, map[int][]PollOption, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getPolls").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	polls := map[int]Poll{}
+	options := map[int][]PollOption{}
+	if len(messageIDs) == 0 {
+		return polls, options, nil
+	}
+
+	var pollRows []Poll
+	err := dbNew.Where("message_id IN ?", messageIDs).Find(&pollRows).Error
+	if err != nil {
+		logMessage(err.Error())
+		return polls, options, err
+	}
+	if len(pollRows) == 0 {
+		return polls, options, nil
+	}
+
+	pollIDs := make([]int, 0, len(pollRows))
+	for _, poll := range pollRows {
+		polls[poll.MessageID] = poll
+		pollIDs = append(pollIDs, poll.MessageID)
+	}
+	var optionRows []PollOption
+	err = dbNew.Where("message_id IN ?", pollIDs).Order("message_id, position").Find(&optionRows).Error
+	if err != nil {
+		logMessage(err.Error())
+		return polls, options, err
+	}
+	for _, option := range optionRows {
+		options[option.MessageID] = append(options[option.MessageID], option)
+	}
+	return polls, options, nil
+}
+
+// getPollVotes returns the options the user voted for, by message id
+func getPollVotes(userID int, messageIDs []int) (map[int]int, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getPollVotes").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	votes := map[int]int{}
+	if len(messageIDs) == 0 {
+		return votes, nil
+	}
+	var rows []PollVote
+	err := dbNew.Where("user_id = ? AND message_id IN ?", userID, messageIDs).Find(&rows).Error
+	if err != nil {
+		logMessage(err.Error())
+		return votes, err
+	}
+	for _, row := range rows {
+		votes[row.MessageID] = row.OptionID
+	}
+	return votes, nil
+}
+
+// votePoll records the vote of a user, votes are final
+func votePoll(userID int, messageID int, optionID int) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("votePoll").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Transaction(func(tx *gorm.DB) error {
+		var message Message
+		result := tx.Where("message_id = ? AND flagged = 0 AND deleted_at = 0", messageID).Limit(1).Find(&message)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errMessageNotFound
+		}
+		visible, err := canSeeAuthor(tx, userID, message.AuthorID)
+		if err != nil {
+			return err
+		}
+		if !visible {
+			return errMessageNotFound
+		}
+		blocked, err := isBlockedEitherWay(tx, userID, message.AuthorID)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			return errBlocked
+		}
+
+		var poll Poll
+		result = tx.Where("message_id = ?", messageID).Limit(1).Find(&poll)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errPollNotFound
+		}
+		if poll.ClosesAt <= int(time.Now().UTC().Unix()) {
+			return errPollClosed
+		}
+
+		var options int64
+		if err := tx.Model(&PollOption{}).Where("option_id = ? AND message_id = ?", optionID, messageID).Count(&options).Error; err != nil {
+			return err
+		}
+		if options == 0 {
+			return errPollOptionNotFound
+		}
+
+		vote := PollVote{MessageID: messageID, UserID: userID, OptionID: optionID, CreatedAt: int(time.Now().UTC().Unix())}
+		result = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&vote)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errAlreadyVoted
+		}
+		if err := tx.Model(&PollOption{}).Where("option_id = ?", optionID).
+			UpdateColumn("vote_count", gorm.Expr("vote_count + 1")).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Poll{}).Where("message_id = ?", messageID).
+			UpdateColumn("vote_count", gorm.Expr("vote_count + 1")).Error
+	})
+
+	if err != nil && err != errMessageNotFound && err != errPollNotFound && err != errPollClosed &&
+		err != errPollOptionNotFound && err != errAlreadyVoted && err != errBlocked {
+		logMessage(err.Error())
+	}
+	return err
+}
+
+/*
+	FEED TOKENS
+*/
+
+// getFeedToken returns the token of the personal feed of the user, "" if the feed is off
+func getFeedToken(userID int) (string, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getFeedToken").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var tokens []string
+	err := dbNew.Model(&FeedToken{}).Where("user_id = ?", userID).Limit(1).Pluck("token", &tokens).Error
+	if err != nil {
+		logMessage(err.Error())
+		return "", err
+	}
+	if len(tokens) == 0 {
+		return "", nil
+	}
+	return tokens[0], nil
+}
+
+// resetFeedToken gives the user a new feed token, the feed urls with the old one stop working
+func resetFeedToken(userID int) (string, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("resetFeedToken").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	token, err := newFeedToken()
+	if err != nil {
+		return "", err
+	}
+	err = dbNew.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"token", "created_at"}),
+	}).Create(&FeedToken{UserID: userID, Token: token, CreatedAt: int(time.Now().UTC().Unix())}).Error
+	if err != nil {
+		logMessage(err.Error())
+		return "", err
+	}
+	return token, nil
+}
+
+// deleteFeedToken turns the personal feed of the user off
+func deleteFeedToken(userID int) error {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("deleteFeedToken").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err := dbNew.Where("user_id = ?", userID).Delete(&FeedToken{}).Error
+	if err != nil {
+		logMessage(err.Error())
+	}
+	return err
+}
+
+// getUserByFeedToken finds the owner of a feed token, found is false for unknown (or revoked) tokens
+func getUserByFeedToken(token string) (User, bool, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getUserByFeedToken").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	var users []User
+	err := dbNew.Model(&User{}).
+		Joins("JOIN feed_token ON feed_token.user_id = user.user_id").
+		Where("feed_token.token = ?", token).
+		Limit(1).
+		Find(&users).Error
+	if err != nil {
+		logMessage(err.Error())
+		return User{}, false, err
+	}
+	if len(users) == 0 {
+		return User{}, false, nil
+	}
+	return users[0], true, nil
+}
+
+/*
+	TIMELINE STREAMS
+*/
+
+// StreamAudience holds who may see the new messages of an author in the timeline streams
+type StreamAudience struct {
+	Private   bool
+	Followers map[int]bool
+	Blocked   map[int]bool // blocking the author or blocked by them
+	Muting    map[int]bool // muting the author, or the author of the message of a plain repost
+}
+
+// getStreamAudience loads the audience of a new message of authorID, repostedAuthorID is the author
+// of the message of a plain repost (0 for other messages)
+func getStreamAudience(authorID int, repostedAuthorID int) (StreamAudience, error) {
+	//monitoring for Prometheus
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		dbProcessDuration.WithLabelValues("getStreamAudience").Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	audience := StreamAudience{Followers: map[int]bool{}, Blocked: map[int]bool{}, Muting: map[int]bool{}}
+	var private []bool
+	var followers, blockers, blocked, muting []int
+	err := dbNew.Model(&User{}).Where("user_id = ?", authorID).Limit(1).Pluck("private", &private).Error
+	if err == nil {
+		err = dbNew.Model(&Follower{}).Where("whom_id = ?", authorID).Pluck("who_id", &followers).Error
+	}
+	if err == nil {
+		err = dbNew.Model(&UserBlock{}).Where("blocked_id = ?", authorID).Pluck("blocker_id", &blockers).Error
+	}
+	if err == nil {
+		err = dbNew.Model(&UserBlock{}).Where("blocker_id = ?", authorID).Pluck("blocked_id", &blocked).Error
+	}
+	if err == nil {
+		err = dbNew.Model(&UserMute{}).Where("muted_id IN ?", []int{authorID, repostedAuthorID}).Pluck("muter_id", &muting).Error
+	}
+	if err != nil {
+		logMessage(err.Error())
+		return audience, err
+	}
+
+	audience.Private = len(private) > 0 && private[0]
+	for _, id := range followers {
+		audience.Followers[id] = true
+	}
+	for _, id := range append(blockers, blocked...) {
+		audience.Blocked[id] = true
+	}
+	for _, id := range muting {
+		audience.Muting[id] = true
+	}
+	return audience, nil
 }
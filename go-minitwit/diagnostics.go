@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/debug"
+	runtimepprof "runtime/pprof"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// set at build time with -ldflags "-X main.buildCommit=<sha> -X main.buildTime=<time>"
+var (
+	buildCommit string
+	buildTime   string
+)
+
+var processStartTime = time.Now()
+
+type BuildInfo struct {
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+	Modified  bool   `json:"modified"`
+}
+
+// getBuildInfo prefers the ldflags values and falls back on the vcs info embedded by the go toolchain
+func getBuildInfo() BuildInfo {
+	info := BuildInfo{
+		Commit:    buildCommit,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildTime == "" {
+					info.BuildTime = setting.Value
+				}
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+	}
+
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = "unknown"
+	}
+	return info
+}
+
+/*
+DIAGNOSTICS ROUTES
+*/
+
+// registerDiagnosticsRoutes mounts pprof and the runtime stats under /debug.
+// The pprof index resolves named profiles from the /debug/pprof/ prefix, so the paths can not be moved.
+func registerDiagnosticsRoutes(router gin.IRouter) {
+	group := router.Group("/debug")
+	group.GET("/pprof/", gin.WrapF(pprof.Index))
+	group.GET("/pprof/cmdline", gin.WrapF(pprof.Cmdline))
+	group.GET("/pprof/profile", cpuProfileHandler)
+	group.GET("/pprof/symbol", gin.WrapF(pprof.Symbol))
+	group.POST("/pprof/symbol", gin.WrapF(pprof.Symbol))
+	group.GET("/pprof/trace", gin.WrapF(pprof.Trace))
+	group.GET("/pprof/:profile", func(c *gin.Context) {
+		pprof.Handler(c.Param("profile")).ServeHTTP(c.Writer, c.Request)
+	})
+
+	group.GET("/runtime", runtimeStatsHandler)
+	group.GET("/buildinfo", func(c *gin.Context) {
+		c.JSON(http.StatusOK, getBuildInfo())
+	})
+}
+
+// loopbackAddr keeps the port of a listen address and binds it to 127.0.0.1 unless it is already
+// on a loopback host
+func loopbackAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if host == "localhost" {
+		return addr, nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return addr, nil
+	}
+	return net.JoinHostPort("127.0.0.1", port), nil
+}
+
+// setupDiagnostics exposes the diagnostics either on a separate admin listener (ADMIN_ADDR)
+// or on the main router. Basic auth is enforced whenever ADMIN_USER and ADMIN_PASSWORD are set.
+// Without credentials the admin listener is bound to localhost, and on the main router the routes
+// are not mounted at all.
+func setupDiagnostics(router *gin.Engine) {
+	adminAddr := os.Getenv("ADMIN_ADDR")
+	adminUser := os.Getenv("ADMIN_USER")
+	adminPassword := os.Getenv("ADMIN_PASSWORD")
+
+	var middleware []gin.HandlerFunc
+	if adminUser != "" && adminPassword != "" {
+		middleware = append(middleware, gin.BasicAuth(gin.Accounts{adminUser: adminPassword}))
+	}
+
+	if adminAddr != "" && len(middleware) == 0 {
+		localAddr, err := loopbackAddr(adminAddr)
+		if err != nil {
+
+			logger.WithFields(logrus.Fields{
+				"action": "start admin server",
+				"status": "failed",
+				"error":  err.Error(),
+			}).Error("Admin listener not started: ADMIN_ADDR is not a host:port address.")
+
+			return
+		}
+		if localAddr != adminAddr {
+			logger.WithFields(logrus.Fields{
+				"action": "start admin server",
+				"status": "restricted",
+				"addr":   localAddr,
+			}).Warn("ADMIN_USER and ADMIN_PASSWORD are not set, the admin listener only accepts local connections.")
+		}
+		adminAddr = localAddr
+	}
+
+	if adminAddr != "" {
+		adminRouter := gin.New()
+		adminRouter.Use(gin.Recovery())
+		adminRouter.Use(middleware...)
+		registerDiagnosticsRoutes(adminRouter)
+
+		go func() {
+			logger.WithFields(logrus.Fields{
+				"action": "start admin server",
+				"status": "success",
+				"addr":   adminAddr,
+			}).Info("Diagnostics are served on the admin listener.")
+
+			if err := adminRouter.Run(adminAddr); err != nil {
+				logger.WithFields(logrus.Fields{
+					"action": "start admin server",
+					"status": "failed",
+					"error":  err.Error(),
+				}).Error("Admin listener stopped.")
+			}
+		}()
+		return
+	}
+
+	if len(middleware) == 0 {
+		logger.WithFields(logrus.Fields{
+			"action": "setup diagnostics",
+			"status": "disabled",
+		}).Warn("Diagnostics disabled: set ADMIN_ADDR or ADMIN_USER and ADMIN_PASSWORD to enable them.")
+		return
+	}
+
+	registerDiagnosticsRoutes(router.Group("", middleware...))
+}
+
+/*
+HANDLERS
+*/
+
+// cpuProfileHandler records a CPU profile for ?seconds=N (default 30, max 300) and sends it as a download
+func cpuProfileHandler(c *gin.Context) {
+	seconds, err := strconv.Atoi(c.DefaultQuery("seconds", "30"))
+	if err != nil || seconds <= 0 || seconds > 300 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "seconds must be between 1 and 300"})
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"source":   "diagnostics",
+		"endpoint": "/debug/pprof/profile",
+		"action":   "cpu_profile",
+		"seconds":  seconds,
+	}).Info("Capturing CPU profile")
+
+	var buf bytes.Buffer
+	if err := runtimepprof.StartCPUProfile(&buf); err != nil {
+		// only one CPU profile can run at a time
+		c.JSON(http.StatusConflict, gin.H{"error": "Could not start CPU profile: " + err.Error()})
+		return
+	}
+
+	select {
+	case <-time.After(time.Duration(seconds) * time.Second):
+	case <-c.Request.Context().Done():
+	}
+	runtimepprof.StopCPUProfile()
+
+	filename := "minitwit-cpu-" + time.Now().UTC().Format("20060102T150405") + ".pprof"
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Data(http.StatusOK, "application/octet-stream", buf.Bytes())
+}
+
+func runtimeStatsHandler(c *gin.Context) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+
+	var lastPauses []string
+	for i, pause := range gc.Pause {
+		if i == 10 {
+			break
+		}
+		lastPauses = append(lastPauses, pause.String())
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"build":      getBuildInfo(),
+		"uptime":     time.Since(processStartTime).Round(time.Second).String(),
+		"goroutines": runtime.NumGoroutine(),
+		"num_cpu":    runtime.NumCPU(),
+		"gomaxprocs": runtime.GOMAXPROCS(0),
+		"memory": gin.H{
+			"alloc_bytes":       mem.Alloc,
+			"total_alloc_bytes": mem.TotalAlloc,
+			"sys_bytes":         mem.Sys,
+			"heap_alloc_bytes":  mem.HeapAlloc,
+			"heap_inuse_bytes":  mem.HeapInuse,
+			"heap_idle_bytes":   mem.HeapIdle,
+			"heap_objects":      mem.HeapObjects,
+			"stack_inuse_bytes": mem.StackInuse,
+		},
+		"gc": gin.H{
+			"num_gc":         gc.NumGC,
+			"last_gc":        gc.LastGC,
+			"pause_total":    gc.PauseTotal.String(),
+			"last_pauses":    lastPauses,
+			"next_gc_bytes":  mem.NextGC,
+			"gc_cpu_percent": mem.GCCPUFraction * 100,
+		},
+	})
+}
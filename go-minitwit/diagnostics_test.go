@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestLoopbackAddr(t *testing.T) {
+	cases := map[string]string{
+		":6060":           "127.0.0.1:6060",
+		"0.0.0.0:6060":    "127.0.0.1:6060",
+		"10.0.0.5:6060":   "127.0.0.1:6060",
+		"[::]:6060":       "127.0.0.1:6060",
+		"127.0.0.1:6060":  "127.0.0.1:6060",
+		"127.0.0.2:6060":  "127.0.0.2:6060",
+		"localhost:6060":  "localhost:6060",
+		"[::1]:6060":      "[::1]:6060",
+		"admin.host:6060": "127.0.0.1:6060",
+	}
+	for addr, want := range cases {
+		if got, err := loopbackAddr(addr); err != nil || got != want {
+			t.Errorf("loopbackAddr(%q) = %q, %v, want %q", addr, got, err, want)
+		}
+	}
+	if _, err := loopbackAddr("6060"); err == nil {
+		t.Errorf("an address without port was accepted")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEditHistoryKeepsVersionsInOrder(t *testing.T) {
+	setupTestDB(t)
+	router := testRouter(t)
+	alice := createTestUser(t, "alice")
+	messageID := postTestMessage(t, alice, "version one")
+
+	// the edits land in the same second, the history is ordered by when they were stored
+	for _, text := range []string{"version two", "version two", "version three", "version four"} {
+		if err := editMessage(alice, messageID, text); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	edits, err := getMessageEdits(messageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, edit := range edits {
+		texts = append(texts, edit.Text)
+	}
+	// saving the same text again is not an edit
+	if strings.Join(texts, "|") != "version one|version two|version three" {
+		t.Errorf("the previous versions are %q, want one, two and three", texts)
+	}
+
+	response := serveTestRequest(router, http.MethodGet, "/api/v2/msgs/"+strconv.Itoa(messageID)+"/history", "", 0)
+	var history struct {
+		Content string `json:"content"`
+		Edits   []struct {
+			Content string `json:"content"`
+		} `json:"edits"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &history); err != nil {
+		t.Fatalf("the API answered %d: %s", response.Code, response.Body.String())
+	}
+	if history.Content != "version four" || len(history.Edits) != 3 || history.Edits[0].Content != "version one" || history.Edits[2].Content != "version three" {
+		t.Errorf("the API history is %+v", history)
+	}
+
+	page := serveTestRequest(router, http.MethodGet, "/msg/"+strconv.Itoa(messageID)+"/history", "", 0).Body.String()
+	previous := -1
+	for _, text := range []string{"version one", "version two", "version three", "version four"} {
+		at := strings.Index(page, text)
+		if at < 0 || at < previous {
+			t.Errorf("the history page does not show %q after the earlier versions", text)
+		}
+		previous = at
+	}
+}
+
+func TestEditMessageLimits(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	messageID := postTestMessage(t, alice, "original")
+
+	if err := editMessage(bob, messageID, "not yours"); err != errNotMessageAuthor {
+		t.Errorf("editing the message of somebody else returned %v, want errNotMessageAuthor", err)
+	}
+	if err := editMessage(alice, messageID+1, "missing"); err != errMessageNotFound {
+		t.Errorf("editing a missing message returned %v, want errMessageNotFound", err)
+	}
+
+	t.Setenv("EDIT_WINDOW", "1m")
+	old := int(time.Now().UTC().Add(-2 * time.Minute).Unix())
+	if err := dbNew.Model(&Message{}).Where("message_id = ?", messageID).Update("pub_date", old).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := editMessage(alice, messageID, "too late"); err != errEditWindowClosed {
+		t.Errorf("editing after the window returned %v, want errEditWindowClosed", err)
+	}
+
+	if err := deleteMessage(alice, messageID); err != nil {
+		t.Fatal(err)
+	}
+	if err := editMessage(alice, messageID, "deleted"); err != errMessageNotFound {
+		t.Errorf("editing a deleted message returned %v, want errMessageNotFound", err)
+	}
+	if edits, _ := getMessageEdits(messageID); len(edits) != 0 {
+		t.Errorf("refused edits left %d versions in the history", len(edits))
+	}
+}
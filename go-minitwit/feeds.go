@@ -0,0 +1,559 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+FEEDS
+The public timeline, the user timelines and the tags have Atom, RSS 2.0 and JSON Feed 1.1 feeds:
+/public.atom, /<username>.atom and /tag/<name>.atom, or .rss and .json. The personal timeline of a
+user has a paged feed at /feeds/<token>.atom (or .json), the secret token stands in for the login
+and is replaced to revoke the old url. Entries are identified by the address of the message page.
+Readers send back the ETag or the Last-Modified date and get 304 while nothing changed.
+*/
+
+const (
+	FEED_FORMAT_ATOM = "atom"
+	FEED_FORMAT_RSS  = "rss"
+	FEED_FORMAT_JSON = "json"
+	FEED_TITLE_RUNES = 80
+)
+
+// Feed is a timeline independent of the format it is served in, with absolute urls
+type Feed struct {
+	Title    string
+	ID       string // the url of the (first page of the) feed
+	SelfURL  string
+	PageURL  string // the timeline page of the feed
+	FirstURL string // the pages of a paged feed
+	PrevURL  string
+	NextURL  string
+	Private  bool // a personal feed, not to be kept by shared caches
+	Updated  time.Time
+	Entries  []FeedEntry
+}
+
+type FeedEntry struct {
+	URL       string // the message page, also the id of the entry
+	Title     string
+	Author    string
+	AuthorURL string
+	Content   string // html
+	Published time.Time
+	Updated   time.Time
+}
+
+// FeedLink is an autodiscovery <link> of a page
+type FeedLink struct {
+	Title string
+	Type  string
+	Href  string
+}
+
+// feedLinks announces the Atom and RSS feeds of a page, path is the feed path without extension
+func feedLinks(path string, title string) []FeedLink {
+	return []FeedLink{
+		{Title: title + " (Atom)", Type: "application/atom+xml", Href: path + ".atom"},
+		{Title: title + " (RSS)", Type: "application/rss+xml", Href: path + ".rss"},
+		{Title: title + " (JSON Feed)", Type: "application/feed+json", Href: path + ".json"},
+	}
+}
+
+// splitFeedName splits "alice.atom" into "alice" and "atom", the format is empty without a feed extension
+func splitFeedName(name string) (string, string) {
+	for _, format := range []string{FEED_FORMAT_ATOM, FEED_FORMAT_RSS, FEED_FORMAT_JSON} {
+		if base := strings.TrimSuffix(name, "."+format); base != name && base != "" {
+			return base, format
+		}
+	}
+	return name, ""
+}
+
+func newFeedToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// personalFeedPath is the path of the personal feed with the token, without extension
+func personalFeedPath(token string) string {
+	return "/feeds/" + token
+}
+
+func profilePath(username string) string {
+	return "/" + url.PathEscape(username)
+}
+
+// siteURL is the address the absolute links of feeds start with, PUBLIC_URL or taken from the request
+func siteURL(c *gin.Context) string {
+	if site := os.Getenv("PUBLIC_URL"); site != "" {
+		return strings.TrimRight(site, "/")
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// newFeed builds the feed of formatted timeline messages, flagged messages are left out
+func newFeed(c *gin.Context, title string, selfPath string, pagePath string, messages []MessageUI) Feed {
+	site := siteURL(c)
+	feed := Feed{Title: title, ID: site + selfPath, SelfURL: site + selfPath, PageURL: site + pagePath, Updated: time.Unix(0, 0).UTC()}
+	for _, m := range messages {
+		if m.Flagged || m.Unavailable {
+			continue
+		}
+		entry := FeedEntry{
+			URL:       site + m.Thread_link,
+			Title:     feedEntryTitle(m),
+			Author:    m.Username,
+			AuthorURL: site + m.Profile_link,
+			Content:   absoluteLinks(feedEntryContent(m), site),
+			Published: time.Unix(int64(m.PubDate), 0).UTC(),
+			Updated:   time.Unix(int64(m.PubDate), 0).UTC(),
+		}
+		if m.EditedAt > m.PubDate {
+			entry.Updated = time.Unix(int64(m.EditedAt), 0).UTC()
+		}
+		if entry.Updated.After(feed.Updated) {
+			feed.Updated = entry.Updated
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed
+}
+
+// paginate links the other pages of a paged feed, all pages share the id of the first one
+func (feed *Feed) paginate(pagination Pagination) {
+	feed.SelfURL = feedPageURL(feed.ID, pagination.Page)
+	feed.FirstURL = feed.ID
+	feed.PrevURL = feedPageURL(feed.ID, pagination.PrevPage)
+	feed.NextURL = feedPageURL(feed.ID, pagination.NextPage)
+}
+
+func feedPageURL(first string, page int) string {
+	switch page {
+	case 0:
+		return ""
+	case 1:
+		return first
+	}
+	return first + "?page=" + strconv.Itoa(page)
+}
+
+// feedEntryTitle is the author and the beginning of the text, feed readers list the entries by title
+func feedEntryTitle(m MessageUI) string {
+	author := "@" + m.Username
+	if len(m.RepostedBy) > 0 {
+		author = "@" + m.RepostedBy[0] + " reposted " + author
+	}
+	text := []rune(strings.Join(strings.Fields(m.Text), " "))
+	switch {
+	case len(text) > FEED_TITLE_RUNES:
+		return author + ": " + string(text[:FEED_TITLE_RUNES-1]) + "…"
+	case len(text) > 0:
+		return author + ": " + string(text)
+	case len(m.Attachments) > 0:
+		return author + " posted an image"
+	}
+	return author
+}
+
+// feedEntryContent renders the message like the timeline does: its text, images, poll options and quoted message
+func feedEntryContent(m MessageUI) string {
+	var out strings.Builder
+	if m.Text != "" {
+		out.WriteString("<p>" + string(renderMessageText(m.Text, m.Mentions, nil)) + "</p>")
+	}
+	if len(m.Attachments) > 0 {
+		out.WriteString("<p>")
+		for _, a := range m.Attachments {
+			out.WriteString(`<a href="` + template.HTMLEscapeString(a.URL) + `"><img src="` + template.HTMLEscapeString(a.ThumbURL) +
+				`" alt="` + template.HTMLEscapeString(a.Alt) + `" width="` + strconv.Itoa(a.Width) + `" height="` + strconv.Itoa(a.Height) + `" /></a> `)
+		}
+		out.WriteString("</p>")
+	}
+	if m.Poll != nil {
+		out.WriteString("<ul>")
+		for _, option := range m.Poll.Options {
+			out.WriteString("<li>" + template.HTMLEscapeString(option.Text) + "</li>")
+		}
+		out.WriteString("</ul>")
+	}
+	if m.Quoted != nil {
+		out.WriteString(`<blockquote><p><a href="` + template.HTMLEscapeString(m.Quoted.Profile_link) + `">@` +
+			template.HTMLEscapeString(m.Quoted.Username) + "</a>: " + string(renderMessageText(m.Quoted.Text, m.Quoted.Mentions, nil)) + "</p></blockquote>")
+	} else if m.QuoteOfID != 0 {
+		out.WriteString("<blockquote><p>The quoted message is unavailable.</p></blockquote>")
+	}
+	return out.String()
+}
+
+// absoluteLinks makes the site relative links of rendered html absolute. The text of messages is
+// escaped when rendered, so only the attributes written by the renderer can match.
+func absoluteLinks(html string, site string) string {
+	html = strings.ReplaceAll(html, `href="/`, `href="`+site+"/")
+	return strings.ReplaceAll(html, `src="/`, `src="`+site+"/")
+}
+
+/*
+FORMATS
+*/
+
+type atomFeed struct {
+	XMLName   xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Generator string      `xml:"generator"`
+	Links     []atomLink  `xml:"link"`
+	Entries   []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Link      atomLink   `xml:"link"`
+	Author    atomPerson `xml:"author"`
+	Content   atomText   `xml:"content"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// atomLinks are the links to the feed itself and its pages, in the format of contentType
+func (feed Feed) atomLinks(contentType string) []atomLink {
+	links := []atomLink{{Rel: "self", Type: contentType, Href: feed.SelfURL}}
+	for _, page := range []atomLink{{Rel: "first", Href: feed.FirstURL}, {Rel: "previous", Href: feed.PrevURL}, {Rel: "next", Href: feed.NextURL}} {
+		if page.Href != "" {
+			page.Type = contentType
+			links = append(links, page)
+		}
+	}
+	return links
+}
+
+func (feed Feed) atom() atomFeed {
+	formatted := atomFeed{
+		ID:        feed.ID,
+		Title:     feed.Title,
+		Updated:   feed.Updated.Format(time.RFC3339),
+		Generator: "MiniTwit",
+		Links:     append(feed.atomLinks("application/atom+xml"), atomLink{Rel: "alternate", Type: "text/html", Href: feed.PageURL}),
+	}
+	for _, entry := range feed.Entries {
+		formatted.Entries = append(formatted.Entries, atomEntry{
+			ID:        entry.URL,
+			Title:     entry.Title,
+			Published: entry.Published.Format(time.RFC3339),
+			Updated:   entry.Updated.Format(time.RFC3339),
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: entry.URL},
+			Author:    atomPerson{Name: entry.Author, URI: entry.AuthorURL},
+			Content:   atomText{Type: "html", Body: entry.Content},
+		})
+	}
+	return formatted
+}
+
+type rssFeed struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	AtomSpace string     `xml:"xmlns:atom,attr"`
+	DCSpace   string     `xml:"xmlns:dc,attr"`
+	Channel   rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string     `xml:"title"`
+	Link          string     `xml:"link"`
+	Description   string     `xml:"description"`
+	LastBuildDate string     `xml:"lastBuildDate"`
+	Links         []atomLink `xml:"atom:link"`
+	Items         []rssItem  `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Creator     string  `xml:"dc:creator"`
+	Description string  `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+func (feed Feed) rss() rssFeed {
+	formatted := rssFeed{
+		Version:   "2.0",
+		AtomSpace: "http://www.w3.org/2005/Atom",
+		DCSpace:   "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:         feed.Title,
+			Link:          feed.PageURL,
+			Description:   feed.Title,
+			LastBuildDate: feed.Updated.Format(time.RFC1123Z),
+			Links:         feed.atomLinks("application/rss+xml"),
+		},
+	}
+	for _, entry := range feed.Entries {
+		formatted.Channel.Items = append(formatted.Channel.Items, rssItem{
+			Title:       entry.Title,
+			Link:        entry.URL,
+			GUID:        rssGUID{IsPermaLink: true, Value: entry.URL},
+			PubDate:     entry.Published.Format(time.RFC1123Z),
+			Creator:     entry.Author,
+			Description: entry.Content,
+		})
+	}
+	return formatted
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	NextURL     string         `json:"next_url,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url"`
+	Title         string           `json:"title"`
+	ContentHTML   string           `json:"content_html"`
+	DatePublished string           `json:"date_published"`
+	DateModified  string           `json:"date_modified"`
+	Authors       []jsonFeedAuthor `json:"authors"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+}
+
+func (feed Feed) json() jsonFeed {
+	formatted := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       feed.Title,
+		HomePageURL: feed.PageURL,
+		FeedURL:     feed.SelfURL,
+		NextURL:     feed.NextURL,
+		Items:       []jsonFeedItem{},
+	}
+	for _, entry := range feed.Entries {
+		formatted.Items = append(formatted.Items, jsonFeedItem{
+			ID:            entry.URL,
+			URL:           entry.URL,
+			Title:         entry.Title,
+			ContentHTML:   entry.Content,
+			DatePublished: entry.Published.Format(time.RFC3339),
+			DateModified:  entry.Updated.Format(time.RFC3339),
+			Authors:       []jsonFeedAuthor{{Name: entry.Author, URL: entry.AuthorURL}},
+		})
+	}
+	return formatted
+}
+
+/*
+SERVING
+*/
+
+// serveFeed answers with the feed in the format, or with 304 if the reader has it already
+func serveFeed(c *gin.Context, feed Feed, format string) {
+	var contentType string
+	var body []byte
+	var err error
+	switch format {
+	case FEED_FORMAT_RSS:
+		contentType = "application/rss+xml; charset=utf-8"
+		body, err = marshalXMLFeed(feed.rss())
+	case FEED_FORMAT_JSON:
+		contentType = "application/feed+json; charset=utf-8"
+		body, err = json.MarshalIndent(feed.json(), "", "  ")
+	default:
+		contentType = "application/atom+xml; charset=utf-8"
+		body, err = marshalXMLFeed(feed.atom())
+	}
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "feed",
+			"action":   "marshal_" + format,
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to render feed")
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(body))
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", feed.Updated.Format(http.TimeFormat))
+	if feed.Private {
+		// the url holds the token, keep it out of shared caches and referrers
+		c.Header("Cache-Control", "private, max-age=60")
+		c.Header("Referrer-Policy", "no-referrer")
+	} else {
+		c.Header("Cache-Control", "public, max-age=60")
+	}
+	if feedNotModified(c.Request, etag, feed.Updated) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, contentType, body)
+}
+
+func marshalXMLFeed(document interface{}) ([]byte, error) {
+	body, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// feedNotModified checks the conditional headers of the request, If-None-Match wins over If-Modified-Since
+func feedNotModified(r *http.Request, etag string, updated time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !updated.After(since)
+}
+
+// feedError logs why a feed could not be built
+func feedError(c *gin.Context, action string, err error) {
+
+	logger.WithFields(logrus.Fields{
+		"source":   "user_interface",
+		"endpoint": "feed",
+		"action":   action,
+		"status":   "error",
+		"error":    err.Error(),
+	}).Error("Error building feed")
+
+	c.AbortWithError(http.StatusInternalServerError, err)
+}
+
+// handles GET /public.atom and /public.rss
+func publicFeedHandler(c *gin.Context) {
+	_, format := splitFeedName(c.Request.URL.Path)
+	messages, err := getPublicMessages(PERPAGE)
+	if err != nil {
+		feedError(c, "fetch_public_messages", err)
+		return
+	}
+	serveFeed(c, newFeed(c, "MiniTwit public timeline", "/public."+format, "/public", formatTimeline(messages, 0)), format)
+}
+
+// userFeedHandler serves /<username>.atom and .rss, private accounts have no feed
+func userFeedHandler(c *gin.Context, username string, format string) {
+	user, err := getUserByUsername(username)
+	if err != nil {
+		feedError(c, "fetch_user", err)
+		return
+	}
+	if user.Username == "" || user.Private {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	messages, err := getUserMessages(user.UserID, 0, PERPAGE)
+	if err != nil {
+		feedError(c, "fetch_user_messages", err)
+		return
+	}
+	path := profilePath(user.Username)
+	serveFeed(c, newFeed(c, "@"+user.Username+" on MiniTwit", path+"."+format, path, formatTimeline(messages, 0)), format)
+}
+
+// tagFeedHandler serves /tag/<name>.atom and .rss
+func tagFeedHandler(c *gin.Context, tag string, format string) {
+	messages, err := getTaggedMessages(tag, 0, PERPAGE, 0)
+	if err != nil {
+		feedError(c, "fetch_tagged_messages", err)
+		return
+	}
+	tagPath := tagLink(tag)
+	serveFeed(c, newFeed(c, "#"+tag+" on MiniTwit", tagPath+"."+format, tagPath, formatTimeline(messages, 0)), format)
+}
+
+// handles GET /feeds/<token>.atom and .json, the personal timeline of the owner of the token in pages
+func personalFeedHandler(c *gin.Context) {
+	token, format := splitFeedName(c.Param("file"))
+	if format == "" {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	user, found, err := getUserByFeedToken(token)
+	if err != nil {
+		feedError(c, "fetch_feed_token", err)
+		return
+	}
+	if !found {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	page := getPage(c)
+	messages, err := getMyMessages(strconv.Itoa(user.UserID), PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+		feedError(c, "fetch_my_messages", err)
+		return
+	}
+	pagination := newPagination(page, len(messages))
+	if len(messages) > PERPAGE {
+		messages = messages[:PERPAGE]
+	}
+
+	feed := newFeed(c, "@"+user.Username+"'s timeline on MiniTwit", personalFeedPath(token)+"."+format, "/", formatTimeline(messages, user.UserID))
+	feed.Private = true
+	feed.paginate(pagination)
+	serveFeed(c, feed, format)
+}
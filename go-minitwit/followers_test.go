@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestFollowingTwiceCountsOnce(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+
+	for i := 0; i < 3; i++ {
+		if err := followUser(strconv.Itoa(alice), strconv.Itoa(bob)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var follows int64
+	if err := dbNew.Model(&Follower{}).Where("who_id = ? AND whom_id = ?", alice, bob).Count(&follows).Error; err != nil {
+		t.Fatal(err)
+	}
+	if follows != 1 {
+		t.Fatalf("got %d follows, want 1", follows)
+	}
+
+	aliceStats, err := getUserStats(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobStats, err := getUserStats(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aliceStats.FollowingCount != 1 || bobStats.FollowerCount != 1 {
+		t.Fatalf("got following %d and followers %d, want 1 and 1", aliceStats.FollowingCount, bobStats.FollowerCount)
+	}
+}
+
+func TestMigrationDropsDuplicateFollows(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+
+	// a database from before the follower pairs were unique
+	if err := dbNew.Migrator().DropIndex(&Follower{}, "idx_follower_pair"); err != nil {
+		t.Fatal(err)
+	}
+	followTestUser(t, alice, bob)
+	followTestUser(t, alice, bob)
+	if err := backfillUserStats(dbNew, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	migrateDB(dbNew)
+
+	if !dbNew.Migrator().HasIndex(&Follower{}, "idx_follower_pair") {
+		t.Fatal("the unique index of the follower pairs is missing")
+	}
+	var follows int64
+	if err := dbNew.Model(&Follower{}).Where("who_id = ? AND whom_id = ?", alice, bob).Count(&follows).Error; err != nil {
+		t.Fatal(err)
+	}
+	if follows != 1 {
+		t.Fatalf("got %d follows, want 1", follows)
+	}
+	stats, err := getUserStats(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.FollowingCount != 1 {
+		t.Fatalf("got following %d, want 1", stats.FollowingCount)
+	}
+}
@@ -3,12 +3,39 @@ package main
 import (
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
+
+	"github.com/gin-gonic/gin"
 )
 
+type Pagination struct {
+	Page     int
+	PrevPage int
+	NextPage int
+}
+
+// the first path segments of the routes, a user with one of these names would have an unreachable
+// profile page. /debug is mounted by setupDiagnostics.
+var reservedUsernames = map[string]bool{
+	"add_message": true, "api": true, "attachments": true, "avatars": true, "bookmarks": true,
+	"debug": true, "feeds": true, "lists": true, "login": true, "logout": true, "messages": true,
+	"metrics": true, "msg": true, "notifications": true, "public": true, "public.atom": true,
+	"public.rss": true, "register": true, "scheduled": true, "search": true, "settings": true,
+	"static": true, "stream": true, "tag": true,
+}
+
+// isReservedUsername tells whether a username is taken by a route, ignoring case
+func isReservedUsername(username string) bool {
+	return reservedUsernames[strings.ToLower(username)]
+}
+
 // Helper functions
 
 func checkPasswordHash(userEnteredPwd string, dbpwd string) bool {
@@ -18,58 +45,323 @@ func checkPasswordHash(userEnteredPwd string, dbpwd string) bool {
 	return str == dbpwd
 }
 
-func gravatarURL(email string, size int) string {
-	if size <= 0 {
-		size = 80 // Default size
+func formatMessages(messages []MessageUser) []MessageUI {
+	var formattedMessages []MessageUI
+
+	for _, m := range messages {
+		formattedMessages = append(formattedMessages, formatMessage(m))
 	}
+	attachMentions(formattedMessages)
+	attachAttachments(formattedMessages)
 
-	email = strings.ToLower(strings.TrimSpace(email))
-	hash := md5.Sum([]byte(email))
-	return fmt.Sprintf("http://www.gravatar.com/avatar/%x?d=identicon&s=%d", hash, size)
+	return formattedMessages
 }
 
-func formatMessages(messages []MessageUser) []MessageUI {
-	var formattedMessages []MessageUI
+func formatMessage(m MessageUser) MessageUI {
+	var msg MessageUI
+	// Use type assertion for int64, then convert to int
+	if reflect.TypeOf(m.MessageID).Kind() == reflect.Int {
+		msg.MessageID = m.MessageID
+	}
+	if reflect.TypeOf(m.AuthorID).Kind() == reflect.Int {
+		msg.AuthorID = m.AuthorID
+	}
+	if reflect.TypeOf(m.UserID).Kind() == reflect.Int {
+		msg.User.UserID = m.UserID
+	}
+	if reflect.TypeOf(m.Text).Kind() == reflect.String {
+		msg.Text = m.Text
+	}
+	if reflect.TypeOf(m.Username).Kind() == reflect.String {
+		msg.Username = m.Username
+	}
+	if reflect.TypeOf(m.Email).Kind() == reflect.String {
+		msg.Email = m.Email
+	}
+	if reflect.TypeOf(m.PubDate).Kind() == reflect.Int {
+		msg.PubDate = m.PubDate
+	}
+	link := "/" + msg.Username
+	msg.Profile_link = strings.ReplaceAll(link, " ", "%20")
+	msg.ReplyToID = m.ReplyToID
+	msg.ReplyToUsername = m.ReplyToUsername
+	msg.Thread_link = "/msg/" + strconv.Itoa(m.MessageID)
+	msg.LikeCount = m.LikeCount
+	msg.RepostCount = m.RepostCount
+	msg.QuoteOfID = m.RepostOfID
+	msg.Flagged = isHiddenMessage(m)
+	msg.EditedAt = m.EditedAt
+
+	msg.Avatar = avatarURL(m.UserID, m.AvatarVersion, m.Email, 48)
+
+	return msg
+}
+
+// isHiddenMessage tells whether a message was flagged or deleted
+func isHiddenMessage(m MessageUser) bool {
+	return m.Flagged != 0 || m.DeletedAt != 0
+}
 
+// isPureRepost tells a repost without commentary from a quote post, which has its own text
+func isPureRepost(m MessageUser) bool {
+	return m.RepostOfID != 0 && m.Text == ""
+}
+
+// formatTimeline formats timeline rows as seen by viewerID (0 for anonymous viewers), replacing reposts
+// by the reposted message and embedding the quoted message of quote posts. A message reposted several
+// times (or present itself as well) is shown once, at its most recent position, with everybody that
+// reposted it. Reposted messages the viewer may not see (private or blocked authors) are unavailable.
+func formatTimeline(messages []MessageUser, viewerID int) []MessageUI {
+	var repostedIDs []int
 	for _, m := range messages {
-		var msg MessageUI
-		// Use type assertion for int64, then convert to int
-		if reflect.TypeOf(m.MessageID).Kind() == reflect.Int {
-			msg.MessageID = m.MessageID
+		if m.RepostOfID != 0 {
+			repostedIDs = append(repostedIDs, m.RepostOfID)
 		}
-		if reflect.TypeOf(m.AuthorID).Kind() == reflect.Int {
-			msg.AuthorID = m.AuthorID
+	}
+	originals, err := getMessagesByIDs(repostedIDs, viewerID)
+	if err != nil {
+		originals = map[int]MessageUser{}
+	}
+
+	var formattedMessages []MessageUI
+	position := map[int]int{}
+
+	for _, m := range messages {
+		if isPureRepost(m) {
+			original, ok := originals[m.RepostOfID]
+			if !ok {
+				original = MessageUser{MessageID: m.RepostOfID}
+			} else if isHiddenMessage(original) {
+				continue
+			}
+			if i, seen := position[original.MessageID]; seen {
+				if !containsString(formattedMessages[i].RepostedBy, m.Username) && formattedMessages[i].Username != m.Username {
+					formattedMessages[i].RepostedBy = append(formattedMessages[i].RepostedBy, m.Username)
+				}
+				continue
+			}
+			msg := formatMessage(original)
+			msg.Unavailable = !ok
+			msg.RepostedBy = []string{m.Username}
+			position[original.MessageID] = len(formattedMessages)
+			formattedMessages = append(formattedMessages, msg)
+			continue
 		}
-		if reflect.TypeOf(m.UserID).Kind() == reflect.Int {
-			msg.User.UserID = m.UserID
+
+		if _, seen := position[m.MessageID]; seen {
+			continue
 		}
-		if reflect.TypeOf(m.Text).Kind() == reflect.String {
-			msg.Text = m.Text
+		msg := formatMessage(m)
+		if m.RepostOfID != 0 {
+			if original, ok := originals[m.RepostOfID]; ok && !isHiddenMessage(original) {
+				quoted := formatMessage(original)
+				msg.Quoted = &quoted
+			}
 		}
-		if reflect.TypeOf(m.Username).Kind() == reflect.String {
-			msg.Username = m.Username
+		position[m.MessageID] = len(formattedMessages)
+		formattedMessages = append(formattedMessages, msg)
+	}
+	attachMentions(formattedMessages)
+	attachAttachments(formattedMessages)
+	attachPolls(formattedMessages)
+
+	return formattedMessages
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
 		}
-		if reflect.TypeOf(m.Email).Kind() == reflect.String {
-			msg.Email = m.Email
+	}
+	return false
+}
+
+func formatUsers(users []User) []UserUI {
+	var formattedUsers []UserUI
+
+	for _, u := range users {
+		link := "/" + u.Username
+		formattedUsers = append(formattedUsers, UserUI{
+			UserID:       u.UserID,
+			Username:     u.Username,
+			Profile_link: strings.ReplaceAll(link, " ", "%20"),
+			Avatar:       avatarURL(u.UserID, u.AvatarVersion, u.Email, 48),
+		})
+	}
+
+	return formattedUsers
+}
+
+func formatProfile(user User) ProfileUI {
+	return ProfileUI{
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		Location:    user.Location,
+		Website:     user.Website,
+		Avatar:      avatarURL(user.UserID, user.AvatarVersion, user.Email, 80),
+	}
+}
+
+func formatConversations(conversations []ConversationSummary) []ConversationUI {
+	var formatted []ConversationUI
+	for _, conversation := range conversations {
+		formatted = append(formatted, ConversationUI{
+			ConversationID: conversation.ConversationID,
+			Link:           "/messages/" + strconv.Itoa(conversation.ConversationID),
+			Participants:   conversation.Participants,
+			LastMessage:    conversation.LastMessage,
+			LastSender:     conversation.LastSender,
+			UpdatedAt:      conversation.UpdatedAt,
+			Unread:         conversation.Unread,
+		})
+	}
+	return formatted
+}
+
+// formatDirectMessages formats a page of direct messages, which are fetched newest first, in chronological order
+func formatDirectMessages(messages []DirectMessageUser, userID int) []DirectMessageUI {
+	var formatted []DirectMessageUI
+	for i := len(messages) - 1; i >= 0; i-- {
+		m := messages[i]
+		formatted = append(formatted, DirectMessageUI{
+			Username:     m.Username,
+			Profile_link: strings.ReplaceAll("/"+m.Username, " ", "%20"),
+			Avatar:       avatarURL(m.SenderID, m.AvatarVersion, m.Email, 48),
+			Text:         m.Text,
+			PubDate:      m.PubDate,
+			Mine:         m.SenderID == userID,
+		})
+	}
+	return formatted
+}
+
+var errMissingUpload = errors.New("no file was uploaded")
+
+// readUpload reads the file of a multipart form field. At most maxBytes+1 bytes are read,
+// so callers can tell a file that is too large from one that just fits.
+func readUpload(c *gin.Context, field string, maxBytes int) ([]byte, error) {
+	header, err := c.FormFile(field)
+	if err == http.ErrMissingFile {
+		return nil, errMissingUpload
+	} else if err != nil {
+		return nil, err
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(io.LimitReader(file, int64(maxBytes)+1))
+}
+
+// renderPage renders a page of the logged in part of the site, adding the unread notification and direct message badges
+func renderPage(c *gin.Context, code int, name string, data gin.H) {
+	if userID, err := c.Cookie("UserID"); err == nil {
+		if userIDInt, err := strconv.Atoi(userID); err == nil {
+			data["UnreadNotifications"], _ = countUnreadNotifications(userIDInt)
+			data["UnreadDirectMessages"], _ = countUnreadDirectMessages(userIDInt)
 		}
-		if reflect.TypeOf(m.PubDate).Kind() == reflect.Int {
-			msg.PubDate = m.PubDate
+	}
+	c.HTML(code, name, data)
+}
+
+// getPage reads the 1-based ?page= query parameter, falling back on the first page
+func getPage(c *gin.Context) int {
+	page, err := strconv.Atoi(c.Query("page"))
+	if err != nil || page < 1 {
+		return 1
+	}
+	return page
+}
+
+// newPagination builds the prev/next links of a page. Pages are fetched with PERPAGE+1 rows,
+// so fetched > PERPAGE tells there is a next page.
+func newPagination(page int, fetched int) Pagination {
+	pagination := Pagination{Page: page}
+	if page > 1 {
+		pagination.PrevPage = page - 1
+	}
+	if fetched > PERPAGE {
+		pagination.NextPage = page + 1
+	}
+	return pagination
+}
+
+// markViewerState sets what the logged in user did with the messages (liked, reposted, bookmarked, wrote them)
+func markViewerState(messages []MessageUI, userID int) {
+	var messageIDs []int
+	for _, m := range messages {
+		messageIDs = append(messageIDs, m.MessageID)
+	}
+
+	liked, err := getLikedMessageIDs(userID, messageIDs)
+	if err != nil {
+		return
+	}
+	reposted, err := getRepostedMessageIDs(userID, messageIDs)
+	if err != nil {
+		return
+	}
+	bookmarked, err := getBookmarkedMessageIDs(userID, messageIDs)
+	if err != nil {
+		return
+	}
+	for i := range messages {
+		messages[i].Liked = liked[messages[i].MessageID]
+		messages[i].Reposted = reposted[messages[i].MessageID]
+		messages[i].Bookmarked = bookmarked[messages[i].MessageID]
+		messages[i].Mine = userID != 0 && messages[i].AuthorID == userID
+		messages[i].Editable = messages[i].Mine && canEditMessage(messages[i].PubDate)
+	}
+	markPollVotes(messages, userID)
+}
+
+// buildThread arranges the messages of a conversation as a tree below rootID.
+// Deleted or flagged messages are kept as Missing placeholders so their replies are still shown.
+func buildThread(rootID int, currentID int, messages []MessageUI) *ThreadNode {
+	nodes := map[int]*ThreadNode{}
+	for _, m := range messages {
+		nodes[m.MessageID] = &ThreadNode{
+			Message: m,
+			Missing: m.Flagged,
+			Current: m.MessageID == currentID,
 		}
-		link := "/" + msg.Username
-		msg.Profile_link = strings.ReplaceAll(link, " ", "%20")
+	}
 
-		gravatarURL := gravatarURL(msg.Email, 48)
-		msg.Gravatar = gravatarURL
+	root, ok := nodes[rootID]
+	if !ok {
+		root = &ThreadNode{Message: MessageUI{MessageID: rootID}, Missing: true}
+		nodes[rootID] = root
+	}
 
-		formattedMessages = append(formattedMessages, msg)
+	// messages are ordered oldest first, so replies are appended in chronological order
+	for _, m := range messages {
+		if m.MessageID == rootID {
+			continue
+		}
+		parent, ok := nodes[m.ReplyToID]
+		if !ok {
+			// the parent is gone, keep its place in the tree directly below the root
+			parent = &ThreadNode{Message: MessageUI{MessageID: m.ReplyToID}, Missing: true}
+			nodes[m.ReplyToID] = parent
+			root.Children = append(root.Children, parent)
+		}
+		parent.Children = append(parent.Children, nodes[m.MessageID])
 	}
 
-	return formattedMessages
+	return root
 }
 
 func filterMessages(messages []MessageUser) []FilteredMsg {
 	var filteredMessages []FilteredMsg
 	for _, m := range messages {
+		// reposts have no content of their own, the v1 API only knows plain messages
+		if isPureRepost(m) {
+			continue
+		}
+
 		var filteredMsg FilteredMsg
 		// content
 		if reflect.TypeOf(m.Text).Kind() == reflect.String {
@@ -84,6 +376,8 @@ func filterMessages(messages []MessageUser) []FilteredMsg {
 			filteredMsg.User = m.Username
 		}
 
+		filteredMsg.ReplyTo = m.ReplyToID
+
 		filteredMessages = append(filteredMessages, filteredMsg)
 	}
 	return filteredMessages
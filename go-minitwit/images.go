@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // registers the decoders of the accepted upload types
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+/*
+IMAGES
+Uploaded images (avatars and attachments) are decoded and re-encoded, which drops the
+metadata (EXIF, GPS position, comments) the original file carried.
+*/
+
+const MAX_IMAGE_DIMENSION = 4096
+
+var acceptedImageTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true}
+
+// errInvalidImage tells the user why an upload was refused
+type errInvalidImage struct {
+	Reason string
+}
+
+func (e errInvalidImage) Error() string {
+	return e.Reason
+}
+
+// decodeImage validates and decodes an upload of at most maxBytes, JPEG photos are turned
+// upright as their EXIF orientation is lost on re-encoding. It returns the detected content type.
+func decodeImage(data []byte, maxBytes int) (image.Image, string, error) {
+	if len(data) == 0 {
+		return nil, "", errMissingUpload
+	}
+	if len(data) > maxBytes {
+		return nil, "", errInvalidImage{fmt.Sprintf("the image can be at most %d MB", maxBytes>>20)}
+	}
+	contentType := http.DetectContentType(data)
+	if !acceptedImageTypes[contentType] {
+		return nil, "", errInvalidImage{"the image must be a JPEG, PNG or GIF file"}
+	}
+
+	// check the dimensions before decoding, a small file can still decode into a huge image
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", errInvalidImage{"the image could not be read"}
+	}
+	if config.Width > MAX_IMAGE_DIMENSION || config.Height > MAX_IMAGE_DIMENSION || config.Width == 0 || config.Height == 0 {
+		return nil, "", errInvalidImage{fmt.Sprintf("the image can be at most %dx%d pixels", MAX_IMAGE_DIMENSION, MAX_IMAGE_DIMENSION)}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", errInvalidImage{"the image could not be read"}
+	}
+	if contentType == "image/jpeg" {
+		img = applyOrientation(toRGBA(img), jpegOrientation(data))
+	}
+	return img, contentType, nil
+}
+
+// encodeImage writes photos as JPEG and everything else (which may be transparent) as PNG,
+// it returns the encoded image and its file extension
+func encodeImage(img image.Image, contentType string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if contentType == "image/jpeg" {
+		err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+		return buf.Bytes(), ".jpg", err
+	}
+	err := png.Encode(&buf, img)
+	return buf.Bytes(), ".png", err
+}
+
+// jpegOrientation reads the EXIF orientation (1-8) of a JPEG file, 1 (upright) if there is none
+func jpegOrientation(data []byte) int {
+	// walk the segments up to the APP1 Exif segment, which comes before the image data
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if marker == 0xDA || length < 2 || i+2+length > len(data) {
+			break
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && len(segment) > 14 && string(segment[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+func tiffOrientation(tiff []byte) int {
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[offset : offset+2]))
+	for e := 0; e < entries; e++ {
+		entry := offset + 2 + e*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8 : entry+10]))
+			if orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// applyOrientation mirrors and rotates an image so it shows upright with orientation 1
+func applyOrientation(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	// orientations 5-8 swap width and height
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dy*dst.Stride+dx*4:dy*dst.Stride+dx*4+4], src.Pix[y*src.Stride+x*4:y*src.Stride+x*4+4])
+		}
+	}
+	return dst
+}
+
+// toRGBA copies an image into an RGBA image starting at 0,0
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == image.Pt(0, 0) {
+		return rgba
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// cropSquare copies the centered square of an image into an RGBA image
+func cropSquare(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	offset := image.Pt(bounds.Min.X+(bounds.Dx()-side)/2, bounds.Min.Y+(bounds.Dy()-side)/2)
+
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, offset, draw.Src)
+	return square
+}
+
+// fitWithin scales width x height down (never up) to fit a maxSide x maxSide box, keeping the aspect ratio
+func fitWithin(width int, height int, maxSide int) (int, int) {
+	if width <= maxSide && height <= maxSide {
+		return width, height
+	}
+	if width >= height {
+		return maxSide, max1(height * maxSide / width)
+	}
+	return max1(width * maxSide / height), maxSide
+}
+
+func max1(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// resizeImage scales with a box filter: every target pixel averages the source pixels it covers,
+// when upscaling that is a single source pixel
+func resizeImage(src *image.RGBA, width int, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := (y + 1) * srcHeight / height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := (x + 1) * srcWidth / width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					pixel := row[sx*4 : sx*4+4]
+					r += int(pixel[0])
+					g += int(pixel[1])
+					b += int(pixel[2])
+					a += int(pixel[3])
+					n++
+				}
+			}
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
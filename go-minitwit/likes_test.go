@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLikedQuotePostsShowTheQuotedMessage(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	carol := createTestUser(t, "carol")
+	original := postTestMessage(t, alice, "a striped zebra")
+	if err := repostMessage(bob, original, "look at this"); err != nil {
+		t.Fatal(err)
+	}
+	if err := likeMessage(carol, lastTestMessageID(t, bob)); err != nil {
+		t.Fatal(err)
+	}
+
+	response := serveTestRequest(testRouter(t), http.MethodGet, "/carol/likes", "", 0)
+	if response.Code != http.StatusOK {
+		t.Fatalf("got status %d", response.Code)
+	}
+	if !strings.Contains(response.Body.String(), "striped zebra") {
+		t.Errorf("the liked quote post doesn't show the quoted message")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+LISTS
+Named lists of users curated by their owner, each with its own timeline.
+Private lists (and their timelines) are only visible to the owner, members are not told they were added.
+*/
+
+const (
+	MAX_LIST_NAME_LENGTH        = 50
+	MAX_LIST_DESCRIPTION_LENGTH = 160
+	MAX_LISTS_PER_USER          = 50
+)
+
+var (
+	errListNotFound           = errors.New("list not found")
+	errListNameTaken          = errors.New("you already have a list with this name")
+	errListNameRequired       = errors.New("the list needs a name")
+	errListNameTooLong        = fmt.Errorf("the list name can be at most %d characters long", MAX_LIST_NAME_LENGTH)
+	errListDescriptionTooLong = fmt.Errorf("the list description can be at most %d characters long", MAX_LIST_DESCRIPTION_LENGTH)
+	errTooManyLists           = fmt.Errorf("you can have at most %d lists", MAX_LISTS_PER_USER)
+)
+
+// ListUpdate holds the list fields to change, nil fields are left as they are
+type ListUpdate struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Private     *bool   `json:"private"`
+}
+
+// validateList trims the name and description of an update and checks their length (in characters)
+func validateList(update *ListUpdate) error {
+	if update.Name != nil {
+		*update.Name = strings.Join(strings.Fields(*update.Name), " ")
+		if *update.Name == "" {
+			return errListNameRequired
+		}
+		if utf8.RuneCountInString(*update.Name) > MAX_LIST_NAME_LENGTH {
+			return errListNameTooLong
+		}
+	}
+	if update.Description != nil {
+		*update.Description = strings.TrimSpace(*update.Description)
+		if utf8.RuneCountInString(*update.Description) > MAX_LIST_DESCRIPTION_LENGTH {
+			return errListDescriptionTooLong
+		}
+	}
+	return nil
+}
+
+// isListInputError tells the errors caused by the submitted list fields
+func isListInputError(err error) bool {
+	return err == errListNameTaken || err == errListNameRequired || err == errListNameTooLong ||
+		err == errListDescriptionTooLong || err == errTooManyLists
+}
+
+// canViewList tells whether a list and its timeline are visible to viewerID (0 for anonymous viewers)
+func canViewList(list UserList, viewerID int) bool {
+	return !list.Private || list.OwnerID == viewerID
+}
+
+type ListUI struct {
+	ListID      int
+	Name        string
+	Description string
+	Private     bool
+	Owner       string
+	Link        string
+	MemberCount int
+	Member      bool // the profile user is a member, on the profile page
+}
+
+func formatLists(lists []ListSummary) []ListUI {
+	var formatted []ListUI
+	for _, list := range lists {
+		formatted = append(formatted, ListUI{
+			ListID:      list.ListID,
+			Name:        list.Name,
+			Description: list.Description,
+			Private:     list.Private,
+			Owner:       list.OwnerUsername,
+			Link:        "/lists/" + strconv.Itoa(list.ListID),
+			MemberCount: list.MemberCount,
+		})
+	}
+	return formatted
+}
+
+// list representation of the v2 API
+type APIList struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Private     bool   `json:"private"`
+	Owner       string `json:"owner"`
+	Members     int    `json:"members"`
+	CreatedAt   int64  `json:"created_at"`
+}
+
+func formatAPIList(list ListSummary) APIList {
+	return APIList{
+		ID:          list.ListID,
+		Name:        list.Name,
+		Description: list.Description,
+		Private:     list.Private,
+		Owner:       list.OwnerUsername,
+		Members:     list.MemberCount,
+		CreatedAt:   int64(list.CreatedAt),
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import "testing"
+
+func createTestList(t *testing.T, ownerID int, name string, private bool) int {
+	t.Helper()
+	list, err := createList(ownerID, ListUpdate{Name: &name, Private: &private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list.ListID
+}
+
+func listTestMemberIDs(t *testing.T, listID int) []int {
+	t.Helper()
+	members, err := getListMembers(listID, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, member := range members {
+		ids = append(ids, member.UserID)
+	}
+	return ids
+}
+
+func TestListMembership(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	carol := createTestUser(t, "carol")
+	mallory := createTestUser(t, "mallory")
+	listID := createTestList(t, alice, "friends", false)
+
+	for _, userID := range []int{bob, carol, bob} {
+		if err := addListMember(alice, listID, userID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if members := listTestMemberIDs(t, listID); len(members) != 2 {
+		t.Errorf("the list has members %v, want bob and carol once", members)
+	}
+	if list, _, _ := getList(listID); list.MemberCount != 2 {
+		t.Errorf("the list counts %d members, want 2", list.MemberCount)
+	}
+	if memberships, _ := getListMemberships(alice, bob); !memberships[listID] {
+		t.Errorf("bob is not shown as a member")
+	}
+
+	// only the owner manages the members
+	if err := addListMember(mallory, listID, mallory); err != errListNotFound {
+		t.Errorf("adding to the list of somebody else returned %v, want errListNotFound", err)
+	}
+	if err := removeListMember(mallory, listID, bob); err != errListNotFound {
+		t.Errorf("removing from the list of somebody else returned %v, want errListNotFound", err)
+	}
+	if err := blockUser(mallory, alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := addListMember(alice, listID, mallory); err != errBlocked {
+		t.Errorf("adding a user that blocked the owner returned %v, want errBlocked", err)
+	}
+
+	if err := removeListMember(alice, listID, bob); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeListMember(alice, listID, bob); err != nil {
+		t.Errorf("removing a non member returned %v", err)
+	}
+	if members := listTestMemberIDs(t, listID); len(members) != 1 || members[0] != carol {
+		t.Errorf("the list has members %v after removing bob, want only carol", members)
+	}
+
+	if err := deleteList(alice, listID); err != nil {
+		t.Fatal(err)
+	}
+	var left int64
+	dbNew.Model(&UserListMember{}).Where("list_id = ?", listID).Count(&left)
+	if left != 0 {
+		t.Errorf("%d memberships are left after deleting the list", left)
+	}
+}
+
+func TestListTimelineShowsOnlyMembers(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	carol := createTestUser(t, "carol")
+	listID := createTestList(t, alice, "friends", false)
+	if err := addListMember(alice, listID, bob); err != nil {
+		t.Fatal(err)
+	}
+	bobs := postTestMessage(t, bob, "from a member")
+	postTestMessage(t, carol, "from somebody else")
+
+	messages, err := getListMessages(listID, 0, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].MessageID != bobs {
+		t.Errorf("the list timeline has %v, want only the message of its member", messages)
+	}
+
+	// a member going private shows up only for their followers
+	setTestUserPrivate(t, bob)
+	if messages, _ := getListMessages(listID, alice, 10, 0); len(messages) != 0 {
+		t.Errorf("the list timeline shows %d messages of a private member to a non-follower", len(messages))
+	}
+}
+
+func TestPrivateListsOnlyShowToTheirOwner(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	createTestList(t, alice, "public", false)
+	privateID := createTestList(t, alice, "secret", true)
+
+	list, found, err := getList(privateID)
+	if err != nil || !found {
+		t.Fatalf("getList returned %v, %v", found, err)
+	}
+	for viewer, want := range map[int]bool{alice: true, bob: false, 0: false} {
+		if got := canViewList(list.UserList, viewer); got != want {
+			t.Errorf("user %d can view the private list: %v, want %v", viewer, got, want)
+		}
+	}
+	if lists, _ := getUserLists(alice, false); len(lists) != 1 || lists[0].Name != "public" {
+		t.Errorf("the lists shown to others are %v, want only the public one", lists)
+	}
+	if lists, _ := getUserLists(alice, true); len(lists) != 2 {
+		t.Errorf("the owner sees %d lists, want 2", len(lists))
+	}
+}
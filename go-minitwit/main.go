@@ -1,6 +1,7 @@
 package main
 
 import (
+	"html/template"
 	"os"
 	"sync"
 
@@ -23,6 +24,7 @@ type FilteredMsg struct {
 	Content string `json:"content"`
 	PubDate int64  `json:"pub_date"`
 	User    string `json:"user"`
+	ReplyTo int    `json:"reply_to,omitempty"`
 }
 
 var dbNew *gorm.DB
@@ -67,12 +69,67 @@ func main() {
 		}
 	}
 
+	// uploaded files (avatars, attachments)
+	blobStore, err = newBlobStore()
+	if err != nil {
+		panic("failed to open the blob store: " + err.Error())
+	}
+
+	// full-text search of the messages
+	searchIndex, err = newSearchBackend(dbNew)
+	if err != nil {
+		panic("failed to set up search: " + err.Error())
+	}
+
+	router := setupRouter()
+
+	threadGroup.Wait()
+
+	// trending hashtags are recomputed in the background
+	startTrendingJob()
+
+	// notifications are stored asynchronously, off the write paths
+	startNotificationWorker()
+
+	// new messages are pushed to the open timelines
+	startStreamHub()
+
+	// images that were uploaded but never attached to a message are removed
+	startAttachmentCleanupJob()
+
+	// scheduled posts are published when they are due, by one replica at a time
+	startSchedulerJob()
+
+	// pprof and runtime diagnostics, behind admin auth or on the admin listener
+	setupDiagnostics(router)
+
+	// Start the server
+	router.Run(":8081")
+
+	logger.WithFields(logrus.Fields{
+		"action": "start server",
+		"status": "success",
+		"port":   8081,
+	}).Info("Application server minitwit is listening.")
+
+}
+
+// setupRouter creates the router with the templates, the middleware and all routes
+func setupRouter() *gin.Engine {
 	// Create a Gin router and set the parsed templates
 	router := gin.Default()
 	router.Use(AfterRequest()) // This is the middleware that will be called after each request for Prometheus
 	router.Use(beforeRequestHandler)
 
+	router.SetFuncMap(template.FuncMap{
+		"linkify":          renderMessageText,
+		"attachmentSlots":  attachmentSlots,
+		"pollSlots":        pollSlots,
+		"pollDurations":    pollDurations,
+		"maxMessageLength": maxMessageLength,
+	})
 	router.LoadHTMLGlob("./templates/*.html")
+	htmlRender = router.HTMLRender
 
 	// sessions, for cookies
 	store := cookie.NewStore([]byte("devops"))
@@ -85,15 +142,67 @@ func main() {
 	// user routes
 	router.GET("/", myTimelineHandler)
 	router.GET("/public", publicTimelineHandler)
+	router.GET("/public.atom", publicFeedHandler)
+	router.GET("/public.rss", publicFeedHandler)
 	router.GET("/:username", userTimelineHandler)
 	router.GET("/register", registerHandler)
 	router.GET("/login", loginHandler)
 	router.GET("/logout", logoutHandler)
-	router.GET("/:username/*action", userFollowActionHandler)
+	router.GET("/msg/:id", threadHandler)
+	router.GET("/msg/:id/likes", likedByHandler)
+	router.GET("/msg/:id/history", messageHistoryHandler)
+	router.GET("/tag/:name", tagHandler)
+	router.GET("/notifications", notificationsHandler)
+	router.GET("/messages", inboxHandler)
+	router.GET("/messages/:id", conversationHandler)
+	router.GET("/avatars/:id/:version/:file", avatarHandler)
+	router.GET("/attachments/:token/:file", attachmentHandler)
+	router.GET("/search", searchHandler)
+	router.GET("/bookmarks", bookmarksHandler)
+	router.GET("/lists", listsHandler)
+	router.GET("/lists/:id", listTimelineHandler)
+	router.GET("/lists/:id/members", listMembersHandler)
+	router.GET("/scheduled", scheduledHandler)
+	router.GET("/settings/profile", profileSettingsHandler)
+	router.GET("/settings/blocks", blockSettingsHandler)
+	router.GET("/settings/follow_requests", followRequestsHandler)
+	router.GET("/feeds/:file", personalFeedHandler)
+	router.GET("/stream/public", streamHandler)
+	router.GET("/stream/home", streamHandler)
+	router.GET("/stream/users/:username", streamHandler)
+	router.GET("/:username/*action", userActionHandler)
 
 	router.POST("/register", registerHandler)
 	router.POST("/login", loginHandler)
 	router.POST("/add_message", addMessageHandler)
+	router.POST("/msg/:id/like", likeActionHandler)
+	router.POST("/msg/:id/unlike", likeActionHandler)
+	router.POST("/msg/:id/bookmark", bookmarkActionHandler)
+	router.POST("/msg/:id/unbookmark", bookmarkActionHandler)
+	router.POST("/msg/:id/vote", voteActionHandler)
+	router.POST("/lists", listSettingsHandler)
+	router.POST("/lists/:id/settings", listSettingsHandler)
+	router.POST("/lists/:id/delete", deleteListHandler)
+	router.POST("/lists/:id/members", listMemberHandler)
+	router.POST("/scheduled/:id", scheduledActionHandler)
+	router.POST("/msg/:id/repost", repostActionHandler)
+	router.POST("/msg/:id/unrepost", repostActionHandler)
+	router.POST("/msg/:id/edit", editMessageHandler)
+	router.POST("/msg/:id/delete", deleteMessageHandler)
+	router.POST("/notifications/read", markNotificationsReadHandler)
+	router.POST("/notifications/:id/read", markNotificationsReadHandler)
+	router.POST("/notifications/preferences", notificationPreferencesHandler)
+	router.POST("/messages", startConversationHandler)
+	router.POST("/messages/settings", directMessageSettingsHandler)
+	router.POST("/messages/:id", sendDirectMessageHandler)
+	router.POST("/settings/profile", updateProfileHandler)
+	router.POST("/settings/avatar", avatarSettingsHandler)
+	router.POST("/settings/email", accountSettingsHandler)
+	router.POST("/settings/password", accountSettingsHandler)
+	router.POST("/settings/blocks", blockActionHandler)
+	router.POST("/settings/follow_requests", followRequestActionHandler)
+	router.POST("/settings/privacy", privacySettingsHandler)
+	router.POST("/settings/feed", feedSettingsHandler)
 
 	// API routes
 	// is it easier to separate the next two routes into two handlers?
@@ -108,18 +217,76 @@ func main() {
 	// some helper method to "cache" what was the latest simulator action
 	router.GET("/api/latest", getLatestHandler)
 
+	// API v2 routes
+	apiV2 := router.Group("/api/v2", apiV2AuthRequired())
+	apiV2.GET("/users/:username/followers", apiV2FollowListHandler)
+	apiV2.GET("/users/:username/following", apiV2FollowListHandler)
+	apiV2.GET("/users/:username/stats", apiV2UserStatsHandler)
+	apiV2.GET("/users/:username/profile", apiV2ProfileHandler)
+	apiV2.PATCH("/users/:username/profile", apiV2ProfileHandler)
+	apiV2.PUT("/users/:username/avatar", apiV2AvatarHandler)
+	apiV2.POST("/users/:username/attachments", apiV2AttachmentHandler)
+	apiV2.DELETE("/users/:username/avatar", apiV2AvatarHandler)
+	apiV2.GET("/users/:username/likes", apiV2UserLikesHandler)
+	apiV2.GET("/users/:username/bookmarks", apiV2BookmarksHandler)
+	apiV2.POST("/users/:username/bookmarks", apiV2BookmarkHandler)
+	apiV2.DELETE("/users/:username/bookmarks/:id", apiV2BookmarkHandler)
+	apiV2.GET("/users/:username/lists", apiV2ListsHandler)
+	apiV2.POST("/users/:username/lists", apiV2ListsHandler)
+	apiV2.PATCH("/users/:username/lists/:id", apiV2ListHandler)
+	apiV2.DELETE("/users/:username/lists/:id", apiV2ListHandler)
+	apiV2.POST("/users/:username/lists/:id/members", apiV2ListMemberHandler)
+	apiV2.DELETE("/users/:username/lists/:id/members/:member", apiV2ListMemberHandler)
+	apiV2.GET("/users/:username/scheduled", apiV2ScheduledHandler)
+	apiV2.POST("/users/:username/scheduled", apiV2ScheduledHandler)
+	apiV2.GET("/users/:username/scheduled/:id", apiV2ScheduledPostHandler)
+	apiV2.PATCH("/users/:username/scheduled/:id", apiV2ScheduledPostHandler)
+	apiV2.DELETE("/users/:username/scheduled/:id", apiV2ScheduledPostHandler)
+	apiV2.POST("/users/:username/scheduled/:id/publish", apiV2PublishScheduledHandler)
+	apiV2.GET("/users/:username/mentions", apiV2UserMentionsHandler)
+	apiV2.GET("/users/:username/notifications", apiV2NotificationsHandler)
+	apiV2.POST("/users/:username/notifications/read", apiV2MarkNotificationsReadHandler)
+	apiV2.GET("/users/:username/notification_preferences", apiV2NotificationPreferencesHandler)
+	apiV2.PUT("/users/:username/notification_preferences", apiV2NotificationPreferencesHandler)
+	apiV2.GET("/users/:username/conversations", apiV2ConversationsHandler)
+	apiV2.POST("/users/:username/conversations", apiV2StartConversationHandler)
+	apiV2.GET("/users/:username/conversations/:id", apiV2ConversationHandler)
+	apiV2.POST("/users/:username/conversations/:id", apiV2SendDirectMessageHandler)
+	apiV2.GET("/users/:username/dm_settings", apiV2DirectMessageSettingsHandler)
+	apiV2.PUT("/users/:username/dm_settings", apiV2DirectMessageSettingsHandler)
+	apiV2.POST("/users/:username/following", apiV2FollowHandler)
+	apiV2.GET("/users/:username/following/:target", apiV2FollowStateHandler)
+	apiV2.DELETE("/users/:username/following/:target", apiV2UnfollowHandler)
+	apiV2.GET("/users/:username/follow_requests", apiV2FollowRequestsHandler)
+	apiV2.POST("/users/:username/follow_requests/:requester", apiV2FollowRequestHandler)
+	apiV2.DELETE("/users/:username/follow_requests/:requester", apiV2FollowRequestHandler)
+	apiV2.GET("/users/:username/privacy", apiV2PrivacyHandler)
+	apiV2.PUT("/users/:username/privacy", apiV2PrivacyHandler)
+	apiV2.GET("/users/:username/blocks", apiV2BlockListHandler)
+	apiV2.POST("/users/:username/blocks", apiV2BlockHandler)
+	apiV2.DELETE("/users/:username/blocks/:target", apiV2BlockHandler)
+	apiV2.GET("/users/:username/mutes", apiV2BlockListHandler)
+	apiV2.POST("/users/:username/mutes", apiV2BlockHandler)
+	apiV2.DELETE("/users/:username/mutes/:target", apiV2BlockHandler)
+	apiV2.PATCH("/msgs/:id", apiV2EditMessageHandler)
+	apiV2.DELETE("/msgs/:id", apiV2DeleteMessageHandler)
+	apiV2.GET("/msgs/:id/history", apiV2MessageHistoryHandler)
+	apiV2.GET("/msgs/:id/likes", apiV2LikesHandler)
+	apiV2.POST("/msgs/:id/likes", apiV2LikeHandler)
+	apiV2.DELETE("/msgs/:id/likes/:username", apiV2UnlikeHandler)
+	apiV2.GET("/msgs/:id/poll", apiV2PollHandler)
+	apiV2.POST("/msgs/:id/poll/votes", apiV2VoteHandler)
+	apiV2.GET("/tags/trending", apiV2TrendingTagsHandler)
+	apiV2.GET("/tags/:name/msgs", apiV2TaggedMessagesHandler)
+	apiV2.GET("/search", apiV2SearchHandler)
+	apiV2.GET("/lists/:id", apiV2ListInfoHandler)
+	apiV2.GET("/lists/:id/members", apiV2ListMembersHandler)
+	apiV2.GET("/lists/:id/msgs", apiV2ListMessagesHandler)
+	apiV2.POST("/msgs/:id/reposts", apiV2RepostHandler)
+	apiV2.DELETE("/msgs/:id/reposts/:username", apiV2UnrepostHandler)
+
 	// registering prometeus
 	router.GET("/metrics", prometheusHandler())
 
-	threadGroup.Wait()
-
-	// Start the server
-	router.Run(":8081")
-
-	logger.WithFields(logrus.Fields{
-		"action": "start server",
-		"status": "success",
-		"port":   8081,
-	}).Info("Application server minitwit is listening.")
-
+	return router
 }
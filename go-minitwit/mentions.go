@@ -0,0 +1,65 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+/*
+MENTIONS
+*/
+
+// like hashtags a mention can not start inside a word, an e-mail address or an url
+var mentionPattern = regexp.MustCompile(`(^|[^\p{L}\p{N}_&/#@.])@([\p{L}\p{N}_][\p{L}\p{N}_.\-]{0,63})`)
+
+// trimMention drops the punctuation that ends a sentence rather than the username ("thanks @bob.")
+func trimMention(name string) string {
+	return strings.TrimRight(name, ".-")
+}
+
+// parseMentions returns the distinct usernames mentioned in a message text.
+// They still need to be resolved against the user table.
+func parseMentions(text string) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		name := trimMention(match[2])
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+func mentionedUser(name string, mentions []string) (string, bool) {
+	for _, username := range mentions {
+		if strings.EqualFold(username, name) {
+			return username, true
+		}
+	}
+	return "", false
+}
+
+// attachMentions loads the resolved mentions of the messages (and the messages they quote) for rendering
+func attachMentions(messages []MessageUI) {
+	var messageIDs []int
+	for _, m := range messages {
+		messageIDs = append(messageIDs, m.MessageID)
+		if m.Quoted != nil {
+			messageIDs = append(messageIDs, m.Quoted.MessageID)
+		}
+	}
+
+	mentions, err := getMentionedUsernames(messageIDs)
+	if err != nil {
+		return
+	}
+	for i := range messages {
+		messages[i].Mentions = mentions[messages[i].MessageID]
+		if messages[i].Quoted != nil {
+			messages[i].Quoted.Mentions = mentions[messages[i].Quoted.MessageID]
+		}
+	}
+}
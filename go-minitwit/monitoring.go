@@ -35,6 +35,21 @@ var (
 		Help: "Total number of new user signups.",
 	})
 
+	droppedNotificationsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "minitwit_notifications_dropped_total",
+		Help: "Notification events dropped because the notification queue was full.",
+	})
+
+	streamClientsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "minitwit_stream_clients",
+		Help: "Clients connected to the timeline streams.",
+	})
+
+	droppedStreamEventsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "minitwit_stream_events_dropped_total",
+		Help: "New messages not streamed because the stream queue was full.",
+	})
+
 	activeUsers = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "active_users",
 		Help: "Current number of active users.",
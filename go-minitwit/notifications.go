@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+/*
+NOTIFICATIONS
+The write paths only queue notification events, a background worker checks the preferences
+of the recipient and stores the notifications, so a slow insert never delays a follow or a message.
+*/
+
+const (
+	NOTIFY_FOLLOW         = "follow"
+	NOTIFY_FOLLOW_REQUEST = "follow_request"
+	NOTIFY_FOLLOW_ACCEPT  = "follow_accept"
+	NOTIFY_MENTION        = "mention"
+	NOTIFY_REPLY          = "reply"
+	NOTIFY_LIKE           = "like"
+)
+
+// the notification types in the order they are listed on the preferences form
+var notificationTypes = []string{NOTIFY_FOLLOW, NOTIFY_FOLLOW_REQUEST, NOTIFY_FOLLOW_ACCEPT, NOTIFY_MENTION, NOTIFY_REPLY, NOTIFY_LIKE}
+
+var notificationDescriptions = map[string]string{
+	NOTIFY_FOLLOW:         "started following you",
+	NOTIFY_FOLLOW_REQUEST: "asked to follow you",
+	NOTIFY_FOLLOW_ACCEPT:  "accepted your follow request",
+	NOTIFY_MENTION:        "mentioned you",
+	NOTIFY_REPLY:          "replied to your message",
+	NOTIFY_LIKE:           "liked your message",
+}
+
+func isNotificationType(notificationType string) bool {
+	for _, t := range notificationTypes {
+		if t == notificationType {
+			return true
+		}
+	}
+	return false
+}
+
+type NotificationEvent struct {
+	Type      string
+	UserID    int // recipient
+	ActorID   int
+	MessageID int
+	CreatedAt int
+}
+
+var notificationQueue chan NotificationEvent
+
+// notify queues a notification without blocking, events are dropped while the queue is full
+// or before the worker was started
+func notify(event NotificationEvent) {
+	if notificationQueue == nil || event.UserID == 0 || event.UserID == event.ActorID {
+		return
+	}
+	if event.CreatedAt == 0 {
+		event.CreatedAt = int(time.Now().UTC().Unix())
+	}
+
+	select {
+	case notificationQueue <- event:
+	default:
+		droppedNotificationsCounter.Inc()
+
+		logger.WithFields(logrus.Fields{
+			"source": "notifications",
+			"action": "queue_notification",
+			"status": "dropped",
+			"type":   event.Type,
+		}).Warn("Notification queue is full, dropping notification")
+	}
+}
+
+// startNotificationWorker creates the queue (NOTIFICATION_QUEUE_SIZE, default 1024) and its worker
+func startNotificationWorker() {
+	size, err := strconv.Atoi(os.Getenv("NOTIFICATION_QUEUE_SIZE"))
+	if err != nil || size <= 0 {
+		size = 1024
+	}
+	queue := make(chan NotificationEvent, size)
+
+	go func() {
+		for event := range queue {
+			storeNotification(event)
+		}
+	}()
+	notificationQueue = queue
+}
+
+func storeNotification(event NotificationEvent) {
+	preferences, err := getNotificationPreferences(event.UserID)
+	if err != nil || !preferences[event.Type] {
+		return
+	}
+	// blocks can happen between the action and its notification
+	if blocked, err := isBlockedEitherWay(dbNew, event.UserID, event.ActorID); err != nil || blocked {
+		return
+	}
+
+	err = createNotification(Notification{
+		UserID:    event.UserID,
+		ActorID:   event.ActorID,
+		Type:      event.Type,
+		MessageID: event.MessageID,
+		CreatedAt: event.CreatedAt,
+	})
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source": "notifications",
+			"action": "store_notification",
+			"status": "error",
+			"type":   event.Type,
+			"error":  err.Error(),
+		}).Error("Failed to store notification")
+	}
+}
+
+func formatNotifications(notifications []NotificationUser) []NotificationUI {
+	var formatted []NotificationUI
+	for _, n := range notifications {
+		notification := NotificationUI{
+			NotificationID: n.NotificationID,
+			Type:           n.Type,
+			Description:    notificationDescriptions[n.Type],
+			ActorUsername:  n.ActorUsername,
+			Actor_link:     strings.ReplaceAll("/"+n.ActorUsername, " ", "%20"),
+			MessageText:    n.MessageText,
+			CreatedAt:      n.CreatedAt,
+			IsRead:         n.IsRead,
+		}
+		if n.MessageID != 0 {
+			notification.Message_link = "/msg/" + strconv.Itoa(n.MessageID)
+		}
+		formatted = append(formatted, notification)
+	}
+	return formatted
+}
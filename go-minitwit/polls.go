@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+POLLS
+A message can carry a poll of 2 to 4 options that closes at a given time. Every user votes once, the
+results are shown to the voters and to everybody once the poll is closed. The vote counts are kept on
+the poll and its options, so timelines read them without counting votes.
+*/
+
+const (
+	MIN_POLL_OPTIONS       = 2
+	MAX_POLL_OPTIONS       = 4
+	MAX_POLL_OPTION_LENGTH = 50
+	MIN_POLL_DURATION      = 5 * time.Minute
+	MAX_POLL_DURATION      = 7 * 24 * time.Hour
+	DEFAULT_POLL_DURATION  = 24 * time.Hour
+)
+
+var (
+	errPollTooFewOptions   = fmt.Errorf("a poll needs at least %d options", MIN_POLL_OPTIONS)
+	errPollTooManyOptions  = fmt.Errorf("a poll can have at most %d options", MAX_POLL_OPTIONS)
+	errPollOptionTooLong   = fmt.Errorf("poll options can be at most %d characters long", MAX_POLL_OPTION_LENGTH)
+	errPollDuplicateOption = errors.New("poll options must be different")
+	errPollDuration        = errors.New("a poll must stay open between 5 minutes and 7 days")
+	errPollNotScheduled    = errors.New("polls can't be saved as drafts or scheduled")
+	errPollNotFound        = errors.New("the message has no poll")
+	errPollOptionNotFound  = errors.New("the poll has no such option")
+	errPollClosed          = errors.New("the poll is closed")
+	errAlreadyVoted        = errors.New("you already voted in this poll")
+)
+
+// PollInput is a validated poll to attach to a new message
+type PollInput struct {
+	Options  []string
+	ClosesAt int
+}
+
+// PollRequest is the poll of a message posted with the API, closing after duration seconds or at closes_at
+type PollRequest struct {
+	Options  []string `json:"options"`
+	Duration int      `json:"duration"`
+	ClosesAt int      `json:"closes_at"`
+}
+
+// newPoll checks the options (blank ones are left out) and how long the poll stays open
+func newPoll(options []string, duration time.Duration) (*PollInput, error) {
+	poll := &PollInput{}
+	seen := map[string]bool{}
+	for _, option := range options {
+		option = strings.Join(strings.Fields(option), " ")
+		if option == "" {
+			continue
+		}
+		if utf8.RuneCountInString(option) > MAX_POLL_OPTION_LENGTH {
+			return nil, errPollOptionTooLong
+		}
+		if seen[strings.ToLower(option)] {
+			return nil, errPollDuplicateOption
+		}
+		seen[strings.ToLower(option)] = true
+		poll.Options = append(poll.Options, option)
+	}
+	if len(poll.Options) < MIN_POLL_OPTIONS {
+		return nil, errPollTooFewOptions
+	}
+	if len(poll.Options) > MAX_POLL_OPTIONS {
+		return nil, errPollTooManyOptions
+	}
+	if duration < MIN_POLL_DURATION || duration > MAX_POLL_DURATION {
+		return nil, errPollDuration
+	}
+	poll.ClosesAt = int(time.Now().UTC().Add(duration).Unix())
+	return poll, nil
+}
+
+// pollFromForm reads the poll of the timeline form (poll0..poll3 and poll_duration in seconds),
+// nil if no option was filled in
+func pollFromForm(c *gin.Context) (*PollInput, error) {
+	var options []string
+	for i := 0; i < MAX_POLL_OPTIONS; i++ {
+		options = append(options, c.Request.FormValue("poll"+strconv.Itoa(i)))
+	}
+	if strings.TrimSpace(strings.Join(options, "")) == "" {
+		return nil, nil
+	}
+	seconds, err := strconv.Atoi(c.Request.FormValue("poll_duration"))
+	if err != nil {
+		return nil, errPollDuration
+	}
+	return newPoll(options, time.Duration(seconds)*time.Second)
+}
+
+// pollFromRequest validates the poll of an API request, nil stays nil
+func pollFromRequest(request *PollRequest) (*PollInput, error) {
+	if request == nil {
+		return nil, nil
+	}
+	duration := time.Duration(request.Duration) * time.Second
+	if request.ClosesAt != 0 {
+		duration = time.Until(time.Unix(int64(request.ClosesAt), 0))
+	} else if request.Duration == 0 {
+		duration = DEFAULT_POLL_DURATION
+	}
+	return newPoll(request.Options, duration)
+}
+
+// isPollInputError tells the errors caused by the submitted poll
+func isPollInputError(err error) bool {
+	return err == errPollTooFewOptions || err == errPollTooManyOptions || err == errPollOptionTooLong ||
+		err == errPollDuplicateOption || err == errPollDuration || err == errPollNotScheduled
+}
+
+// the closing times offered by the timeline form
+type PollDurationUI struct {
+	Label   string
+	Seconds int
+	Default bool
+}
+
+func pollDurations() []PollDurationUI {
+	return []PollDurationUI{
+		{Label: "5 minutes", Seconds: 5 * 60},
+		{Label: "1 hour", Seconds: 60 * 60},
+		{Label: "1 day", Seconds: 24 * 60 * 60, Default: true},
+		{Label: "3 days", Seconds: 3 * 24 * 60 * 60},
+		{Label: "7 days", Seconds: 7 * 24 * 60 * 60},
+	}
+}
+
+// pollSlots numbers the option inputs of the timeline form
+func pollSlots() []int {
+	slots := make([]int, MAX_POLL_OPTIONS)
+	for i := range slots {
+		slots[i] = i
+	}
+	return slots
+}
+
+type PollOptionUI struct {
+	OptionID int
+	Text     string
+	Votes    int
+	Percent  int
+	Chosen   bool // the option the logged in user voted for
+}
+
+type PollUI struct {
+	MessageID  int
+	Options    []PollOptionUI
+	TotalVotes int
+	ClosesAt   int
+	Closed     bool
+	Voted      bool // the logged in user voted
+}
+
+// ShowResults tells whether the vote counts can be shown, to voters or once the poll is closed
+func (p *PollUI) ShowResults() bool {
+	return p.Voted || p.Closed
+}
+
+func formatPoll(poll Poll, options []PollOption) *PollUI {
+	formatted := &PollUI{
+		MessageID:  poll.MessageID,
+		TotalVotes: poll.VoteCount,
+		ClosesAt:   poll.ClosesAt,
+		Closed:     poll.ClosesAt <= int(time.Now().UTC().Unix()),
+	}
+	for _, option := range options {
+		percent := 0
+		if poll.VoteCount > 0 {
+			percent = (option.VoteCount*100 + poll.VoteCount/2) / poll.VoteCount
+		}
+		formatted.Options = append(formatted.Options, PollOptionUI{
+			OptionID: option.OptionID,
+			Text:     option.Text,
+			Votes:    option.VoteCount,
+			Percent:  percent,
+		})
+	}
+	return formatted
+}
+
+// attachPolls loads the polls of the messages for rendering, the votes of the viewer are marked by markPollVotes
+func attachPolls(messages []MessageUI) {
+	var messageIDs []int
+	for _, m := range messages {
+		messageIDs = append(messageIDs, m.MessageID)
+	}
+
+	polls, options, err := getPolls(messageIDs)
+	if err != nil {
+		return
+	}
+	for i := range messages {
+		if poll, ok := polls[messages[i].MessageID]; ok {
+			messages[i].Poll = formatPoll(poll, options[poll.MessageID])
+		}
+	}
+}
+
+// markPollVotes marks the polls the user voted in and the options they chose
+func markPollVotes(messages []MessageUI, userID int) {
+	var pollIDs []int
+	for _, m := range messages {
+		if m.Poll != nil {
+			pollIDs = append(pollIDs, m.MessageID)
+		}
+	}
+	if userID == 0 || len(pollIDs) == 0 {
+		return
+	}
+
+	votes, err := getPollVotes(userID, pollIDs)
+	if err != nil {
+		return
+	}
+	for i := range messages {
+		optionID, voted := votes[messages[i].MessageID]
+		if messages[i].Poll == nil || !voted {
+			continue
+		}
+		messages[i].Poll.Voted = true
+		for j := range messages[i].Poll.Options {
+			messages[i].Poll.Options[j].Chosen = messages[i].Poll.Options[j].OptionID == optionID
+		}
+	}
+}
+
+// poll representation of the v2 API, the counts are left out while they are hidden from the user
+type APIPollOption struct {
+	ID    int    `json:"id"`
+	Text  string `json:"text"`
+	Votes *int   `json:"votes,omitempty"`
+}
+
+type APIPoll struct {
+	Options    []APIPollOption `json:"options"`
+	ClosesAt   int             `json:"closes_at"`
+	Closed     bool            `json:"closed"`
+	TotalVotes *int            `json:"total_votes,omitempty"`
+	Voted      int             `json:"voted,omitempty"` // the option chosen by the user
+}
+
+func formatAPIPoll(poll *PollUI) *APIPoll {
+	if poll == nil {
+		return nil
+	}
+	formatted := &APIPoll{ClosesAt: poll.ClosesAt, Closed: poll.Closed, Options: []APIPollOption{}}
+	if poll.ShowResults() {
+		total := poll.TotalVotes
+		formatted.TotalVotes = &total
+	}
+	for _, option := range poll.Options {
+		apiOption := APIPollOption{ID: option.OptionID, Text: option.Text}
+		if poll.ShowResults() {
+			votes := option.Votes
+			apiOption.Votes = &votes
+		}
+		if option.Chosen {
+			formatted.Voted = option.OptionID
+		}
+		formatted.Options = append(formatted.Options, apiOption)
+	}
+	return formatted
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// postTestPoll posts a message with a poll and returns the message and its first option
+func postTestPoll(t *testing.T, authorID int) (int, int) {
+	t.Helper()
+	poll := &PollInput{Options: []string{"yes", "no"}, ClosesAt: int(time.Now().UTC().Unix()) + 3600}
+	if err := addMessage("what do you think?", authorID, 0, nil, poll); err != nil {
+		t.Fatal(err)
+	}
+	messageID := lastTestMessageID(t, authorID)
+	var option PollOption
+	if err := dbNew.Where("message_id = ?", messageID).Order("option_id").First(&option).Error; err != nil {
+		t.Fatal(err)
+	}
+	return messageID, option.OptionID
+}
+
+func TestNewPoll(t *testing.T) {
+	long := strings.Repeat("x", MAX_POLL_OPTION_LENGTH)
+	cases := []struct {
+		name     string
+		options  []string
+		duration time.Duration
+		want     []string
+		err      error
+	}{
+		{"two options", []string{"yes", "no"}, time.Hour, []string{"yes", "no"}, nil},
+		{"blanks left out and spaces folded", []string{" yes  please ", "", "  ", "no"}, time.Hour, []string{"yes please", "no"}, nil},
+		{"most options", []string{"a", "b", "c", "d"}, time.Hour, []string{"a", "b", "c", "d"}, nil},
+		{"longest option", []string{long, "no"}, time.Hour, []string{long, "no"}, nil},
+		{"one option", []string{"yes", ""}, time.Hour, nil, errPollTooFewOptions},
+		{"too many options", []string{"a", "b", "c", "d", "e"}, time.Hour, nil, errPollTooManyOptions},
+		{"option too long", []string{long + "x", "no"}, time.Hour, nil, errPollOptionTooLong},
+		{"duplicates ignore case", []string{"Yes", "yes"}, time.Hour, nil, errPollDuplicateOption},
+		{"shortest duration", []string{"yes", "no"}, MIN_POLL_DURATION, []string{"yes", "no"}, nil},
+		{"longest duration", []string{"yes", "no"}, MAX_POLL_DURATION, []string{"yes", "no"}, nil},
+		{"too short", []string{"yes", "no"}, MIN_POLL_DURATION - time.Second, nil, errPollDuration},
+		{"too long", []string{"yes", "no"}, MAX_POLL_DURATION + time.Second, nil, errPollDuration},
+	}
+	for _, c := range cases {
+		poll, err := newPoll(c.options, c.duration)
+		if err != c.err {
+			t.Errorf("%s: got error %v, want %v", c.name, err, c.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if strings.Join(poll.Options, "|") != strings.Join(c.want, "|") {
+			t.Errorf("%s: got options %q, want %q", c.name, poll.Options, c.want)
+		}
+		if closesIn := poll.ClosesAt - int(time.Now().UTC().Unix()); closesIn < int(c.duration.Seconds())-1 || closesIn > int(c.duration.Seconds()) {
+			t.Errorf("%s: the poll closes in %d seconds, want %v", c.name, closesIn, c.duration)
+		}
+	}
+}
+
+func TestPollFromRequest(t *testing.T) {
+	if poll, err := pollFromRequest(nil); poll != nil || err != nil {
+		t.Errorf("no poll gave %v, %v", poll, err)
+	}
+	poll, err := pollFromRequest(&PollRequest{Options: []string{"yes", "no"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int(time.Now().UTC().Add(DEFAULT_POLL_DURATION).Unix()); poll.ClosesAt < want-1 || poll.ClosesAt > want {
+		t.Errorf("a poll without duration closes at %d, want %d", poll.ClosesAt, want)
+	}
+	past := int(time.Now().UTC().Unix()) - 60
+	if _, err := pollFromRequest(&PollRequest{Options: []string{"yes", "no"}, ClosesAt: past}); err != errPollDuration {
+		t.Errorf("a poll closing in the past returned %v, want errPollDuration", err)
+	}
+}
+
+func TestVotePoll(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	carol := createTestUser(t, "carol")
+	messageID, optionID := postTestPoll(t, alice)
+	plain := postTestMessage(t, alice, "no poll here")
+
+	if err := votePoll(bob, messageID, optionID); err != nil {
+		t.Fatal(err)
+	}
+	if err := votePoll(bob, messageID, optionID); err != errAlreadyVoted {
+		t.Errorf("a second vote returned %v, want errAlreadyVoted", err)
+	}
+	if err := votePoll(bob, messageID, optionID+1); err != errAlreadyVoted {
+		t.Errorf("changing the vote returned %v, want errAlreadyVoted", err)
+	}
+	if err := votePoll(carol, messageID, optionID+2); err != errPollOptionNotFound {
+		t.Errorf("voting for an option of no poll returned %v, want errPollOptionNotFound", err)
+	}
+	if err := votePoll(carol, plain, optionID); err != errPollNotFound {
+		t.Errorf("voting on a message without poll returned %v, want errPollNotFound", err)
+	}
+
+	polls, options, err := getPolls([]int{messageID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if polls[messageID].VoteCount != 1 || options[messageID][0].VoteCount != 1 || options[messageID][1].VoteCount != 0 {
+		t.Errorf("the counts are %d total, %d and %d, want 1, 1 and 0",
+			polls[messageID].VoteCount, options[messageID][0].VoteCount, options[messageID][1].VoteCount)
+	}
+
+	closed := int(time.Now().UTC().Unix())
+	if err := dbNew.Model(&Poll{}).Where("message_id = ?", messageID).Update("closes_at", closed).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := votePoll(carol, messageID, optionID); err != errPollClosed {
+		t.Errorf("voting after the poll closed returned %v, want errPollClosed", err)
+	}
+	polls, options, _ = getPolls([]int{messageID})
+	if poll := formatPoll(polls[messageID], options[messageID]); !poll.Closed || !poll.ShowResults() {
+		t.Errorf("the expired poll is not shown closed with its results")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// privateTestAccount creates carol, a private account followed by dave, with a message and bob,
+// who doesn't follow her
+func privateTestAccount(t *testing.T) (carol int, bob int, dave int, messageID int) {
+	t.Helper()
+	carol = createTestUser(t, "carol")
+	bob = createTestUser(t, "bob")
+	dave = createTestUser(t, "dave")
+	followTestUser(t, dave, carol)
+	setTestUserPrivate(t, carol)
+	messageID, _ = postTestPoll(t, carol)
+	return carol, bob, dave, messageID
+}
+
+func TestPrivateMessagesOnlyReachFollowers(t *testing.T) {
+	setupTestDB(t)
+	carol, bob, dave, _ := privateTestAccount(t)
+
+	public, err := getPublicMessages(PERPAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(public) != 0 {
+		t.Errorf("the public timeline has %d messages of a private account", len(public))
+	}
+
+	for _, c := range []struct {
+		viewer int
+		want   int
+	}{{0, 0}, {bob, 0}, {dave, 1}, {carol, 1}} {
+		messages, err := getUserMessages(carol, c.viewer, PERPAGE)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) != c.want {
+			t.Errorf("user %d sees %d messages of the private account, want %d", c.viewer, len(messages), c.want)
+		}
+	}
+}
+
+func TestPrivatePagesAnswerNotFoundToNonFollowers(t *testing.T) {
+	setupTestDB(t)
+	router := testRouter(t)
+	_, bob, dave, messageID := privateTestAccount(t)
+	message := "/msg/" + strconv.Itoa(messageID)
+
+	for _, path := range []string{message, message + "/likes", message + "/history"} {
+		for _, viewer := range []int{0, bob} {
+			if response := serveTestRequest(router, http.MethodGet, path, "", viewer); response.Code != http.StatusNotFound {
+				t.Errorf("GET %s as user %d answered %d, want 404", path, viewer, response.Code)
+			}
+		}
+		if response := serveTestRequest(router, http.MethodGet, path, "", dave); response.Code != http.StatusOK {
+			t.Errorf("GET %s as a follower answered %d, want 200", path, response.Code)
+		}
+	}
+
+	response := serveTestRequest(router, http.MethodGet, "/carol", "", bob)
+	if response.Code != http.StatusOK || strings.Contains(response.Body.String(), "what do you think?") {
+		t.Errorf("the profile of the private account shows its messages to a non-follower (%d)", response.Code)
+	}
+	response = serveTestRequest(router, http.MethodGet, "/carol", "", dave)
+	if !strings.Contains(response.Body.String(), "what do you think?") {
+		t.Errorf("the profile of the private account hides its messages from a follower")
+	}
+}
+
+func TestNonFollowersCannotInteractWithPrivateMessages(t *testing.T) {
+	setupTestDB(t)
+	_, bob, dave, messageID := privateTestAccount(t)
+	var option PollOption
+	if err := dbNew.Where("message_id = ?", messageID).First(&option).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	actions := []struct {
+		name string
+		do   func(userID int) error
+		// what followers get, reposts of private messages are refused to everybody
+		follower error
+	}{
+		{"reply", func(userID int) error { return addMessage("a reply", userID, messageID, nil, nil) }, nil},
+		{"like", func(userID int) error { return likeMessage(userID, messageID) }, nil},
+		{"vote", func(userID int) error { return votePoll(userID, messageID, option.OptionID) }, nil},
+		{"repost", func(userID int) error { return repostMessage(userID, messageID, "") }, errPrivateRepost},
+		{"quote", func(userID int) error { return repostMessage(userID, messageID, "a quote") }, errPrivateRepost},
+	}
+	for _, action := range actions {
+		if err := action.do(bob); err != errMessageNotFound && err != errReplyTargetNotFound {
+			t.Errorf("%s by a non-follower returned %v, want a not found error", action.name, err)
+		}
+		if err := action.do(dave); err != action.follower {
+			t.Errorf("%s by a follower returned %v, want %v", action.name, err, action.follower)
+		}
+	}
+}
+
+func TestAPIAnswersNotFoundToNonFollowers(t *testing.T) {
+	setupTestDB(t)
+	router := testRouter(t)
+	_, _, _, messageID := privateTestAccount(t)
+	var option PollOption
+	if err := dbNew.Where("message_id = ?", messageID).First(&option).Error; err != nil {
+		t.Fatal(err)
+	}
+	message := "/api/v2/msgs/" + strconv.Itoa(messageID)
+
+	requests := map[string]string{
+		message + "/likes":      `{"username": "bob"}`,
+		message + "/reposts":    `{"username": "bob", "content": "a quote"}`,
+		message + "/poll/votes": `{"username": "bob", "option": ` + strconv.Itoa(option.OptionID) + `}`,
+	}
+	for path, body := range requests {
+		if response := serveTestRequest(router, http.MethodPost, path, body, 0); response.Code != http.StatusNotFound {
+			t.Errorf("POST %s for a non-follower answered %d, want 404", path, response.Code)
+		}
+	}
+}
+
+func TestTrendingLeavesOutPrivateAccounts(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	carol := createTestUser(t, "carol")
+	setTestUserPrivate(t, carol)
+	postTestMessage(t, alice, "hello #public")
+	postTestMessage(t, carol, "hello #secret")
+
+	tags, err := countTagsSince(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0].Tag != "public" {
+		t.Errorf("trending tags are %v, want only #public", tags)
+	}
+}
+
+func TestMentionsAndLikesHidePrivateMessages(t *testing.T) {
+	setupTestDB(t)
+	router := testRouter(t)
+	carol, bob, dave, _ := privateTestAccount(t)
+	messageID := postTestMessage(t, carol, "whispering to @bob")
+	if err := likeMessage(dave, messageID); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/bob/mentions", "/dave/likes", "/api/v2/users/bob/mentions", "/api/v2/users/dave/likes"} {
+		for _, viewer := range []int{0, bob} {
+			request := path
+			if strings.HasPrefix(path, "/api/") && viewer == bob {
+				request += "?username=bob"
+			}
+			response := serveTestRequest(router, http.MethodGet, request, "", viewer)
+			if response.Code != http.StatusOK || strings.Contains(response.Body.String(), "whispering") {
+				t.Errorf("GET %s as user %d answered %d with the private message", request, viewer, response.Code)
+			}
+		}
+
+		request := path
+		if strings.HasPrefix(path, "/api/") {
+			request += "?username=dave"
+		}
+		if response := serveTestRequest(router, http.MethodGet, request, "", dave); !strings.Contains(response.Body.String(), "whispering") {
+			t.Errorf("GET %s hides the private message from a follower", request)
+		}
+	}
+}
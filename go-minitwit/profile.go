@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+PROFILES
+*/
+
+const (
+	MAX_DISPLAY_NAME_LENGTH = 50
+	MAX_BIO_LENGTH          = 160
+	MAX_LOCATION_LENGTH     = 30
+	MAX_WEBSITE_LENGTH      = 100
+)
+
+// ProfileUpdate holds the profile fields to change, nil fields are left as they are
+type ProfileUpdate struct {
+	DisplayName *string `json:"display_name"`
+	Bio         *string `json:"bio"`
+	Location    *string `json:"location"`
+	Website     *string `json:"website"`
+}
+
+// errInvalidProfileField names the field that did not pass validation
+type errInvalidProfileField struct {
+	Field  string
+	Reason string
+}
+
+func (e errInvalidProfileField) Error() string {
+	return e.Field + " " + e.Reason
+}
+
+// validateProfile trims the fields of an update and checks their length (in characters) and format.
+// An empty website is allowed and removes it, a website without a scheme gets https://.
+func validateProfile(update *ProfileUpdate) error {
+	fields := []struct {
+		name      string
+		value     *string
+		maxLength int
+	}{
+		{"display_name", update.DisplayName, MAX_DISPLAY_NAME_LENGTH},
+		{"bio", update.Bio, MAX_BIO_LENGTH},
+		{"location", update.Location, MAX_LOCATION_LENGTH},
+		{"website", update.Website, MAX_WEBSITE_LENGTH},
+	}
+	for _, field := range fields {
+		if field.value == nil {
+			continue
+		}
+		*field.value = strings.TrimSpace(*field.value)
+		if utf8.RuneCountInString(*field.value) > field.maxLength {
+			return errInvalidProfileField{Field: field.name, Reason: "can be at most " + strconv.Itoa(field.maxLength) + " characters long"}
+		}
+		if field.name != "bio" && strings.ContainsAny(*field.value, "\r\n") {
+			return errInvalidProfileField{Field: field.name, Reason: "must be a single line"}
+		}
+	}
+
+	if update.Website != nil && *update.Website != "" {
+		website, ok := normalizeWebsite(*update.Website)
+		if !ok {
+			return errInvalidProfileField{Field: "website", Reason: "must be a http or https address"}
+		}
+		*update.Website = website
+	}
+	return nil
+}
+
+func normalizeWebsite(website string) (string, bool) {
+	if !strings.Contains(website, "://") {
+		website = "https://" + website
+	}
+	parsed, err := url.Parse(website)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || parsed.User != nil {
+		return "", false
+	}
+	return parsed.String(), true
+}
+
+// validateEmail applies the check of the registration form
+func validateEmail(email string) error {
+	if email == "" || !strings.Contains(email, "@") || strings.ContainsAny(email, " \r\n") {
+		return errInvalidProfileField{Field: "email", Reason: "must be a valid email address"}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReservedUsernamesCoverTheRoutes(t *testing.T) {
+	setupTestDB(t)
+	for _, route := range testRouter(t).Routes() {
+		segment := strings.SplitN(strings.TrimPrefix(route.Path, "/"), "/", 2)[0]
+		if segment == "" || strings.HasPrefix(segment, ":") {
+			continue
+		}
+		if !isReservedUsername(segment) {
+			t.Errorf("the route %s %s takes over the profile of a user named %q", route.Method, route.Path, segment)
+		}
+	}
+}
+
+func TestRegisterRefusesReservedUsernames(t *testing.T) {
+	setupTestDB(t)
+	router := testRouter(t)
+
+	for _, username := range []string{"search", "Settings", "msg"} {
+		form := "username=" + username + "&email=" + username + "@example.com&password=secret&passwordConfirm=secret"
+		response := serveTestRequest(router, http.MethodPost, "/register", form, 0)
+		if !strings.Contains(response.Body.String(), "The username is reserved") {
+			t.Errorf("registering %q in the form answered %d", username, response.Code)
+		}
+
+		body := `{"username": "` + username + `", "email": "` + username + `@example.com", "pwd": "secret"}`
+		response = serveTestRequest(router, http.MethodPost, "/api/register", body, 0)
+		if response.Code != http.StatusBadRequest || !strings.Contains(response.Body.String(), "The username is reserved") {
+			t.Errorf("registering %q with the API answered %d: %s", username, response.Code, response.Body.String())
+		}
+
+		if userID, _ := getUserIDByUsername(username); userID != -1 {
+			t.Errorf("the user %q was registered", username)
+		}
+	}
+
+	body := `{"username": "searcher", "email": "searcher@example.com", "pwd": "secret"}`
+	if response := serveTestRequest(router, http.MethodPost, "/api/register", body, 0); response.Code != http.StatusNoContent {
+		t.Errorf("registering a name starting like a route answered %d, want 204", response.Code)
+	}
+}
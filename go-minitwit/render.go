@@ -0,0 +1,433 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+MESSAGE TEXT
+Messages are stored as written and rendered on display: `code` spans, **bold**, *italics* (or _italics_)
+and links for urls, hashtags and the mentions of existing users. The renderer never lets html of the
+text through, it escapes all of it and only writes the few tags it creates itself. The length of a
+message is counted in user perceived characters, so an emoji with a skin tone or a flag counts as one.
+*/
+
+const (
+	DEFAULT_MESSAGE_LENGTH  = 500
+	MAX_BYTES_PER_CHARACTER = 32 // bounds characters stacking up combining marks
+	MAX_LINK_DISPLAY_LENGTH = 50
+)
+
+var errMessageTooLarge = errors.New("the message has too many combining characters")
+
+// errMessageTooLong tells how far a message goes over the length limit
+type errMessageTooLong struct {
+	Length int
+	Limit  int
+}
+
+func (e errMessageTooLong) Error() string {
+	return fmt.Sprintf("the message is %d characters long, the limit is %d", e.Length, e.Limit)
+}
+
+// maxMessageLength is the longest message in characters, MESSAGE_MAX_LENGTH (default 500)
+func maxMessageLength() int {
+	limit, err := strconv.Atoi(os.Getenv("MESSAGE_MAX_LENGTH"))
+	if err != nil || limit <= 0 {
+		return DEFAULT_MESSAGE_LENGTH
+	}
+	return limit
+}
+
+// validateMessageText checks the length of a message, quote or draft text
+func validateMessageText(text string) error {
+	limit := maxMessageLength()
+	if length := graphemeCount(text); length > limit {
+		return errMessageTooLong{Length: length, Limit: limit}
+	}
+	if len(text) > limit*MAX_BYTES_PER_CHARACTER {
+		return errMessageTooLarge
+	}
+	return nil
+}
+
+// isMessageTextError tells the errors caused by the submitted text
+func isMessageTextError(err error) bool {
+	_, tooLong := err.(errMessageTooLong)
+	return tooLong || err == errMessageTooLarge
+}
+
+/*
+RENDERING
+*/
+
+// urls start with a scheme or www., the punctuation ending a sentence is trimmed off by trimURL
+var urlPattern = regexp.MustCompile("(?i)\\b(?:https?://|www\\.)[^\\s<>\"`]+")
+
+// textToken is a piece of the message text between start and end. Code spans and links are rendered
+// as a whole into html, runs of * or _ become tags when they find a matching run.
+type textToken struct {
+	start, end int
+	html       string
+	delim      byte
+	open       bool // the run can open an emphasis
+	close      bool // the run can close an emphasis
+	tag        string
+	closing    bool
+}
+
+// renderMessageText escapes the message text and renders its code spans, emphasis and links: urls,
+// hashtags linking to the tag pages and the mentions of existing users (mentions holds their usernames)
+// linking to their profiles. The words matching the highlight terms of a search are marked.
+func renderMessageText(text string, mentions []string, highlight []string) template.HTML {
+	var tokens []textToken
+	last := 0
+	for _, span := range append(atomicSpans(text, mentions, highlight), textToken{start: len(text), end: len(text)}) {
+		tokens = append(tokens, delimiterTokens(text, last, span.start)...)
+		if span.end > span.start {
+			tokens = append(tokens, span)
+		}
+		last = span.end
+	}
+	matchEmphasis(tokens)
+
+	var out strings.Builder
+	for _, token := range tokens {
+		switch {
+		case token.html != "":
+			out.WriteString(token.html)
+		case token.tag != "" && token.closing:
+			out.WriteString("</" + token.tag + ">")
+		case token.tag != "":
+			out.WriteString("<" + token.tag + ">")
+		default:
+			out.WriteString(highlightText(text[token.start:token.end], highlight))
+		}
+	}
+	return template.HTML(out.String())
+}
+
+// atomicSpans finds the code spans and links of the text, ordered and without overlaps.
+// Code spans win over links and urls over the hashtags and mentions inside them.
+func atomicSpans(text string, mentions []string, highlight []string) []textToken {
+	var spans []textToken
+	for _, loc := range codeSpans(text) {
+		spans = append(spans, textToken{start: loc[0], end: loc[1], html: "<code>" + highlightText(text[loc[2]:loc[3]], highlight) + "</code>"})
+	}
+	for _, loc := range urlPattern.FindAllStringIndex(text, -1) {
+		raw := trimURL(text[loc[0]:loc[1]])
+		if href, ok := linkHref(raw); ok {
+			spans = append(spans, textToken{start: loc[0], end: loc[0] + len(raw), html: `<a class="link" href="` +
+				template.HTMLEscapeString(href) + `" rel="nofollow ugc noopener">` + highlightText(shortenLink(raw), highlight) + `</a>`})
+		}
+	}
+	for _, loc := range hashtagPattern.FindAllStringSubmatchIndex(text, -1) {
+		// loc[4]:loc[5] is the tag without the #
+		if tag := normalizeTag(text[loc[4]:loc[5]]); tag != "" {
+			spans = append(spans, linkToken(text, loc[4]-1, loc[5], tagLink(tag), "hashtag", highlight))
+		}
+	}
+	for _, loc := range mentionPattern.FindAllStringSubmatchIndex(text, -1) {
+		name := trimMention(text[loc[4]:loc[5]])
+		if username, ok := mentionedUser(name, mentions); ok {
+			spans = append(spans, linkToken(text, loc[4]-1, loc[4]+len(name), "/"+url.PathEscape(username), "mention", highlight))
+		}
+	}
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	var kept []textToken
+	last := 0
+	for _, span := range spans {
+		if span.start < last {
+			continue
+		}
+		kept = append(kept, span)
+		last = span.end
+	}
+	return kept
+}
+
+func linkToken(text string, start int, end int, href string, class string, highlight []string) textToken {
+	return textToken{start: start, end: end, html: `<a class="` + class + `" href="` + template.HTMLEscapeString(href) + `">` +
+		highlightText(text[start:end], highlight) + `</a>`}
+}
+
+// codeSpans returns the start and end of the code spans with the start and end of their content.
+// A span opens with a run of backticks and closes with the next run of the same length.
+func codeSpans(text string) [][4]int {
+	var spans [][4]int
+	for i := 0; i < len(text); {
+		if text[i] != '`' {
+			i++
+			continue
+		}
+		n := backtickRun(text, i)
+		closing := -1
+		for j := i + n; j < len(text); {
+			if text[j] != '`' {
+				j++
+				continue
+			}
+			m := backtickRun(text, j)
+			if m == n {
+				closing = j
+				break
+			}
+			j += m
+		}
+		if closing == -1 || closing == i+n {
+			i += n
+			continue
+		}
+		spans = append(spans, [4]int{i, closing + n, i + n, closing})
+		i = closing + n
+	}
+	return spans
+}
+
+func backtickRun(text string, i int) int {
+	n := 0
+	for i+n < len(text) && text[i+n] == '`' {
+		n++
+	}
+	return n
+}
+
+// trimURL drops the punctuation that ends a sentence rather than the url, and a closing parenthesis
+// without an opening one in the url ("(see https://example.com)")
+func trimURL(raw string) string {
+	for raw != "" {
+		last := raw[len(raw)-1]
+		if strings.IndexByte(".,:;!?'\"*_", last) >= 0 ||
+			(last == ')' && strings.Count(raw, "(") < strings.Count(raw, ")")) {
+			raw = raw[:len(raw)-1]
+			continue
+		}
+		break
+	}
+	return raw
+}
+
+// linkHref is the address a url of the text links to, only http and https urls are linked
+func linkHref(raw string) (string, bool) {
+	href := raw
+	if strings.HasPrefix(strings.ToLower(raw), "www.") {
+		if len(raw) == len("www.") {
+			return "", false
+		}
+		href = "https://" + raw
+	}
+	u, err := url.Parse(href)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	return u.String(), true
+}
+
+// shortenLink cuts long urls down for display, the link keeps the full address
+func shortenLink(raw string) string {
+	if utf8.RuneCountInString(raw) <= MAX_LINK_DISPLAY_LENGTH {
+		return raw
+	}
+	runes := []rune(raw)
+	return string(runes[:MAX_LINK_DISPLAY_LENGTH-1]) + "…"
+}
+
+// delimiterTokens splits text[from:to] into plain text and the runs of * or _ that can be emphasis.
+// A run opens when followed by a non space and closes when preceded by one, _ only at word boundaries
+// so snake_case stays as it is. Runs of three or more are plain text.
+func delimiterTokens(text string, from int, to int) []textToken {
+	var tokens []textToken
+	last := from
+	for i := from; i < to; {
+		c := text[i]
+		if c != '*' && c != '_' {
+			i++
+			continue
+		}
+		end := i
+		for end < to && text[end] == c {
+			end++
+		}
+		if end-i > 2 {
+			i = end
+			continue
+		}
+
+		before, after := ' ', ' '
+		if i > 0 {
+			before, _ = utf8.DecodeLastRuneInString(text[:i])
+		}
+		if end < len(text) {
+			after, _ = utf8.DecodeRuneInString(text[end:])
+		}
+		delim := textToken{start: i, end: end, delim: c, open: !unicode.IsSpace(after), close: !unicode.IsSpace(before)}
+		if c == '_' {
+			delim.open = delim.open && !isWordRune(before)
+			delim.close = delim.close && !isWordRune(after)
+		}
+		if delim.open || delim.close {
+			if i > last {
+				tokens = append(tokens, textToken{start: last, end: i})
+			}
+			tokens = append(tokens, delim)
+			last = end
+		}
+		i = end
+	}
+	if to > last {
+		tokens = append(tokens, textToken{start: last, end: to})
+	}
+	return tokens
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// matchEmphasis pairs every closing run with the nearest opening run of the same kind and length,
+// ** becomes bold and a single * or _ italics. The runs opened in between stay plain text, so the
+// tags always nest.
+func matchEmphasis(tokens []textToken) {
+	var openers []int
+	for i := range tokens {
+		token := &tokens[i]
+		if token.delim == 0 {
+			continue
+		}
+		if token.close && matchOpener(tokens, &openers, token) {
+			continue
+		}
+		if token.open {
+			openers = append(openers, i)
+		}
+	}
+}
+
+func matchOpener(tokens []textToken, openers *[]int, closer *textToken) bool {
+	for j := len(*openers) - 1; j >= 0; j-- {
+		opener := &tokens[(*openers)[j]]
+		if opener.delim != closer.delim || opener.end-opener.start != closer.end-closer.start {
+			continue
+		}
+		tag := "em"
+		if closer.end-closer.start == 2 {
+			tag = "strong"
+		}
+		opener.tag, closer.tag, closer.closing = tag, tag, true
+		*openers = (*openers)[:j]
+		return true
+	}
+	return false
+}
+
+/*
+CHARACTER COUNT
+*/
+
+// graphemeCount counts the user perceived characters of text, following the grapheme cluster rules
+// of Unicode closely enough for message lengths: combining marks, variation selectors and skin tones
+// extend the character before them, emoji joined by a zero width joiner, flags (pairs of regional
+// indicators) and Hangul syllables written in jamo count as one, and so does \r\n.
+func graphemeCount(text string) int {
+	count := 0
+	prev := rune(-1)
+	regionalIndicators := 0 // in a row, up to prev
+	for _, r := range text {
+		if prev == -1 || graphemeBoundary(prev, r, regionalIndicators) {
+			count++
+		}
+		if isRegionalIndicator(r) {
+			regionalIndicators++
+		} else {
+			regionalIndicators = 0
+		}
+		prev = r
+	}
+	return count
+}
+
+func graphemeBoundary(prev rune, r rune, regionalIndicators int) bool {
+	switch {
+	case prev == '\r' && r == '\n':
+		return false
+	case isGraphemeControl(prev) || isGraphemeControl(r):
+		return true
+	case isGraphemeExtend(r):
+		return false
+	case prev == '\u200d' && isPictographic(r):
+		return false
+	case isRegionalIndicator(prev) && isRegionalIndicator(r):
+		return regionalIndicators%2 == 0
+	}
+	return hangulBoundary(prev, r)
+}
+
+func isGraphemeControl(r rune) bool {
+	return unicode.Is(unicode.Cc, r) || r == '\u2028' || r == '\u2029'
+}
+
+// marks, zero width (non) joiners, variation selectors, emoji tags and skin tones
+func isGraphemeExtend(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc) || r == '\u200c' || r == '\u200d' ||
+		(r >= 0xE0020 && r <= 0xE007F) || (r >= 0x1F3FB && r <= 0x1F3FF)
+}
+
+func isPictographic(r rune) bool {
+	return (r >= 0x1F000 && r <= 0x1FAFF) || (r >= 0x2600 && r <= 0x27BF) || (r >= 0x2300 && r <= 0x23FF) ||
+		(r >= 0x2B00 && r <= 0x2BFF) || (r >= 0x2190 && r <= 0x21FF) || r == 0xA9 || r == 0xAE
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// the parts of Hangul syllables: leading consonants, vowels, trailing consonants and precomposed syllables
+const (
+	hangulOther = iota
+	hangulL
+	hangulV
+	hangulT
+	hangulLV
+	hangulLVT
+)
+
+func hangulType(r rune) int {
+	switch {
+	case (r >= 0x1100 && r <= 0x115F) || (r >= 0xA960 && r <= 0xA97C):
+		return hangulL
+	case (r >= 0x1160 && r <= 0x11A7) || (r >= 0xD7B0 && r <= 0xD7C6):
+		return hangulV
+	case (r >= 0x11A8 && r <= 0x11FF) || (r >= 0xD7CB && r <= 0xD7FB):
+		return hangulT
+	case r >= 0xAC00 && r <= 0xD7A3:
+		if (r-0xAC00)%28 == 0 {
+			return hangulLV
+		}
+		return hangulLVT
+	}
+	return hangulOther
+}
+
+func hangulBoundary(prev rune, r rune) bool {
+	next := hangulType(r)
+	switch hangulType(prev) {
+	case hangulL:
+		return next != hangulL && next != hangulV && next != hangulLV && next != hangulLVT
+	case hangulV, hangulLV:
+		return next != hangulV && next != hangulT
+	case hangulT, hangulLVT:
+		return next != hangulT
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRenderMessageText(t *testing.T) {
+	link := func(href string, text string) string {
+		return `<a class="link" href="` + href + `" rel="nofollow ugc noopener">` + text + `</a>`
+	}
+	cases := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"html is escaped", "<script>alert(1)</script>", "&lt;script&gt;alert(1)&lt;/script&gt;"},
+		{"quotes and ampersands", `a & b "q" 'x'`, "a &amp; b &#34;q&#34; &#39;x&#39;"},
+		{"bold and italics", "**bold** and *em* and _em_", "<strong>bold</strong> and <em>em</em> and <em>em</em>"},
+		{"nested emphasis", "_*both*_", "<em><em>both</em></em>"},
+		{"snake case", "snake_case_name", "snake_case_name"},
+		{"unclosed bold", "**unclosed", "**unclosed"},
+		{"delimiters next to spaces", "* not em *", "* not em *"},
+		{"runs of three", "***both***", "***both***"},
+		{"code is escaped", "`<b>code</b>` **x**", "<code>&lt;b&gt;code&lt;/b&gt;</code> <strong>x</strong>"},
+		{"no markdown in code", "`**not bold**`", "<code>**not bold**</code>"},
+		{"trailing punctuation", "see https://example.com/path.", "see " + link("https://example.com/path", "https://example.com/path") + "."},
+		{"balanced parentheses", "(https://example.com/a_(b))", "(" + link("https://example.com/a_(b)", "https://example.com/a_(b)") + ")"},
+		{"www links", "www.example.com", link("https://www.example.com", "www.example.com")},
+		{"query strings", "https://example.com/?a=1&b=2", link("https://example.com/?a=1&amp;b=2", "https://example.com/?a=1&amp;b=2")},
+		{"long links are shortened", "http://example.com/" + strings.Repeat("a", 56),
+			link("http://example.com/"+strings.Repeat("a", 56), "http://example.com/"+strings.Repeat("a", 30)+"…")},
+		{"links end at quotes", `https://example.com/"onmouseover="x`, link("https://example.com/", "https://example.com/") + "&#34;onmouseover=&#34;x"},
+		{"other schemes are text", "javascript:alert(1)", "javascript:alert(1)"},
+		{"mentions of existing users", "hi @bob and @nobody", `hi <a class="mention" href="/bob">@bob</a> and @nobody`},
+		{"email addresses", "email me@bob.com", "email me@bob.com"},
+		{"hashtags", "#golang rocks", `<a class="hashtag" href="/tag/golang">#golang</a> rocks`},
+		{"hashtags start words", "x#notatag", "x#notatag"},
+	}
+	for _, c := range cases {
+		if got := string(renderMessageText(c.text, []string{"bob"}, nil)); got != c.want {
+			t.Errorf("%s: renderMessageText(%q)\n got %q\nwant %q", c.name, c.text, got, c.want)
+		}
+	}
+}
+
+// the tags and attributes the renderer writes itself
+var renderedTag = regexp.MustCompile(`</?(strong|em|code)>|<a class="(link|mention|hashtag)" href="[^"<>]*"( rel="nofollow ugc noopener")?>|</a>`)
+
+func TestRenderMessageTextNeverPassesHTML(t *testing.T) {
+	attacks := []string{
+		`<img src=x onerror=alert(1)>`,
+		"**<script>**alert(1)**</script>**",
+		"`</code><script>alert(1)</script>`",
+		`https://example.com/<script>`,
+		`https://example.com/'onmouseover='alert(1)`,
+		`www.example.com/"><svg onload=alert(1)>`,
+		`#tag"><script>`,
+		`@bob"><script>`,
+		"_<i>_ *</em>* **</strong>**",
+		`[x](javascript:alert(1))`,
+		"JaVaScRiPt:alert(1) data:text/html,<script>",
+	}
+	for _, text := range attacks {
+		rendered := string(renderMessageText(text, []string{"bob"}, []string{"script"}))
+		rendered = strings.ReplaceAll(rendered, "<mark>", "")
+		rendered = strings.ReplaceAll(rendered, "</mark>", "")
+		if rest := renderedTag.ReplaceAllString(rendered, ""); strings.ContainsAny(rest, "<>") {
+			t.Errorf("renderMessageText(%q) lets html through: %q", text, rendered)
+		}
+		if strings.Contains(strings.ToLower(rendered), `href="javascript`) || strings.Contains(strings.ToLower(rendered), `href="data`) {
+			t.Errorf("renderMessageText(%q) links to a script: %q", text, rendered)
+		}
+	}
+}
+
+func TestGraphemeCount(t *testing.T) {
+	cases := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"abc", 3},
+		{"\u00e9", 1},
+		{"e\u0301", 1},
+		{"\U0001f44d\U0001f3fd", 1},
+		{"\U0001f468\u200d\U0001f469\u200d\U0001f467", 1},
+		{"\U0001f1e9\U0001f1f0\U0001f1f8\U0001f1ea", 2},
+		{"\U0001f1e9\U0001f1f0\U0001f1f8", 2},
+		{"\r\n", 1},
+		{"\n\r", 2},
+		{"\u1100\u1161\u11a8", 1}, // a Hangul syllable written in jamo
+		{"\ud55c\uad6d\uc5b4", 3},
+		{"\u2764\ufe0f", 1},
+	}
+	for _, c := range cases {
+		if got := graphemeCount(c.text); got != c.want {
+			t.Errorf("graphemeCount(%q) = %d, want %d", c.text, got, c.want)
+		}
+	}
+}
+
+func TestValidateMessageText(t *testing.T) {
+	t.Setenv("MESSAGE_MAX_LENGTH", "5")
+	cases := []struct {
+		text string
+		want error
+	}{
+		{"hello", nil},
+		{"hello!", errMessageTooLong{Length: 6, Limit: 5}},
+		{strings.Repeat("\U0001f44d\U0001f3fd", 5), nil},
+		{strings.Repeat("\U0001f44d\U0001f3fd", 6), errMessageTooLong{Length: 6, Limit: 5}},
+		{"a" + strings.Repeat("\u0301", 200), errMessageTooLarge},
+	}
+	for _, c := range cases {
+		if err := validateMessageText(c.text); err != c.want {
+			t.Errorf("validateMessageText(%q) = %v, want %v", c.text, err, c.want)
+		}
+		if c.want != nil && !isMessageTextError(validateMessageText(c.text)) {
+			t.Errorf("isMessageTextError doesn't recognize the error of %q", c.text)
+		}
+	}
+
+	t.Setenv("MESSAGE_MAX_LENGTH", "not a number")
+	if limit := maxMessageLength(); limit != DEFAULT_MESSAGE_LENGTH {
+		t.Errorf("an invalid MESSAGE_MAX_LENGTH gives a limit of %d, want %d", limit, DEFAULT_MESSAGE_LENGTH)
+	}
+}
+
+func TestTooLongMessagesAnswerBadRequest(t *testing.T) {
+	setupTestDB(t)
+	router := testRouter(t)
+	createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	messageID := postTestMessage(t, bob, "hello")
+	t.Setenv("MESSAGE_MAX_LENGTH", "5")
+
+	requests := map[string]string{
+		"/api/msgs/alice": `{"content": "too long"}`,
+		"/api/v2/msgs/" + strconv.Itoa(messageID) + "/reposts": `{"username": "alice", "content": "too long"}`,
+	}
+	for path, body := range requests {
+		if response := serveTestRequest(router, http.MethodPost, path, body, 0); response.Code != http.StatusBadRequest {
+			t.Errorf("POST %s with a too long text answered %d, want 400", path, response.Code)
+		}
+	}
+}
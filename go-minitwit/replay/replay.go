@@ -0,0 +1,378 @@
+package main
+
+/*
+Replays a simulator request log against a running minitwit instance.
+
+Every line of the log is one JSON request:
+
+	{"method": "POST", "path": "/api/fllws/aa", "latest": 42, "body": {"follow": "bb"}, "status": 204}
+
+"latest" is sent as the ?latest= query parameter, "status" is the expected response status code
+and "headers" can override the default simulator headers.
+
+Usage:
+
+	go run replay.go -log requests.jsonl -url http://localhost:8081 -workers 4 -rate 50 -report replay_report.json
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const SIMULATOR_AUTH = "Basic c2ltdWxhdG9yOnN1cGVyX3NhZmUh"
+
+type Request struct {
+	Line    int               `json:"-"`
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Latest  *int              `json:"latest,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Status  int               `json:"status"`
+}
+
+type Result struct {
+	Request  Request
+	Status   int
+	Latency  time.Duration
+	Err      error
+	Response string
+}
+
+type Mismatch struct {
+	Line     int    `json:"line"`
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	Expected int    `json:"expected_status"`
+	Actual   int    `json:"actual_status"`
+	Error    string `json:"error,omitempty"`
+	Response string `json:"response,omitempty"`
+}
+
+type LatencyReport struct {
+	P50 string `json:"p50"`
+	P90 string `json:"p90"`
+	P99 string `json:"p99"`
+	Max string `json:"max"`
+}
+
+type LatestReport struct {
+	Mode       string `json:"mode"`
+	Expected   int    `json:"expected"`
+	Actual     int    `json:"actual"`
+	Consistent bool   `json:"consistent"`
+	Note       string `json:"note,omitempty"`
+}
+
+type Report struct {
+	Target     string         `json:"target"`
+	Log        string         `json:"log"`
+	Workers    int            `json:"workers"`
+	Rate       float64        `json:"rate"`
+	Duration   string         `json:"duration"`
+	Requests   int            `json:"requests"`
+	Failed     int            `json:"failed"`
+	StatusDist map[string]int `json:"status_codes"`
+	Latency    LatencyReport  `json:"latency"`
+	Latest     LatestReport   `json:"latest"`
+	Mismatches []Mismatch     `json:"mismatches"`
+}
+
+// readLog parses the JSONL request log, empty lines are skipped
+func readLog(path string) ([]Request, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var requests []Request
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var req Request
+		if err := json.Unmarshal(line, &req); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		if req.Method == "" || req.Path == "" {
+			return nil, fmt.Errorf("line %d: method and path are required", lineNo)
+		}
+		req.Line = lineNo
+		requests = append(requests, req)
+	}
+	return requests, scanner.Err()
+}
+
+// applyLatest rewrites the latest values of the log according to the selected mode:
+// keep sends them as logged, strip drops them and renumber sends 1..n in log order
+func applyLatest(requests []Request, mode string) (int, error) {
+	expected := -1
+	for i := range requests {
+		switch mode {
+		case "keep":
+		case "strip":
+			requests[i].Latest = nil
+		case "renumber":
+			value := i + 1
+			requests[i].Latest = &value
+		default:
+			return 0, errors.New("unknown latest mode " + mode + ", use keep, strip or renumber")
+		}
+		if requests[i].Latest != nil {
+			expected = *requests[i].Latest
+		}
+	}
+	return expected, nil
+}
+
+func send(client *http.Client, baseURL string, req Request) Result {
+	result := Result{Request: req}
+
+	target := baseURL + req.Path
+	if req.Latest != nil {
+		parsed, err := url.Parse(target)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		query := parsed.Query()
+		query.Set("latest", strconv.Itoa(*req.Latest))
+		parsed.RawQuery = query.Encode()
+		target = parsed.String()
+	}
+
+	var body io.Reader
+	if len(req.Body) > 0 {
+		body = bytes.NewReader(req.Body)
+	}
+	httpReq, err := http.NewRequest(req.Method, target, body)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", SIMULATOR_AUTH)
+	for key, value := range req.Headers {
+		httpReq.Header.Set(key, value)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		result.Err = err
+		result.Latency = time.Since(start)
+		return result
+	}
+	// the report keeps the start of the body, the rest is drained so the connection can be reused
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	result.Latency = time.Since(start)
+	result.Status = resp.StatusCode
+	result.Response = string(respBody)
+	return result
+}
+
+// replay fires the requests with the given number of workers, rate limited to rate req/s (0 = unlimited).
+// With a single worker the log order is preserved exactly.
+func replay(client *http.Client, baseURL string, requests []Request, workers int, rate float64) []Result {
+	jobs := make(chan int)
+	results := make([]Result, len(requests))
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = send(client, baseURL, requests[i])
+			}
+		}()
+	}
+
+	var ticker *time.Ticker
+	if rate > 0 {
+		ticker = time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer ticker.Stop()
+	}
+	for i := range requests {
+		if ticker != nil {
+			<-ticker.C
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := int(float64(len(sorted)-1) * p)
+	return sorted[index]
+}
+
+func fetchLatest(client *http.Client, baseURL string) (int, error) {
+	resp, err := client.Get(baseURL + "/api/latest")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Latest int `json:"latest"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	return body.Latest, nil
+}
+
+func buildReport(results []Result, elapsed time.Duration) Report {
+	report := Report{
+		Duration:   elapsed.Round(time.Millisecond).String(),
+		Requests:   len(results),
+		StatusDist: map[string]int{},
+		Mismatches: []Mismatch{},
+	}
+
+	var latencies []time.Duration
+	for _, result := range results {
+		latencies = append(latencies, result.Latency)
+
+		if result.Err != nil {
+			report.Failed++
+			report.StatusDist["error"]++
+		} else {
+			report.StatusDist[strconv.Itoa(result.Status)]++
+		}
+
+		expected := result.Request.Status
+		if result.Err != nil || (expected != 0 && expected != result.Status) {
+			mismatch := Mismatch{
+				Line:     result.Request.Line,
+				Method:   result.Request.Method,
+				Path:     result.Request.Path,
+				Expected: expected,
+				Actual:   result.Status,
+				Response: result.Response,
+			}
+			if result.Err != nil {
+				mismatch.Error = result.Err.Error()
+			}
+			report.Mismatches = append(report.Mismatches, mismatch)
+		}
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	report.Latency = LatencyReport{
+		P50: percentile(latencies, 0.50).String(),
+		P90: percentile(latencies, 0.90).String(),
+		P99: percentile(latencies, 0.99).String(),
+	}
+	if len(latencies) > 0 {
+		report.Latency.Max = latencies[len(latencies)-1].String()
+	}
+
+	return report
+}
+
+func main() {
+	logPath := flag.String("log", "requests.jsonl", "JSONL request log to replay")
+	baseURL := flag.String("url", "http://localhost:8081", "base URL of the minitwit instance")
+	workers := flag.Int("workers", 1, "number of concurrent workers")
+	rate := flag.Float64("rate", 0, "maximum requests per second, 0 for unlimited")
+	latestMode := flag.String("latest", "keep", "latest handling: keep, strip or renumber")
+	reportPath := flag.String("report", "replay_report.json", "file the JSON report is written to")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout per request")
+	flag.Parse()
+
+	if *workers < 1 {
+		fmt.Println("workers must be at least 1")
+		os.Exit(2)
+	}
+
+	requests, err := readLog(*logPath)
+	if err != nil {
+		fmt.Println("Error reading request log:", err)
+		os.Exit(1)
+	}
+	expectedLatest, err := applyLatest(requests, *latestMode)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	client := &http.Client{Timeout: *timeout}
+
+	fmt.Printf("Replaying %d requests against %s with %d workers\n", len(requests), *baseURL, *workers)
+	start := time.Now()
+	results := replay(client, *baseURL, requests, *workers, *rate)
+	report := buildReport(results, time.Since(start))
+	report.Target = *baseURL
+	report.Log = *logPath
+	report.Workers = *workers
+	report.Rate = *rate
+
+	// final /api/latest consistency
+	report.Latest.Mode = *latestMode
+	report.Latest.Expected = expectedLatest
+	actualLatest, err := fetchLatest(client, *baseURL)
+	if err != nil {
+		report.Latest.Note = "could not read /api/latest: " + err.Error()
+	} else {
+		report.Latest.Actual = actualLatest
+		report.Latest.Consistent = expectedLatest == -1 || expectedLatest == actualLatest
+		if expectedLatest == -1 {
+			report.Latest.Note = "no latest values were sent"
+		} else if !report.Latest.Consistent && *workers > 1 {
+			report.Latest.Note = "requests were replayed concurrently, the last processed request may differ from the last logged one"
+		}
+	}
+
+	file, err := os.Create(*reportPath)
+	if err != nil {
+		fmt.Println("Error creating report file:", err)
+		os.Exit(1)
+	}
+	defer file.Close()
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Println("Error writing report:", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Done in %s: %d requests, %d mismatches, %d failed\n", report.Duration, report.Requests, len(report.Mismatches), report.Failed)
+	fmt.Printf("Latency p50=%s p90=%s p99=%s max=%s\n", report.Latency.P50, report.Latency.P90, report.Latency.P99, report.Latency.Max)
+	fmt.Printf("Latest expected=%d actual=%d consistent=%v\n", report.Latest.Expected, report.Latest.Actual, report.Latest.Consistent)
+	fmt.Println("Report written to", *reportPath)
+
+	if len(report.Mismatches) > 0 || !report.Latest.Consistent {
+		os.Exit(1)
+	}
+}
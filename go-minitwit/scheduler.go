@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+/*
+SCHEDULED POSTS
+Drafts and scheduled posts wait in the scheduled_message table. The scheduler job publishes the due
+posts, on one replica at a time: each tick it takes (or renews) a lease in the job_lease table and
+only the holder publishes. The lease is not what makes publishing exactly-once, a post is deleted in
+the transaction creating its message, so a replica that lost its lease mid-run can't publish it twice.
+*/
+
+const (
+	MAX_PENDING_POSTS  = 100
+	MAX_SCHEDULE_AHEAD = 365 * 24 * time.Hour
+	SCHEDULER_LEASE    = "scheduled_messages"
+	SCHEDULER_BATCH    = 100
+)
+
+// as sent by <input type="datetime-local">, with or without seconds
+const (
+	scheduleTimeLayout    = "2006-01-02T15:04"
+	scheduleTimeLayoutSec = "2006-01-02T15:04:05"
+)
+
+var (
+	errScheduledNotFound   = errors.New("scheduled post not found")
+	errEmptyPost           = errors.New("the post needs a text or an image")
+	errScheduleInPast      = errors.New("the publishing time is in the past")
+	errScheduleTooFar      = fmt.Errorf("posts can be scheduled at most %d days ahead", int(MAX_SCHEDULE_AHEAD.Hours()/24))
+	errInvalidScheduleTime = errors.New("the publishing time is not a date and time")
+	errTooManyPendingPosts = fmt.Errorf("you can have at most %d drafts and scheduled posts", MAX_PENDING_POSTS)
+)
+
+// ScheduledUpdate holds the fields of a pending post to change, nil fields are left as they are
+type ScheduledUpdate struct {
+	Text      *string
+	PublishAt *int // 0 turns the post into a draft
+}
+
+// validatePublishTime checks a publishing time in unix seconds, 0 stands for a draft
+func validatePublishTime(publishAt int) error {
+	if publishAt == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	if publishAt <= int(now.Unix()) {
+		return errScheduleInPast
+	}
+	if publishAt > int(now.Add(MAX_SCHEDULE_AHEAD).Unix()) {
+		return errScheduleTooFar
+	}
+	return nil
+}
+
+// parseScheduleTime reads the local date and time of the schedule form, offset is the browser's
+// time zone offset in minutes (UTC minus local time, as in Date.getTimezoneOffset). An empty time is 0.
+func parseScheduleTime(value string, offset string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	local, err := time.ParseInLocation(scheduleTimeLayout, value, time.UTC)
+	if err != nil {
+		local, err = time.ParseInLocation(scheduleTimeLayoutSec, value, time.UTC)
+	}
+	if err != nil {
+		return 0, errInvalidScheduleTime
+	}
+	minutes, _ := strconv.Atoi(offset)
+	return int(local.Add(time.Duration(minutes) * time.Minute).Unix()), nil
+}
+
+// isScheduleInputError tells the errors caused by the submitted post
+func isScheduleInputError(err error) bool {
+	return err == errEmptyPost || err == errScheduleInPast || err == errScheduleTooFar ||
+		err == errInvalidScheduleTime || err == errTooManyPendingPosts || err == errReplyTargetNotFound || err == errBlocked ||
+		err == errAttachmentNotFound || err == errTooManyAttachments || isUploadError(err) || isMessageTextError(err)
+}
+
+type ScheduledUI struct {
+	ScheduledID int
+	Text        string
+	ReplyToID   int
+	PublishAt   int
+	Draft       bool
+	Error       string
+	UpdatedAt   int
+	Link        string
+	Attachments []AttachmentUI
+}
+
+func formatScheduled(posts []ScheduledMessage, attachments map[int][]Attachment) []ScheduledUI {
+	var formatted []ScheduledUI
+	for _, post := range posts {
+		var images []AttachmentUI
+		for _, a := range attachments[post.ScheduledID] {
+			images = append(images, formatAttachment(a))
+		}
+		formatted = append(formatted, ScheduledUI{
+			ScheduledID: post.ScheduledID,
+			Text:        post.Text,
+			ReplyToID:   post.ReplyToID,
+			PublishAt:   post.PublishAt,
+			Draft:       post.PublishAt == 0,
+			Error:       post.Error,
+			UpdatedAt:   post.UpdatedAt,
+			Link:        "/scheduled/" + strconv.Itoa(post.ScheduledID),
+			Attachments: images,
+		})
+	}
+	return formatted
+}
+
+// pending post representation of the v2 API
+type APIScheduledPost struct {
+	ID          int             `json:"id"`
+	Content     string          `json:"content"`
+	ReplyTo     int             `json:"reply_to,omitempty"`
+	Status      string          `json:"status"` // "draft" or "scheduled"
+	PublishAt   int             `json:"publish_at,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	Attachments []APIAttachment `json:"attachments,omitempty"`
+	CreatedAt   int             `json:"created_at"`
+	UpdatedAt   int             `json:"updated_at"`
+}
+
+func formatAPIScheduled(post ScheduledMessage, attachments []Attachment) APIScheduledPost {
+	formatted := APIScheduledPost{
+		ID:        post.ScheduledID,
+		Content:   post.Text,
+		ReplyTo:   post.ReplyToID,
+		Status:    "scheduled",
+		PublishAt: post.PublishAt,
+		Error:     post.Error,
+		CreatedAt: post.CreatedAt,
+		UpdatedAt: post.UpdatedAt,
+	}
+	if post.PublishAt == 0 {
+		formatted.Status = "draft"
+	}
+	for _, a := range attachments {
+		formatted.Attachments = append(formatted.Attachments, formatAPIAttachment(a))
+	}
+	return formatted
+}
+
+// schedulerHolder names this replica in the job leases
+var schedulerHolder = newSchedulerHolder()
+
+func newSchedulerHolder() string {
+	hostname, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// publishDueMessages publishes the posts that are due, if this replica holds the scheduler lease
+func publishDueMessages(leaseSeconds int) {
+	holder, err := acquireLease(SCHEDULER_LEASE, schedulerHolder, leaseSeconds)
+	if err != nil || !holder {
+		return
+	}
+
+	due, err := getDueScheduledMessages(int(time.Now().UTC().Unix()), SCHEDULER_BATCH)
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source": "scheduler_job",
+			"action": "get_due_posts",
+			"status": "error",
+			"error":  err.Error(),
+		}).Error("Failed to find the due posts")
+
+		return
+	}
+
+	for _, post := range due {
+		_, err := publishScheduledMessage(post, true)
+		if err == errScheduledNotFound {
+			// edited, deleted or published since it was read
+			continue
+		} else if err == errReplyTargetNotFound {
+			err = unscheduleMessage(post.ScheduledID, "the message it replies to was deleted")
+		} else if err == errBlocked {
+			err = unscheduleMessage(post.ScheduledID, "you can't reply to the author of the message anymore")
+		}
+		if err != nil {
+
+			logger.WithFields(logrus.Fields{
+				"source":       "scheduler_job",
+				"action":       "publish",
+				"status":       "error",
+				"scheduled_id": post.ScheduledID,
+				"error":        err.Error(),
+			}).Error("Failed to publish a scheduled post")
+
+		}
+	}
+}
+
+// startSchedulerJob publishes the due posts every SCHEDULER_INTERVAL (default 15s). The lease lasts
+// three intervals, so when the replica holding it stops another one takes over within that time.
+func startSchedulerJob() {
+	interval, err := time.ParseDuration(os.Getenv("SCHEDULER_INTERVAL"))
+	if err != nil || interval < time.Second {
+		interval = 15 * time.Second
+	}
+	leaseSeconds := int(3 * interval / time.Second)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			publishDueMessages(leaseSeconds)
+		}
+	}()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// scheduleTestPost stores a post of the author due a minute ago, as if the scheduler was late
+func scheduleTestPost(t *testing.T, authorID int, text string) ScheduledMessage {
+	t.Helper()
+	post := ScheduledMessage{AuthorID: authorID, Text: text, PublishAt: int(time.Now().UTC().Unix()) + 3600}
+	if err := createScheduledMessage(&post, nil); err != nil {
+		t.Fatal(err)
+	}
+	due := int(time.Now().UTC().Unix()) - 60
+	if err := dbNew.Model(&ScheduledMessage{}).Where("scheduled_id = ?", post.ScheduledID).Update("publish_at", due).Error; err != nil {
+		t.Fatal(err)
+	}
+	post.PublishAt = due
+	return post
+}
+
+func countTestMessages(t *testing.T, authorID int) int64 {
+	t.Helper()
+	var count int64
+	if err := dbNew.Model(&Message{}).Where("author_id = ?", authorID).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestScheduledPostIsPublishedOnce(t *testing.T) {
+	setupTestDB(t)
+	// SQLite has one writer, the workers queue for the connection instead of failing as locked
+	sqlDB, err := dbNew.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	alice := createTestUser(t, "alice")
+	post := scheduleTestPost(t, alice, "published once")
+
+	const workers = 8
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := publishScheduledMessage(post, true)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	published := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			published++
+		case errScheduledNotFound:
+		default:
+			t.Errorf("publishing returned %v", err)
+		}
+	}
+	if published != 1 {
+		t.Errorf("%d workers published the post, want 1", published)
+	}
+	if count := countTestMessages(t, alice); count != 1 {
+		t.Errorf("the post became %d messages, want 1", count)
+	}
+}
+
+func TestScheduledPostEditedSinceReadIsNotPublished(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	post := scheduleTestPost(t, alice, "the first text")
+
+	// the edit lands in the same second as the read, its time doesn't tell the two apart
+	text := "the edited text"
+	if err := updateScheduledMessage(alice, post.ScheduledID, ScheduledUpdate{Text: &text}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbNew.Model(&ScheduledMessage{}).Where("scheduled_id = ?", post.ScheduledID).Update("updated_at", post.UpdatedAt).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := publishScheduledMessage(post, true); err != errScheduledNotFound {
+		t.Fatalf("publishing the stale post returned %v, want errScheduledNotFound", err)
+	}
+	current, err := getScheduledMessage(alice, post.ScheduledID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := publishScheduledMessage(current, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Text != text {
+		t.Errorf("published %q, want the edited text %q", message.Text, text)
+	}
+}
+
+func TestAcquireLease(t *testing.T) {
+	setupTestDB(t)
+
+	steps := []struct {
+		holder string
+		want   bool
+	}{
+		{"replica-a", true},  // free lease
+		{"replica-b", false}, // held by a
+		{"replica-a", true},  // renewed by its holder
+		{"replica-b", false},
+	}
+	for _, step := range steps {
+		got, err := acquireLease(SCHEDULER_LEASE, step.holder, 60)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != step.want {
+			t.Errorf("%s got the lease: %v, want %v", step.holder, got, step.want)
+		}
+	}
+
+	// a lease past its expiry moves to the next replica asking for it
+	expired := int(time.Now().UTC().Unix()) - 1
+	if err := dbNew.Model(&JobLease{}).Where("name = ?", SCHEDULER_LEASE).Update("expires_at", expired).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := acquireLease(SCHEDULER_LEASE, "replica-b", 60); !got {
+		t.Errorf("replica-b did not take over the expired lease")
+	}
+	if got, _ := acquireLease(SCHEDULER_LEASE, "replica-a", 60); got {
+		t.Errorf("replica-a got the lease back while replica-b holds it")
+	}
+}
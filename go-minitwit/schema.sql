@@ -3,13 +3,21 @@ create table user (
   user_id integer primary key autoincrement,
   username string not null,
   email string not null,
-  pw_hash string not null
+  pw_hash string not null,
+  open_dms boolean not null default false,
+  private boolean not null default false,
+  display_name string not null default '',
+  bio string not null default '',
+  location string not null default '',
+  website string not null default '',
+  avatar_version integer not null default 0
 );
 
 drop table if exists follower;
 create table follower (
   who_id integer,
-  whom_id integer
+  whom_id integer,
+  unique (who_id, whom_id)
 );
 
 
@@ -20,17 +28,238 @@ create table message (
   author_id integer not null,
   text string not null,
   pub_date integer,
-  flagged integer
+  flagged integer,
+  reply_to_id integer not null default 0,
+  root_id integer not null default 0,
+  like_count integer not null default 0,
+  repost_of_id integer not null default 0,
+  repost_count integer not null default 0,
+  deleted_at integer not null default 0,
+  edited_at integer not null default 0
 );
 
+drop table if exists message_edit;
+create table message_edit (
+  edit_id integer primary key autoincrement,
+  message_id integer not null,
+  text string not null,
+  edited_at integer
+);
 
-CREATE INDEX idx_username ON user(username);
-CREATE INDEX idx_author_id ON message(author_id);
-CREATE INDEX idx_pub_date ON message(pub_date);
-CREATE INDEX idx_email ON user(email);
+drop table if exists message_like;
+create table message_like (
+  message_id integer not null,
+  user_id integer not null,
+  created_at integer,
+  primary key (message_id, user_id)
+);
+
+drop table if exists message_tag;
+create table message_tag (
+  message_id integer not null,
+  tag string not null,
+  pub_date integer,
+  primary key (message_id, tag)
+);
+
+drop table if exists message_mention;
+create table message_mention (
+  message_id integer not null,
+  user_id integer not null,
+  primary key (message_id, user_id)
+);
+
+drop table if exists notification;
+create table notification (
+  notification_id integer primary key autoincrement,
+  user_id integer not null,
+  actor_id integer not null,
+  type string not null,
+  message_id integer not null default 0,
+  created_at integer,
+  is_read boolean not null default false
+);
+
+drop table if exists notification_preference;
+create table notification_preference (
+  user_id integer not null,
+  type string not null,
+  enabled boolean,
+  primary key (user_id, type)
+);
+
+drop table if exists conversation;
+create table conversation (
+  conversation_id integer primary key autoincrement,
+  last_message_id integer not null default 0,
+  updated_at integer
+);
+
+drop table if exists conversation_participant;
+create table conversation_participant (
+  conversation_id integer not null,
+  user_id integer not null,
+  last_read_id integer not null default 0,
+  primary key (conversation_id, user_id)
+);
+
+drop table if exists direct_message;
+create table direct_message (
+  direct_message_id integer primary key autoincrement,
+  conversation_id integer not null,
+  sender_id integer not null,
+  text string not null,
+  pub_date integer
+);
+
+drop table if exists user_block;
+create table user_block (
+  blocker_id integer not null,
+  blocked_id integer not null,
+  created_at integer,
+  primary key (blocker_id, blocked_id)
+);
+
+drop table if exists user_mute;
+create table user_mute (
+  muter_id integer not null,
+  muted_id integer not null,
+  created_at integer,
+  primary key (muter_id, muted_id)
+);
+
+drop table if exists follow_request;
+create table follow_request (
+  requester_id integer not null,
+  target_id integer not null,
+  created_at integer,
+  primary key (requester_id, target_id)
+);
+
+drop table if exists attachment;
+create table attachment (
+  attachment_id integer primary key autoincrement,
+  message_id integer not null default 0,
+  scheduled_id integer not null default 0,
+  uploader_id integer not null,
+  token string(32) not null unique,
+  extension string(8) not null,
+  alt_text string not null default '',
+  width integer,
+  height integer,
+  thumb_width integer,
+  thumb_height integer,
+  position integer not null default 0,
+  created_at integer
+);
+
+drop table if exists message_bookmark;
+create table message_bookmark (
+  user_id integer not null,
+  message_id integer not null,
+  created_at integer,
+  primary key (user_id, message_id)
+);
+
+drop table if exists user_list;
+create table user_list (
+  list_id integer primary key autoincrement,
+  owner_id integer not null,
+  name string not null,
+  description string not null default '',
+  private boolean not null default 0,
+  created_at integer,
+  unique (owner_id, name)
+);
+
+drop table if exists user_list_member;
+create table user_list_member (
+  list_id integer not null,
+  user_id integer not null,
+  created_at integer,
+  primary key (list_id, user_id)
+);
+
+drop table if exists scheduled_message;
+create table scheduled_message (
+  scheduled_id integer primary key autoincrement,
+  author_id integer not null,
+  text string,
+  reply_to_id integer not null default 0,
+  publish_at integer not null default 0,
+  error string not null default '',
+  created_at integer,
+  updated_at integer
+);
 
+drop table if exists job_lease;
+create table job_lease (
+  name string(64) primary key,
+  holder string(128) not null,
+  expires_at integer not null
+);
 
+drop table if exists feed_token;
+create table feed_token (
+  user_id integer primary key,
+  token string(64) not null unique,
+  created_at integer
+);
 
+drop table if exists poll;
+create table poll (
+  message_id integer primary key,
+  closes_at integer not null,
+  vote_count integer not null default 0
+);
 
+drop table if exists poll_option;
+create table poll_option (
+  option_id integer primary key autoincrement,
+  message_id integer not null,
+  position integer not null,
+  text string not null,
+  vote_count integer not null default 0
+);
 
+drop table if exists poll_vote;
+create table poll_vote (
+  message_id integer not null,
+  user_id integer not null,
+  option_id integer not null,
+  created_at integer,
+  primary key (message_id, user_id)
+);
 
+drop table if exists user_stats;
+create table user_stats (
+  user_id integer primary key,
+  follower_count integer not null default 0,
+  following_count integer not null default 0,
+  message_count integer not null default 0
+);
+
+CREATE INDEX idx_username ON user(username);
+CREATE INDEX idx_author_id ON message(author_id);
+CREATE INDEX idx_pub_date ON message(pub_date);
+CREATE INDEX idx_email ON user(email);
+CREATE INDEX idx_message_root_id ON message(root_id);
+CREATE INDEX idx_message_like_user_id ON message_like(user_id);
+CREATE INDEX idx_message_repost_of_id ON message(repost_of_id);
+CREATE INDEX idx_message_tag_tag ON message_tag(tag);
+CREATE INDEX idx_message_tag_pub_date ON message_tag(pub_date);
+CREATE INDEX idx_message_mention_user_id ON message_mention(user_id);
+CREATE INDEX idx_notification_user_id ON notification(user_id);
+CREATE INDEX idx_conversation_participant_user_id ON conversation_participant(user_id);
+CREATE INDEX idx_direct_message_conversation_id ON direct_message(conversation_id);
+CREATE INDEX idx_message_edit_message_id ON message_edit(message_id);
+CREATE INDEX idx_user_block_blocked_id ON user_block(blocked_id);
+CREATE INDEX idx_follow_request_target_id ON follow_request(target_id);
+CREATE INDEX idx_attachment_message_id ON attachment(message_id);
+CREATE INDEX idx_attachment_created_at ON attachment(created_at);
+CREATE INDEX idx_message_bookmark_message_id ON message_bookmark(message_id);
+CREATE INDEX idx_user_list_member_user_id ON user_list_member(user_id);
+CREATE INDEX idx_attachment_scheduled_id ON attachment(scheduled_id);
+CREATE INDEX idx_scheduled_message_author_id ON scheduled_message(author_id);
+CREATE INDEX idx_scheduled_message_publish_at ON scheduled_message(publish_at);
+CREATE INDEX idx_poll_option_message_id ON poll_option(message_id);
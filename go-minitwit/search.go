@@ -0,0 +1,374 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/*
+SEARCH
+Message text is matched by a pluggable SearchBackend, the filters (author, hashtag, dates) and the
+visibility rules are applied by the database query around it, so all backends return the same results.
+The backend is picked with SEARCH_BACKEND: "fts5" (SQLite, needs the sqlite_fts5 build tag),
+"mysql" (FULLTEXT index) or "words" (a table of the words). By default it follows the database, and
+SQLite builds without FTS5 fall back on the words backend.
+*/
+
+const MAX_SEARCH_TERMS = 8
+
+// words are made of letters, digits and _, like hashtags
+var searchTokenPattern = regexp.MustCompile(`[\p{L}\p{N}_]+`)
+
+type SearchBackend interface {
+	Name() string
+	// Setup creates the index if needed and indexes the existing messages
+	Setup(db *gorm.DB) error
+	// Index adds a message or replaces its text, it runs in the transaction writing the message
+	Index(tx *gorm.DB, message Message) error
+	Remove(tx *gorm.DB, messageID int) error
+	// Match restricts a message query to the messages containing all terms, as words or word prefixes
+	Match(query *gorm.DB, terms []string) *gorm.DB
+}
+
+var searchIndex SearchBackend
+
+func newSearchBackend(db *gorm.DB) (SearchBackend, error) {
+	name := os.Getenv("SEARCH_BACKEND")
+	if name == "" {
+		name = "fts5"
+		if db.Dialector.Name() == "mysql" {
+			name = "mysql"
+		}
+	}
+
+	var backend SearchBackend
+	switch name {
+	case "fts5":
+		backend = &fts5SearchBackend{}
+	case "mysql":
+		backend = &mysqlSearchBackend{}
+	case "words":
+		backend = &wordsSearchBackend{}
+	default:
+		return nil, errors.New("unknown SEARCH_BACKEND " + name)
+	}
+
+	err := backend.Setup(db)
+	if err != nil && name == "fts5" && os.Getenv("SEARCH_BACKEND") == "" {
+
+		logger.WithFields(logrus.Fields{
+			"source": "search",
+			"action": "setup",
+			"status": "fallback",
+			"error":  err.Error(),
+		}).Warn("FTS5 is not available (build with -tags sqlite_fts5), searching the words table")
+
+		backend = &wordsSearchBackend{}
+		err = backend.Setup(db)
+	}
+	return backend, err
+}
+
+// searchTokens returns the distinct lowercased words of a text
+func searchTokens(text string) []string {
+	var tokens []string
+	seen := map[string]bool{}
+	for _, token := range searchTokenPattern.FindAllString(strings.ToLower(text), -1) {
+		if !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+/*
+SQLITE FTS5
+An FTS5 table keyed by message id, holding a copy of the message text.
+*/
+
+type fts5SearchBackend struct{}
+
+func (b *fts5SearchBackend) Name() string {
+	return "fts5"
+}
+
+func (b *fts5SearchBackend) Setup(db *gorm.DB) error {
+	if db.Migrator().HasTable("message_search") {
+		return nil
+	}
+	err := db.Exec("CREATE VIRTUAL TABLE message_search USING fts5(text, tokenize='unicode61 remove_diacritics 2')").Error
+	if err != nil {
+		return err
+	}
+	return db.Exec("INSERT INTO message_search(rowid, text) SELECT message_id, text FROM message WHERE deleted_at = 0 AND text <> ''").Error
+}
+
+func (b *fts5SearchBackend) Index(tx *gorm.DB, message Message) error {
+	if err := b.Remove(tx, message.MessageID); err != nil {
+		return err
+	}
+	if message.Text == "" {
+		return nil
+	}
+	return tx.Exec("INSERT INTO message_search(rowid, text) VALUES (?, ?)", message.MessageID, message.Text).Error
+}
+
+func (b *fts5SearchBackend) Remove(tx *gorm.DB, messageID int) error {
+	return tx.Exec("DELETE FROM message_search WHERE rowid = ?", messageID).Error
+}
+
+func (b *fts5SearchBackend) Match(query *gorm.DB, terms []string) *gorm.DB {
+	var expression []string
+	for _, term := range terms {
+		// terms only hold word characters, quoting keeps FTS5 keywords like AND literal
+		expression = append(expression, `"`+term+`"*`)
+	}
+	return query.Where("message.message_id IN (SELECT rowid FROM message_search WHERE message_search MATCH ?)", strings.Join(expression, " AND "))
+}
+
+/*
+MYSQL FULLTEXT
+InnoDB maintains the FULLTEXT index on message.text itself, so indexing is a no-op.
+Words shorter than innodb_ft_min_token_size (3 by default) are not indexed.
+*/
+
+type mysqlSearchBackend struct{}
+
+func (b *mysqlSearchBackend) Name() string {
+	return "mysql"
+}
+
+func (b *mysqlSearchBackend) Setup(db *gorm.DB) error {
+	if db.Migrator().HasIndex(&Message{}, "idx_message_text_fulltext") {
+		return nil
+	}
+	return db.Exec("CREATE FULLTEXT INDEX idx_message_text_fulltext ON message(text)").Error
+}
+
+func (b *mysqlSearchBackend) Index(tx *gorm.DB, message Message) error {
+	return nil
+}
+
+func (b *mysqlSearchBackend) Remove(tx *gorm.DB, messageID int) error {
+	return nil
+}
+
+func (b *mysqlSearchBackend) Match(query *gorm.DB, terms []string) *gorm.DB {
+	var expression []string
+	for _, term := range terms {
+		expression = append(expression, "+"+term+"*")
+	}
+	return query.Where("MATCH(message.text) AGAINST (? IN BOOLEAN MODE)", strings.Join(expression, " "))
+}
+
+/*
+WORDS TABLE
+An inverted index of the words in a table of its own, for tests and small setups. Each term is one
+subquery, so the number of matching messages doesn't reach the query. Words are cut to 64 characters.
+*/
+
+const SEARCH_WORD_SIZE = 64 // the size of the word column
+
+// MessageSearchWord is a word of a message for the words backend
+type MessageSearchWord struct {
+	MessageID int    `gorm:"primaryKey;autoIncrement:false"`
+	Word      string `gorm:"primaryKey;size:64;index"`
+}
+
+type wordsSearchBackend struct{}
+
+func (b *wordsSearchBackend) Name() string {
+	return "words"
+}
+
+func (b *wordsSearchBackend) Setup(db *gorm.DB) error {
+	hadTable := db.Migrator().HasTable(&MessageSearchWord{})
+	if err := db.AutoMigrate(&MessageSearchWord{}); err != nil || hadTable {
+		return err
+	}
+
+	var messages []Message
+	return db.Select("message_id, text").Where("deleted_at = 0 AND text <> ''").
+		FindInBatches(&messages, 1000, func(tx *gorm.DB, batch int) error {
+			var words []MessageSearchWord
+			for _, m := range messages {
+				words = append(words, messageSearchWords(m)...)
+			}
+			if len(words) == 0 {
+				return nil
+			}
+			return db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&words, 500).Error
+		}).Error
+}
+
+// messageSearchWords returns the rows of the words of a message
+func messageSearchWords(message Message) []MessageSearchWord {
+	var words []MessageSearchWord
+	seen := map[string]bool{}
+	for _, token := range searchTokens(message.Text) {
+		word := truncateSearchWord(token)
+		if !seen[word] {
+			seen[word] = true
+			words = append(words, MessageSearchWord{MessageID: message.MessageID, Word: word})
+		}
+	}
+	return words
+}
+
+func truncateSearchWord(word string) string {
+	runes := []rune(word)
+	if len(runes) > SEARCH_WORD_SIZE {
+		return string(runes[:SEARCH_WORD_SIZE])
+	}
+	return word
+}
+
+func (b *wordsSearchBackend) Index(tx *gorm.DB, message Message) error {
+	if err := b.Remove(tx, message.MessageID); err != nil {
+		return err
+	}
+	words := messageSearchWords(message)
+	if len(words) == 0 {
+		return nil
+	}
+	return tx.Create(&words).Error
+}
+
+func (b *wordsSearchBackend) Remove(tx *gorm.DB, messageID int) error {
+	return tx.Where("message_id = ?", messageID).Delete(&MessageSearchWord{}).Error
+}
+
+func (b *wordsSearchBackend) Match(query *gorm.DB, terms []string) *gorm.DB {
+	for _, term := range terms {
+		// terms only hold word characters, _ is the only one LIKE gives a meaning
+		pattern := strings.ReplaceAll(truncateSearchWord(term), "_", "!_") + "%"
+		query = query.Where("message.message_id IN (SELECT message_id FROM message_search_word WHERE word LIKE ? ESCAPE '!')", pattern)
+	}
+	return query
+}
+
+/*
+QUERIES
+*/
+
+// SearchQuery is a parsed search, Since and Until are unix times (Until is exclusive, 0 for no bound)
+type SearchQuery struct {
+	Terms []string
+	From  string
+	Tag   string
+	Since int
+	Until int
+}
+
+// Empty tells a query that would match every message
+func (q SearchQuery) Empty() bool {
+	return len(q.Terms) == 0 && q.From == "" && q.Tag == ""
+}
+
+const searchDateLayout = "2006-01-02"
+
+// parseSearchDate reads a YYYY-MM-DD date as the start of the day in UTC, 0 if it is not a date
+func parseSearchDate(value string) int {
+	date, err := time.Parse(searchDateLayout, value)
+	if err != nil {
+		return 0
+	}
+	return int(date.Unix())
+}
+
+// parseSearchQuery reads the words of a search and its filters: from:<username>, #<tag>,
+// since:<YYYY-MM-DD> and until:<YYYY-MM-DD> (both days included)
+func parseSearchQuery(raw string) SearchQuery {
+	var query SearchQuery
+	var text []string
+	for _, field := range strings.Fields(raw) {
+		lower := strings.ToLower(field)
+		switch {
+		case strings.HasPrefix(lower, "from:"):
+			query.From = strings.TrimPrefix(field[len("from:"):], "@")
+		case strings.HasPrefix(lower, "since:"):
+			query.Since = parseSearchDate(field[len("since:"):])
+		case strings.HasPrefix(lower, "until:"):
+			if until := parseSearchDate(field[len("until:"):]); until != 0 {
+				query.Until = until + 24*60*60
+			}
+		case strings.HasPrefix(field, "#") && normalizeTag(field) != "":
+			query.Tag = normalizeTag(field)
+		default:
+			text = append(text, field)
+		}
+	}
+
+	query.Terms = searchTokens(strings.Join(text, " "))
+	if len(query.Terms) > MAX_SEARCH_TERMS {
+		query.Terms = query.Terms[:MAX_SEARCH_TERMS]
+	}
+	return query
+}
+
+// searchQueryFromRequest parses ?q= and adds the filters given as separate parameters
+// (from, tag, since and until), which take precedence over the ones in q
+func searchQueryFromRequest(c *gin.Context) SearchQuery {
+	query := parseSearchQuery(c.Query("q"))
+	if from := strings.TrimPrefix(strings.TrimSpace(c.Query("from")), "@"); from != "" {
+		query.From = from
+	}
+	if tag := normalizeTag(c.Query("tag")); tag != "" {
+		query.Tag = tag
+	}
+	if since := parseSearchDate(c.Query("since")); since != 0 {
+		query.Since = since
+	}
+	if until := parseSearchDate(c.Query("until")); until != 0 {
+		query.Until = until + 24*60*60
+	}
+	return query
+}
+
+// searchPageLink links another page of the current search
+func searchPageLink(c *gin.Context, page int) template.URL {
+	if page == 0 {
+		return ""
+	}
+	params := url.Values{}
+	for key, values := range c.Request.URL.Query() {
+		params[key] = values
+	}
+	params.Set("page", strconv.Itoa(page))
+	return template.URL("/search?" + params.Encode())
+}
+
+// highlightText escapes text, wrapping the words starting with one of the terms in <mark>
+func highlightText(text string, terms []string) string {
+	if len(terms) == 0 {
+		return template.HTMLEscapeString(text)
+	}
+
+	var out strings.Builder
+	last := 0
+	for _, loc := range searchTokenPattern.FindAllStringIndex(text, -1) {
+		word := strings.ToLower(text[loc[0]:loc[1]])
+		for _, term := range terms {
+			if strings.HasPrefix(word, term) {
+				out.WriteString(template.HTMLEscapeString(text[last:loc[0]]))
+				out.WriteString("<mark>" + template.HTMLEscapeString(text[loc[0]:loc[1]]) + "</mark>")
+				last = loc[1]
+				break
+			}
+		}
+	}
+	out.WriteString(template.HTMLEscapeString(text[last:]))
+	return out.String()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearchFindsQuotePosts(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+
+	original := postTestMessage(t, alice, "the original message")
+	if err := repostMessage(bob, original, "a zebra quoting it"); err != nil {
+		t.Fatal(err)
+	}
+	quote := lastTestMessageID(t, bob)
+
+	messages, err := searchMessages(parseSearchQuery("zebra"), 0, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].MessageID != quote {
+		t.Fatalf("searching the quote text found %v, want the quote post %d", messages, quote)
+	}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	day := parseSearchDate
+	cases := []struct {
+		raw  string
+		want SearchQuery
+	}{
+		{"", SearchQuery{}},
+		{"Hello, World hello", SearchQuery{Terms: []string{"hello", "world"}}},
+		{"from:alice cats", SearchQuery{Terms: []string{"cats"}, From: "alice"}},
+		{"FROM:@alice", SearchQuery{From: "alice"}},
+		{"#Go news", SearchQuery{Terms: []string{"news"}, Tag: "go"}},
+		{"since:2024-03-01", SearchQuery{Since: day("2024-03-01")}},
+		// until includes the whole day
+		{"until:2024-03-01", SearchQuery{Until: day("2024-03-02")}},
+		{"since:2024-03-01 until:2024-03-31 rain", SearchQuery{Terms: []string{"rain"}, Since: day("2024-03-01"), Until: day("2024-04-01")}},
+		// dates that don't parse are left out
+		{"since:yesterday until:2024-13-01", SearchQuery{}},
+		{"# lonely", SearchQuery{Terms: []string{"lonely"}}},
+		{"a b c d e f g h i j", SearchQuery{Terms: []string{"a", "b", "c", "d", "e", "f", "g", "h"}}},
+	}
+	for _, c := range cases {
+		if got := parseSearchQuery(c.raw); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parseSearchQuery(%q) = %+v, want %+v", c.raw, got, c.want)
+		}
+	}
+
+	if !parseSearchQuery("since:2024-03-01").Empty() {
+		t.Errorf("a query with only dates is not empty")
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+	march := postTestMessage(t, alice, "rain in march")
+	april := postTestMessage(t, alice, "rain in april #weather")
+	bobs := postTestMessage(t, bob, "rain for bob")
+	for messageID, date := range map[int]string{march: "2024-03-31", april: "2024-04-01", bobs: "2024-03-15"} {
+		pubDate := parseSearchDate(date) + 12*60*60
+		if err := dbNew.Model(&Message{}).Where("message_id = ?", messageID).Update("pub_date", pubDate).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		raw  string
+		want []int
+	}{
+		{"rain", []int{april, march, bobs}},
+		{"ra", []int{april, march, bobs}},
+		{"rain march", []int{march}},
+		{"from:alice rain", []int{april, march}},
+		{"from:bob", []int{bobs}},
+		{"#weather", []int{april}},
+		{"since:2024-04-01", []int{april}},
+		{"until:2024-03-31", []int{march, bobs}},
+		{"from:alice since:2024-03-01 until:2024-03-31", []int{march}},
+		{"snow", nil},
+	}
+	for _, c := range cases {
+		messages, err := searchMessages(parseSearchQuery(c.raw), 0, 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		for _, m := range messages {
+			got = append(got, m.MessageID)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("searching %q found %v, want %v", c.raw, got, c.want)
+		}
+	}
+}
+
+func TestSearchBackendFallsBackOnWords(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+	messageID := postTestMessage(t, alice, "indexed before setup")
+
+	t.Setenv("SEARCH_BACKEND", "")
+	backend, err := newSearchBackend(dbNew)
+	if err != nil {
+		t.Fatalf("the default backend failed to set up: %v", err)
+	}
+	if backend.Name() != "fts5" && backend.Name() != "words" {
+		t.Fatalf("SQLite picked the %s backend", backend.Name())
+	}
+	searchIndex = backend
+	messages, err := searchMessages(parseSearchQuery("setup"), 0, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].MessageID != messageID {
+		t.Errorf("the %s backend did not index the existing messages: %v", backend.Name(), messages)
+	}
+
+	if backend.Name() == "words" {
+		// asked for explicitly, FTS5 does not fall back
+		t.Setenv("SEARCH_BACKEND", "fts5")
+		if _, err := newSearchBackend(dbNew); err == nil {
+			t.Errorf("SEARCH_BACKEND=fts5 set up without FTS5")
+		}
+	}
+	t.Setenv("SEARCH_BACKEND", "words")
+	if backend, err := newSearchBackend(dbNew); err != nil || backend.Name() != "words" {
+		t.Errorf("SEARCH_BACKEND=words gave %v, %v", backend, err)
+	}
+	t.Setenv("SEARCH_BACKEND", "elastic")
+	if _, err := newSearchBackend(dbNew); err == nil {
+		t.Errorf("an unknown SEARCH_BACKEND was accepted")
+	}
+}
+
+func TestWordsSearchMatchesMoreMessagesThanBoundVariables(t *testing.T) {
+	setupTestDB(t)
+	alice := createTestUser(t, "alice")
+
+	// more matches than SQLite binds in one statement (999 before 3.32, 32766 after)
+	const count = 33000
+	messages := make([]Message, count)
+	for i := range messages {
+		messages[i] = Message{AuthorID: alice, Text: "a haystack needle", PubDate: i}
+	}
+	if err := dbNew.CreateInBatches(&messages, 50).Error; err != nil {
+		t.Fatal(err)
+	}
+	var words []MessageSearchWord
+	for _, m := range messages {
+		words = append(words, messageSearchWords(m)...)
+	}
+	if err := dbNew.CreateInBatches(&words, 400).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := searchMessages(parseSearchQuery("need hay"), 0, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 10 || found[0].MessageID != messages[count-1].MessageID {
+		t.Fatalf("found %d messages starting with %v, want the 10 newest", len(found), found)
+	}
+}
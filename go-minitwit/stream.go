@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+TIMELINE STREAMS
+Timelines get their new messages pushed with Server-Sent Events: /stream/public, /stream/home (the
+personal timeline) and /stream/users/<username>. The write paths hand new messages to the hub, which
+checks once per message who may see it (follows, blocks, mutes, private accounts) and passes it on to
+the matching connections. Every connection renders the messages for its viewer.
+
+The event id is the message id. A client reconnecting with Last-Event-ID (or ?since= on the first
+connection) first gets the newer messages of the timeline's first page. The hub lives in the process,
+so a connection only sees the messages posted on its replica until it reconnects.
+*/
+
+const (
+	STREAM_PUBLIC = "public"
+	STREAM_HOME   = "home"
+	STREAM_USER   = "user"
+
+	STREAM_QUEUE_SIZE = 256
+	STREAM_BUFFER     = 16   // messages waiting for a slow connection before it is closed
+	STREAM_RETRY      = 5000 // milliseconds the browser waits before reconnecting
+)
+
+var (
+	streamQueue       chan int // ids of new messages
+	streamMutex       sync.Mutex
+	streamSubscribers = map[*streamSubscriber]bool{}
+	htmlRender        render.HTMLRender // the templates of the router, to render messages for the streams
+)
+
+type streamSubscriber struct {
+	timeline  string
+	viewerID  int // 0 for anonymous viewers
+	profileID int // the user of a user timeline
+	messages  chan MessageUser
+	lagged    chan struct{} // closes the connection, the client catches up when it reconnects
+}
+
+// wants tells whether the new message belongs to the timeline of the subscriber and its viewer may see it
+func (s *streamSubscriber) wants(m MessageUser, audience StreamAudience) bool {
+	if isHiddenMessage(m) {
+		return false
+	}
+	if s.viewerID != 0 && s.viewerID != m.AuthorID && audience.Blocked[s.viewerID] {
+		return false
+	}
+	switch s.timeline {
+	case STREAM_PUBLIC:
+		return !audience.Private && !isPureRepost(m)
+	case STREAM_USER:
+		return m.AuthorID == s.profileID && (!audience.Private || s.viewerID == m.AuthorID || audience.Followers[s.viewerID])
+	case STREAM_HOME:
+		return (m.AuthorID == s.viewerID || audience.Followers[s.viewerID]) && !audience.Muting[s.viewerID]
+	}
+	return false
+}
+
+// streamNewMessage hands a new message to the timeline streams, without waiting for them
+func streamNewMessage(messageID int) {
+	if streamQueue == nil {
+		return
+	}
+	select {
+	case streamQueue <- messageID:
+	default:
+		droppedStreamEventsCounter.Inc()
+	}
+}
+
+// startStreamHub creates the queue of new messages and the worker passing them on to the connections
+func startStreamHub() {
+	queue := make(chan int, STREAM_QUEUE_SIZE)
+	go func() {
+		for messageID := range queue {
+			dispatchStreamMessage(messageID)
+		}
+	}()
+	streamQueue = queue
+}
+
+func dispatchStreamMessage(messageID int) {
+	streamMutex.Lock()
+	idle := len(streamSubscribers) == 0
+	streamMutex.Unlock()
+	if idle {
+		return
+	}
+
+	message, found, err := getMessage(messageID)
+	if err != nil || !found {
+		return
+	}
+	repostedAuthorID := 0
+	if isPureRepost(message) {
+		original, found, err := getMessage(message.RepostOfID)
+		if err != nil {
+			return
+		}
+		if found {
+			repostedAuthorID = original.AuthorID
+		}
+	}
+	audience, err := getStreamAudience(message.AuthorID, repostedAuthorID)
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":     "stream",
+			"action":     "get_audience",
+			"status":     "error",
+			"message_id": messageID,
+			"error":      err.Error(),
+		}).Error("Failed to stream a new message")
+
+		return
+	}
+
+	streamMutex.Lock()
+	defer streamMutex.Unlock()
+	for subscriber := range streamSubscribers {
+		if !subscriber.wants(message, audience) {
+			continue
+		}
+		select {
+		case subscriber.messages <- message:
+		default:
+			select {
+			case subscriber.lagged <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func subscribe(subscriber *streamSubscriber) {
+	streamMutex.Lock()
+	streamSubscribers[subscriber] = true
+	streamMutex.Unlock()
+	streamClientsGauge.Inc()
+}
+
+func unsubscribe(subscriber *streamSubscriber) {
+	streamMutex.Lock()
+	delete(streamSubscribers, subscriber)
+	streamMutex.Unlock()
+	streamClientsGauge.Dec()
+}
+
+// streamHeartbeat is how often idle connections get a comment, STREAM_HEARTBEAT (default 15s),
+// so proxies don't close them
+func streamHeartbeat() time.Duration {
+	interval, err := time.ParseDuration(os.Getenv("STREAM_HEARTBEAT"))
+	if err != nil || interval < time.Second {
+		return 15 * time.Second
+	}
+	return interval
+}
+
+// replay returns the messages of the first page of the timeline newer than lastID, oldest first
+func (s *streamSubscriber) replay(lastID int) ([]MessageUser, error) {
+	var messages []MessageUser
+	var err error
+	switch s.timeline {
+	case STREAM_PUBLIC:
+		messages, err = getPublicMessages(PERPAGE)
+	case STREAM_USER:
+		messages, err = getUserMessages(s.profileID, s.viewerID, PERPAGE)
+	case STREAM_HOME:
+		messages, err = getMyMessages(strconv.Itoa(s.viewerID), PERPAGE, 0)
+	}
+
+	// by id, the messages of the same second are in no particular order
+	var newer []MessageUser
+	for _, m := range messages {
+		if m.MessageID > lastID && !isHiddenMessage(m) {
+			newer = append(newer, m)
+		}
+	}
+	sort.Slice(newer, func(i, j int) bool { return newer[i].MessageID < newer[j].MessageID })
+	return newer, err
+}
+
+// renderStreamMessage renders a new message for the viewer as an item of the timeline list,
+// "" if there is nothing to show (a repost of a message that is gone)
+func renderStreamMessage(m MessageUser, viewerID int) (string, error) {
+	formatted := formatTimeline([]MessageUser{m}, viewerID)
+	if len(formatted) == 0 {
+		return "", nil
+	}
+	markViewerState(formatted, viewerID)
+
+	instance, ok := htmlRender.Instance("partials.html", nil).(render.HTML)
+	if !ok {
+		return "", fmt.Errorf("the templates can't be rendered outside of a request")
+	}
+	var out strings.Builder
+	out.WriteString(`<li data-message-id="` + strconv.Itoa(formatted[0].MessageID) + `">`)
+	if err := instance.Template.ExecuteTemplate(&out, "MessageItem", formatted[0]); err != nil {
+		return "", err
+	}
+	out.WriteString("</li>")
+	return out.String(), nil
+}
+
+// writeStreamEvent writes a message event, every line of the html goes in its own data field
+func writeStreamEvent(c *gin.Context, id int, html string) {
+	fmt.Fprintf(c.Writer, "id: %d\nevent: message\n", id)
+	for _, line := range strings.Split(html, "\n") {
+		fmt.Fprintf(c.Writer, "data: %s\n", line)
+	}
+	fmt.Fprint(c.Writer, "\n")
+	c.Writer.Flush()
+}
+
+// handles GET /stream/public, /stream/home and /stream/users/:username
+func streamHandler(c *gin.Context) {
+	_, viewerID, _ := loggedInUser(c)
+	subscriber := &streamSubscriber{
+		viewerID: viewerID,
+		messages: make(chan MessageUser, STREAM_BUFFER),
+		lagged:   make(chan struct{}, 1),
+	}
+
+	switch {
+	case strings.HasSuffix(c.FullPath(), "/public"):
+		subscriber.timeline = STREAM_PUBLIC
+	case strings.HasSuffix(c.FullPath(), "/home"):
+		if viewerID == 0 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		subscriber.timeline = STREAM_HOME
+	default:
+		profileUser, err := getUserByUsername(c.Param("username"))
+		if err != nil || profileUser.Username == "" {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		blockStatus, err := checkBlockStatus(viewerID, profileUser.UserID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if blockStatus.BlockedBy {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		subscriber.timeline = STREAM_USER
+		subscriber.profileID = profileUser.UserID
+	}
+
+	lastID, err := strconv.Atoi(c.GetHeader("Last-Event-ID"))
+	if err != nil {
+		lastID, _ = strconv.Atoi(c.Query("since"))
+	}
+
+	// subscribed before the replay, so no message falls in between
+	subscribe(subscriber)
+	defer unsubscribe(subscriber)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", STREAM_RETRY)
+	c.Writer.Flush()
+
+	send := func(m MessageUser) bool {
+		if m.MessageID <= lastID {
+			return true
+		}
+		html, err := renderStreamMessage(m, viewerID)
+		if err != nil {
+
+			logger.WithFields(logrus.Fields{
+				"source":     "stream",
+				"action":     "render_message",
+				"status":     "error",
+				"message_id": m.MessageID,
+				"error":      err.Error(),
+			}).Error("Failed to render a streamed message")
+
+			return false
+		}
+		if html != "" {
+			writeStreamEvent(c, m.MessageID, html)
+		}
+		lastID = m.MessageID
+		return true
+	}
+
+	if lastID > 0 {
+		missed, err := subscriber.replay(lastID)
+		if err != nil {
+			return
+		}
+		for _, m := range missed {
+			if !send(m) {
+				return
+			}
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat())
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-subscriber.lagged:
+			return
+		case m := <-subscriber.messages:
+			if !send(m) {
+				return
+			}
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": heartbeat\n\n")
+			c.Writer.Flush()
+		}
+	}
+}
+
+// streamSince is the newest message of a timeline page, where its stream takes over
+func streamSince(messages []MessageUser) int {
+	since := 0
+	for _, m := range messages {
+		if m.MessageID > since {
+			since = m.MessageID
+		}
+	}
+	return since
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+/*
+HASHTAGS
+*/
+
+// a hashtag starts after whitespace/punctuation (not inside words or urls) and is made of letters, digits and _
+var hashtagPattern = regexp.MustCompile(`(^|[^\p{L}\p{N}_&/#])#([\p{L}\p{N}_]{1,64})`)
+
+var tagNamePattern = regexp.MustCompile(`^[\p{L}\p{N}_]{1,64}$`)
+
+var digitsOnly = regexp.MustCompile(`^[0-9]+$`)
+
+// normalizeTag lowercases a tag, it returns "" for things that are not tags (like #1)
+func normalizeTag(tag string) string {
+	tag = strings.ToLower(strings.TrimPrefix(tag, "#"))
+	if !tagNamePattern.MatchString(tag) || digitsOnly.MatchString(tag) {
+		return ""
+	}
+	return tag
+}
+
+// parseHashtags returns the distinct normalized tags of a message text
+func parseHashtags(text string) []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, match := range hashtagPattern.FindAllStringSubmatch(text, -1) {
+		tag := normalizeTag(match[2])
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+func tagLink(tag string) string {
+	return "/tag/" + url.PathEscape(tag)
+}
+
+/*
+TRENDING TAGS
+*/
+
+type TrendingTag struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+	Link  string `json:"-"`
+}
+
+// the sliding windows trending tags are computed for, the first one is shown on the timelines
+var trendingWindows = []struct {
+	Name     string
+	Duration time.Duration
+}{
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+}
+
+const TRENDING_LIMIT = 10
+
+var trending = struct {
+	sync.RWMutex
+	tags      map[string][]TrendingTag
+	updatedAt time.Time
+}{tags: map[string][]TrendingTag{}}
+
+// getTrendingTags returns the last computed trending tags of a window, ok is false for unknown windows
+func getTrendingTags(window string) ([]TrendingTag, time.Time, bool) {
+	trending.RLock()
+	defer trending.RUnlock()
+	tags, ok := trending.tags[window]
+	return tags, trending.updatedAt, ok
+}
+
+func refreshTrendingTags() {
+	now := time.Now().UTC()
+	computed := map[string][]TrendingTag{}
+	for _, window := range trendingWindows {
+		tags, err := countTagsSince(int(now.Add(-window.Duration).Unix()), TRENDING_LIMIT)
+		if err != nil {
+
+			logger.WithFields(logrus.Fields{
+				"source": "trending_job",
+				"action": "count_tags",
+				"window": window.Name,
+				"status": "error",
+				"error":  err.Error(),
+			}).Error("Failed to compute trending tags")
+
+			return
+		}
+		for i := range tags {
+			tags[i].Link = tagLink(tags[i].Tag)
+		}
+		computed[window.Name] = tags
+	}
+
+	trending.Lock()
+	trending.tags = computed
+	trending.updatedAt = now
+	trending.Unlock()
+}
+
+// startTrendingJob recomputes the trending tags every TRENDING_INTERVAL (default 1m)
+func startTrendingJob() {
+	interval, err := time.ParseDuration(os.Getenv("TRENDING_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = time.Minute
+	}
+
+	go func() {
+		refreshTrendingTags()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			refreshTrendingTags()
+		}
+	}()
+}
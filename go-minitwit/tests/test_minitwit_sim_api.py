@@ -29,6 +29,28 @@ def clean_database():
     cur.execute("DELETE FROM user;")
     cur.execute("DELETE FROM message;")
     cur.execute("DELETE FROM follower;")
+    cur.execute("DELETE FROM user_stats;")
+    cur.execute("DELETE FROM message_like;")
+    cur.execute("DELETE FROM message_tag;")
+    cur.execute("DELETE FROM message_mention;")
+    cur.execute("DELETE FROM notification;")
+    cur.execute("DELETE FROM notification_preference;")
+    cur.execute("DELETE FROM conversation;")
+    cur.execute("DELETE FROM conversation_participant;")
+    cur.execute("DELETE FROM direct_message;")
+    cur.execute("DELETE FROM message_edit;")
+    cur.execute("DELETE FROM user_block;")
+    cur.execute("DELETE FROM user_mute;")
+    cur.execute("DELETE FROM follow_request;")
+    cur.execute("DELETE FROM attachment;")
+    cur.execute("DELETE FROM message_bookmark;")
+    cur.execute("DELETE FROM user_list_member;")
+    cur.execute("DELETE FROM user_list;")
+    cur.execute("DELETE FROM scheduled_message;")
+    cur.execute("DELETE FROM poll_vote;")
+    cur.execute("DELETE FROM poll_option;")
+    cur.execute("DELETE FROM poll;")
+    cur.execute("DELETE FROM feed_token;")
     conn.commit()
     conn.close()
     
@@ -211,6 +233,25 @@ def test_a_unfollows_b():
     response = session.get(f'{BASE_URL}/latest')
     assert response.json()['latest'] == 11
     
+def test_v2_follow_lists_and_stats():
+    session = create_new_session()
+    response = session.get(f'{BASE_URL}/v2/users/cc/followers')
+    assert response.ok
+    assert response.json()['users'] == ['aa']
+    assert response.json()['next_page'] is None
+
+    response = session.get(f'{BASE_URL}/v2/users/aa/following')
+    assert response.ok
+    assert response.json()['users'] == ['cc']
+
+    response = session.get(f'{BASE_URL}/v2/users/aa/stats')
+    assert response.ok
+    assert response.json()['following'] == 1
+    assert response.json()['messages'] == 1
+
+    response = session.get(f'{BASE_URL}/v2/users/nobody/stats')
+    assert response.status_code == 404
+
 def test_cleaning_the_db():
     session = create_new_session()
     username = 'aa'
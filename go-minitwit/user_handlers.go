@@ -15,6 +15,21 @@ import (
 )
 
 // Handlers
+
+// dispatches the /:username/<action> routes
+func userActionHandler(c *gin.Context) {
+	switch c.Param("action") {
+	case "/followers", "/following":
+		followListHandler(c)
+	case "/likes":
+		userLikesHandler(c)
+	case "/mentions":
+		userMentionsHandler(c)
+	default:
+		userFollowActionHandler(c)
+	}
+}
+
 func userFollowActionHandler(c *gin.Context) {
 	session := sessions.Default(c)
 
@@ -54,19 +69,29 @@ func userFollowActionHandler(c *gin.Context) {
 	action := c.Param("action")
 
 	if action == "/follow" {
-		followUser(userID, profileUserID)
+		userIDInt, _ := strconv.Atoi(userID)
+		state, err := requestFollow(userIDInt, profileUser.UserID)
+		if err == errBlocked {
+			session.AddFlash("You can't follow " + profileUserName)
+		} else if state == FOLLOW_STATE_REQUESTED {
+			session.AddFlash("You asked to follow " + profileUserName + ", your request is waiting for approval")
+		} else {
 
-		logger.WithFields(logrus.Fields{
-			"source":   "user_interface",
-			"endpoint": "following",
-			"action":   "follow",
-			"status":   "success",
-		}).Info("User followed another user")
+			logger.WithFields(logrus.Fields{
+				"source":   "user_interface",
+				"endpoint": "following",
+				"action":   "follow",
+				"status":   "success",
+			}).Info("User followed another user")
 
-		session.AddFlash("You are now following " + profileUserName)
+			session.AddFlash("You are now following " + profileUserName)
+		}
 	}
 	if action == "/unfollow" {
+		// also withdraws a pending follow request
 		unfollowUser(userID, profileUserID)
+		userIDInt, _ := strconv.Atoi(userID)
+		deleteFollowRequest(userIDInt, profileUser.UserID)
 
 		logger.WithFields(logrus.Fields{
 			"source":   "user_interface",
@@ -81,531 +106,2903 @@ func userFollowActionHandler(c *gin.Context) {
 	c.Redirect(http.StatusFound, "/"+profileUserName)
 }
 
-func publicTimelineHandler(c *gin.Context) {
-	// need to pass a default value to getPublicMessages (GoLang doesn't support default values for arguments)
-	messages, err := getPublicMessages(PERPAGE)
-	if err != nil {
+// renders the paginated /:username/followers and /:username/following pages
+func followListHandler(c *gin.Context) {
+	listKind := strings.TrimPrefix(c.Param("action"), "/")
+	profileUserName := c.Param("username")
+	profileUser, err := getUserByUsername(profileUserName)
+	if err != nil || profileUser.Username == "" {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": listKind,
+			"action":   "fetch_user",
+			"status":   "user_not_found",
+		}).Warn("User not found for follow list")
+
+		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
-	formattedMessages := formatMessages(messages)
 
-	context := gin.H{
-		"TimelineBody": true, // This seems to be a flag you use to render specific parts of your layout
-		"Endpoint":     "public_timeline",
-		"Messages":     formattedMessages,
+	page := getPage(c)
+	profileUserID := strconv.Itoa(profileUser.UserID)
+	var users []User
+	if listKind == "followers" {
+		users, err = getFollowers(profileUserID, PERPAGE+1, (page-1)*PERPAGE)
+	} else {
+		users, err = getFollowing(profileUserID, PERPAGE+1, (page-1)*PERPAGE)
 	}
+	if err != nil {
 
-	userID, errID := c.Cookie("UserID")
-	if errID == nil {
-		context["UserID"] = userID
-		userName, errName := getUserNameByUserID(userID)
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": listKind,
+			"action":   "fetch_follow_list",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Error fetching follow list")
 
-		if errName == nil {
-			context["UserName"] = userName
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
 
-			logger.WithFields(logrus.Fields{
-				"source":   "user_interface",
-				"endpoint": "public_timeline",
-				"action":   "identify user",
-			}).Info("User identified for public timeline")
-		}
+	pagination := newPagination(page, len(users))
+	if len(users) > PERPAGE {
+		users = users[:PERPAGE]
+	}
+
+	stats, err := getUserStats(profileUser.UserID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
 	}
 
+	userID, _ := c.Cookie("UserID")
+	userName, _ := getUserNameByUserID(userID)
+
 	logger.WithFields(logrus.Fields{
-		"source":        "user_interface",
-		"endpoint":      "public_timeline",
-		"action":        "render",
-		"messagesCount": len(formattedMessages),
-	}).Info("Rendering public timeline")
+		"source":      "user_interface",
+		"endpoint":    listKind,
+		"action":      "render_follow_list",
+		"users_count": len(users),
+		"page":        page,
+	}).Info("Rendering follow list")
+
+	listTitle := profileUser.Username + " is following"
+	if listKind == "followers" {
+		listTitle = "Followers of " + profileUser.Username
+	}
 
-	// Render timeline template with the context including link variables
-	c.HTML(http.StatusOK, "timeline.html", context)
+	renderPage(c, http.StatusOK, "follow_list.html", gin.H{
+		"FollowListBody":  true,
+		"ListTitle":       listTitle,
+		"UserID":          userID,
+		"UserName":        userName,
+		"Users":           formatUsers(users),
+		"ProfileUserName": profileUser.Username,
+		"Stats":           stats,
+		"Pagination":      pagination,
+	})
 }
 
-func userTimelineHandler(c *gin.Context) {
+// renders the conversation a message belongs to at /msg/:id
+func threadHandler(c *gin.Context) {
 	session := sessions.Default(c)
 	flashMessages := session.Flashes()
 	session.Save()
-	profileUserName := c.Param("username")
-	profileUser, err := getUserByUsername(profileUserName)
-
-	if profileUser.Username == "" {
-
-		logger.WithFields(logrus.Fields{
-			"source":   "user_interface",
-			"endpoint": "user_timeline",
-			"action":   "fetch_user",
-			"status":   "user_not_found",
-		}).Warn("User not found for timeline")
 
-		c.AbortWithStatus(404)
+	messageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
+
+	message, found, err := getMessage(messageID)
 	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !found {
 
 		logger.WithFields(logrus.Fields{
-			"source":   "user_interface",
-			"endpoint": "user_timeline",
-			"action":   "fetch_user",
-			"status":   "error",
-			"error":    err.Error(),
-		}).Error("Error fetching user for timeline")
-
-		c.AbortWithError(http.StatusInternalServerError, err)
+			"source":    "user_interface",
+			"endpoint":  "thread",
+			"action":    "fetch_message",
+			"status":    "message_not_found",
+			"messageID": messageID,
+		}).Warn("Message not found for thread")
+
+		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
-	// does the logged in user follow them
-	followed := false
-	pUserId := profileUser.UserID
-	profileName := profileUser.Username
-	userID, errID := c.Cookie("UserID")
+	userID, _ := c.Cookie("UserID")
 	userIDInt, _ := strconv.Atoi(userID)
-	userName, _ := getUserNameByUserID(userID)
-
-	if errID == nil {
-		followed, err = checkFollowStatus(userIDInt, pUserId)
-		if err != nil {
-
-			logger.WithFields(logrus.Fields{
-				"source":   "user_interface",
-				"endpoint": "user_timeline",
-				"action":   "check_follow_status",
-				"status":   "error",
-				"error":    err.Error(),
-			}).Error("Error checking follow status")
 
-			logMessage(err.Error())
-			return
-		}
+	// the messages of private accounts don't exist for those who may not see them
+	visible, err := canViewMessages(userIDInt, message.AuthorID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !visible {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
 	}
 
-	messages, err := getUserMessages(pUserId, PERPAGE)
-	fmt.Println(messages)
+	if message.RepostOfID != 0 && message.Text == "" {
+		// plain reposts have no conversation of their own
+		c.Redirect(http.StatusFound, "/msg/"+strconv.Itoa(message.RepostOfID))
+		return
+	}
 
+	rootID := message.RootID
+	if rootID == 0 {
+		rootID = message.MessageID
+	}
+	messages, err := getThreadMessages(rootID, userIDInt)
 	if err != nil {
 
 		logger.WithFields(logrus.Fields{
 			"source":   "user_interface",
-			"endpoint": "user_timeline",
-			"action":   "fetch_user_messages",
+			"endpoint": "thread",
+			"action":   "fetch_thread",
 			"status":   "error",
 			"error":    err.Error(),
-		}).Error("Error fetching user messages")
+		}).Error("Error fetching thread messages")
 
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
-	formattedMessages := formatMessages(messages)
+	userName, _ := getUserNameByUserID(userID)
+
+	formattedMessages := formatTimeline(messages, userIDInt)
+	markViewerState(formattedMessages, userIDInt)
 
 	logger.WithFields(logrus.Fields{
 		"source":         "user_interface",
-		"endpoint":       "user_timeline",
-		"action":         "render_user_timeline",
-		"messages_count": len(formattedMessages),
-	}).Info("Rendering users public timeline")
+		"endpoint":       "thread",
+		"action":         "render_thread",
+		"messages_count": len(messages),
+	}).Info("Rendering thread")
+
+	editable := false
+	for _, m := range formattedMessages {
+		if m.MessageID == messageID {
+			editable = m.Editable && !m.Flagged
+		}
+	}
 
-	c.HTML(http.StatusOK, "timeline.html", gin.H{
-		"TimelineBody":    true,
-		"Endpoint":        "user_timeline",
-		"UserID":          userIDInt,
-		"UserName":        userName,
-		"Messages":        formattedMessages,
-		"Followed":        followed,
-		"ProfileUser":     pUserId,
-		"ProfileUserName": profileName,
-		"Flashes":         flashMessages,
+	renderPage(c, http.StatusOK, "thread.html", gin.H{
+		"ThreadBody":  true,
+		"UserID":      userID,
+		"UserName":    userName,
+		"Thread":      buildThread(rootID, messageID, formattedMessages),
+		"ReplyTo":     messageID,
+		"Flagged":     isHiddenMessage(message),
+		"Editable":    editable,
+		"CurrentText": message.Text,
+		"Flashes":     flashMessages,
 	})
 }
 
-func myTimelineHandler(c *gin.Context) {
-	userID, err := c.Cookie("UserID")
-	errMsg := c.Query("error")
+// handles the edit form of the thread page, POST /msg/:id/edit
+func editMessageHandler(c *gin.Context) {
+	session := sessions.Default(c)
+
+	_, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
 
+	messageID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	redirectTo := "/msg/" + strconv.Itoa(messageID)
+
+	text := strings.TrimSpace(c.PostForm("text"))
+	if text == "" {
+		session.AddFlash("You have to enter a value")
+		session.Save()
+		c.Redirect(http.StatusSeeOther, redirectTo)
+		return
+	}
+
+	err = editMessage(userIDInt, messageID, text)
+	switch {
+	case err == nil:
+		session.AddFlash("Your message was updated")
+	case err == errMessageNotFound:
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	case err == errNotMessageAuthor, err == errEditWindowClosed:
+		session.AddFlash(err.Error())
+	case isMessageTextError(err):
+		session.AddFlash("Your message was not updated: " + err.Error())
+	default:
 
 		logger.WithFields(logrus.Fields{
 			"source":   "user_interface",
-			"endpoint": "my_timeline",
-			"action":   "get_cookie",
+			"endpoint": "edit_message",
+			"action":   "edit",
 			"status":   "error",
 			"error":    err.Error(),
-		}).Error("Error getting user information")
+		}).Error("Failed to edit message")
 
-		c.Redirect(http.StatusFound, "/public")
+		session.AddFlash("Failed to update your message")
+	}
+	session.Save()
+
+	c.Redirect(http.StatusSeeOther, redirectTo)
+}
+
+// handles POST /msg/:id/delete and goes back to the page the message was deleted from
+func deleteMessageHandler(c *gin.Context) {
+	session := sessions.Default(c)
+
+	_, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
 		return
 	}
 
-	userName, err := getUserNameByUserID(userID)
+	messageID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	err = deleteMessage(userIDInt, messageID)
+	switch err {
+	case nil:
+		session.AddFlash("Your message was deleted")
+	case errMessageNotFound:
+		session.AddFlash("The message does not exist anymore")
+	case errNotMessageAuthor:
+		session.AddFlash(err.Error())
+	default:
 
 		logger.WithFields(logrus.Fields{
 			"source":   "user_interface",
-			"endpoint": "my_timeline",
-			"action":   "get_user_name",
+			"endpoint": "delete_message",
+			"action":   "delete",
 			"status":   "error",
 			"error":    err.Error(),
-		}).Error("Error getting username by id")
+		}).Error("Failed to delete message")
 
-		c.AbortWithError(http.StatusInternalServerError, err)
-		return
+		session.AddFlash("Failed to delete your message")
 	}
+	session.Save()
 
-	session := sessions.Default(c)
-	flashMessages := session.Flashes()
-	session.Save() // Clear flashes after retrieving
+	redirectTo := c.Request.Referer()
+	if redirectTo == "" || strings.Contains(redirectTo, "/msg/"+strconv.Itoa(messageID)) {
+		redirectTo = "/"
+	}
+	c.Redirect(http.StatusSeeOther, redirectTo)
+}
 
-	messages, err := getMyMessages(userID)
+// renders the edit history of a message at /msg/:id/history
+func messageHistoryHandler(c *gin.Context) {
+	messageID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
 
-		logger.WithFields(logrus.Fields{
-			"source":   "user_interface",
-			"endpoint": "my_timeline",
-			"action":   "get_my_messages",
-			"status":   "error",
-			"error":    err.Error(),
-		}).Error("Error getting users messages")
+	message, found, err := getMessage(messageID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !found || isHiddenMessage(message) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	_, viewerID, _ := loggedInUser(c)
+	if visible, err := canViewMessages(viewerID, message.AuthorID); err != nil || !visible {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
 
+	edits, err := getMessageEdits(messageID)
+	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
-	formattedMessages := formatMessages(messages)
-	fmt.Println(formattedMessages)
+	// every edit stores the replaced text, so version i was published when version i-1 was replaced
+	var versions []gin.H
+	publishedAt := message.PubDate
+	for _, edit := range edits {
+		versions = append(versions, gin.H{"Text": edit.Text, "PubDate": publishedAt})
+		publishedAt = edit.EditedAt
+	}
+	versions = append(versions, gin.H{"Text": message.Text, "PubDate": publishedAt, "Current": true})
 
-	logger.WithFields(logrus.Fields{
-		"source":         "user_interface",
-		"endpoint":       "my_timeline",
-		"action":         "format_messages",
-		"messages_count": len(formattedMessages),
-	}).Info("Rendering users timeline")
+	userID, _ := c.Cookie("UserID")
+	userName, _ := getUserNameByUserID(userID)
 
-	// For template rendering with Gin
-	c.HTML(http.StatusOK, "timeline.html", gin.H{
-		"TimelineBody": true,
-		"Endpoint":     "my_timeline",
-		"UserID":       userID,
-		"UserName":     userName,
-		"Messages":     formattedMessages,
-		"Followed":     false,
-		"ProfileUser":  userID,
-		"Flashes":      flashMessages,
-		"Error":        errMsg,
+	renderPage(c, http.StatusOK, "history.html", gin.H{
+		"HistoryBody": true,
+		"UserID":      userID,
+		"UserName":    userName,
+		"Message":     formatMessages([]MessageUser{message})[0],
+		"Versions":    versions,
 	})
 }
 
-func addMessageHandler(c *gin.Context) {
+// handles POST /msg/:id/like and /msg/:id/unlike from the timelines and redirects back
+func likeActionHandler(c *gin.Context) {
 	session := sessions.Default(c)
 
-	userID, err := c.Cookie("UserID")
-	userIDString, errStr := strconv.Atoi(userID)
-	if err != nil || errStr != nil {
+	userID, errID := c.Cookie("UserID")
+	userIDInt, errConv := strconv.Atoi(userID)
+	if errID != nil || errConv != nil {
 
 		logger.WithFields(logrus.Fields{
 			"source":   "user_interface",
-			"endpoint": "add_messages",
-			"action":   "get_cookie",
-			"status":   "error",
-			"error":    err.Error(),
-		}).Error("Error getting user information")
+			"endpoint": "like",
+			"action":   "check login",
+			"status":   "not logged in",
+		}).Info("Attempt to like/unlike without being logged in")
 
-		c.Redirect(http.StatusFound, "/public")
+		session.AddFlash("You need to login before you can like messages.")
+		session.Save()
+		c.Redirect(http.StatusFound, "/login")
 		return
 	}
 
-	var errorData string
-	if c.Request.Method == http.MethodPost {
-		err := c.Request.ParseForm()
-		if err != nil {
-
-			logger.WithFields(logrus.Fields{
-				"source":   "user_interface",
-				"endpoint": "add_messages",
-				"action":   "parse_from_data",
-				"status":   "error",
-				"error":    err.Error(),
-			}).Error("Error failed to parse form data")
+	messageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
 
-			errorData = "Failed to parse form data"
-			c.Redirect(http.StatusBadRequest, "/?error="+errorData)
-			return
-		}
+	action := "like"
+	if strings.HasSuffix(c.FullPath(), "/unlike") {
+		action = "unlike"
+		err = unlikeMessage(userIDInt, messageID)
+	} else {
+		err = likeMessage(userIDInt, messageID)
+	}
 
-		// Validate form data
-		text := c.Request.FormValue("text")
+	if err == errMessageNotFound {
+		session.AddFlash("The message does not exist anymore")
+	} else if err == errBlocked {
+		session.AddFlash("You can't like this message")
+	} else if err != nil {
 
-		if text == "" {
-			c.Redirect(http.StatusSeeOther, "/")
-			session.AddFlash("You have to enter a value")
-			session.Save()
-			return
-		} else {
-			err := addMessage(text, userIDString)
-			if err != nil {
-
-				logger.WithFields(logrus.Fields{
-					"source":   "user_interface",
-					"endpoint": "add_messages",
-					"action":   "enter_value",
-					"status":   "error",
-					"error":    err.Error(),
-				}).Error("Error failed to add message")
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "like",
+			"action":   action,
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to " + action + " message")
 
-				errorData = "Failed to add message"
-				c.Redirect(http.StatusInternalServerError, "/?error="+errorData)
-				return
-			}
+		session.AddFlash("Failed to " + action + " the message")
+	} else {
 
-			logger.WithFields(logrus.Fields{
-				"source":   "user_interface",
-				"endpoint": "add_messages",
-				"action":   "enter_value",
-				"status":   "success",
-			}).Info("Rendering users timeline")
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "like",
+			"action":   action,
+			"status":   "success",
+		}).Info("User " + action + "d a message")
+	}
+	session.Save()
 
-			c.Redirect(http.StatusSeeOther, "/")
-			session.AddFlash("Your message was recorded")
-			session.Save()
-			return
-		}
+	redirectTo := c.Request.Referer()
+	if redirectTo == "" {
+		redirectTo = "/msg/" + strconv.Itoa(messageID)
 	}
-	c.Redirect(http.StatusSeeOther, "/")
+	c.Redirect(http.StatusSeeOther, redirectTo)
 }
 
-func registerHandler(c *gin.Context) {
+// handles POST /msg/:id/bookmark and /msg/:id/unbookmark
+func bookmarkActionHandler(c *gin.Context) {
 	session := sessions.Default(c)
 
-	userID, exists := c.Get("UserID")
-	if exists {
+	_, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		session.AddFlash("You need to login before you can bookmark messages.")
+		session.Save()
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	messageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	action := "bookmark"
+	if strings.HasSuffix(c.FullPath(), "/unbookmark") {
+		action = "unbookmark"
+		err = unbookmarkMessage(userIDInt, messageID)
+	} else {
+		err = bookmarkMessage(userIDInt, messageID)
+	}
+
+	if err == errMessageNotFound {
+		session.AddFlash("The message does not exist anymore")
+	} else if err != nil {
 
 		logger.WithFields(logrus.Fields{
 			"source":   "user_interface",
-			"endpoint": "register_user",
-			"action":   "get_user",
-		}).Info("User exists")
+			"endpoint": "bookmark",
+			"action":   action,
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to " + action + " message")
 
-		fmt.Println("userID:", userID)
-		return
+		session.AddFlash("Failed to " + action + " the message")
 	}
+	session.Save()
 
-	var errorData string
-	if c.Request.Method == http.MethodPost {
-		err := c.Request.ParseForm()
-		if err != nil {
+	redirectTo := c.Request.Referer()
+	if redirectTo == "" {
+		redirectTo = "/bookmarks"
+	}
+	c.Redirect(http.StatusSeeOther, redirectTo)
+}
 
-			logger.WithFields(logrus.Fields{
-				"source":   "user_interface",
-				"endpoint": "register_user",
-				"action":   "parse_data",
-				"status":   "error",
-				"error":    err.Error(),
-			}).Error("Error failed to parse form data")
+// handles POST /msg/:id/vote with the chosen option
+func voteActionHandler(c *gin.Context) {
+	session := sessions.Default(c)
 
-			errorData = "Failed to parse form data"
-			c.HTML(http.StatusBadRequest, "register.html", gin.H{
-				"RegisterBody": true,
-				"Error":        errorData,
-			})
-			return
-		}
+	_, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		session.AddFlash("You need to login before you can vote.")
+		session.Save()
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
 
-		// Validate form data
-		userName := c.Request.FormValue("username")
-		email := c.Request.FormValue("email")
-		password := c.Request.FormValue("password")
-		passwordConfirm := c.Request.FormValue("passwordConfirm")
+	messageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
 
-		userID, err := getUserIDByUsername(userName)
-		if err != nil {
+	optionID, _ := strconv.Atoi(c.PostForm("option"))
+	if optionID == 0 {
+		session.AddFlash("Choose an option to vote")
+	} else {
+		err = votePoll(userIDInt, messageID, optionID)
+		if err == errMessageNotFound {
+			session.AddFlash("The message does not exist anymore")
+		} else if err == errBlocked {
+			session.AddFlash("You can't vote on this poll")
+		} else if err == errPollClosed || err == errAlreadyVoted || err == errPollNotFound || err == errPollOptionNotFound {
+			session.AddFlash("Your vote was not counted: " + err.Error())
+		} else if err != nil {
 
 			logger.WithFields(logrus.Fields{
 				"source":   "user_interface",
-				"endpoint": "register_user",
-				"action":   "get_user_by_id",
+				"endpoint": "vote",
+				"action":   "vote",
 				"status":   "error",
 				"error":    err.Error(),
-			}).Error("Error getting username by id")
+			}).Error("Failed to vote")
 
-			c.AbortWithError(http.StatusInternalServerError, err)
-			return
+			session.AddFlash("Failed to count your vote")
 		}
+	}
+	session.Save()
 
-		if userName == "" {
-			errorData = "You have to enter a username"
-		} else if email == "" || !strings.Contains(email, "@") {
-			errorData = "You have to enter a valid email address"
-		} else if password == "" {
-			errorData = "You have to enter a password"
-		} else if password != passwordConfirm {
-			errorData = "The two passwords do not match"
-		} else if fmt.Sprint(userID) != "-1" {
-			errorData = "The username is already taken"
-		} else {
-			hash := md5.Sum([]byte(password))
-			err := registerUser(userName, email, hash)
-			if err != nil {
+	redirectTo := c.Request.Referer()
+	if redirectTo == "" {
+		redirectTo = "/msg/" + strconv.Itoa(messageID)
+	}
+	c.Redirect(http.StatusSeeOther, redirectTo)
+}
 
-				logger.WithFields(logrus.Fields{
-					"source":   "user_interface",
-					"endpoint": "register_user",
-					"action":   "registration_attempt",
-					"status":   "failed",
-					"reason":   "error_registering_user",
-					"error":    err.Error(),
-				}).Error("Failed registration attempt due to an error during registration")
+// renders the bookmarks of the logged in user at /bookmarks
+func bookmarksHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	flashMessages := session.Flashes()
+	session.Save()
 
-				errorData = "Failed to register user"
-				c.HTML(http.StatusInternalServerError, "register.html", gin.H{
-					"RegisterBody": true,
-					"Error":        errorData,
-				})
-				return
-			}
-			newSignupsCounter.Inc() //adding Prometheus new signups counter
+	userID, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
 
-			logger.WithFields(logrus.Fields{
-				"source":   "user_interface",
-				"endpoint": "register_user",
-				"action":   "registration",
-				"status":   "success",
-			}).Info("User successfully registered")
+	page := getPage(c)
+	messages, err := getBookmarkedMessages(userIDInt, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
 
-			// Redirect to login page after successful registration
-			session.AddFlash("You were successfully registered and can login now")
-			// print session info
-			fmt.Println("session info:", session, "Logged in")
-			session.Save()
-			c.Redirect(http.StatusSeeOther, "/login")
-			return
-		}
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "bookmarks",
+			"action":   "fetch_bookmarked_messages",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Error fetching bookmarked messages")
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
 	}
-	c.HTML(http.StatusOK, "register.html", gin.H{
-		"RegisterBody": true,
-		"Error":        errorData,
+
+	pagination := newPagination(page, len(messages))
+	if len(messages) > PERPAGE {
+		messages = messages[:PERPAGE]
+	}
+
+	userName, _ := getUserNameByUserID(userID)
+	formattedMessages := formatTimeline(messages, userIDInt)
+	markViewerState(formattedMessages, userIDInt)
+
+	renderPage(c, http.StatusOK, "timeline.html", gin.H{
+		"TimelineBody": true,
+		"Endpoint":     "bookmarks",
+		"UserID":       userID,
+		"UserName":     userName,
+		"Messages":     formattedMessages,
+		"Pagination":   pagination,
+		"Flashes":      flashMessages,
 	})
 }
 
-func loginHandler(c *gin.Context) {
+// handles POST /msg/:id/repost (with a "text" field it becomes a quote post) and /msg/:id/unrepost
+func repostActionHandler(c *gin.Context) {
 	session := sessions.Default(c)
-	flashMessages := session.Flashes()
-	session.Save()
 
-	userID, _ := c.Cookie("UserID")
-	if userID != "" {
+	userID, errID := c.Cookie("UserID")
+	userIDInt, errConv := strconv.Atoi(userID)
+	if errID != nil || errConv != nil {
 
 		logger.WithFields(logrus.Fields{
 			"source":   "user_interface",
-			"endpoint": "login_user",
-			"action":   "login_check",
-			"status":   "already_logged_in",
-			"userID":   userID,
-		}).Info("User already logged in, redirecting")
+			"endpoint": "repost",
+			"action":   "check login",
+			"status":   "not logged in",
+		}).Info("Attempt to repost without being logged in")
 
-		session.AddFlash("You were logged in")
+		session.AddFlash("You need to login before you can repost messages.")
 		session.Save()
-		c.Redirect(http.StatusFound, "/")
+		c.Redirect(http.StatusFound, "/login")
 		return
 	}
 
-	var errorData string
+	messageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
 
-	if c.Request.Method == http.MethodPost {
+	action := "repost"
+	text := strings.TrimSpace(c.PostForm("text"))
+	if strings.HasSuffix(c.FullPath(), "/unrepost") {
+		action = "unrepost"
+		err = unrepostMessage(userIDInt, messageID)
+	} else {
+		err = repostMessage(userIDInt, messageID, text)
+	}
 
-		err := c.Request.ParseForm()
-		if err != nil {
+	if err == errMessageNotFound {
+		session.AddFlash("The message does not exist anymore")
+	} else if err == errAlreadyReposted {
+		session.AddFlash("You already reposted this message")
+	} else if err == errPrivateRepost {
+		session.AddFlash("Messages of private accounts can't be reposted")
+	} else if err == errBlocked {
+		session.AddFlash("You can't repost this message")
+	} else if isMessageTextError(err) {
+		session.AddFlash("Your message was not posted: " + err.Error())
+	} else if err != nil {
 
-			logger.WithFields(logrus.Fields{
-				"source":   "user_interface",
-				"endpoint": "login_user",
-				"action":   "login_attempt",
-				"status":   "failed",
-				"reason":   "parse_form_error",
-				"error":    err.Error(),
-			}).Error("Failed to parse login form")
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "repost",
+			"action":   action,
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to " + action + " message")
 
-			errorData = "Failed to parse form data"
-			c.HTML(http.StatusBadRequest, "login.html", gin.H{
-				"loginBody": true,
-				"Error":     errorData,
-			})
-			return
-		}
+		session.AddFlash("Failed to " + action + " the message")
+	} else {
 
-		userName := c.Request.FormValue("username")
-		password := c.Request.FormValue("password")
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "repost",
+			"action":   action,
+			"status":   "success",
+		}).Info("User " + action + "ed a message")
 
-		user, err := getUserByUsername(userName)
-		if err != nil {
-			c.AbortWithError(http.StatusInternalServerError, err)
-			return
+		if action == "repost" && text != "" {
+			session.AddFlash("Your message was recorded")
 		}
+	}
+	session.Save()
 
-		if user.Username == "" {
-			errorData = "Invalid username"
-		} else if !checkPasswordHash(password, user.PwHash) {
-			errorData = "Invalid password"
-		} else {
-			userID, err := getUserIDByUsername(userName)
-			if err != nil {
-
-				logger.WithFields(logrus.Fields{
-					"source":   "user_interface",
-					"endpoint": "login_user",
-					"action":   "login_attempt",
-					"status":   "failed",
-					"reason":   "user_id_retrieval_error",
-					"error":    err.Error(),
-				}).Error("Failed to retrieve userID during login")
+	redirectTo := c.Request.Referer()
+	if redirectTo == "" {
+		redirectTo = "/msg/" + strconv.Itoa(messageID)
+	}
+	c.Redirect(http.StatusSeeOther, redirectTo)
+}
 
-				c.AbortWithError(http.StatusInternalServerError, err)
-				return
-			}
+// renders the "liked by" list of a message at /msg/:id/likes
+func likedByHandler(c *gin.Context) {
+	messageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
 
-			logger.WithFields(logrus.Fields{
-				"source":   "user_interface",
-				"endpoint": "login_user",
-				"action":   "login",
+	message, found, err := getMessage(messageID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !found || isHiddenMessage(message) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	_, viewerID, _ := loggedInUser(c)
+	if visible, err := canViewMessages(viewerID, message.AuthorID); err != nil || !visible {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	page := getPage(c)
+	users, err := getLikingUsers(messageID, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "liked_by",
+			"action":   "fetch_liking_users",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Error fetching users that liked a message")
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	pagination := newPagination(page, len(users))
+	if len(users) > PERPAGE {
+		users = users[:PERPAGE]
+	}
+
+	userID, _ := c.Cookie("UserID")
+	userName, _ := getUserNameByUserID(userID)
+
+	renderPage(c, http.StatusOK, "follow_list.html", gin.H{
+		"FollowListBody": true,
+		"ListTitle":      "Liked by",
+		"UserID":         userID,
+		"UserName":       userName,
+		"Users":          formatUsers(users),
+		"Messages":       formatMessages([]MessageUser{message}),
+		"Pagination":     pagination,
+	})
+}
+
+// renders the messages a user liked at /:username/likes
+func userLikesHandler(c *gin.Context) {
+	profileUser, err := getUserByUsername(c.Param("username"))
+	if err != nil || profileUser.Username == "" {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	userID, _ := c.Cookie("UserID")
+	userIDInt, _ := strconv.Atoi(userID)
+
+	page := getPage(c)
+	messages, err := getLikedMessages(profileUser.UserID, userIDInt, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "user_likes",
+			"action":   "fetch_liked_messages",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Error fetching liked messages")
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	pagination := newPagination(page, len(messages))
+	if len(messages) > PERPAGE {
+		messages = messages[:PERPAGE]
+	}
+
+	stats, err := getUserStats(profileUser.UserID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	userName, _ := getUserNameByUserID(userID)
+
+	formattedMessages := formatTimeline(messages, userIDInt)
+	markViewerState(formattedMessages, userIDInt)
+
+	renderPage(c, http.StatusOK, "timeline.html", gin.H{
+		"TimelineBody":    true,
+		"Endpoint":        "user_likes",
+		"UserID":          userID,
+		"UserName":        userName,
+		"Messages":        formattedMessages,
+		"ProfileUser":     profileUser.UserID,
+		"ProfileUserName": profileUser.Username,
+		"Stats":           stats,
+		"Pagination":      pagination,
+	})
+}
+
+// renders the messages mentioning a user at /:username/mentions
+func userMentionsHandler(c *gin.Context) {
+	profileUser, err := getUserByUsername(c.Param("username"))
+	if err != nil || profileUser.Username == "" {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	userID, _ := c.Cookie("UserID")
+	userIDInt, _ := strconv.Atoi(userID)
+
+	page := getPage(c)
+	messages, err := getMentioningMessages(profileUser.UserID, userIDInt, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "user_mentions",
+			"action":   "fetch_mentioning_messages",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Error fetching messages mentioning a user")
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	pagination := newPagination(page, len(messages))
+	if len(messages) > PERPAGE {
+		messages = messages[:PERPAGE]
+	}
+
+	stats, err := getUserStats(profileUser.UserID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	userName, _ := getUserNameByUserID(userID)
+
+	formattedMessages := formatTimeline(messages, userIDInt)
+	markViewerState(formattedMessages, userIDInt)
+
+	renderPage(c, http.StatusOK, "timeline.html", gin.H{
+		"TimelineBody":    true,
+		"Endpoint":        "user_mentions",
+		"UserID":          userID,
+		"UserName":        userName,
+		"Messages":        formattedMessages,
+		"ProfileUser":     profileUser.UserID,
+		"ProfileUserName": profileUser.Username,
+		"Stats":           stats,
+		"Pagination":      pagination,
+	})
+}
+
+// renders the messages with a hashtag at /tag/:name
+func tagHandler(c *gin.Context) {
+	tag := normalizeTag(c.Param("name"))
+	if tag == "" {
+		// /tag/<name>.atom and .rss are the feeds of the tag
+		if name, format := splitFeedName(c.Param("name")); format != "" && normalizeTag(name) != "" {
+			tagFeedHandler(c, normalizeTag(name), format)
+			return
+		}
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	userID, _ := c.Cookie("UserID")
+	userIDInt, _ := strconv.Atoi(userID)
+
+	page := getPage(c)
+	messages, err := getTaggedMessages(tag, userIDInt, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "tag",
+			"action":   "fetch_tagged_messages",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Error fetching tagged messages")
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	pagination := newPagination(page, len(messages))
+	if len(messages) > PERPAGE {
+		messages = messages[:PERPAGE]
+	}
+
+	userName, _ := getUserNameByUserID(userID)
+
+	formattedMessages := formatTimeline(messages, userIDInt)
+	markViewerState(formattedMessages, userIDInt)
+	trendingTags, _, _ := getTrendingTags(trendingWindows[0].Name)
+
+	renderPage(c, http.StatusOK, "timeline.html", gin.H{
+		"TimelineBody": true,
+		"Endpoint":     "tag",
+		"Tag":          tag,
+		"UserID":       userID,
+		"UserName":     userName,
+		"Messages":     formattedMessages,
+		"Trending":     trendingTags,
+		"Pagination":   pagination,
+		"Feeds":        feedLinks(tagLink(tag), "#"+tag+" on MiniTwit"),
+	})
+}
+
+// searchHandler searches messages (?type=messages, the default) or users (?type=users), see parseSearchQuery for the filters
+func searchHandler(c *gin.Context) {
+	kind := c.DefaultQuery("type", "messages")
+	if kind != "users" {
+		kind = "messages"
+	}
+	query := searchQueryFromRequest(c)
+	page := getPage(c)
+
+	userID, _ := c.Cookie("UserID")
+	userIDInt, _ := strconv.Atoi(userID)
+	userName, _ := getUserNameByUserID(userID)
+
+	data := gin.H{
+		"SearchBody": true,
+		"Query":      c.Query("q"),
+		"From":       c.Query("from"),
+		"Tag":        c.Query("tag"),
+		"Since":      c.Query("since"),
+		"Until":      c.Query("until"),
+		"Type":       kind,
+		"UserID":     userID,
+		"UserName":   userName,
+	}
+
+	var fetched int
+	var err error
+	if kind == "users" {
+		var users []User
+		users, err = searchUsers(query.Terms, PERPAGE+1, (page-1)*PERPAGE)
+		fetched = len(users)
+		if len(users) > PERPAGE {
+			users = users[:PERPAGE]
+		}
+		data["Users"] = formatUsers(users)
+	} else if !query.Empty() {
+		var messages []MessageUser
+		messages, err = searchMessages(query, userIDInt, PERPAGE+1, (page-1)*PERPAGE)
+		fetched = len(messages)
+		if len(messages) > PERPAGE {
+			messages = messages[:PERPAGE]
+		}
+		formattedMessages := formatTimeline(messages, userIDInt)
+		for i := range formattedMessages {
+			formattedMessages[i].Highlight = query.Terms
+		}
+		markViewerState(formattedMessages, userIDInt)
+		data["Messages"] = formattedMessages
+	}
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "search",
+			"action":   "search_" + kind,
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Error searching")
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	pagination := newPagination(page, fetched)
+	data["Pagination"] = pagination
+	data["PrevLink"] = searchPageLink(c, pagination.PrevPage)
+	data["NextLink"] = searchPageLink(c, pagination.NextPage)
+	renderPage(c, http.StatusOK, "search.html", data)
+}
+
+func publicTimelineHandler(c *gin.Context) {
+	// need to pass a default value to getPublicMessages (GoLang doesn't support default values for arguments)
+	messages, err := getPublicMessages(PERPAGE)
+	if err != nil {
+		return
+	}
+	userID, errID := c.Cookie("UserID")
+	userIDInt, _ := strconv.Atoi(userID)
+	formattedMessages := formatTimeline(messages, userIDInt)
+	trendingTags, _, _ := getTrendingTags(trendingWindows[0].Name)
+
+	context := gin.H{
+		"TimelineBody": true, // This seems to be a flag you use to render specific parts of your layout
+		"Endpoint":     "public_timeline",
+		"Messages":     formattedMessages,
+		"Trending":     trendingTags,
+		"Feeds":        feedLinks("/public", "MiniTwit public timeline"),
+		"Stream":       "/stream/public",
+		"StreamSince":  streamSince(messages),
+	}
+
+	if errID == nil {
+		context["UserID"] = userID
+		markViewerState(formattedMessages, userIDInt)
+		userName, errName := getUserNameByUserID(userID)
+
+		if errName == nil {
+			context["UserName"] = userName
+
+			logger.WithFields(logrus.Fields{
+				"source":   "user_interface",
+				"endpoint": "public_timeline",
+				"action":   "identify user",
+			}).Info("User identified for public timeline")
+		}
+	}
+
+	logger.WithFields(logrus.Fields{
+		"source":        "user_interface",
+		"endpoint":      "public_timeline",
+		"action":        "render",
+		"messagesCount": len(formattedMessages),
+	}).Info("Rendering public timeline")
+
+	// Render timeline template with the context including link variables
+	renderPage(c, http.StatusOK, "timeline.html", context)
+}
+
+func userTimelineHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	flashMessages := session.Flashes()
+	session.Save()
+	profileUserName := c.Param("username")
+	profileUser, err := getUserByUsername(profileUserName)
+
+	// /<username>.atom and .rss are the feeds of the user, unless a user has that name
+	if name, format := splitFeedName(profileUserName); profileUser.Username == "" && format != "" {
+		userFeedHandler(c, name, format)
+		return
+	}
+
+	if profileUser.Username == "" {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "user_timeline",
+			"action":   "fetch_user",
+			"status":   "user_not_found",
+		}).Warn("User not found for timeline")
+
+		c.AbortWithStatus(404)
+		return
+	}
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "user_timeline",
+			"action":   "fetch_user",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Error fetching user for timeline")
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// does the logged in user follow them, or wait for their approval
+	followState := FOLLOW_STATE_NONE
+	pUserId := profileUser.UserID
+	profileName := profileUser.Username
+	userID, errID := c.Cookie("UserID")
+	userIDInt, _ := strconv.Atoi(userID)
+	userName, _ := getUserNameByUserID(userID)
+
+	if errID == nil && userIDInt != pUserId {
+		followState, err = getFollowState(userIDInt, pUserId)
+		if err != nil {
+
+			logger.WithFields(logrus.Fields{
+				"source":   "user_interface",
+				"endpoint": "user_timeline",
+				"action":   "check_follow_status",
+				"status":   "error",
+				"error":    err.Error(),
+			}).Error("Error checking follow status")
+
+			logMessage(err.Error())
+			return
+		}
+	}
+
+	blockStatus, err := checkBlockStatus(userIDInt, pUserId)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	var messages []MessageUser
+	if !blockStatus.BlockedBy {
+		messages, err = getUserMessages(pUserId, userIDInt, PERPAGE)
+	}
+
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "user_timeline",
+			"action":   "fetch_user_messages",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Error fetching user messages")
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	formattedMessages := formatTimeline(messages, userIDInt)
+	markViewerState(formattedMessages, userIDInt)
+
+	stats, err := getUserStats(pUserId)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// private accounts have no feeds
+	var feeds []FeedLink
+	if !profileUser.Private {
+		feeds = feedLinks(profilePath(profileName), "@"+profileName+" on MiniTwit")
+	}
+
+	// new messages are streamed unless the profile user blocked the viewer
+	var stream string
+	if !blockStatus.BlockedBy {
+		stream = "/stream/users" + profilePath(profileName)
+	}
+
+	// the lists of the viewer, to add the profile user to them or remove them
+	var lists []ListUI
+	if userIDInt != 0 && userIDInt != pUserId && !blockStatus.Blocked && !blockStatus.BlockedBy {
+		ownLists, err := getUserLists(userIDInt, true)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		memberships, err := getListMemberships(userIDInt, pUserId)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		lists = formatLists(ownLists)
+		for i := range lists {
+			lists[i].Member = memberships[lists[i].ListID]
+		}
+	}
+
+	logger.WithFields(logrus.Fields{
+		"source":         "user_interface",
+		"endpoint":       "user_timeline",
+		"action":         "render_user_timeline",
+		"messages_count": len(formattedMessages),
+	}).Info("Rendering users public timeline")
+
+	renderPage(c, http.StatusOK, "timeline.html", gin.H{
+		"TimelineBody":    true,
+		"Endpoint":        "user_timeline",
+		"UserID":          userIDInt,
+		"UserName":        userName,
+		"Messages":        formattedMessages,
+		"Followed":        followState == FOLLOW_STATE_FOLLOWING,
+		"Requested":       followState == FOLLOW_STATE_REQUESTED,
+		"Private":         profileUser.Private,
+		"Profile":         formatProfile(profileUser),
+		"BlockStatus":     blockStatus,
+		"ProfileUser":     pUserId,
+		"ProfileUserName": profileName,
+		"Stats":           stats,
+		"Lists":           lists,
+		"Feeds":           feeds,
+		"Stream":          stream,
+		"StreamSince":     streamSince(messages),
+		"Flashes":         flashMessages,
+	})
+}
+
+func myTimelineHandler(c *gin.Context) {
+	userID, err := c.Cookie("UserID")
+	errMsg := c.Query("error")
+
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "my_timeline",
+			"action":   "get_cookie",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Error getting user information")
+
+		c.Redirect(http.StatusFound, "/public")
+		return
+	}
+
+	userName, err := getUserNameByUserID(userID)
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "my_timeline",
+			"action":   "get_user_name",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Error getting username by id")
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	session := sessions.Default(c)
+	flashMessages := session.Flashes()
+	session.Save() // Clear flashes after retrieving
+
+	messages, err := getMyMessages(userID, -1, 0)
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "my_timeline",
+			"action":   "get_my_messages",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Error getting users messages")
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	userIDInt, _ := strconv.Atoi(userID)
+	formattedMessages := formatTimeline(messages, userIDInt)
+	markViewerState(formattedMessages, userIDInt)
+
+	logger.WithFields(logrus.Fields{
+		"source":         "user_interface",
+		"endpoint":       "my_timeline",
+		"action":         "format_messages",
+		"messages_count": len(formattedMessages),
+	}).Info("Rendering users timeline")
+
+	trendingTags, _, _ := getTrendingTags(trendingWindows[0].Name)
+
+	// the page is only shown to its owner, so it can announce the secret feed
+	var feeds []FeedLink
+	if feedToken, _ := getFeedToken(userIDInt); feedToken != "" {
+		feeds = feedLinks(personalFeedPath(feedToken), "Your MiniTwit timeline")
+	}
+
+	// For template rendering with Gin
+	renderPage(c, http.StatusOK, "timeline.html", gin.H{
+		"TimelineBody": true,
+		"Endpoint":     "my_timeline",
+		"UserID":       userID,
+		"UserName":     userName,
+		"Messages":     formattedMessages,
+		"Followed":     false,
+		"ProfileUser":  userID,
+		"Trending":     trendingTags,
+		"Feeds":        feeds,
+		"Stream":       "/stream/home",
+		"StreamSince":  streamSince(messages),
+		"Flashes":      flashMessages,
+		"Error":        errMsg,
+	})
+}
+
+func addMessageHandler(c *gin.Context) {
+	session := sessions.Default(c)
+
+	userID, err := c.Cookie("UserID")
+	userIDString, errStr := strconv.Atoi(userID)
+	if err != nil || errStr != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "add_messages",
+			"action":   "get_cookie",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Error getting user information")
+
+		c.Redirect(http.StatusFound, "/public")
+		return
+	}
+
+	var errorData string
+	if c.Request.Method == http.MethodPost {
+		err := c.Request.ParseForm()
+		if err == nil && strings.HasPrefix(c.ContentType(), "multipart/") {
+			// the timeline form is multipart (for the images), ParseForm leaves its fields out
+			err = c.Request.ParseMultipartForm(32 << 20)
+		}
+		if err != nil {
+
+			logger.WithFields(logrus.Fields{
+				"source":   "user_interface",
+				"endpoint": "add_messages",
+				"action":   "parse_from_data",
+				"status":   "error",
+				"error":    err.Error(),
+			}).Error("Error failed to parse form data")
+
+			errorData = "Failed to parse form data"
+			c.Redirect(http.StatusBadRequest, "/?error="+errorData)
+			return
+		}
+
+		// Validate form data
+		text := c.Request.FormValue("text")
+		// replies are posted from the thread page and go back there
+		redirectTo := "/"
+		replyTo, _ := strconv.Atoi(c.Request.FormValue("reply_to"))
+		if replyTo > 0 {
+			redirectTo = "/msg/" + strconv.Itoa(replyTo)
+		}
+
+		// the poll options poll0..poll3 are checked before any image is stored
+		action := c.Request.FormValue("action")
+		poll, errPoll := pollFromForm(c)
+		if errPoll == nil && poll != nil && (action == "draft" || action == "schedule") {
+			errPoll = errPollNotScheduled
+		}
+		if errPoll != nil {
+			session.AddFlash("Your message was not posted: " + errPoll.Error())
+			session.Save()
+			c.Redirect(http.StatusSeeOther, redirectTo)
+			return
+		}
+
+		// the timeline form posts images as image0..image3 with their alt texts alt0..alt3
+		var attachments []Attachment
+		var attachmentIDs []int
+		if strings.HasPrefix(c.ContentType(), "multipart/") {
+			for i := 0; i < MAX_ATTACHMENTS; i++ {
+				data, err := readUpload(c, "image"+strconv.Itoa(i), ATTACHMENT_MAX_BYTES)
+				if err == errMissingUpload {
+					continue
+				}
+				var attachment Attachment
+				if err == nil {
+					attachment, err = createAttachment(userIDString, data, c.Request.FormValue("alt"+strconv.Itoa(i)))
+				}
+				if err != nil {
+					removeUnattached(attachments)
+					if isUploadError(err) {
+						session.AddFlash("Your message was not posted: " + err.Error())
+					} else {
+
+						logger.WithFields(logrus.Fields{
+							"source":   "user_interface",
+							"endpoint": "add_messages",
+							"action":   "upload_image",
+							"status":   "error",
+							"error":    err.Error(),
+						}).Error("Error failed to store image")
+
+						session.AddFlash("Your message was not posted: the image could not be stored")
+					}
+					session.Save()
+					c.Redirect(http.StatusSeeOther, redirectTo)
+					return
+				}
+				attachments = append(attachments, attachment)
+				attachmentIDs = append(attachmentIDs, attachment.AttachmentID)
+			}
+		}
+
+		// "Save draft" and "Schedule" keep the post for later instead of publishing it
+		if action == "draft" || action == "schedule" {
+			post := ScheduledMessage{AuthorID: userIDString, Text: text, ReplyToID: replyTo}
+			var err error
+			if action == "schedule" {
+				post.PublishAt, err = parseScheduleTime(c.Request.FormValue("publish_at"), c.Request.FormValue("timezone_offset"))
+				if err == nil && post.PublishAt == 0 {
+					err = errInvalidScheduleTime
+				}
+				if err == nil {
+					err = validatePublishTime(post.PublishAt)
+				}
+			}
+			if err == nil {
+				err = createScheduledMessage(&post, attachmentIDs)
+			}
+			if err != nil {
+				removeUnattached(attachments)
+			}
+
+			if isScheduleInputError(err) {
+				session.AddFlash("Your post was not saved: " + err.Error())
+			} else if err != nil {
+
+				logger.WithFields(logrus.Fields{
+					"source":   "user_interface",
+					"endpoint": "add_messages",
+					"action":   action,
+					"status":   "error",
+					"error":    err.Error(),
+				}).Error("Error failed to save post for later")
+
+				session.AddFlash("Failed to save your post")
+			} else if action == "draft" {
+				session.AddFlash("Your draft was saved")
+				redirectTo = "/scheduled"
+			} else {
+				session.AddFlash("Your post was scheduled")
+				redirectTo = "/scheduled"
+			}
+			session.Save()
+			c.Redirect(http.StatusSeeOther, redirectTo)
+			return
+		}
+
+		if text == "" && len(attachmentIDs) == 0 && poll == nil {
+			c.Redirect(http.StatusSeeOther, redirectTo)
+			session.AddFlash("You have to enter a value")
+			session.Save()
+			return
+		} else {
+			err := addMessage(text, userIDString, replyTo, attachmentIDs, poll)
+			if err != nil {
+				removeUnattached(attachments)
+			}
+			if err == errReplyTargetNotFound {
+				c.Redirect(http.StatusSeeOther, "/")
+				session.AddFlash("The message you reply to does not exist anymore")
+				session.Save()
+				return
+			} else if err == errBlocked {
+				c.Redirect(http.StatusSeeOther, redirectTo)
+				session.AddFlash("You can't reply to this message")
+				session.Save()
+				return
+			} else if isMessageTextError(err) {
+				c.Redirect(http.StatusSeeOther, redirectTo)
+				session.AddFlash("Your message was not posted: " + err.Error())
+				session.Save()
+				return
+			} else if err != nil {
+
+				logger.WithFields(logrus.Fields{
+					"source":   "user_interface",
+					"endpoint": "add_messages",
+					"action":   "enter_value",
+					"status":   "error",
+					"error":    err.Error(),
+				}).Error("Error failed to add message")
+
+				errorData = "Failed to add message"
+				c.Redirect(http.StatusInternalServerError, "/?error="+errorData)
+				return
+			}
+
+			logger.WithFields(logrus.Fields{
+				"source":   "user_interface",
+				"endpoint": "add_messages",
+				"action":   "enter_value",
+				"status":   "success",
+			}).Info("Rendering users timeline")
+
+			c.Redirect(http.StatusSeeOther, redirectTo)
+			session.AddFlash("Your message was recorded")
+			session.Save()
+			return
+		}
+	}
+	c.Redirect(http.StatusSeeOther, "/")
+}
+
+func registerHandler(c *gin.Context) {
+	session := sessions.Default(c)
+
+	userID, exists := c.Get("UserID")
+	if exists {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "register_user",
+			"action":   "get_user",
+		}).Info("User exists")
+
+		fmt.Println("userID:", userID)
+		return
+	}
+
+	var errorData string
+	if c.Request.Method == http.MethodPost {
+		err := c.Request.ParseForm()
+		if err != nil {
+
+			logger.WithFields(logrus.Fields{
+				"source":   "user_interface",
+				"endpoint": "register_user",
+				"action":   "parse_data",
+				"status":   "error",
+				"error":    err.Error(),
+			}).Error("Error failed to parse form data")
+
+			errorData = "Failed to parse form data"
+			c.HTML(http.StatusBadRequest, "register.html", gin.H{
+				"RegisterBody": true,
+				"Error":        errorData,
+			})
+			return
+		}
+
+		// Validate form data
+		userName := c.Request.FormValue("username")
+		email := c.Request.FormValue("email")
+		password := c.Request.FormValue("password")
+		passwordConfirm := c.Request.FormValue("passwordConfirm")
+
+		userID, err := getUserIDByUsername(userName)
+		if err != nil {
+
+			logger.WithFields(logrus.Fields{
+				"source":   "user_interface",
+				"endpoint": "register_user",
+				"action":   "get_user_by_id",
+				"status":   "error",
+				"error":    err.Error(),
+			}).Error("Error getting username by id")
+
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		if userName == "" {
+			errorData = "You have to enter a username"
+		} else if email == "" || !strings.Contains(email, "@") {
+			errorData = "You have to enter a valid email address"
+		} else if password == "" {
+			errorData = "You have to enter a password"
+		} else if password != passwordConfirm {
+			errorData = "The two passwords do not match"
+		} else if fmt.Sprint(userID) != "-1" {
+			errorData = "The username is already taken"
+		} else if isReservedUsername(userName) {
+			errorData = "The username is reserved"
+		} else {
+			hash := md5.Sum([]byte(password))
+			err := registerUser(userName, email, hash)
+			if err != nil {
+
+				logger.WithFields(logrus.Fields{
+					"source":   "user_interface",
+					"endpoint": "register_user",
+					"action":   "registration_attempt",
+					"status":   "failed",
+					"reason":   "error_registering_user",
+					"error":    err.Error(),
+				}).Error("Failed registration attempt due to an error during registration")
+
+				errorData = "Failed to register user"
+				c.HTML(http.StatusInternalServerError, "register.html", gin.H{
+					"RegisterBody": true,
+					"Error":        errorData,
+				})
+				return
+			}
+			newSignupsCounter.Inc() //adding Prometheus new signups counter
+
+			logger.WithFields(logrus.Fields{
+				"source":   "user_interface",
+				"endpoint": "register_user",
+				"action":   "registration",
+				"status":   "success",
+			}).Info("User successfully registered")
+
+			// Redirect to login page after successful registration
+			session.AddFlash("You were successfully registered and can login now")
+			// print session info
+			fmt.Println("session info:", session, "Logged in")
+			session.Save()
+			c.Redirect(http.StatusSeeOther, "/login")
+			return
+		}
+	}
+	c.HTML(http.StatusOK, "register.html", gin.H{
+		"RegisterBody": true,
+		"Error":        errorData,
+	})
+}
+
+func loginHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	flashMessages := session.Flashes()
+	session.Save()
+
+	userID, _ := c.Cookie("UserID")
+	if userID != "" {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "login_user",
+			"action":   "login_check",
+			"status":   "already_logged_in",
+			"userID":   userID,
+		}).Info("User already logged in, redirecting")
+
+		session.AddFlash("You were logged in")
+		session.Save()
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	var errorData string
+
+	if c.Request.Method == http.MethodPost {
+
+		err := c.Request.ParseForm()
+		if err != nil {
+
+			logger.WithFields(logrus.Fields{
+				"source":   "user_interface",
+				"endpoint": "login_user",
+				"action":   "login_attempt",
+				"status":   "failed",
+				"reason":   "parse_form_error",
+				"error":    err.Error(),
+			}).Error("Failed to parse login form")
+
+			errorData = "Failed to parse form data"
+			c.HTML(http.StatusBadRequest, "login.html", gin.H{
+				"loginBody": true,
+				"Error":     errorData,
+			})
+			return
+		}
+
+		userName := c.Request.FormValue("username")
+		password := c.Request.FormValue("password")
+
+		user, err := getUserByUsername(userName)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		if user.Username == "" {
+			errorData = "Invalid username"
+		} else if !checkPasswordHash(password, user.PwHash) {
+			errorData = "Invalid password"
+		} else {
+			userID, err := getUserIDByUsername(userName)
+			if err != nil {
+
+				logger.WithFields(logrus.Fields{
+					"source":   "user_interface",
+					"endpoint": "login_user",
+					"action":   "login_attempt",
+					"status":   "failed",
+					"reason":   "user_id_retrieval_error",
+					"error":    err.Error(),
+				}).Error("Failed to retrieve userID during login")
+
+				c.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+
+			logger.WithFields(logrus.Fields{
+				"source":   "user_interface",
+				"endpoint": "login_user",
+				"action":   "login",
 				"status":   "success",
 			}).Info("User successfully logged in")
 
-			c.SetCookie("UserID", fmt.Sprint(userID), 3600, "/", "", false, true)
+			c.SetCookie("UserID", fmt.Sprint(userID), 3600, "/", "", false, true)
+
+			userLogIn() //adding Prometheus user login counter
+
+			session.AddFlash("You were logged in")
+			session.Save()
+			c.Redirect(http.StatusFound, "/")
+			return
+		}
+
+	}
+
+	c.HTML(http.StatusOK, "login.html", gin.H{
+		"LoginBody": true,
+		"Error":     errorData,
+		"Flashes":   flashMessages,
+	})
+}
+
+func logoutHandler(c *gin.Context) {
+	session := sessions.Default(c)
+
+	logger.WithFields(logrus.Fields{
+		"source":   "user_interface",
+		"endpoint": "logout_user",
+		"action":   "logout",
+		"status":   "success",
+	}).Info("User successfully logged out")
+
+	session.AddFlash("You were logged out")
+
+	userLogout() //decrement counter when logoout for Prometeus
+
+	session.Save()
+	// Invalidate the cookie by setting its max age to -1
+	// will delete the cookie <- nice stuff
+	c.SetCookie("UserID", "", -1, "/", "", false, true)
+	// redirect the user to the home page or login page
+	c.Redirect(http.StatusFound, "/login")
+}
+
+// renders the notifications of the logged in user at /notifications (?unread=1 for the unread ones only)
+func notificationsHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	flashMessages := session.Flashes()
+	session.Save()
+
+	userID, errID := c.Cookie("UserID")
+	userIDInt, errConv := strconv.Atoi(userID)
+	if errID != nil || errConv != nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	unreadOnly := c.Query("unread") == "1"
+	page := getPage(c)
+	notifications, err := getNotifications(userIDInt, unreadOnly, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "notifications",
+			"action":   "fetch_notifications",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Error fetching notifications")
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	pagination := newPagination(page, len(notifications))
+	if len(notifications) > PERPAGE {
+		notifications = notifications[:PERPAGE]
+	}
+
+	preferences, err := getNotificationPreferences(userIDInt)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	var preferenceList []gin.H
+	for _, notificationType := range notificationTypes {
+		preferenceList = append(preferenceList, gin.H{
+			"Type":        notificationType,
+			"Description": notificationDescriptions[notificationType],
+			"Enabled":     preferences[notificationType],
+		})
+	}
+
+	userName, _ := getUserNameByUserID(userID)
+
+	renderPage(c, http.StatusOK, "notifications.html", gin.H{
+		"NotificationsBody": true,
+		"UserID":            userID,
+		"UserName":          userName,
+		"Notifications":     formatNotifications(notifications),
+		"UnreadOnly":        unreadOnly,
+		"Preferences":       preferenceList,
+		"Pagination":        pagination,
+		"Flashes":           flashMessages,
+	})
+}
+
+// handles POST /notifications/read (all notifications) and /notifications/:id/read
+func markNotificationsReadHandler(c *gin.Context) {
+	userID, errID := c.Cookie("UserID")
+	userIDInt, errConv := strconv.Atoi(userID)
+	if errID != nil || errConv != nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	var notificationIDs []int
+	if c.Param("id") != "" {
+		notificationID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		notificationIDs = append(notificationIDs, notificationID)
+	}
+
+	if err := markNotificationsRead(userIDInt, notificationIDs); err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "notifications",
+			"action":   "mark_read",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to mark notifications as read")
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	redirectTo := c.Request.Referer()
+	if redirectTo == "" {
+		redirectTo = "/notifications"
+	}
+	c.Redirect(http.StatusSeeOther, redirectTo)
+}
+
+// handles the preferences form of the notifications page, unchecked types are turned off
+func notificationPreferencesHandler(c *gin.Context) {
+	session := sessions.Default(c)
+
+	userID, errID := c.Cookie("UserID")
+	userIDInt, errConv := strconv.Atoi(userID)
+	if errID != nil || errConv != nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	preferences := map[string]bool{}
+	for _, notificationType := range notificationTypes {
+		preferences[notificationType] = c.PostForm(notificationType) == "on"
+	}
+
+	if err := setNotificationPreferences(userIDInt, preferences); err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "notifications",
+			"action":   "set_preferences",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to save notification preferences")
+
+		session.AddFlash("Failed to save your notification preferences")
+	} else {
+		session.AddFlash("Your notification preferences were saved")
+	}
+	session.Save()
+
+	c.Redirect(http.StatusSeeOther, "/notifications")
+}
+
+// loggedInUser reads the UserID cookie, ok is false when nobody is logged in
+func loggedInUser(c *gin.Context) (string, int, bool) {
+	userID, err := c.Cookie("UserID")
+	if err != nil {
+		return "", 0, false
+	}
+	userIDInt, err := strconv.Atoi(userID)
+	if err != nil {
+		return "", 0, false
+	}
+	return userID, userIDInt, true
+}
+
+// renders the direct message inbox at /messages, ?to=<username> prefills the new conversation form
+func inboxHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	flashMessages := session.Flashes()
+	session.Save()
+
+	userID, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	page := getPage(c)
+	conversations, err := getConversations(userIDInt, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "inbox",
+			"action":   "fetch_conversations",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Error fetching conversations")
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	pagination := newPagination(page, len(conversations))
+	if len(conversations) > PERPAGE {
+		conversations = conversations[:PERPAGE]
+	}
+
+	userName, _ := getUserNameByUserID(userID)
+	user, _ := getUserByUsername(userName)
+
+	renderPage(c, http.StatusOK, "messages.html", gin.H{
+		"InboxBody":     true,
+		"UserID":        userID,
+		"UserName":      user.Username,
+		"OpenDMs":       user.OpenDMs,
+		"Conversations": formatConversations(conversations),
+		"To":            c.Query("to"),
+		"Pagination":    pagination,
+		"Flashes":       flashMessages,
+	})
+}
+
+// handles the new conversation form of the inbox, "to" holds comma separated usernames
+func startConversationHandler(c *gin.Context) {
+	session := sessions.Default(c)
+
+	_, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	text := strings.TrimSpace(c.PostForm("text"))
+	var usernames []string
+	for _, name := range strings.Split(c.PostForm("to"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			usernames = append(usernames, name)
+		}
+	}
+	if text == "" || len(usernames) == 0 {
+		session.AddFlash("You have to enter the recipients and a message")
+		session.Save()
+		c.Redirect(http.StatusSeeOther, "/messages")
+		return
+	}
+
+	userIDs, err := getUserIDsByUsernames(usernames)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	var recipientIDs []int
+	for _, name := range usernames {
+		recipientID, found := userIDs[name]
+		if !found {
+			session.AddFlash("The user " + name + " does not exist")
+			session.Save()
+			c.Redirect(http.StatusSeeOther, "/messages")
+			return
+		}
+		recipientIDs = append(recipientIDs, recipientID)
+	}
+
+	conversationID, err := startConversation(userIDInt, recipientIDs, text)
+	if err != nil {
+		if _, denied := err.(errNotAllowedToMessage); denied || err == errTooManyParticipants || err == errNoRecipients {
+			session.AddFlash(err.Error())
+		} else {
+
+			logger.WithFields(logrus.Fields{
+				"source":   "user_interface",
+				"endpoint": "inbox",
+				"action":   "start_conversation",
+				"status":   "error",
+				"error":    err.Error(),
+			}).Error("Failed to start conversation")
+
+			session.AddFlash("Failed to send your message")
+		}
+		session.Save()
+		c.Redirect(http.StatusSeeOther, "/messages")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/messages/"+strconv.Itoa(conversationID))
+}
+
+// renders a conversation at /messages/:id for its participants and marks it as read
+func conversationHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	flashMessages := session.Flashes()
+	session.Save()
+
+	userID, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	participants, err := getConversation(conversationID, userIDInt)
+	if err == errConversationNotFound {
+		// not telling apart missing conversations and conversations of others
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	page := getPage(c)
+	messages, err := getDirectMessages(conversationID, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "conversation",
+			"action":   "fetch_direct_messages",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Error fetching direct messages")
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	pagination := newPagination(page, len(messages))
+	if len(messages) > PERPAGE {
+		messages = messages[:PERPAGE]
+	}
+	if page == 1 {
+		markConversationRead(conversationID, userIDInt)
+	}
+	userName, _ := getUserNameByUserID(userID)
+
+	renderPage(c, http.StatusOK, "conversation.html", gin.H{
+		"ConversationBody": true,
+		"UserID":           userID,
+		"UserName":         userName,
+		"ConversationID":   conversationID,
+		"Participants":     participants,
+		"DirectMessages":   formatDirectMessages(messages, userIDInt),
+		"Pagination":       pagination,
+		"Flashes":          flashMessages,
+	})
+}
+
+// handles the reply form of a conversation
+func sendDirectMessageHandler(c *gin.Context) {
+	session := sessions.Default(c)
+
+	_, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	redirectTo := "/messages/" + strconv.Itoa(conversationID)
+
+	text := strings.TrimSpace(c.PostForm("text"))
+	if text == "" {
+		session.AddFlash("You have to enter a value")
+		session.Save()
+		c.Redirect(http.StatusSeeOther, redirectTo)
+		return
+	}
+
+	err = sendDirectMessage(conversationID, userIDInt, text)
+	if err == errConversationNotFound {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	} else if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "conversation",
+			"action":   "send_direct_message",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to send direct message")
+
+		session.AddFlash("Failed to send your message")
+		session.Save()
+	}
+
+	c.Redirect(http.StatusSeeOther, redirectTo)
+}
+
+// handles the open direct messages setting of the inbox
+func directMessageSettingsHandler(c *gin.Context) {
+	session := sessions.Default(c)
+
+	_, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	if err := setOpenDMs(userIDInt, c.PostForm("open_dms") == "on"); err != nil {
+		session.AddFlash("Failed to save your settings")
+	} else {
+		session.AddFlash("Your settings were saved")
+	}
+	session.Save()
+
+	c.Redirect(http.StatusSeeOther, "/messages")
+}
+
+// renders the blocked and muted users at /settings/blocks
+func blockSettingsHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	flashMessages := session.Flashes()
+	session.Save()
+
+	userID, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	// both lists share the page, there is a next page as long as one of them goes on
+	page := getPage(c)
+	blocked, err := getBlockedUsers(userIDInt, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	muted, err := getMutedUsers(userIDInt, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	pagination := newPagination(page, len(blocked))
+	if len(muted) > len(blocked) {
+		pagination = newPagination(page, len(muted))
+	}
+	if len(blocked) > PERPAGE {
+		blocked = blocked[:PERPAGE]
+	}
+	if len(muted) > PERPAGE {
+		muted = muted[:PERPAGE]
+	}
+
+	userName, _ := getUserNameByUserID(userID)
+
+	renderPage(c, http.StatusOK, "blocks.html", gin.H{
+		"BlocksBody": true,
+		"UserID":     userID,
+		"UserName":   userName,
+		"Blocked":    formatUsers(blocked),
+		"Muted":      formatUsers(muted),
+		"Pagination": pagination,
+		"Flashes":    flashMessages,
+	})
+}
+
+// handles the block/unblock/mute/unmute forms of the settings page and of the profiles,
+// "next" is the page to go back to
+func blockActionHandler(c *gin.Context) {
+	session := sessions.Default(c)
+
+	_, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	redirectTo := c.PostForm("next")
+	if !strings.HasPrefix(redirectTo, "/") || strings.HasPrefix(redirectTo, "//") {
+		redirectTo = "/settings/blocks"
+	}
+
+	username := strings.TrimSpace(c.PostForm("username"))
+	target, err := getUserByUsername(username)
+	if err != nil || target.Username == "" {
+		session.AddFlash("User " + username + " does not exist")
+		session.Save()
+		c.Redirect(http.StatusSeeOther, redirectTo)
+		return
+	}
+	if target.UserID == userIDInt {
+		session.AddFlash("You can't block or mute yourself")
+		session.Save()
+		c.Redirect(http.StatusSeeOther, redirectTo)
+		return
+	}
+
+	action := c.PostForm("action")
+	var flash string
+	switch action {
+	case "block":
+		err = blockUser(userIDInt, target.UserID)
+		flash = "You blocked " + target.Username
+	case "unblock":
+		err = unblockUser(userIDInt, target.UserID)
+		flash = "You unblocked " + target.Username
+	case "mute":
+		err = muteUser(userIDInt, target.UserID)
+		flash = "You muted " + target.Username
+	case "unmute":
+		err = unmuteUser(userIDInt, target.UserID)
+		flash = "You unmuted " + target.Username
+	default:
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "block_settings",
+			"action":   action,
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to change block settings")
+
+		flash = "Failed to " + action + " " + target.Username
+	}
+	session.AddFlash(flash)
+	session.Save()
+
+	c.Redirect(http.StatusSeeOther, redirectTo)
+}
+
+// renders the pending follow requests and the private account setting at /settings/follow_requests
+func followRequestsHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	flashMessages := session.Flashes()
+	session.Save()
+
+	userID, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	page := getPage(c)
+	requests, err := getFollowRequests(userIDInt, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "follow_requests",
+			"action":   "fetch_follow_requests",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Error fetching follow requests")
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	pagination := newPagination(page, len(requests))
+	if len(requests) > PERPAGE {
+		requests = requests[:PERPAGE]
+	}
+
+	userName, _ := getUserNameByUserID(userID)
+	user, _ := getUserByUsername(userName)
+
+	renderPage(c, http.StatusOK, "follow_requests.html", gin.H{
+		"FollowRequestsBody": true,
+		"UserID":             userID,
+		"UserName":           user.Username,
+		"Private":            user.Private,
+		"Requests":           formatUsers(requests),
+		"Pagination":         pagination,
+		"Flashes":            flashMessages,
+	})
+}
+
+// handles the approve and deny buttons of the follow requests page
+func followRequestActionHandler(c *gin.Context) {
+	session := sessions.Default(c)
+
+	_, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	username := c.PostForm("username")
+	requesterID, err := getUserIDByUsername(username)
+	if err != nil || requesterID == -1 {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	action := c.PostForm("action")
+	switch action {
+	case "approve":
+		err = approveFollowRequest(userIDInt, requesterID)
+		if err == nil {
+			session.AddFlash(username + " is now following you")
+		}
+	case "deny":
+		err = deleteFollowRequest(requesterID, userIDInt)
+		if err == nil {
+			session.AddFlash("You denied the follow request of " + username)
+		}
+	default:
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err == errFollowRequestNotFound {
+		session.AddFlash("The follow request of " + username + " was withdrawn")
+	} else if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "follow_requests",
+			"action":   action,
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to " + action + " follow request")
+
+		session.AddFlash("Failed to " + action + " the follow request")
+	}
+	session.Save()
+
+	c.Redirect(http.StatusSeeOther, "/settings/follow_requests")
+}
+
+// handles the private account setting of the follow requests page
+func privacySettingsHandler(c *gin.Context) {
+	session := sessions.Default(c)
+
+	_, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	if err := setPrivate(userIDInt, c.PostForm("private") == "on"); err != nil {
+		session.AddFlash("Failed to save your settings")
+	} else {
+		session.AddFlash("Your settings were saved")
+	}
+	session.Save()
+
+	c.Redirect(http.StatusSeeOther, "/settings/follow_requests")
+}
+
+// renders the profile, e-mail and password forms at /settings/profile
+func profileSettingsHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	flashMessages := session.Flashes()
+	session.Save()
+
+	userID, _, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	userName, err := getUserNameByUserID(userID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	user, err := getUserByUsername(userName)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	feedToken, err := getFeedToken(user.UserID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	var feedURL string
+	if feedToken != "" {
+		feedURL = siteURL(c) + personalFeedPath(feedToken)
+	}
+
+	renderPage(c, http.StatusOK, "settings.html", gin.H{
+		"ProfileSettingsBody": true,
+		"UserID":              userID,
+		"UserName":            user.Username,
+		"User":                user,
+		"Avatar":              avatarURL(user.UserID, user.AvatarVersion, user.Email, 80),
+		"FeedURL":             feedURL,
+		"Flashes":             flashMessages,
+	})
+}
+
+// handles the timeline feed form of the settings page: action=reset creates a new feed url, revoking
+// the old one, and action=disable turns the feed off
+func feedSettingsHandler(c *gin.Context) {
+	session := sessions.Default(c)
+
+	_, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	var err error
+	switch c.PostForm("action") {
+	case "reset":
+		_, err = resetFeedToken(userIDInt)
+		if err == nil {
+			session.AddFlash("Your timeline feed has a new address, the old one does not work anymore")
+		}
+	case "disable":
+		err = deleteFeedToken(userIDInt)
+		if err == nil {
+			session.AddFlash("Your timeline feed was turned off")
+		}
+	default:
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "feed_settings",
+			"action":   c.PostForm("action"),
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to change the timeline feed")
+
+		session.AddFlash("Failed to change your timeline feed")
+	}
+	session.Save()
+
+	c.Redirect(http.StatusSeeOther, "/settings/profile")
+}
+
+// handles the profile form of the settings page
+func updateProfileHandler(c *gin.Context) {
+	session := sessions.Default(c)
 
-			userLogIn() //adding Prometheus user login counter
+	_, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
 
-			session.AddFlash("You were logged in")
-			session.Save()
-			c.Redirect(http.StatusFound, "/")
-			return
+	displayName := c.PostForm("display_name")
+	bio := c.PostForm("bio")
+	location := c.PostForm("location")
+	website := c.PostForm("website")
+	update := ProfileUpdate{DisplayName: &displayName, Bio: &bio, Location: &location, Website: &website}
+
+	if err := validateProfile(&update); err != nil {
+		session.AddFlash("Your profile was not saved: " + err.Error())
+	} else if err := updateProfile(userIDInt, update); err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "profile_settings",
+			"action":   "update_profile",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to update profile")
+
+		session.AddFlash("Failed to save your profile")
+	} else {
+		session.AddFlash("Your profile was saved")
+	}
+	session.Save()
+
+	c.Redirect(http.StatusSeeOther, "/settings/profile")
+}
+
+// handles the e-mail and password forms of the settings page, both ask for the current password
+func accountSettingsHandler(c *gin.Context) {
+	session := sessions.Default(c)
+
+	_, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	currentPassword := c.PostForm("current_password")
+	var err error
+	var done string
+	if strings.HasSuffix(c.FullPath(), "/email") {
+		email := strings.TrimSpace(c.PostForm("email"))
+		if err = validateEmail(email); err == nil {
+			err = changeEmail(userIDInt, currentPassword, email)
+		}
+		done = "Your email address was changed"
+	} else {
+		password := c.PostForm("password")
+		if password == "" {
+			err = errInvalidProfileField{Field: "password", Reason: "can not be empty"}
+		} else if password != c.PostForm("passwordConfirm") {
+			err = errInvalidProfileField{Field: "password", Reason: "and its confirmation do not match"}
+		} else {
+			err = changePassword(userIDInt, currentPassword, md5.Sum([]byte(password)))
 		}
+		done = "Your password was changed"
+	}
 
+	if _, invalid := err.(errInvalidProfileField); invalid || err == errWrongPassword {
+		session.AddFlash("Nothing was changed: " + err.Error())
+	} else if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "account_settings",
+			"action":   c.FullPath(),
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to change account settings")
+
+		session.AddFlash("Failed to save your settings")
+	} else {
+		session.AddFlash(done)
 	}
+	session.Save()
 
-	c.HTML(http.StatusOK, "login.html", gin.H{
-		"LoginBody": true,
-		"Error":     errorData,
-		"Flashes":   flashMessages,
+	c.Redirect(http.StatusSeeOther, "/settings/profile")
+}
+
+// handles the avatar form of the settings page, "remove" goes back to the default avatar
+func avatarSettingsHandler(c *gin.Context) {
+	session := sessions.Default(c)
+
+	userID, _, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	userName, _ := getUserNameByUserID(userID)
+	user, err := getUserByUsername(userName)
+	if err != nil || user.Username == "" {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	if c.PostForm("remove") != "" {
+		err = removeAvatar(user)
+	} else {
+		var data []byte
+		data, err = readUpload(c, "avatar", AVATAR_MAX_BYTES)
+		if err == nil {
+			err = setAvatar(user, data)
+		}
+	}
+
+	if _, invalid := err.(errInvalidImage); invalid || err == errMissingUpload {
+		session.AddFlash("Your avatar was not saved: " + err.Error())
+	} else if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "profile_settings",
+			"action":   "set_avatar",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to save avatar")
+
+		session.AddFlash("Failed to save your avatar")
+	} else {
+		session.AddFlash("Your avatar was saved")
+	}
+	session.Save()
+
+	c.Redirect(http.StatusSeeOther, "/settings/profile")
+}
+
+// viewableList reads the list of /lists/:id, it aborts with 404 when the list does not exist
+// or is a private list of somebody else
+func viewableList(c *gin.Context, viewerID int) (ListSummary, bool) {
+	listID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return ListSummary{}, false
+	}
+	list, found, err := getList(listID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return list, false
+	}
+	if !found || !canViewList(list.UserList, viewerID) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return list, false
+	}
+	return list, true
+}
+
+// renders the lists of the logged in user at /lists, with the form to create a list
+func listsHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	flashMessages := session.Flashes()
+	session.Save()
+
+	userID, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	lists, err := getUserLists(userIDInt, true)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	userName, _ := getUserNameByUserID(userID)
+
+	renderPage(c, http.StatusOK, "lists.html", gin.H{
+		"ListsBody":            true,
+		"UserID":               userID,
+		"UserName":             userName,
+		"Lists":                formatLists(lists),
+		"MaxNameLength":        MAX_LIST_NAME_LENGTH,
+		"MaxDescriptionLength": MAX_LIST_DESCRIPTION_LENGTH,
+		"Flashes":              flashMessages,
 	})
 }
 
-func logoutHandler(c *gin.Context) {
+// handles POST /lists (create) and /lists/:id/settings (rename, describe, make private or public)
+func listSettingsHandler(c *gin.Context) {
 	session := sessions.Default(c)
 
-	logger.WithFields(logrus.Fields{
-		"source":   "user_interface",
-		"endpoint": "logout_user",
-		"action":   "logout",
-		"status":   "success",
-	}).Info("User successfully logged out")
+	_, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
 
-	session.AddFlash("You were logged out")
+	name := c.PostForm("name")
+	description := c.PostForm("description")
+	private := c.PostForm("private") != ""
+	update := ListUpdate{Name: &name, Description: &description, Private: &private}
+
+	err := validateList(&update)
+	action := "create_list"
+	flash := "Your list was created"
+	if err == nil {
+		if c.Param("id") == "" {
+			_, err = createList(userIDInt, update)
+		} else {
+			action = "update_list"
+			flash = "Your list was saved"
+			listID, _ := strconv.Atoi(c.Param("id"))
+			err = updateList(userIDInt, listID, update)
+		}
+	}
 
-	userLogout() //decrement counter when logoout for Prometeus
+	if isListInputError(err) {
+		flash = "Your list was not saved: " + err.Error()
+	} else if err == errListNotFound {
+		flash = "The list does not exist anymore"
+	} else if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "lists",
+			"action":   action,
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to save list")
 
+		flash = "Failed to save your list"
+	}
+	session.AddFlash(flash)
 	session.Save()
-	// Invalidate the cookie by setting its max age to -1
-	// will delete the cookie <- nice stuff
-	c.SetCookie("UserID", "", -1, "/", "", false, true)
-	// redirect the user to the home page or login page
-	c.Redirect(http.StatusFound, "/login")
+	c.Redirect(http.StatusSeeOther, "/lists")
+}
+
+// handles POST /lists/:id/delete
+func deleteListHandler(c *gin.Context) {
+	session := sessions.Default(c)
+
+	_, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	listID, _ := strconv.Atoi(c.Param("id"))
+	err := deleteList(userIDInt, listID)
+	if err == errListNotFound {
+		session.AddFlash("The list does not exist anymore")
+	} else if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "lists",
+			"action":   "delete_list",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to delete list")
+
+		session.AddFlash("Failed to delete your list")
+	} else {
+		session.AddFlash("Your list was deleted")
+	}
+	session.Save()
+	c.Redirect(http.StatusSeeOther, "/lists")
+}
+
+// handles POST /lists/:id/members with the username and action (add or remove) and where to go next
+func listMemberHandler(c *gin.Context) {
+	session := sessions.Default(c)
+
+	_, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	redirectTo := c.PostForm("next")
+	if !strings.HasPrefix(redirectTo, "/") || strings.HasPrefix(redirectTo, "//") {
+		redirectTo = "/lists/" + c.Param("id") + "/members"
+	}
+
+	username := strings.TrimSpace(c.PostForm("username"))
+	target, err := getUserByUsername(username)
+	if err != nil || target.Username == "" {
+		session.AddFlash("User " + username + " does not exist")
+		session.Save()
+		c.Redirect(http.StatusSeeOther, redirectTo)
+		return
+	}
+
+	listID, _ := strconv.Atoi(c.Param("id"))
+	action := c.PostForm("action")
+	var flash string
+	switch action {
+	case "add":
+		err = addListMember(userIDInt, listID, target.UserID)
+		flash = target.Username + " was added to the list"
+	case "remove":
+		err = removeListMember(userIDInt, listID, target.UserID)
+		flash = target.Username + " was removed from the list"
+	default:
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err == errListNotFound {
+		flash = "The list does not exist anymore"
+	} else if err == errBlocked {
+		flash = "You can't add " + target.Username + " to a list"
+	} else if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "list_members",
+			"action":   action,
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to change list members")
+
+		flash = "Failed to " + action + " " + target.Username
+	}
+	session.AddFlash(flash)
+	session.Save()
+	c.Redirect(http.StatusSeeOther, redirectTo)
+}
+
+// renders the timeline of a list at /lists/:id
+func listTimelineHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	flashMessages := session.Flashes()
+	session.Save()
+
+	userID, _ := c.Cookie("UserID")
+	userIDInt, _ := strconv.Atoi(userID)
+	list, ok := viewableList(c, userIDInt)
+	if !ok {
+		return
+	}
+
+	page := getPage(c)
+	messages, err := getListMessages(list.ListID, userIDInt, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "list_timeline",
+			"action":   "fetch_list_messages",
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Error fetching list messages")
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	pagination := newPagination(page, len(messages))
+	if len(messages) > PERPAGE {
+		messages = messages[:PERPAGE]
+	}
+
+	userName, _ := getUserNameByUserID(userID)
+	formattedMessages := formatTimeline(messages, userIDInt)
+	markViewerState(formattedMessages, userIDInt)
+
+	renderPage(c, http.StatusOK, "timeline.html", gin.H{
+		"TimelineBody": true,
+		"Endpoint":     "list",
+		"List":         formatLists([]ListSummary{list})[0],
+		"UserID":       userID,
+		"UserName":     userName,
+		"Messages":     formattedMessages,
+		"Pagination":   pagination,
+		"Flashes":      flashMessages,
+	})
+}
+
+// renders the members of a list at /lists/:id/members, the owner can add and remove members there
+func listMembersHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	flashMessages := session.Flashes()
+	session.Save()
+
+	userID, _ := c.Cookie("UserID")
+	userIDInt, _ := strconv.Atoi(userID)
+	list, ok := viewableList(c, userIDInt)
+	if !ok {
+		return
+	}
+
+	page := getPage(c)
+	users, err := getListMembers(list.ListID, PERPAGE+1, (page-1)*PERPAGE)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	pagination := newPagination(page, len(users))
+	if len(users) > PERPAGE {
+		users = users[:PERPAGE]
+	}
+
+	userName, _ := getUserNameByUserID(userID)
+	renderPage(c, http.StatusOK, "follow_list.html", gin.H{
+		"FollowListBody": true,
+		"ListTitle":      "Members of " + list.Name,
+		"List":           formatLists([]ListSummary{list})[0],
+		"ListOwner":      userIDInt != 0 && list.OwnerID == userIDInt,
+		"UserID":         userID,
+		"UserName":       userName,
+		"Users":          formatUsers(users),
+		"Pagination":     pagination,
+		"Flashes":        flashMessages,
+	})
+}
+
+// renders the drafts and scheduled posts of the logged in user at /scheduled
+func scheduledHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	flashMessages := session.Flashes()
+	session.Save()
+
+	userID, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	posts, err := getScheduledMessages(userIDInt)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	var scheduledIDs []int
+	for _, post := range posts {
+		scheduledIDs = append(scheduledIDs, post.ScheduledID)
+	}
+	attachments, err := getScheduledAttachments(scheduledIDs)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	userName, _ := getUserNameByUserID(userID)
+	renderPage(c, http.StatusOK, "scheduled.html", gin.H{
+		"ScheduledBody": true,
+		"UserID":        userID,
+		"UserName":      userName,
+		"Posts":         formatScheduled(posts, attachments),
+		"MaxPending":    MAX_PENDING_POSTS,
+		"Flashes":       flashMessages,
+	})
+}
+
+// handles POST /scheduled/:id with the action: save (the text and the time, no time for a draft),
+// publish (right away) or delete
+func scheduledActionHandler(c *gin.Context) {
+	session := sessions.Default(c)
+
+	_, userIDInt, ok := loggedInUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	scheduledID, _ := strconv.Atoi(c.Param("id"))
+	post, err := getScheduledMessage(userIDInt, scheduledID)
+	action := c.PostForm("action")
+	var flash string
+	if err == nil {
+		switch action {
+		case "save":
+			text := c.PostForm("text")
+			var publishAt int
+			publishAt, err = parseScheduleTime(c.PostForm("publish_at"), c.PostForm("timezone_offset"))
+			if err == nil {
+				err = validatePublishTime(publishAt)
+			}
+			if err == nil {
+				err = updateScheduledMessage(userIDInt, scheduledID, ScheduledUpdate{Text: &text, PublishAt: &publishAt})
+			}
+			flash = "Your post was saved"
+		case "publish":
+			var message Message
+			message, err = publishScheduledMessage(post, false)
+			if err == nil {
+				session.AddFlash("Your message was recorded")
+				session.Save()
+				c.Redirect(http.StatusSeeOther, "/msg/"+strconv.Itoa(message.MessageID))
+				return
+			}
+		case "delete":
+			err = deleteScheduledMessage(userIDInt, scheduledID)
+			flash = "Your post was deleted"
+		default:
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err == errScheduledNotFound {
+		flash = "The post was published or deleted in the meantime"
+	} else if isScheduleInputError(err) {
+		flash = "Your post was not saved: " + err.Error()
+		if action == "publish" {
+			flash = "Your post was not published: " + err.Error()
+		}
+	} else if err != nil {
+
+		logger.WithFields(logrus.Fields{
+			"source":   "user_interface",
+			"endpoint": "scheduled",
+			"action":   action,
+			"status":   "error",
+			"error":    err.Error(),
+		}).Error("Failed to change scheduled post")
+
+		flash = "Failed to " + action + " your post"
+	}
+	session.AddFlash(flash)
+	session.Save()
+	c.Redirect(http.StatusSeeOther, "/scheduled")
 }